@@ -0,0 +1,94 @@
+package api
+
+import (
+	"errors"
+	"filestore-server/pkg/dao"
+	"filestore-server/pkg/mw"
+	"filestore-server/service"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FileACLUpdate 把调用者空间（或 team 参数指定的团队空间，需要 admin 及以上角色）中某个文件或目录的权限授予其他用户或团队。
+// 表单字段：path（文件路径或目录，空表示根目录）、principal（用户名或 team:<id>）、permission（read、write）、
+// inherit（为 true 时目录权限对子孙目录生效）。
+func FileACLUpdate(c *gin.Context) {
+	owner, ok := requestOwner(c, service.PermManage)
+	if !ok {
+		return
+	}
+	inherit, _ := strconv.ParseBool(c.DefaultPostForm("inherit", "false"))
+
+	entry, err := service.SetACL(c.Request.Context(), c.GetString(mw.SessionUserKey), owner,
+		c.PostForm("path"), c.PostForm("principal"), c.PostForm("permission"), inherit)
+	if err != nil {
+		writeACLError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, entry)
+}
+
+// FileACLDelete 删除授予某个主体的权限，表单字段 path、principal。
+func FileACLDelete(c *gin.Context) {
+	owner, ok := requestOwner(c, service.PermManage)
+	if !ok {
+		return
+	}
+
+	if err := service.DeleteACL(c.Request.Context(), c.GetString(mw.SessionUserKey), owner, c.PostForm("path"), c.PostForm("principal")); err != nil {
+		writeACLError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "acl deleted"})
+}
+
+// FileACLList 列出设置在某个文件或目录上的权限。
+func FileACLList(c *gin.Context) {
+	owner, ok := requestOwner(c, service.PermManage)
+	if !ok {
+		return
+	}
+
+	entries, err := service.ListACL(c.Request.Context(), owner, c.Query("path"))
+	if err != nil {
+		writeACLError(c, err)
+		return
+	}
+	if entries == nil {
+		entries = []dao.ACLEntry{}
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
+
+// FilePermissions 返回用户对文件的有效权限及其来源，用于排查谁能访问什么；
+// user 参数为空时查询调用者本人，查询他人需要对文件有 manage 权限。
+func FilePermissions(c *gin.Context) {
+	username := c.GetString(mw.SessionUserKey)
+	filehash := c.GetString(mw.CtxFileHashKey)
+
+	access, err := service.EffectiveFileAccess(c.Request.Context(), username, filehash, c.Query("user"))
+	if err != nil {
+		switch {
+		case writeAuthError(c, err):
+		case errors.Is(err, dao.ErrUserFileNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to evaluate permissions"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"filehash": filehash, "access": access})
+}
+
+func writeACLError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidACL), errors.Is(err, service.ErrUnknownPrincipal):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, dao.ErrACLNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update acl"})
+	}
+}
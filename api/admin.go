@@ -0,0 +1,191 @@
+package api
+
+import (
+	"errors"
+	"filestore-server/pkg/dao"
+	"filestore-server/pkg/mw"
+	"filestore-server/service"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MimePolicyList 列出用户组的上传类型规则，group 缺省为默认用户组。
+func MimePolicyList(c *gin.Context) {
+	group := strings.TrimSpace(c.Query("group"))
+	if group == "" {
+		group = dao.DefaultUserGroup
+	}
+
+	policies, err := service.ListMimePolicies(c.Request.Context(), group)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list mime policies"})
+		return
+	}
+	if policies == nil {
+		policies = []dao.MimePolicy{}
+	}
+	c.JSON(http.StatusOK, gin.H{"group": group, "policies": policies})
+}
+
+// MimePolicyUpdate 新增或覆盖一条上传类型规则，表单字段 group、mime_type、action(allow/deny)。
+func MimePolicyUpdate(c *gin.Context) {
+	policy := dao.MimePolicy{
+		GroupName: c.PostForm("group"),
+		MimeType:  c.PostForm("mime_type"),
+		Action:    c.PostForm("action"),
+	}
+	if err := service.SetMimePolicy(c.Request.Context(), policy); err != nil {
+		if errors.Is(err, service.ErrInvalidMimePolicy) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save mime policy"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "mime policy saved"})
+}
+
+// MimePolicyDelete 删除一条上传类型规则。
+func MimePolicyDelete(c *gin.Context) {
+	if err := service.DeleteMimePolicy(c.Request.Context(), c.PostForm("group"), c.PostForm("mime_type")); err != nil {
+		if err.Error() == "mime policy not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete mime policy"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "mime policy deleted"})
+}
+
+// UserGroupUpdate 修改用户所属的用户组，表单字段 user_name、group。
+func UserGroupUpdate(c *gin.Context) {
+	if err := service.SetUserGroup(c.Request.Context(), c.PostForm("user_name"), c.PostForm("group")); err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidUserGroup):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case err.Error() == "user not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update user group"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "user group updated"})
+}
+
+// writeUserAdminError 写出用户管理接口的错误响应。
+func writeUserAdminError(c *gin.Context, err error, msg string) {
+	switch {
+	case writePolicyError(c, err):
+	case errors.Is(err, service.ErrInvalidUserStatus), errors.Is(err, service.ErrInvalidUserRole),
+		errors.Is(err, service.ErrInvalidQuota):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrSelfModify):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err.Error() == "user not found":
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+	}
+}
+
+// AdminUserList 查询用户，参数 q（匹配用户名、邮箱、手机号）、status（active/disabled/locked）、limit、offset。
+func AdminUserList(c *gin.Context) {
+	limit, err := int64Query(c, "limit")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+		return
+	}
+	offset, err := int64Query(c, "offset")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid offset"})
+		return
+	}
+
+	users, total, err := service.ListUsers(c.Request.Context(), c.Query("q"), c.Query("status"), int(limit), int(offset))
+	if err != nil {
+		writeUserAdminError(c, err, "failed to list users")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"users": users, "total": total})
+}
+
+// AdminUserGet 返回单个用户的信息，包括存储用量和配额。
+func AdminUserGet(c *gin.Context) {
+	user, err := service.GetUserAccount(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		writeUserAdminError(c, err, "failed to get user")
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// adminUserStatusHandler 返回把账户状态改为 status 的处理函数。
+func adminUserStatusHandler(status int, message string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := c.GetString(mw.SessionUserKey)
+		if err := service.SetUserStatus(c.Request.Context(), actor, c.Param("name"), status); err != nil {
+			writeUserAdminError(c, err, "failed to update user status")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": message})
+	}
+}
+
+var (
+	// AdminUserDisable 禁用账户。
+	AdminUserDisable = adminUserStatusHandler(dao.UserStatusDisabled, "user disabled")
+	// AdminUserEnable 启用被禁用的账户。
+	AdminUserEnable = adminUserStatusHandler(dao.UserStatusActive, "user enabled")
+	// AdminUserLock 锁定账户。
+	AdminUserLock = adminUserStatusHandler(dao.UserStatusLocked, "user locked")
+	// AdminUserUnlock 解除账户锁定。
+	AdminUserUnlock = adminUserStatusHandler(dao.UserStatusActive, "user unlocked")
+)
+
+// AdminUserPasswordReset 重置用户密码，表单字段 password 为空时生成随机密码并在响应中返回。
+func AdminUserPasswordReset(c *gin.Context) {
+	actor := c.GetString(mw.SessionUserKey)
+
+	generated, err := service.ResetUserPassword(c.Request.Context(), actor, c.Param("name"), c.PostForm("password"))
+	if err != nil {
+		writeUserAdminError(c, err, "failed to reset password")
+		return
+	}
+	resp := gin.H{"message": "password reset"}
+	if generated != "" {
+		resp["password"] = generated
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// AdminUserQuotaUpdate 修改用户的存储配额，表单字段 quota（字节，0 表示使用系统默认值）。
+func AdminUserQuotaUpdate(c *gin.Context) {
+	actor := c.GetString(mw.SessionUserKey)
+	quota, err := strconv.ParseInt(c.PostForm("quota"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid quota"})
+		return
+	}
+
+	if err := service.SetUserQuota(c.Request.Context(), actor, c.Param("name"), quota); err != nil {
+		writeUserAdminError(c, err, "failed to update quota")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "quota updated"})
+}
+
+// AdminUserRoleUpdate 修改用户角色，表单字段 role（user/admin）。
+func AdminUserRoleUpdate(c *gin.Context) {
+	actor := c.GetString(mw.SessionUserKey)
+
+	if err := service.SetUserRole(c.Request.Context(), actor, c.Param("name"), c.PostForm("role")); err != nil {
+		writeUserAdminError(c, err, "failed to update role")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "role updated"})
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"errors"
+	"filestore-server/pkg/mw"
+	"filestore-server/service"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BatchDownload 把调用者的多个文件或一个目录下的全部文件打包成 zip 或 tar.gz 流式下载。
+func BatchDownload(c *gin.Context) {
+	owner, ok := requestOwner(c, service.PermRead)
+	if !ok {
+		return
+	}
+
+	format := c.DefaultQuery("format", c.DefaultPostForm("format", service.ArchiveZip))
+	contentType := ""
+	switch format {
+	case service.ArchiveZip:
+		contentType = "application/zip"
+	case service.ArchiveTarGz:
+		contentType = "application/gzip"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be zip or tar.gz"})
+		return
+	}
+
+	var entries []service.ArchiveEntry
+	var err error
+	if dir, ok := c.GetQuery("dir"); ok {
+		entries, err = service.PrepareDirArchive(c.Request.Context(), owner, dir)
+	} else if dir, ok := c.GetPostForm("dir"); ok {
+		entries, err = service.PrepareDirArchive(c.Request.Context(), owner, dir)
+	} else {
+		entries, err = service.PrepareArchive(c.Request.Context(), owner, c.GetStringSlice(mw.CtxFileHashesKey))
+	}
+	if err != nil {
+		if writeScanError(c, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, service.ErrFilesNotOwned) || err.Error() == "file not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": "some files not found"})
+			return
+		case errors.Is(err, service.ErrInvalidBatch):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dir"})
+			return
+		case errors.Is(err, service.ErrTooManyArchiveFiles):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "too many files in dir"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to prepare archive"})
+		return
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", "attachment;filename=\"files."+format+"\"")
+	c.Status(http.StatusOK)
+	// 响应头已发出，出错时只能中断连接，让客户端感知到压缩包不完整。
+	if err := service.WriteArchive(c.Request.Context(), c.Writer, format, entries); err != nil {
+		log.Printf("batch download for %s aborted: %v", owner, err)
+		panic(http.ErrAbortHandler)
+	}
+}
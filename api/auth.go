@@ -1,9 +1,13 @@
 package api
 
 import (
+	"errors"
+	"filestore-server/pkg/dao"
 	"filestore-server/pkg/mw"
 	"filestore-server/service"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
@@ -24,6 +28,9 @@ func Signup(c *gin.Context) {
 	}
 
 	if err := service.RegisterUser(c.Request.Context(), payload.Username, payload.Password); err != nil {
+		if writePolicyError(c, err) {
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -39,14 +46,27 @@ func Login(c *gin.Context) {
 		return
 	}
 
-	if err := service.AuthenticateUser(c.Request.Context(), payload.Username, payload.Password); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	user, err := service.Login(c.Request.Context(), payload.Username, payload.Password, c.ClientIP())
+	if err != nil {
+		writeLoginError(c, err)
 		return
 	}
 
-	session := sessions.Default(c)
-	session.Set(mw.SessionUserKey, payload.Username)
-	if err := session.Save(); err != nil {
+	finishLogin(c, user)
+}
+
+// finishLogin 在第一步认证通过后写入会话。启用两步验证的用户只记录待验证状态，提交验证码后才写入登录用户。
+func finishLogin(c *gin.Context, user dao.User) {
+	if user.TOTPEnabled {
+		if err := savePendingLogin(c, user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save session"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication required", "status": "2fa_required"})
+		return
+	}
+
+	if err := saveLoginSession(c, user); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save session"})
 		return
 	}
@@ -54,6 +74,36 @@ func Login(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "login success"})
 }
 
+// writeLoginError 把登录各步骤的错误转换为响应：限制登录时返回 429 和 Retry-After。
+func writeLoginError(c *gin.Context, err error) {
+	var terr *service.ThrottleError
+	if errors.As(err, &terr) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(terr.RetryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": terr.Error()})
+		return
+	}
+	if errors.Is(err, service.ErrUserDisabled) || errors.Is(err, service.ErrUserLocked) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, service.ErrInvalidCredentials) || errors.Is(err, service.ErrInvalidTOTPCode) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to login"})
+}
+
+// saveLoginSession 把登录用户及其会话版本写入 session，并清除待验证的登录状态。
+func saveLoginSession(c *gin.Context, user dao.User) error {
+	session := sessions.Default(c)
+	session.Delete(mw.SessionPendingUserKey)
+	session.Delete(mw.SessionPendingVersionKey)
+	session.Delete(mw.SessionPendingAtKey)
+	session.Set(mw.SessionUserKey, user.UserName)
+	session.Set(mw.SessionVersionKey, user.SessionVersion)
+	return session.Save()
+}
+
 // Logout 清理 session。
 func Logout(c *gin.Context) {
 	session := sessions.Default(c)
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"errors"
+	"filestore-server/service"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IdempotencyKeyHeader 是批量操作请求携带幂等键的请求头。
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// FileBatch 对调用者的多个文件执行批量操作，请求体为 JSON：
+// {"atomic": false, "operations": [{"op": "delete|rename|move|tag|untag", "filehash": "...", "name": "...", "dir": "...", "tags": [...]}]}。
+// 部分操作失败时仍返回 200，逐项结果见 results；重放的结果带有 Idempotent-Replayed: true 响应头。
+func FileBatch(c *gin.Context) {
+	owner, ok := requestOwner(c, service.PermWrite)
+	if !ok {
+		return
+	}
+
+	var req service.BatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid batch request"})
+		return
+	}
+
+	result, err := service.RunBatch(c.Request.Context(), owner, c.GetHeader(IdempotencyKeyHeader), req)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidBatch):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrBatchTooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error(), "max_operations": service.MaxBatchOps()})
+		case errors.Is(err, service.ErrIdempotencyConflict):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to run batch"})
+		}
+		return
+	}
+
+	if result.Replayed {
+		c.Header("Idempotent-Replayed", "true")
+	}
+	c.JSON(http.StatusOK, result)
+}
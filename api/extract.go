@@ -0,0 +1,37 @@
+package api
+
+import (
+	"errors"
+	"filestore-server/pkg/mw"
+	"filestore-server/service"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FileExtract 为调用者可读的压缩包创建后台解压任务，带 team 参数时解压到团队空间（需要 editor 及以上角色）。
+func FileExtract(c *gin.Context) {
+	space, ok := requestOwner(c, service.PermWrite)
+	if !ok {
+		return
+	}
+	filehash := c.GetString(mw.CtxFileHashKey)
+
+	jobID, err := service.StartExtract(c.Request.Context(), c.GetString(mw.SessionUserKey), space, filehash)
+	if err != nil {
+		if writeScanError(c, err) || writeAuthError(c, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, service.ErrNotArchive):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case err.Error() == "file not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start extract job"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"job_id": jobID})
+}
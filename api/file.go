@@ -1,20 +1,24 @@
 package api
 
 import (
+	"errors"
 	"filestore-server/pkg/dao"
 	"filestore-server/pkg/mw"
 	util "filestore-server/pkg/utils"
 	"filestore-server/service"
+	"io"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 )
 
 // 上传文件
-// GET 返回上传页；POST 上传文件并存储元信息。
+// GET 返回上传页；POST 上传文件并存储元信息，带 team 参数时保存到团队空间（需要 editor 及以上角色）。
 func UploadFile(c *gin.Context) {
 	switch c.Request.Method {
 	case http.MethodGet:
@@ -39,24 +43,22 @@ func UploadFile(c *gin.Context) {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid session"})
 			return
 		}
-		sha1 := util.FileSha1ReadSeeker(file)
-		fmeta, exists, err := service.GetFileExist(c.Request.Context(), sha1)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get file meta"})
+		owner, ok := requestOwner(c, service.PermWrite)
+		if !ok {
 			return
 		}
-
-		if !exists {
-			fmeta, err = service.UploadFile(c.Request.Context(), file, header.Filename)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to persist file meta"})
-				return
+		fmeta, err := service.SaveUpload(c.Request.Context(), username, owner, file, header.Filename, header.Size)
+		if err != nil {
+			switch {
+			case errors.Is(err, service.ErrFileTypeNotAllowed):
+				c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "file type not allowed", "mime_type": c.GetString(mw.CtxMimeTypeKey)})
+			case errors.Is(err, dao.ErrDuplicateUserFile):
+				c.JSON(http.StatusConflict, gin.H{"error": dao.ErrDuplicateUserFile.Error()})
+			case errors.Is(err, service.ErrQuotaExceeded):
+				c.JSON(http.StatusInsufficientStorage, gin.H{"error": err.Error()})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save uploaded file"})
 			}
-		}
-		fmeta.FileName = header.Filename
-
-		if err := service.InsertUserFileMeta(c.Request.Context(), username, fmeta.FileSha1, fmeta.FileSize, fmeta.FileName); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update user file meta"})
 			return
 		}
 		c.JSON(http.StatusOK, gin.H{"message": "upload file success", "file": fmeta})
@@ -65,12 +67,19 @@ func UploadFile(c *gin.Context) {
 	}
 }
 
-// 获取文件元信息
+// 获取文件元信息，附带文件所在空间设置的标签和自定义属性
 func GetFileMeta(c *gin.Context) {
+	owner, ok := requestFileSpace(c, service.PermRead)
+	if !ok {
+		return
+	}
 	fileSha1 := c.GetString(mw.CtxFileHashKey)
 
-	fmeta, err := dao.GetFileMeta(c.Request.Context(), fileSha1)
+	fmeta, err := service.GetUserFileMeta(c.Request.Context(), c.GetString(mw.SessionUserKey), owner, fileSha1)
 	if err != nil {
+		if writeAuthError(c, err) {
+			return
+		}
 		c.JSON(http.StatusNotFound, gin.H{"error": "meta not found"})
 		return
 	}
@@ -80,10 +89,17 @@ func GetFileMeta(c *gin.Context) {
 
 // DownloadFile 下载文件
 func DownloadFile(c *gin.Context) {
+	owner, ok := requestFileSpace(c, service.PermRead)
+	if !ok {
+		return
+	}
 	filesha1 := c.GetString(mw.CtxFileHashKey)
 
-	fmeta, data, err := service.DownloadFile(c.Request.Context(), filesha1)
+	fmeta, data, err := service.DownloadFile(c.Request.Context(), c.GetString(mw.SessionUserKey), owner, filesha1, acceptsEncoding(c))
 	if err != nil {
+		if writeScanError(c, err) || writeAuthError(c, err) {
+			return
+		}
 		if err.Error() == "file not found" {
 			c.JSON(http.StatusNotFound, gin.H{"error": "meta not found"})
 			return
@@ -91,24 +107,95 @@ func DownloadFile(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read file"})
 		return
 	}
+	defer data.Close()
+
+	serveFile(c, fmeta, data)
+}
+
+// serveFile 输出文件内容：可随机读取时由 http.ServeContent 处理 Range 与条件请求，否则直接流式输出。
+// 只有可安全展示的类型才以 inline 方式返回真实类型，其余一律作为附件下载。
+func serveFile(c *gin.Context, fmeta dao.FileMeta, data service.FileContent) {
+	if util.InlineSafeMimeType(fmeta.MimeType) {
+		c.Header("Content-Type", fmeta.MimeType)
+		c.Header("Content-Disposition", "inline;filename=\""+fmeta.FileName+"\"")
+	} else {
+		c.Header("Content-Type", "application/octet-stream")
+		c.Header("Content-Disposition", "attachment;filename=\""+fmeta.FileName+"\"")
+	}
+	c.Header("X-Content-Type-Options", "nosniff")
+	if data.Encoding != "" {
+		c.Header("Content-Encoding", data.Encoding)
+	}
+	if fmeta.Compression != "" {
+		c.Header("Vary", "Accept-Encoding")
+	}
+
+	if rs, ok := data.Seeker(); ok {
+		http.ServeContent(c.Writer, c.Request, fmeta.FileName, time.Time{}, rs)
+		return
+	}
+
+	c.Header("Content-Length", strconv.FormatInt(fmeta.FileSize, 10))
+	c.Status(http.StatusOK)
+	if c.Request.Method == http.MethodHead {
+		return
+	}
+	_, _ = io.Copy(c.Writer, data)
+}
 
-	c.Header("Content-Type", "application/octet-stream")
-	c.Header("Content-Disposition", "attachment;filename=\""+fmeta.FileName+"\"")
-	c.Data(http.StatusOK, "application/octet-stream", data)
+// writeScanError 在 err 表示文件未通过病毒扫描时写出对应的错误响应并返回 true。
+func writeScanError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, service.ErrFileNotScanned):
+		c.JSON(http.StatusConflict, gin.H{"error": service.ErrFileNotScanned.Error()})
+	case errors.Is(err, service.ErrFileInfected):
+		c.JSON(http.StatusForbidden, gin.H{"error": service.ErrFileInfected.Error()})
+	default:
+		return false
+	}
+	return true
+}
+
+// acceptsEncoding 返回判断客户端 Accept-Encoding 是否接受某种编码的函数。
+func acceptsEncoding(c *gin.Context) func(string) bool {
+	header := c.GetHeader("Accept-Encoding")
+	return func(encoding string) bool {
+		for _, part := range strings.Split(header, ",") {
+			name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			if !strings.EqualFold(strings.TrimSpace(name), encoding) {
+				continue
+			}
+			q := strings.ReplaceAll(params, " ", "")
+			return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+		}
+		return false
+	}
 }
 
 // FileMetaUpdate 更新元信息接口(重命名)
 func FileMetaUpdate(c *gin.Context) {
+	owner, ok := requestFileSpace(c, service.PermWrite)
+	if !ok {
+		return
+	}
 	filesha1 := c.GetString(mw.CtxFileHashKey)
 	newFileName := c.GetString(mw.CtxFilenameKey)
 
-	curFileMeta, err := service.RenameFile(c.Request.Context(), filesha1, newFileName)
+	curFileMeta, err := service.RenameFile(c.Request.Context(), c.GetString(mw.SessionUserKey), owner, filesha1, newFileName)
 	if err != nil {
-		if err.Error() == "file not found" {
-			c.JSON(http.StatusNotFound, gin.H{"error": "meta not found"})
+		if writeAuthError(c, err) {
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update file meta"})
+		switch {
+		case errors.Is(err, dao.ErrUserFileNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "meta not found"})
+		case errors.Is(err, service.ErrInvalidBatch):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid filename"})
+		case errors.Is(err, dao.ErrUserFileExists):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update file meta"})
+		}
 		return
 	}
 
@@ -117,9 +204,16 @@ func FileMetaUpdate(c *gin.Context) {
 
 // FileDelete 删除文件元信息
 func FileDelete(c *gin.Context) {
+	owner, ok := requestFileSpace(c, service.PermWrite)
+	if !ok {
+		return
+	}
 	filesha1 := c.GetString(mw.CtxFileHashKey)
 
-	if err := service.DeleteFile(c.Request.Context(), filesha1); err != nil {
+	if err := service.DeleteFile(c.Request.Context(), c.GetString(mw.SessionUserKey), owner, filesha1); err != nil {
+		if writeAuthError(c, err) {
+			return
+		}
 		if err.Error() == "file not found" {
 			c.JSON(http.StatusNotFound, gin.H{"error": "meta not found"})
 			return
@@ -131,54 +225,81 @@ func FileDelete(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "delete success"})
 }
 
+// UserFilelistQuery 返回调用者或其所在团队（team 参数）的文件列表；
+// user_name 为其他用户时只列出对方通过 ACL 共享给调用者的文件。
+// 参数：sort（name、size、upload_at、last_update）、order（asc、desc）、prefix、min_size、max_size、limit，
+// 以及 offset 或上一页返回的 cursor；按 cursor 翻页时不返回 total。
 func UserFilelistQuery(c *gin.Context) {
-	username := c.GetString(mw.CtxUsernameKey)
-
-	limit := 0
-	if limitStr := c.PostForm("limit"); limitStr != "" {
-		val, err := strconv.Atoi(limitStr)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
-			return
-		}
-		limit = val
-	} else if limitStr := c.Query("limit"); limitStr != "" {
-		val, err := strconv.Atoi(limitStr)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+	username := c.GetString(mw.SessionUserKey)
+	owner := c.GetString(mw.CtxUsernameKey)
+	if formValue(c, "team") != "" {
+		var ok bool
+		if owner, ok = requestOwner(c, service.PermRead); !ok {
 			return
 		}
-		limit = val
 	}
 
-	offset := 0
-	if offsetStr := c.PostForm("offset"); offsetStr != "" {
-		val, err := strconv.Atoi(offsetStr)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid offset"})
-			return
+	opts := service.ListOptions{
+		SortBy:     formValue(c, "sort"),
+		NamePrefix: formValue(c, "prefix"),
+		Cursor:     formValue(c, "cursor"),
+	}
+	switch formValue(c, "order") {
+	case "", "desc":
+	case "asc":
+		opts.Asc = true
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid order"})
+		return
+	}
+	var limit, offset int64
+	for key, dst := range map[string]*int64{
+		"limit":    &limit,
+		"offset":   &offset,
+		"min_size": &opts.MinSize,
+		"max_size": &opts.MaxSize,
+	} {
+		v := formValue(c, key)
+		if v == "" {
+			continue
 		}
-		offset = val
-	} else if offsetStr := c.Query("offset"); offsetStr != "" {
-		val, err := strconv.Atoi(offsetStr)
+		n, err := strconv.ParseInt(v, 10, 64)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid offset"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + key})
 			return
 		}
-		offset = val
+		*dst = n
 	}
+	opts.Limit, opts.Offset = int(limit), int(offset)
 
-	files, total, err := service.GetUserFilelist(c.Request.Context(), username, service.ListOptions{
-		Limit:  limit,
-		Offset: offset,
-	})
+	page, err := service.GetVisibleFilelist(c.Request.Context(), username, owner, opts)
 	if err != nil {
+		if writeAuthError(c, err) {
+			return
+		}
+		if errors.Is(err, service.ErrInvalidListOptions) || errors.Is(err, service.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get user file list"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"total": total,
-		"files": files,
-	})
+	resp := gin.H{
+		"files":       page.Files,
+		"next_cursor": page.NextCursor,
+		"prev_cursor": page.PrevCursor,
+	}
+	if page.Total >= 0 {
+		resp["total"] = page.Total
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// formValue 优先读取表单参数，没有时读取查询参数。
+func formValue(c *gin.Context, key string) string {
+	if v := c.PostForm(key); v != "" {
+		return v
+	}
+	return c.Query(key)
 }
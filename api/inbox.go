@@ -0,0 +1,142 @@
+package api
+
+import (
+	"errors"
+	"filestore-server/pkg/dao"
+	"filestore-server/pkg/mw"
+	"filestore-server/service"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FileSend 把调用者的文件发送给另一个用户，表单字段 to、message；对方在收件箱中接收后才会拥有该文件。
+func FileSend(c *gin.Context) {
+	username := c.GetString(mw.SessionUserKey)
+	filehash := c.GetString(mw.CtxFileHashKey)
+
+	id, err := service.SendFile(c.Request.Context(), username, c.PostForm("to"), filehash, c.PostForm("message"))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidRecipient), errors.Is(err, service.ErrMessageTooLong):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrFilesNotOwned), err.Error() == "file not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+		case errors.Is(err, service.ErrAlreadySent):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			if !writeScanError(c, err) {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send file"})
+			}
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "file sent", "id": id})
+}
+
+// InboxList 列出调用者待接收的文件。
+func InboxList(c *gin.Context) {
+	username := c.GetString(mw.SessionUserKey)
+
+	limit, err := int64Query(c, "limit")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+		return
+	}
+	offset, err := int64Query(c, "offset")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid offset"})
+		return
+	}
+
+	items, err := service.ListInbox(c.Request.Context(), username, int(limit), int(offset))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list inbox"})
+		return
+	}
+	if items == nil {
+		items = []dao.InboxItem{}
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// InboxAccept 接收收件箱中的文件，表单字段 id、dir（保存目录，缺省为根目录）。
+func InboxAccept(c *gin.Context) {
+	username := c.GetString(mw.SessionUserKey)
+	id, err := strconv.ParseInt(c.PostForm("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	fmeta, err := service.AcceptInboxItem(c.Request.Context(), username, id, c.PostForm("dir"))
+	if err != nil {
+		switch {
+		case errors.Is(err, dao.ErrInboxItemNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrInvalidBatch):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dir"})
+		case errors.Is(err, service.ErrQuotaExceeded):
+			c.JSON(http.StatusInsufficientStorage, gin.H{"error": err.Error()})
+		case errors.Is(err, dao.ErrDuplicateUserFile), errors.Is(err, dao.ErrUserFileExists):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case err.Error() == "file not found":
+			c.JSON(http.StatusGone, gin.H{"error": "file no longer available"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to accept file"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "file accepted", "file": fmeta})
+}
+
+// InboxDecline 拒收收件箱中的文件。
+func InboxDecline(c *gin.Context) {
+	username := c.GetString(mw.SessionUserKey)
+	id, err := strconv.ParseInt(c.PostForm("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	if err := service.DeclineInboxItem(c.Request.Context(), username, id); err != nil {
+		if errors.Is(err, dao.ErrInboxItemNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decline file"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "file declined"})
+}
+
+// FileMove 把 team 或 user_name 参数指定空间（默认为调用者本人）中的文件移动到该空间的 dir 目录（空为根目录），
+// 只修改路径不复制内容。
+// 同一用户的相同内容只保存一份，因此不支持在自己的目录之间复制。
+func FileMove(c *gin.Context) {
+	owner, ok := requestFileSpace(c, service.PermWrite)
+	if !ok {
+		return
+	}
+	filehash := c.GetString(mw.CtxFileHashKey)
+
+	name, err := service.MoveFile(c.Request.Context(), c.GetString(mw.SessionUserKey), owner, filehash, c.PostForm("dir"))
+	if err != nil {
+		if writeAuthError(c, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, dao.ErrUserFileNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrInvalidBatch):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dir"})
+		case errors.Is(err, dao.ErrUserFileExists):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to move file"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "file moved", "filename": name})
+}
@@ -0,0 +1,21 @@
+package api
+
+import (
+	"filestore-server/pkg/mw"
+	"filestore-server/service"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JobStatus 查询调用者创建的后台任务状态。
+func JobStatus(c *gin.Context) {
+	username := c.GetString(mw.SessionUserKey)
+
+	job, err := service.GetJob(c.Request.Context(), username, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
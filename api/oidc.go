@@ -0,0 +1,89 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"filestore-server/pkg/config"
+	"filestore-server/pkg/mw"
+	"filestore-server/service"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// OIDCLogin 跳转到身份提供方登录，state、nonce 和 PKCE 校验码保存在会话中。
+func OIDCLogin(c *gin.Context) {
+	req, err := service.BeginOIDCLogin(c.Request.Context())
+	if err != nil {
+		if errors.Is(err, service.ErrOIDCNotConfigured) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": "identity provider unavailable"})
+		return
+	}
+
+	session := sessions.Default(c)
+	session.Set(mw.SessionOIDCStateKey, req.State)
+	session.Set(mw.SessionOIDCNonceKey, req.Nonce)
+	session.Set(mw.SessionOIDCVerifierKey, req.Verifier)
+	session.Set(mw.SessionOIDCAtKey, time.Now().Unix())
+	if err := session.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save session"})
+		return
+	}
+	c.Redirect(http.StatusFound, req.URL)
+}
+
+// OIDCCallback 处理身份提供方的回调，参数 code、state。校验通过后与本地登录一样写入会话，
+// 启用了两步验证的用户仍需提交验证码。
+func OIDCCallback(c *gin.Context) {
+	session := sessions.Default(c)
+	state, _ := session.Get(mw.SessionOIDCStateKey).(string)
+	nonce, _ := session.Get(mw.SessionOIDCNonceKey).(string)
+	verifier, _ := session.Get(mw.SessionOIDCVerifierKey).(string)
+	at, _ := session.Get(mw.SessionOIDCAtKey).(int64)
+	// 登录参数只能使用一次。
+	session.Delete(mw.SessionOIDCStateKey)
+	session.Delete(mw.SessionOIDCNonceKey)
+	session.Delete(mw.SessionOIDCVerifierKey)
+	session.Delete(mw.SessionOIDCAtKey)
+	if err := session.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save session"})
+		return
+	}
+
+	if e := c.Query("error"); e != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "identity provider returned " + e})
+		return
+	}
+	ttl := config.Duration("FILESTORE_OIDC_STATE_TTL", 10*time.Minute)
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(c.Query("state"))) != 1 ||
+		time.Since(time.Unix(at, 0)) > ttl {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired login state"})
+		return
+	}
+
+	user, err := service.CompleteOIDCLogin(c.Request.Context(), c.Query("code"), verifier, nonce)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrOIDCLogin):
+			// 具体原因只写日志，避免向客户端暴露校验细节。
+			log.Printf("oidc callback: %v", err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": service.ErrOIDCLogin.Error()})
+		case errors.Is(err, service.ErrOIDCNoAccount), errors.Is(err, service.ErrUserDisabled),
+			errors.Is(err, service.ErrUserLocked):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrOIDCNotConfigured):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to login"})
+		}
+		return
+	}
+
+	finishLogin(c, user)
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"errors"
+	"filestore-server/pkg/mw"
+	"filestore-server/service"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// writePolicyError 在 err 为 *service.PolicyError 时写出 400 响应并列出违反的规则，返回是否已写出。
+func writePolicyError(c *gin.Context, err error) bool {
+	var perr *service.PolicyError
+	if !errors.As(err, &perr) {
+		return false
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": perr.Error(), "violations": perr.Violations})
+	return true
+}
+
+// PasswordChange 修改调用者的密码，表单字段 old_password、new_password。
+// 其他会话随之失效，当前会话更新为新的会话版本后继续有效。
+func PasswordChange(c *gin.Context) {
+	username := c.GetString(mw.SessionUserKey)
+
+	user, err := service.ChangePassword(c.Request.Context(), username, c.PostForm("old_password"), c.PostForm("new_password"))
+	if err != nil {
+		switch {
+		case writePolicyError(c, err):
+		case errors.Is(err, service.ErrWrongPassword):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrPasswordRequired):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to change password"})
+		}
+		return
+	}
+	if err := saveLoginSession(c, user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save session"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "password changed"})
+}
+
+// PasswordForgot 申请重置密码，表单字段 login 为用户名或已验证的邮箱。
+// 无论账户是否存在都返回相同的响应。
+func PasswordForgot(c *gin.Context) {
+	if err := service.RequestPasswordReset(c.Request.Context(), c.PostForm("login")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to request password reset"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "if the account exists, a reset token has been sent"})
+}
+
+// PasswordReset 用重置令牌设置新密码，表单字段 token、password。
+func PasswordReset(c *gin.Context) {
+	if err := service.ResetPassword(c.Request.Context(), c.PostForm("token"), c.PostForm("password")); err != nil {
+		if writePolicyError(c, err) {
+			return
+		}
+		if errors.Is(err, service.ErrInvalidResetToken) || errors.Is(err, service.ErrPasswordRequired) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset password"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "password reset"})
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"errors"
+	"filestore-server/pkg/mw"
+	"filestore-server/pkg/notify"
+	"filestore-server/service"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// writeProfileError 写出资料和联系方式接口的错误响应。
+func writeProfileError(c *gin.Context, err error, msg string) {
+	switch {
+	case errors.Is(err, service.ErrInvalidProfile), errors.Is(err, service.ErrInvalidEmail),
+		errors.Is(err, service.ErrInvalidPhone), errors.Is(err, service.ErrInvalidVerifyCode),
+		errors.Is(err, service.ErrNothingToVerify):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrVerifyCodeTooSoon):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+	}
+}
+
+// UserProfileGet 返回调用者的资料、邮箱和手机号及其验证状态。
+func UserProfileGet(c *gin.Context) {
+	username := c.GetString(mw.SessionUserKey)
+
+	profile, err := service.GetUserProfile(c.Request.Context(), username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get profile"})
+		return
+	}
+	c.JSON(http.StatusOK, profile)
+}
+
+// UserProfileUpdate 修改调用者的资料，表单字段 nickname、bio、location、website，未提交的字段保持不变。
+func UserProfileUpdate(c *gin.Context) {
+	username := c.GetString(mw.SessionUserKey)
+
+	current, err := service.GetUserProfile(c.Request.Context(), username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get profile"})
+		return
+	}
+	p := current.Profile
+	for key, dst := range map[string]*string{
+		"nickname": &p.Nickname,
+		"bio":      &p.Bio,
+		"location": &p.Location,
+		"website":  &p.Website,
+	} {
+		if v, ok := c.GetPostForm(key); ok {
+			*dst = v
+		}
+	}
+
+	if err := service.UpdateProfile(c.Request.Context(), username, p); err != nil {
+		writeProfileError(c, err, "failed to update profile")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "profile updated"})
+}
+
+// UserEmailUpdate 修改调用者的邮箱并发送验证码，表单字段 email。
+func UserEmailUpdate(c *gin.Context) {
+	username := c.GetString(mw.SessionUserKey)
+
+	if err := service.SetUserEmail(c.Request.Context(), username, c.PostForm("email")); err != nil {
+		writeProfileError(c, err, "failed to update email")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "verify code sent"})
+}
+
+// UserPhoneUpdate 修改调用者的手机号并发送验证码，表单字段 phone。
+func UserPhoneUpdate(c *gin.Context) {
+	username := c.GetString(mw.SessionUserKey)
+
+	if err := service.SetUserPhone(c.Request.Context(), username, c.PostForm("phone")); err != nil {
+		writeProfileError(c, err, "failed to update phone")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "verify code sent"})
+}
+
+// contactVerifyHandler 返回校验 channel 渠道验证码的处理函数，表单字段 code。
+func contactVerifyHandler(channel string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username := c.GetString(mw.SessionUserKey)
+
+		if err := service.VerifyContact(c.Request.Context(), username, channel, c.PostForm("code")); err != nil {
+			writeProfileError(c, err, "failed to verify code")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "verified"})
+	}
+}
+
+// contactResendHandler 返回重新发送 channel 渠道验证码的处理函数。
+func contactResendHandler(channel string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username := c.GetString(mw.SessionUserKey)
+
+		if err := service.ResendVerifyCode(c.Request.Context(), username, channel); err != nil {
+			writeProfileError(c, err, "failed to send verify code")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "verify code sent"})
+	}
+}
+
+var (
+	// UserEmailVerify 校验邮箱验证码。
+	UserEmailVerify = contactVerifyHandler(notify.ChannelEmail)
+	// UserPhoneVerify 校验手机验证码。
+	UserPhoneVerify = contactVerifyHandler(notify.ChannelSMS)
+	// UserEmailResend 重新发送邮箱验证码。
+	UserEmailResend = contactResendHandler(notify.ChannelEmail)
+	// UserPhoneResend 重新发送手机验证码。
+	UserPhoneResend = contactResendHandler(notify.ChannelSMS)
+)
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"errors"
+	"filestore-server/service"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FileSearch 搜索调用者的文件。
+// 参数：name（子串或 * ? 通配符）、min_size、max_size、from、to（日期或 RFC3339 时间，to 为日期时包含当天）、
+// mime（精确类型或 type/*）、tag（可重复，须同时带有）、q（正文全文检索）、limit、offset。
+func FileSearch(c *gin.Context) {
+	owner, ok := requestOwner(c, service.PermRead)
+	if !ok {
+		return
+	}
+
+	opts := service.SearchOptions{
+		Name:     c.Query("name"),
+		MimeType: c.Query("mime"),
+		Query:    c.Query("q"),
+		Tags:     tagParams(c),
+	}
+	var err error
+	if opts.MinSize, err = int64Query(c, "min_size"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid min_size"})
+		return
+	}
+	if opts.MaxSize, err = int64Query(c, "max_size"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid max_size"})
+		return
+	}
+	if opts.From, _, err = timeQuery(c, "from"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from"})
+		return
+	}
+	to, dateOnly, err := timeQuery(c, "to")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to"})
+		return
+	}
+	if dateOnly {
+		to = to.AddDate(0, 0, 1)
+	}
+	opts.To = to
+	limit, err := int64Query(c, "limit")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+		return
+	}
+	offset, err := int64Query(c, "offset")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid offset"})
+		return
+	}
+	opts.Limit, opts.Offset = int(limit), int(offset)
+
+	files, total, err := service.SearchUserFiles(c.Request.Context(), owner, opts)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidSearch) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to search files"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"total": total,
+		"files": files,
+	})
+}
+
+// int64Query 读取整数查询参数，未提供时返回 0。
+func int64Query(c *gin.Context, key string) (int64, error) {
+	v := c.Query(key)
+	if v == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+// timeQuery 读取 2006-01-02 或 RFC3339 格式的时间参数，dateOnly 表示只给了日期。
+func timeQuery(c *gin.Context, key string) (t time.Time, dateOnly bool, err error) {
+	v := c.Query(key)
+	if v == "" {
+		return time.Time{}, false, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, v, time.Local); err == nil {
+		return t, true, nil
+	}
+	t, err = time.Parse(time.RFC3339, v)
+	return t, false, err
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"errors"
+	"filestore-server/pkg/dao"
+	"filestore-server/pkg/mw"
+	"filestore-server/service"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FileTagAdd 给调用者的多个文件批量打标签，参数 filehash 与 tag 均可重复或逗号分隔。
+func FileTagAdd(c *gin.Context) {
+	owner, ok := requestOwner(c, service.PermWrite)
+	if !ok {
+		return
+	}
+	filehashes := c.GetStringSlice(mw.CtxFileHashesKey)
+
+	if err := service.TagFiles(c.Request.Context(), owner, filehashes, tagParams(c)); err != nil {
+		writeTagError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "tags added"})
+}
+
+// FileTagRemove 从调用者的多个文件上批量去掉标签。
+func FileTagRemove(c *gin.Context) {
+	owner, ok := requestOwner(c, service.PermWrite)
+	if !ok {
+		return
+	}
+	filehashes := c.GetStringSlice(mw.CtxFileHashesKey)
+
+	if err := service.UntagFiles(c.Request.Context(), owner, filehashes, tagParams(c)); err != nil {
+		writeTagError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "tags removed"})
+}
+
+// UserTagList 列出调用者的全部标签及各自的文件数。
+func UserTagList(c *gin.Context) {
+	owner, ok := requestOwner(c, service.PermRead)
+	if !ok {
+		return
+	}
+
+	tags, err := service.ListUserTags(c.Request.Context(), owner)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list tags"})
+		return
+	}
+	if tags == nil {
+		tags = []dao.TagCount{}
+	}
+	c.JSON(http.StatusOK, gin.H{"tags": tags})
+}
+
+// FileAttrUpdate 设置文件的自定义属性，表单字段 key、value。
+func FileAttrUpdate(c *gin.Context) {
+	owner, ok := requestOwner(c, service.PermWrite)
+	if !ok {
+		return
+	}
+	filehash := c.GetString(mw.CtxFileHashKey)
+
+	if err := service.SetFileAttr(c.Request.Context(), owner, filehash, c.PostForm("key"), c.PostForm("value")); err != nil {
+		writeTagError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "attr saved"})
+}
+
+// FileAttrDelete 删除文件的自定义属性。
+func FileAttrDelete(c *gin.Context) {
+	owner, ok := requestOwner(c, service.PermWrite)
+	if !ok {
+		return
+	}
+	filehash := c.GetString(mw.CtxFileHashKey)
+
+	if err := service.DeleteFileAttr(c.Request.Context(), owner, filehash, c.PostForm("key")); err != nil {
+		if errors.Is(err, dao.ErrAttrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		writeTagError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "attr deleted"})
+}
+
+func writeTagError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrFilesNotOwned):
+		c.JSON(http.StatusNotFound, gin.H{"error": "some files not found"})
+	case errors.Is(err, service.ErrInvalidTag), errors.Is(err, service.ErrTooManyTags),
+		errors.Is(err, service.ErrInvalidAttr), errors.Is(err, service.ErrTooManyAttrs):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update file metadata"})
+	}
+}
+
+// tagParams 读取可重复或逗号分隔的 tag 参数。
+func tagParams(c *gin.Context) []string {
+	var tags []string
+	for _, item := range append(c.PostFormArray("tag"), c.QueryArray("tag")...) {
+		for _, tag := range strings.Split(item, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
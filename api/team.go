@@ -0,0 +1,233 @@
+package api
+
+import (
+	"errors"
+	"filestore-server/pkg/dao"
+	"filestore-server/pkg/mw"
+	"filestore-server/service"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestOwner 返回本次请求操作的文件空间：带 team 参数时为该团队，否则为调用者本人。
+// 团队不存在或调用者角色不足 perm 时写出错误响应并返回 false。
+func requestOwner(c *gin.Context, perm service.Permission) (string, bool) {
+	username := c.GetString(mw.SessionUserKey)
+
+	var teamID int64
+	if v := formValue(c, "team"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid team"})
+			return "", false
+		}
+		teamID = id
+	}
+
+	owner, err := service.ResolveOwner(c.Request.Context(), username, teamID, perm)
+	if err != nil {
+		if !writeAuthError(c, err) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check team membership"})
+		}
+		return "", false
+	}
+	return owner, true
+}
+
+// requestFileSpace 返回本次请求操作的文件所在空间：team 参数指定团队空间（调用者角色须具有 perm），
+// user_name 参数指定通过 ACL 把文件共享给调用者的其他用户，都没有时为调用者本人。
+// 对文件本身的权限由 service 按该空间中的路径判断。出错时写出错误响应并返回 false。
+func requestFileSpace(c *gin.Context, perm service.Permission) (string, bool) {
+	if formValue(c, "team") != "" {
+		return requestOwner(c, perm)
+	}
+	if owner := strings.TrimSpace(formValue(c, "user_name")); owner != "" {
+		return owner, true
+	}
+	return c.GetString(mw.SessionUserKey), true
+}
+
+// writeAuthError 在 err 表示无权访问团队或文件时写出对应的错误响应并返回 true。
+func writeAuthError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, service.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": service.ErrForbidden.Error()})
+	case errors.Is(err, dao.ErrTeamNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": dao.ErrTeamNotFound.Error()})
+	default:
+		return false
+	}
+	return true
+}
+
+// teamIDParam 读取必填的 team 参数。
+func teamIDParam(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(formValue(c, "team"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid team"})
+		return 0, false
+	}
+	return id, true
+}
+
+// writeTeamError 写出团队管理接口的错误响应。
+func writeTeamError(c *gin.Context, err error) {
+	switch {
+	case writeAuthError(c, err):
+	case errors.Is(err, service.ErrInvalidTeamName), errors.Is(err, service.ErrInvalidTeamRole),
+		errors.Is(err, service.ErrInvalidRecipient):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, dao.ErrTeamMemberNotFound), errors.Is(err, dao.ErrTeamInviteNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, dao.ErrTeamMemberExists), errors.Is(err, service.ErrAlreadyInvited),
+		errors.Is(err, service.ErrLastTeamOwner):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update team"})
+	}
+}
+
+// TeamCreate 创建团队，表单字段 name，调用者成为 owner。
+func TeamCreate(c *gin.Context) {
+	username := c.GetString(mw.SessionUserKey)
+
+	team, err := service.CreateTeam(c.Request.Context(), username, c.PostForm("name"))
+	if err != nil {
+		writeTeamError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, team)
+}
+
+// TeamList 列出调用者加入的团队及其角色。
+func TeamList(c *gin.Context) {
+	username := c.GetString(mw.SessionUserKey)
+
+	teams, err := service.ListTeams(c.Request.Context(), username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list teams"})
+		return
+	}
+	if teams == nil {
+		teams = []dao.Team{}
+	}
+	c.JSON(http.StatusOK, gin.H{"teams": teams})
+}
+
+// TeamMemberList 列出团队成员。
+func TeamMemberList(c *gin.Context) {
+	username := c.GetString(mw.SessionUserKey)
+	teamID, ok := teamIDParam(c)
+	if !ok {
+		return
+	}
+
+	members, err := service.ListTeamMembers(c.Request.Context(), username, teamID)
+	if err != nil {
+		writeTeamError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"members": members})
+}
+
+// TeamInvite 邀请用户加入团队，表单字段 team、user、role（默认 viewer）。
+func TeamInvite(c *gin.Context) {
+	username := c.GetString(mw.SessionUserKey)
+	teamID, ok := teamIDParam(c)
+	if !ok {
+		return
+	}
+	role := c.DefaultPostForm("role", service.TeamRoleViewer)
+
+	id, err := service.InviteTeamMember(c.Request.Context(), username, teamID, c.PostForm("user"), role)
+	if err != nil {
+		writeTeamError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "invite sent", "id": id})
+}
+
+// TeamInviteList 列出调用者待处理的团队邀请。
+func TeamInviteList(c *gin.Context) {
+	username := c.GetString(mw.SessionUserKey)
+
+	invites, err := service.ListTeamInvites(c.Request.Context(), username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list team invites"})
+		return
+	}
+	if invites == nil {
+		invites = []dao.TeamInvite{}
+	}
+	c.JSON(http.StatusOK, gin.H{"invites": invites})
+}
+
+// TeamInviteAccept 接受团队邀请，表单字段 id。
+func TeamInviteAccept(c *gin.Context) {
+	username := c.GetString(mw.SessionUserKey)
+	id, err := strconv.ParseInt(c.PostForm("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	invite, err := service.AcceptTeamInvite(c.Request.Context(), username, id)
+	if err != nil {
+		writeTeamError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "joined team", "team": invite.TeamID, "role": invite.Role})
+}
+
+// TeamInviteDecline 拒绝团队邀请，表单字段 id。
+func TeamInviteDecline(c *gin.Context) {
+	username := c.GetString(mw.SessionUserKey)
+	id, err := strconv.ParseInt(c.PostForm("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	if err := service.DeclineTeamInvite(c.Request.Context(), username, id); err != nil {
+		writeTeamError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "invite declined"})
+}
+
+// TeamMemberRoleUpdate 修改成员角色，表单字段 team、user、role。
+func TeamMemberRoleUpdate(c *gin.Context) {
+	username := c.GetString(mw.SessionUserKey)
+	teamID, ok := teamIDParam(c)
+	if !ok {
+		return
+	}
+
+	if err := service.SetTeamMemberRole(c.Request.Context(), username, teamID, c.PostForm("user"), c.PostForm("role")); err != nil {
+		writeTeamError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "role updated"})
+}
+
+// TeamMemberRemove 把成员移出团队，表单字段 team、user；user 为空或为调用者本人时表示退出团队。
+func TeamMemberRemove(c *gin.Context) {
+	username := c.GetString(mw.SessionUserKey)
+	teamID, ok := teamIDParam(c)
+	if !ok {
+		return
+	}
+	member := c.PostForm("user")
+	if member == "" {
+		member = username
+	}
+
+	if err := service.RemoveTeamMember(c.Request.Context(), username, teamID, member); err != nil {
+		writeTeamError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "member removed"})
+}
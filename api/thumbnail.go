@@ -0,0 +1,50 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"filestore-server/pkg/mw"
+	"filestore-server/service"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FileThumbnail 返回图片或 PDF 文件的缩略图，size 为缩略图边长（像素）。
+func FileThumbnail(c *gin.Context) {
+	filesha1 := c.GetString(mw.CtxFileHashKey)
+
+	size := service.DefaultThumbnailSize
+	if sizeStr := c.Query("size"); sizeStr != "" {
+		val, err := strconv.Atoi(sizeStr)
+		if err != nil || !service.ValidThumbnailSize(val) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid size"})
+			return
+		}
+		size = val
+	}
+
+	data, err := service.GetThumbnail(c.Request.Context(), c.GetString(mw.SessionUserKey), filesha1, size)
+	if err != nil {
+		if writeScanError(c, err) || writeAuthError(c, err) {
+			return
+		}
+		switch {
+		case err.Error() == "file not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": "meta not found"})
+		case errors.Is(err, service.ErrNoPreview):
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": service.ErrNoPreview.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate thumbnail"})
+		}
+		return
+	}
+
+	// 缩略图由内容 sha1 决定，内容不变缩略图就不变。
+	c.Header("Content-Type", "image/jpeg")
+	c.Header("Cache-Control", "private, max-age=86400")
+	c.Header("ETag", "\""+filesha1+"_"+strconv.Itoa(size)+"\"")
+	http.ServeContent(c.Writer, c.Request, "", time.Time{}, bytes.NewReader(data))
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"errors"
+	"filestore-server/pkg/config"
+	"filestore-server/pkg/dao"
+	"filestore-server/pkg/mw"
+	"filestore-server/service"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// savePendingLogin 清除原有会话，记录密码已校验、等待两步验证码的用户。
+func savePendingLogin(c *gin.Context, user dao.User) error {
+	session := sessions.Default(c)
+	session.Clear()
+	session.Set(mw.SessionPendingUserKey, user.UserName)
+	session.Set(mw.SessionPendingVersionKey, user.SessionVersion)
+	session.Set(mw.SessionPendingAtKey, time.Now().Unix())
+	return session.Save()
+}
+
+// pendingLoginUser 返回未过期的待验证用户，FILESTORE_2FA_PENDING_TTL 为密码校验后提交验证码的期限。
+func pendingLoginUser(c *gin.Context) (string, int, bool) {
+	session := sessions.Default(c)
+	username, _ := session.Get(mw.SessionPendingUserKey).(string)
+	version, _ := session.Get(mw.SessionPendingVersionKey).(int)
+	at, _ := session.Get(mw.SessionPendingAtKey).(int64)
+	ttl := config.Duration("FILESTORE_2FA_PENDING_TTL", 5*time.Minute)
+	if username == "" || time.Since(time.Unix(at, 0)) > ttl {
+		return "", 0, false
+	}
+	return username, version, true
+}
+
+// writeTwoFactorError 把两步验证相关的错误转换为响应。
+func writeTwoFactorError(c *gin.Context, err error, msg string) {
+	switch {
+	case errors.Is(err, service.ErrInvalidTOTPCode), errors.Is(err, service.ErrPasswordRequired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrWrongPassword), errors.Is(err, service.ErrTOTPRequired):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrTOTPAlreadyEnabled), errors.Is(err, service.ErrTOTPNotEnabled),
+		errors.Is(err, service.ErrTOTPNotEnrolled):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+	}
+}
+
+// LoginSecondFactor 完成两步登录，表单字段 code 为验证器应用生成的 6 位验证码或恢复码。
+func LoginSecondFactor(c *gin.Context) {
+	username, version, ok := pendingLoginUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "no pending login or login expired"})
+		return
+	}
+
+	user, err := service.LoginSecondFactor(c.Request.Context(), username, c.PostForm("code"), c.ClientIP())
+	if err != nil {
+		if errors.Is(err, service.ErrTOTPNotEnabled) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "no pending login or login expired"})
+			return
+		}
+		writeLoginError(c, err)
+		return
+	}
+	// 密码或两步验证在两步之间被修改、重置时会话版本已变化，需要重新登录。
+	if user.SessionVersion != version {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "no pending login or login expired"})
+		return
+	}
+
+	if err := saveLoginSession(c, user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save session"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "login success"})
+}
+
+// TwoFactorStatus 返回调用者两步验证的状态。
+func TwoFactorStatus(c *gin.Context) {
+	status, err := service.GetTOTPStatus(c.Request.Context(), c.GetString(mw.SessionUserKey))
+	if err != nil {
+		writeTwoFactorError(c, err, "failed to get two-factor status")
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// TwoFactorEnroll 生成待启用的 TOTP 密钥，返回密钥及 otpauth:// 地址。
+func TwoFactorEnroll(c *gin.Context) {
+	enrollment, err := service.EnrollTOTP(c.Request.Context(), c.GetString(mw.SessionUserKey))
+	if err != nil {
+		writeTwoFactorError(c, err, "failed to enroll two-factor authentication")
+		return
+	}
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// TwoFactorConfirm 用表单字段 code 确认密钥并启用两步验证，返回恢复码。
+func TwoFactorConfirm(c *gin.Context) {
+	codes, err := service.ConfirmTOTP(c.Request.Context(), c.GetString(mw.SessionUserKey), c.PostForm("code"))
+	if err != nil {
+		writeTwoFactorError(c, err, "failed to enable two-factor authentication")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication enabled", "recovery_codes": codes})
+}
+
+// TwoFactorDisable 关闭两步验证，表单字段 password、code（验证码或恢复码）。
+// 其他会话随之失效，当前会话更新为新的会话版本后继续有效。
+func TwoFactorDisable(c *gin.Context) {
+	user, err := service.DisableTOTP(c.Request.Context(), c.GetString(mw.SessionUserKey), c.PostForm("password"), c.PostForm("code"))
+	if err != nil {
+		writeTwoFactorError(c, err, "failed to disable two-factor authentication")
+		return
+	}
+	if err := saveLoginSession(c, user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save session"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication disabled"})
+}
+
+// TwoFactorRecoveryCodes 用表单字段 code 中的验证码重新生成恢复码。
+func TwoFactorRecoveryCodes(c *gin.Context) {
+	codes, err := service.RegenerateRecoveryCodes(c.Request.Context(), c.GetString(mw.SessionUserKey), c.PostForm("code"))
+	if err != nil {
+		writeTwoFactorError(c, err, "failed to regenerate recovery codes")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// AdminUserTwoFactorRequire 设置是否要求用户启用两步验证，表单字段 required 为 true 或 false。
+func AdminUserTwoFactorRequire(c *gin.Context) {
+	required, err := strconv.ParseBool(c.PostForm("required"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid required"})
+		return
+	}
+	actor := c.GetString(mw.SessionUserKey)
+	if err := service.SetUserTOTPRequired(c.Request.Context(), actor, c.Param("name"), required); err != nil {
+		writeUserAdminError(c, err, "failed to update two-factor requirement")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor requirement updated", "required": required})
+}
+
+// AdminUserTwoFactorReset 关闭用户的两步验证，用于用户丢失验证器的情况。
+func AdminUserTwoFactorReset(c *gin.Context) {
+	actor := c.GetString(mw.SessionUserKey)
+	if err := service.ResetUserTOTP(c.Request.Context(), actor, c.Param("name")); err != nil {
+		writeUserAdminError(c, err, "failed to reset two-factor authentication")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication reset"})
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"errors"
+	"filestore-server/pkg/dao"
+	"filestore-server/pkg/mw"
+	"filestore-server/service"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FileVersionList 列出当前用户某个文件路径的历史版本。
+func FileVersionList(c *gin.Context) {
+	owner, ok := requestOwner(c, service.PermRead)
+	if !ok {
+		return
+	}
+	filename := c.GetString(mw.CtxFilenameKey)
+
+	versions, err := service.ListFileVersions(c.Request.Context(), owner, filename)
+	if err != nil {
+		if err.Error() == "file not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list file versions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"filename": filename,
+		"versions": versions,
+	})
+}
+
+// FileVersionDownload 按 sha1 下载某个文件路径的指定版本。
+func FileVersionDownload(c *gin.Context) {
+	owner, ok := requestOwner(c, service.PermRead)
+	if !ok {
+		return
+	}
+	filename := c.GetString(mw.CtxFilenameKey)
+	filehash := c.GetString(mw.CtxFileHashKey)
+
+	fmeta, data, err := service.DownloadFileVersion(c.Request.Context(), owner, filename, filehash, acceptsEncoding(c))
+	if err != nil {
+		if writeScanError(c, err) {
+			return
+		}
+		if errors.Is(err, dao.ErrVersionNotFound) || err.Error() == "file not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "version not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read file"})
+		return
+	}
+
+	defer data.Close()
+
+	serveFile(c, fmeta, data)
+}
+
+// FileVersionRestore 把指定版本恢复为当前版本。
+func FileVersionRestore(c *gin.Context) {
+	owner, ok := requestOwner(c, service.PermWrite)
+	if !ok {
+		return
+	}
+	filename := c.GetString(mw.CtxFilenameKey)
+	filehash := c.GetString(mw.CtxFileHashKey)
+
+	if err := service.RestoreFileVersion(c.Request.Context(), owner, filename, filehash); err != nil {
+		switch {
+		case errors.Is(err, dao.ErrVersionNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "version not found"})
+		case errors.Is(err, dao.ErrDuplicateUserFile):
+			c.JSON(http.StatusConflict, gin.H{"error": dao.ErrDuplicateUserFile.Error()})
+		case errors.Is(err, service.ErrQuotaExceeded):
+			c.JSON(http.StatusInsufficientStorage, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to restore file version"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "restore success"})
+}
+
+// VersionPolicyUpdate 设置当前用户每个文件路径保留的版本数。
+func VersionPolicyUpdate(c *gin.Context) {
+	username := c.GetString(mw.SessionUserKey)
+
+	maxVersions, err := strconv.Atoi(c.PostForm("max_versions"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid max_versions"})
+		return
+	}
+
+	if err := service.SetMaxVersions(c.Request.Context(), username, maxVersions); err != nil {
+		if errors.Is(err, service.ErrInvalidMaxVersions) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "max_versions must be between 0 and " + strconv.Itoa(service.MaxVersionsLimit)})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update version policy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "update success", "max_versions": maxVersions})
+}
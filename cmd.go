@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"filestore-server/service"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// runFsck 实现 `filestore fsck` 子命令：发现问题且未修复时返回非零退出码。
+func runFsck(args []string) int {
+	opts := service.DefaultFsckOptions()
+
+	fs := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	fs.BoolVar(&opts.Repair, "repair", opts.Repair, "repair found problems")
+	fs.BoolVar(&opts.Quarantine, "quarantine", opts.Quarantine, "move orphan and corrupt blobs to quarantine instead of deleting them")
+	fs.Int64Var(&opts.BytesPerSec, "rate", opts.BytesPerSec, "max bytes per second read while verifying sha1 (0 = unlimited)")
+	fs.DurationVar(&opts.OrphanGrace, "grace", opts.OrphanGrace, "skip orphan blobs modified within this duration")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	report, err := service.Fsck(context.Background(), opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fsck failed:", err)
+		return 1
+	}
+
+	out, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(out))
+	if !report.Clean() && !opts.Repair {
+		return 1
+	}
+	return 0
+}
+
+// runReindex 实现 `filestore reindex` 子命令：为已有文件重建全文索引。
+func runReindex() int {
+	indexed, err := service.ReindexAll(context.Background())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "reindex failed:", err)
+		return 1
+	}
+	fmt.Printf("indexed %d files\n", indexed)
+	return 0
+}
+
+// runRotateKeys 实现 `filestore rotate-keys` 子命令：用当前主密钥重新包装所有数据密钥。
+func runRotateKeys() int {
+	rotated, err := service.RotateKeys(context.Background())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "rotate keys failed:", err)
+		return 1
+	}
+	fmt.Printf("rewrapped %d data keys\n", rotated)
+	return 0
+}
+
+// runSetRole 实现 `filestore set-role <user> <role>` 子命令，用于指定第一个管理员。
+func runSetRole(args []string) int {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: filestore set-role <user> <user|admin>")
+		return 2
+	}
+	if err := service.SetUserRole(context.Background(), "cli", args[0], args[1]); err != nil {
+		fmt.Fprintln(os.Stderr, "set role failed:", err)
+		return 1
+	}
+	fmt.Printf("%s is now %s\n", args[0], args[1])
+	return 0
+}
@@ -8,6 +8,13 @@ CREATE TABLE `tbl_file` (
   `create_at` datetime default NOW() COMMENT '创建日期',
   `update_at` datetime default NOW() on update current_timestamp() COMMENT '更新日期',
   `status` int(11) NOT NULL DEFAULT '0' COMMENT '状态(可用/禁用/已删除等状态)',
+  `key_id` varchar(64) NOT NULL DEFAULT '' COMMENT '包装数据密钥的主密钥ID(空表示未加密)',
+  `enc_key` varchar(256) NOT NULL DEFAULT '' COMMENT '被主密钥包装的数据密钥',
+  `compression` varchar(16) NOT NULL DEFAULT '' COMMENT '落盘压缩算法(空表示未压缩)',
+  `stored_size` bigint(20) NOT NULL DEFAULT '0' COMMENT '落盘后实际占用大小',
+  `store_state` int(11) NOT NULL DEFAULT '0' COMMENT '存储位置(0本地1二级存储)',
+  `mime_type` varchar(128) NOT NULL DEFAULT '' COMMENT '按内容识别的文件类型',
+  `scan_state` int(11) NOT NULL DEFAULT '0' COMMENT '病毒扫描状态(0通过1待扫描2已感染)',
   `ext1` int(11) DEFAULT '0' COMMENT '备用字段1',
   `ext2` text COMMENT '备用字段2',
   PRIMARY KEY (`id`),
@@ -17,6 +24,25 @@ CREATE TABLE `tbl_file` (
 
 
 
+-- 创建派生内容表，记录由文件生成的缩略图等内容的存储位置，加密和转存方式与 tbl_file 相同
+CREATE TABLE `tbl_file_derived` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `file_sha1` char(40) NOT NULL DEFAULT '' COMMENT '源文件hash',
+  `kind` varchar(32) NOT NULL DEFAULT '' COMMENT '派生类型(如thumb_256)',
+  `blob_key` varchar(128) NOT NULL DEFAULT '' COMMENT '派生内容的存储键',
+  `file_addr` varchar(1024) NOT NULL DEFAULT '' COMMENT '存储位置',
+  `file_size` bigint(20) NOT NULL DEFAULT '0' COMMENT '明文大小',
+  `stored_size` bigint(20) NOT NULL DEFAULT '0' COMMENT '落盘后实际占用大小',
+  `key_id` varchar(64) NOT NULL DEFAULT '' COMMENT '包装数据密钥的主密钥ID(空表示未加密)',
+  `enc_key` varchar(256) NOT NULL DEFAULT '' COMMENT '被主密钥包装的数据密钥',
+  `compression` varchar(16) NOT NULL DEFAULT '' COMMENT '落盘压缩算法(空表示未压缩)',
+  `store_state` int(11) NOT NULL DEFAULT '0' COMMENT '存储位置(0本地1二级存储)',
+  `create_at` datetime default NOW() COMMENT '生成时间',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_file_kind` (`file_sha1`, `kind`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+
 -- 创建用户表
 CREATE TABLE `tbl_user` (
   `id` int(11) NOT NULL AUTO_INCREMENT,
@@ -29,7 +55,14 @@ CREATE TABLE `tbl_user` (
   `signup_at` datetime DEFAULT CURRENT_TIMESTAMP COMMENT '注册日期',
   `last_active` datetime DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT '最后活跃时间戳',
   `profile` text COMMENT '用户属性',
-  `status` int(11) NOT NULL DEFAULT '0' COMMENT '账户状态(启用/禁用/锁定/标记删除等)',
+  `status` int(11) NOT NULL DEFAULT '0' COMMENT '账户状态(0或1启用/2禁用/3锁定)',
+  `max_versions` int(11) NOT NULL DEFAULT '0' COMMENT '每个文件保留的历史版本数(0使用系统默认)',
+  `role` varchar(16) NOT NULL DEFAULT 'user' COMMENT '角色(user/admin)',
+  `group_name` varchar(64) NOT NULL DEFAULT 'default' COMMENT '用户组',
+  `quota` bigint(20) NOT NULL DEFAULT '0' COMMENT '存储配额(字节,0使用系统默认)',
+  `session_version` int(11) NOT NULL DEFAULT '0' COMMENT '会话版本(修改密码时递增,使旧会话失效)',
+  `totp_enabled` tinyint(1) NOT NULL DEFAULT '0' COMMENT '是否已启用TOTP两步验证',
+  `totp_required` tinyint(1) NOT NULL DEFAULT '0' COMMENT '管理员是否要求启用两步验证',
   PRIMARY KEY (`id`),
   UNIQUE KEY `idx_username` (`user_name`),
   KEY `idx_status` (`status`)
@@ -50,4 +83,193 @@ CREATE TABLE `tbl_user_file` (
   UNIQUE KEY `idx_user_file` (`user_name`, `file_sha1`),
   KEY `idx_status` (`status`),
   KEY `idx_user_id` (`user_name`)
-) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
\ No newline at end of file
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+
+-- 创建用户文件版本表
+CREATE TABLE `tbl_user_file_version` (
+  `id` int(11) NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  `user_name` varchar(64) NOT NULL,
+  `file_name` varchar(256) NOT NULL DEFAULT '' COMMENT '用户文件路径',
+  `version` int(11) NOT NULL DEFAULT '0' COMMENT '版本号',
+  `file_sha1` varchar(64) NOT NULL DEFAULT '' COMMENT '该版本的文件hash',
+  `file_size` bigint(20) DEFAULT '0' COMMENT '文件大小',
+  `create_at` datetime DEFAULT CURRENT_TIMESTAMP COMMENT '版本创建时间',
+  `status` int(11) NOT NULL DEFAULT '0' COMMENT '版本状态(0保留1已清理)',
+  UNIQUE KEY `idx_user_file_version` (`user_name`, `file_name`, `version`),
+  KEY `idx_user_file_sha1` (`user_name`, `file_name`, `file_sha1`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+
+-- 创建上传类型策略表
+CREATE TABLE `tbl_mime_policy` (
+  `id` int(11) NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  `group_name` varchar(64) NOT NULL DEFAULT '' COMMENT '用户组',
+  `mime_type` varchar(128) NOT NULL DEFAULT '' COMMENT '文件类型(支持type/*通配)',
+  `action` varchar(8) NOT NULL DEFAULT '' COMMENT '规则(allow/deny)',
+  UNIQUE KEY `idx_group_mime` (`group_name`, `mime_type`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+
+-- 创建审计日志表
+CREATE TABLE `tbl_audit_log` (
+  `id` bigint(20) NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  `actor` varchar(64) NOT NULL DEFAULT '' COMMENT '操作者(system表示系统)',
+  `action` varchar(64) NOT NULL DEFAULT '' COMMENT '操作',
+  `target` varchar(256) NOT NULL DEFAULT '' COMMENT '操作对象',
+  `detail` text COMMENT '详情',
+  `create_at` datetime DEFAULT CURRENT_TIMESTAMP COMMENT '记录时间',
+  KEY `idx_target` (`target`),
+  KEY `idx_create_at` (`create_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+
+-- 创建用户文件标签表
+CREATE TABLE `tbl_user_file_tag` (
+  `id` bigint(20) NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  `user_name` varchar(64) NOT NULL,
+  `file_sha1` varchar(64) NOT NULL DEFAULT '' COMMENT '文件hash',
+  `tag` varchar(64) NOT NULL DEFAULT '' COMMENT '标签(按用户隔离)',
+  `create_at` datetime DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+  UNIQUE KEY `idx_user_file_tag` (`user_name`, `file_sha1`, `tag`),
+  KEY `idx_user_tag` (`user_name`, `tag`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+
+-- 创建用户文件自定义属性表
+CREATE TABLE `tbl_user_file_attr` (
+  `id` bigint(20) NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  `user_name` varchar(64) NOT NULL,
+  `file_sha1` varchar(64) NOT NULL DEFAULT '' COMMENT '文件hash',
+  `attr_key` varchar(64) NOT NULL DEFAULT '' COMMENT '属性名',
+  `attr_value` varchar(1024) NOT NULL DEFAULT '' COMMENT '属性值',
+  UNIQUE KEY `idx_user_file_attr` (`user_name`, `file_sha1`, `attr_key`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+
+-- 创建批量操作幂等记录表
+CREATE TABLE `tbl_idempotency` (
+  `id` bigint(20) NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  `user_name` varchar(64) NOT NULL,
+  `idem_key` varchar(128) NOT NULL COMMENT '客户端提供的幂等键',
+  `request_hash` char(64) NOT NULL DEFAULT '' COMMENT '请求内容的sha256',
+  `response` mediumblob COMMENT '第一次执行的结果',
+  `create_at` datetime DEFAULT CURRENT_TIMESTAMP COMMENT '记录时间',
+  UNIQUE KEY `idx_user_key` (`user_name`, `idem_key`),
+  KEY `idx_create_at` (`create_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+
+-- 创建用户收件箱表（其他用户发送的待接收文件）
+CREATE TABLE `tbl_user_inbox` (
+  `id` bigint(20) NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  `sender` varchar(64) NOT NULL COMMENT '发送者',
+  `recipient` varchar(64) NOT NULL COMMENT '接收者',
+  `file_sha1` varchar(64) NOT NULL DEFAULT '' COMMENT '文件hash',
+  `file_name` varchar(256) NOT NULL DEFAULT '' COMMENT '文件名',
+  `file_size` bigint(20) NOT NULL DEFAULT '0' COMMENT '文件大小',
+  `message` varchar(2048) NOT NULL DEFAULT '' COMMENT '附言',
+  `status` int(11) NOT NULL DEFAULT '0' COMMENT '状态(0待接收1已接收2已拒收)',
+  `create_at` datetime DEFAULT CURRENT_TIMESTAMP COMMENT '发送时间',
+  KEY `idx_recipient_status` (`recipient`, `status`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+
+-- 创建团队表，团队文件在 tbl_user_file 中以 "team:<id>" 作为 user_name 保存
+CREATE TABLE `tbl_team` (
+  `id` bigint(20) NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  `name` varchar(64) NOT NULL DEFAULT '' COMMENT '团队名称',
+  `create_at` datetime DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间'
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+
+-- 创建团队成员表
+CREATE TABLE `tbl_team_member` (
+  `id` bigint(20) NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  `team_id` bigint(20) NOT NULL,
+  `user_name` varchar(64) NOT NULL,
+  `role` varchar(16) NOT NULL DEFAULT 'viewer' COMMENT '角色(owner/admin/editor/viewer)',
+  `join_at` datetime DEFAULT CURRENT_TIMESTAMP COMMENT '加入时间',
+  UNIQUE KEY `idx_team_user` (`team_id`, `user_name`),
+  KEY `idx_user` (`user_name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+
+-- 创建团队邀请表
+CREATE TABLE `tbl_team_invite` (
+  `id` bigint(20) NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  `team_id` bigint(20) NOT NULL,
+  `inviter` varchar(64) NOT NULL COMMENT '邀请者',
+  `invitee` varchar(64) NOT NULL COMMENT '被邀请者',
+  `role` varchar(16) NOT NULL DEFAULT 'viewer' COMMENT '加入后的角色',
+  `status` int(11) NOT NULL DEFAULT '0' COMMENT '状态(0待处理1已接受2已拒绝)',
+  `create_at` datetime DEFAULT CURRENT_TIMESTAMP COMMENT '邀请时间',
+  KEY `idx_invitee_status` (`invitee`, `status`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+
+-- 创建访问控制表，把某个空间中文件或目录的权限授予用户或团队
+CREATE TABLE `tbl_acl` (
+  `id` bigint(20) NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  `owner` varchar(64) NOT NULL COMMENT '文件所在空间(用户名或team:<id>)',
+  `path` varchar(256) NOT NULL DEFAULT '' COMMENT '文件路径或目录,空表示根目录',
+  `principal` varchar(64) NOT NULL COMMENT '被授权的用户名或team:<id>',
+  `permission` varchar(16) NOT NULL DEFAULT 'read' COMMENT '权限(read/write)',
+  `inherit` tinyint(1) NOT NULL DEFAULT 0 COMMENT '目录权限是否对子孙目录生效',
+  `create_by` varchar(64) NOT NULL DEFAULT '' COMMENT '授权人',
+  `create_at` datetime DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT '授权时间',
+  UNIQUE KEY `idx_owner_path_principal` (`owner`, `path`, `principal`),
+  KEY `idx_owner_principal` (`owner`, `principal`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+
+-- 创建验证码表，保存确认邮箱或手机号的一次性验证码
+CREATE TABLE `tbl_user_verify_code` (
+  `user_name` varchar(64) NOT NULL COMMENT '用户名',
+  `channel` varchar(16) NOT NULL COMMENT '渠道(email/sms)',
+  `target` varchar(128) NOT NULL DEFAULT '' COMMENT '待验证的邮箱或手机号',
+  `code_hash` char(64) NOT NULL DEFAULT '' COMMENT '验证码的sha256',
+  `attempts` int(11) NOT NULL DEFAULT '0' COMMENT '错误尝试次数',
+  `expire_at` datetime NOT NULL COMMENT '过期时间',
+  `create_at` datetime NOT NULL COMMENT '发送时间',
+  PRIMARY KEY (`user_name`, `channel`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+
+-- 创建重置密码令牌表，令牌只保存哈希，使用后删除
+CREATE TABLE `tbl_password_reset` (
+  `token_hash` char(64) NOT NULL PRIMARY KEY COMMENT '令牌的sha256',
+  `user_name` varchar(64) NOT NULL COMMENT '用户名',
+  `expire_at` datetime NOT NULL COMMENT '过期时间',
+  `create_at` datetime NOT NULL COMMENT '申请时间',
+  KEY `idx_user_name` (`user_name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- 创建TOTP密钥表，确认前为待启用的密钥，配置主密钥时密钥加密保存
+CREATE TABLE `tbl_user_totp` (
+  `user_name` varchar(64) NOT NULL PRIMARY KEY COMMENT '用户名',
+  `key_id` varchar(64) NOT NULL DEFAULT '' COMMENT '加密密钥的主密钥ID(空表示未加密)',
+  `secret` varchar(256) NOT NULL COMMENT 'TOTP密钥(base32或加密后的base64)',
+  `last_step` bigint(20) NOT NULL DEFAULT '0' COMMENT '最近一次使用的时间步,防止验证码重放',
+  `create_at` datetime NOT NULL COMMENT '创建时间'
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- 创建两步验证恢复码表，恢复码只保存哈希，使用一次后删除
+CREATE TABLE `tbl_user_recovery_code` (
+  `user_name` varchar(64) NOT NULL COMMENT '用户名',
+  `code_hash` char(64) NOT NULL COMMENT '恢复码的sha256',
+  `create_at` datetime NOT NULL COMMENT '生成时间',
+  PRIMARY KEY (`user_name`, `code_hash`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- 创建外部身份表，记录OIDC身份提供方的用户(issuer+subject)关联的本地用户
+CREATE TABLE `tbl_user_identity` (
+  `issuer` varchar(255) NOT NULL COMMENT '身份提供方issuer',
+  `subject` varchar(255) NOT NULL COMMENT '身份提供方中的用户ID(sub)',
+  `user_name` varchar(64) NOT NULL COMMENT '关联的用户名',
+  `email` varchar(64) NOT NULL DEFAULT '' COMMENT '身份提供方返回的已验证邮箱',
+  `create_at` datetime NOT NULL COMMENT '关联时间',
+  `last_login_at` datetime DEFAULT NULL COMMENT '最近一次登录时间',
+  PRIMARY KEY (`issuer`, `subject`),
+  KEY `idx_user_name` (`user_name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
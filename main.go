@@ -1,8 +1,31 @@
 package main
 
-import "filestore-server/pkg/router"
+import (
+	"context"
+	"filestore-server/pkg/config"
+	"filestore-server/pkg/router"
+	"filestore-server/service"
+	"os"
+)
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "fsck":
+			os.Exit(runFsck(os.Args[2:]))
+		case "rotate-keys":
+			os.Exit(runRotateKeys())
+		case "reindex":
+			os.Exit(runReindex())
+		case "set-role":
+			os.Exit(runSetRole(os.Args[2:]))
+		}
+	}
+
+	if interval := config.Duration("FILESTORE_FSCK_INTERVAL", 0); interval > 0 {
+		service.StartFsckScheduler(context.Background(), interval, service.DefaultFsckOptions())
+	}
+
 	r := router.New()
 	r.Run(":8080")
 }
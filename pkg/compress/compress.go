@@ -0,0 +1,134 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// Zstd 和 Gzip 同时也是对应的 HTTP Content-Encoding 名称。
+	Zstd = "zstd"
+	Gzip = "gzip"
+
+	// SampleSize 是判断是否值得压缩时采样的字节数。
+	SampleSize = 64 << 10
+	// minSize 以下的文件压缩收益太小，直接跳过。
+	minSize = 1 << 10
+	// maxRatio 是采样压缩后体积与原体积之比的上限，超过则认为不值得压缩。
+	maxRatio = 0.9
+)
+
+// incompressible 是本身已压缩、再压缩基本没有收益的类型前缀。
+var incompressible = []string{
+	"image/jpeg", "image/png", "image/gif", "image/webp",
+	"video/", "audio/",
+	"application/zip", "application/gzip", "application/x-gzip",
+	"application/x-7z-compressed", "application/x-rar-compressed",
+	"application/x-xz", "application/x-bzip2", "application/zstd",
+	"application/pdf",
+}
+
+// compressible 是通常压缩率很高的文本类类型。
+var compressible = []string{
+	"text/", "application/json", "application/xml", "application/javascript",
+	"application/x-ndjson", "application/yaml", "application/x-yaml",
+}
+
+// Supported 判断 alg 是否是支持的压缩算法。
+func Supported(alg string) bool {
+	return alg == Zstd || alg == Gzip
+}
+
+// ShouldCompress 根据文件扩展名、内容嗅探出的 MIME 类型以及采样压缩率决定是否压缩。
+func ShouldCompress(filename string, sample []byte) bool {
+	if len(sample) < minSize {
+		return false
+	}
+
+	types := []string{http.DetectContentType(sample)}
+	if byExt := mime.TypeByExtension(strings.ToLower(path.Ext(filename))); byExt != "" {
+		types = append(types, byExt)
+	}
+	for _, t := range types {
+		if hasAnyPrefix(t, incompressible) {
+			return false
+		}
+	}
+	for _, t := range types {
+		if hasAnyPrefix(t, compressible) {
+			return true
+		}
+	}
+
+	// 类型不明确时用采样试压缩。
+	var buf bytes.Buffer
+	w, _ := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	w.Write(sample)
+	w.Close()
+	return float64(buf.Len()) < float64(len(sample))*maxRatio
+}
+
+// NewWriter 返回把压缩数据写入 w 的 writer，Close 时写出剩余数据但不关闭 w。
+func NewWriter(alg string, w io.Writer) (io.WriteCloser, error) {
+	switch alg {
+	case Zstd:
+		return zstd.NewWriter(w)
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	}
+	return nil, fmt.Errorf("unsupported compression: %s", alg)
+}
+
+// NewReader 返回解压 r 的 reader，Close 时同时关闭 r（如果它实现了 io.Closer）。
+func NewReader(alg string, r io.Reader) (io.ReadCloser, error) {
+	var dec io.ReadCloser
+	switch alg {
+	case Zstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		dec = zr.IOReadCloser()
+	case Gzip:
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		dec = gr
+	default:
+		return nil, fmt.Errorf("unsupported compression: %s", alg)
+	}
+	return &readCloser{ReadCloser: dec, src: r}, nil
+}
+
+type readCloser struct {
+	io.ReadCloser
+	src io.Reader
+}
+
+func (r *readCloser) Close() error {
+	err := r.ReadCloser.Close()
+	if c, ok := r.src.(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
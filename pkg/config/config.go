@@ -0,0 +1,54 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// String 读取环境变量，未设置时返回默认值。
+func String(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return strings.TrimSpace(v)
+	}
+	return def
+}
+
+// Int 读取整数类型的环境变量，未设置或格式错误时返回默认值。
+func Int(key string, def int) int {
+	v, err := strconv.Atoi(String(key, ""))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// Int64 读取 int64 类型的环境变量，未设置或格式错误时返回默认值。
+func Int64(key string, def int64) int64 {
+	v, err := strconv.ParseInt(String(key, ""), 10, 64)
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// Bool 读取布尔类型的环境变量（1/true/on 等），未设置或格式错误时返回默认值。
+func Bool(key string, def bool) bool {
+	switch strings.ToLower(String(key, "")) {
+	case "1", "true", "yes", "on":
+		return true
+	case "0", "false", "no", "off":
+		return false
+	}
+	return def
+}
+
+// Duration 读取时长类型的环境变量（如 30s、1h），未设置或格式错误时返回默认值。
+func Duration(key string, def time.Duration) time.Duration {
+	v, err := time.ParseDuration(String(key, ""))
+	if err != nil {
+		return def
+	}
+	return v
+}
@@ -0,0 +1,168 @@
+package crypt
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DataKeySize 是每个文件随机生成的数据密钥长度（AES-256）。
+const DataKeySize = 32
+
+// ErrUnknownKey 表示找不到包装数据密钥时使用的主密钥。
+var ErrUnknownKey = errors.New("unknown master key")
+
+// Keyring 保存所有可用的主密钥，新数据密钥总是用 active 主密钥包装。
+type Keyring struct {
+	keys   map[string][]byte
+	active string
+}
+
+// NewKeyring 创建密钥环，active 必须是 keys 中的一个。
+func NewKeyring(keys map[string][]byte, active string) (*Keyring, error) {
+	for id, key := range keys {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("master key %s must be 32 bytes, got %d", id, len(key))
+		}
+	}
+	if _, ok := keys[active]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, active)
+	}
+	return &Keyring{keys: keys, active: active}, nil
+}
+
+// LoadKeyring 从配置加载密钥环：key 为单个 base64 主密钥（ID 为 default），
+// keyFile 为每行 `<id>:<base64 key>` 的密钥文件；activeID 为空时使用文件中最后一个密钥。
+func LoadKeyring(key, keyFile, activeID string) (*Keyring, error) {
+	keys := make(map[string][]byte)
+	lastID := ""
+
+	if key != "" {
+		raw, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid master key: %w", err)
+		}
+		keys["default"] = raw
+		lastID = "default"
+	}
+
+	if keyFile != "" {
+		f, err := os.Open(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open key file: %w", err)
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			id, encoded, ok := strings.Cut(line, ":")
+			if !ok || id == "" {
+				return nil, fmt.Errorf("invalid key file line: %q", line)
+			}
+			raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+			if err != nil {
+				return nil, fmt.Errorf("invalid master key %s: %w", id, err)
+			}
+			keys[id] = raw
+			lastID = id
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read key file: %w", err)
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no master key configured")
+	}
+	if activeID == "" {
+		activeID = lastID
+	}
+	return NewKeyring(keys, activeID)
+}
+
+// ActiveID 返回当前用于包装新数据密钥的主密钥 ID。
+func (k *Keyring) ActiveID() string {
+	return k.active
+}
+
+// NewDataKey 生成随机数据密钥并用 active 主密钥包装，返回明文密钥、主密钥 ID 和包装后的密钥。
+func (k *Keyring) NewDataKey() ([]byte, string, string, error) {
+	dataKey := make([]byte, DataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, "", "", fmt.Errorf("failed to generate data key: %w", err)
+	}
+	wrapped, err := k.Wrap(k.active, dataKey)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return dataKey, k.active, wrapped, nil
+}
+
+// Wrap 用指定主密钥包装数据密钥，结果为 base64(nonce || ciphertext)。
+func (k *Keyring) Wrap(keyID string, dataKey []byte) (string, error) {
+	aead, err := k.aead(keyID)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, dataKey, []byte(keyID))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Unwrap 用 keyID 对应的主密钥解开被包装的数据密钥。
+func (k *Keyring) Unwrap(keyID, wrapped string) ([]byte, error) {
+	aead, err := k.aead(keyID)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wrapped key: %w", err)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("invalid wrapped key: too short")
+	}
+	dataKey, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	return dataKey, nil
+}
+
+// Rewrap 把用旧主密钥包装的数据密钥改用 active 主密钥包装，不涉及文件内容。
+func (k *Keyring) Rewrap(keyID, wrapped string) (string, string, error) {
+	dataKey, err := k.Unwrap(keyID, wrapped)
+	if err != nil {
+		return "", "", err
+	}
+	rewrapped, err := k.Wrap(k.active, dataKey)
+	if err != nil {
+		return "", "", err
+	}
+	return k.active, rewrapped, nil
+}
+
+func (k *Keyring) aead(keyID string) (cipher.AEAD, error) {
+	key, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,187 @@
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ChunkSize 是每个加密分块的明文长度。每块独立做 AES-GCM，下载 Range 时只需解密涉及的分块。
+const ChunkSize = 64 << 10
+
+const tagSize = 16
+
+// EncryptedSize 返回明文长度为 plainSize 时加密后的文件长度。
+func EncryptedSize(plainSize int64) int64 {
+	return plainSize + chunkCount(plainSize)*tagSize
+}
+
+func chunkCount(plainSize int64) int64 {
+	if plainSize == 0 {
+		return 1
+	}
+	return (plainSize + ChunkSize - 1) / ChunkSize
+}
+
+// chunkNonce 由分块序号和是否为最后一块组成；数据密钥每个文件唯一，因此 nonce 不会重复。
+// 最后一块带标记，防止密文被截断后仍能通过校验。
+func chunkNonce(index int64, final bool) []byte {
+	nonce := make([]byte, 12)
+	if final {
+		nonce[0] = 1
+	}
+	binary.BigEndian.PutUint64(nonce[4:], uint64(index))
+	return nonce
+}
+
+func newAEAD(dataKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+type encryptWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	buf   []byte
+	index int64
+}
+
+// NewEncryptWriter 返回按分块加密写入 w 的 writer，必须调用 Close 写出最后一块。
+func NewEncryptWriter(w io.Writer, dataKey []byte) (io.WriteCloser, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, buf: make([]byte, 0, ChunkSize+1)}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := ChunkSize + 1 - len(e.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		e.buf = append(e.buf, p[:n]...)
+		p = p[n:]
+		written += n
+
+		// 缓冲超过一个分块时才写出，保证最后一块留到 Close 时带上结束标记。
+		if len(e.buf) > ChunkSize {
+			if err := e.flush(e.buf[:ChunkSize], false); err != nil {
+				return written, err
+			}
+			e.buf = append(e.buf[:0], e.buf[ChunkSize:]...)
+		}
+	}
+	return written, nil
+}
+
+func (e *encryptWriter) flush(chunk []byte, final bool) error {
+	sealed := e.aead.Seal(nil, chunkNonce(e.index, final), chunk, nil)
+	e.index++
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+func (e *encryptWriter) Close() error {
+	return e.flush(e.buf, true)
+}
+
+// DecryptReader 解密分块加密的文件，支持 Seek，可直接交给 http.ServeContent 处理 Range 请求。
+type DecryptReader struct {
+	r        io.ReadSeeker
+	aead     cipher.AEAD
+	size     int64
+	pos      int64
+	chunkIdx int64
+	chunk    []byte
+}
+
+// NewDecryptReader 创建解密 reader，plainSize 为明文长度。
+func NewDecryptReader(r io.ReadSeeker, dataKey []byte, plainSize int64) (*DecryptReader, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &DecryptReader{r: r, aead: aead, size: plainSize, chunkIdx: -1}, nil
+}
+
+func (d *DecryptReader) Read(p []byte) (int, error) {
+	if d.pos >= d.size {
+		return 0, io.EOF
+	}
+
+	idx := d.pos / ChunkSize
+	if idx != d.chunkIdx {
+		if err := d.load(idx); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.chunk[d.pos-idx*ChunkSize:])
+	d.pos += int64(n)
+	return n, nil
+}
+
+func (d *DecryptReader) load(idx int64) error {
+	if _, err := d.r.Seek(idx*(ChunkSize+tagSize), io.SeekStart); err != nil {
+		return err
+	}
+	sealed := make([]byte, ChunkSize+tagSize)
+	n, err := io.ReadFull(d.r, sealed)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+
+	final := idx == chunkCount(d.size)-1
+	chunk, err := d.aead.Open(nil, chunkNonce(idx, final), sealed[:n], nil)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt chunk %d: %w", idx, err)
+	}
+	d.chunk = chunk
+	d.chunkIdx = idx
+	return nil
+}
+
+func (d *DecryptReader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = d.pos + offset
+	case io.SeekEnd:
+		abs = d.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence")
+	}
+	if abs < 0 {
+		return 0, fmt.Errorf("negative position")
+	}
+	d.pos = abs
+	return abs, nil
+}
+
+// Close 关闭底层 reader（如果它实现了 io.Closer）。
+func (d *DecryptReader) Close() error {
+	if c, ok := d.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// PlainSize 是 EncryptedSize 的逆运算，由加密文件长度推出明文长度。
+func PlainSize(encryptedSize int64) int64 {
+	chunks := (encryptedSize + ChunkSize + tagSize - 1) / (ChunkSize + tagSize)
+	if chunks == 0 {
+		chunks = 1
+	}
+	return encryptedSize - chunks*tagSize
+}
@@ -0,0 +1,158 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"filestore-server/pkg/db"
+	"fmt"
+	"strings"
+)
+
+// ACL 授予的权限。
+const (
+	ACLRead  = "read"
+	ACLWrite = "write"
+)
+
+// ErrACLNotFound 表示访问控制条目不存在。
+var ErrACLNotFound = errors.New("acl entry not found")
+
+// ACLEntry 是一条访问控制条目：把 Owner 空间中 Path（文件或目录）的权限授予 Principal。
+// Principal 为用户名或 "team:<id>"；Inherit 为 true 时目录条目对所有子孙目录中的文件生效，否则只对直接位于该目录的文件生效。
+type ACLEntry struct {
+	ID         int64
+	Owner      string
+	Path       string
+	Principal  string
+	Permission string
+	Inherit    bool
+	CreateBy   string
+	CreateAt   string
+}
+
+// PathScope 是文件列表的路径范围：路径本身，或路径作为目录时其中的文件；Recursive 为 true 时包括子孙目录。
+// Path 为空表示根目录。
+type PathScope struct {
+	Path      string
+	Recursive bool
+}
+
+// SetACLEntry 新增或覆盖同一空间、路径和主体的访问控制条目。
+func SetACLEntry(ctx context.Context, entry ACLEntry) error {
+	const sqlStr = "insert into tbl_acl (`owner`,`path`,`principal`,`permission`,`inherit`,`create_by`) values (?,?,?,?,?,?) " +
+		"on duplicate key update `permission`=values(`permission`),`inherit`=values(`inherit`),`create_by`=values(`create_by`)"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	_, err := conn.ExecContext(ctx, sqlStr, entry.Owner, entry.Path, entry.Principal, entry.Permission, entry.Inherit, entry.CreateBy)
+	if err != nil {
+		return fmt.Errorf("failed to save acl entry: %w", err)
+	}
+	return nil
+}
+
+// DeleteACLEntry 删除一条访问控制条目。
+func DeleteACLEntry(ctx context.Context, owner, path, principal string) error {
+	conn := db.DBconn()
+	if conn == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	result, err := conn.ExecContext(ctx, "delete from tbl_acl where owner=? and path=? and principal=?", owner, path, principal)
+	if err != nil {
+		return fmt.Errorf("failed to delete acl entry: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rows == 0 {
+		return ErrACLNotFound
+	}
+	return nil
+}
+
+// GetACLEntries 返回设置在 owner 空间中某个路径上的全部条目。
+func GetACLEntries(ctx context.Context, owner, path string) ([]ACLEntry, error) {
+	return queryACLEntries(ctx, "owner=? and path=?", owner, path)
+}
+
+// GetPrincipalACLEntries 返回 owner 空间中授予 principals 的条目；paths 非空时只返回设置在这些路径上的条目。
+func GetPrincipalACLEntries(ctx context.Context, owner string, principals, paths []string) ([]ACLEntry, error) {
+	if len(principals) == 0 {
+		return nil, nil
+	}
+	where := "owner=? and principal in (" + placeholders(len(principals)) + ")"
+	args := []any{owner}
+	for _, p := range principals {
+		args = append(args, p)
+	}
+	if len(paths) > 0 {
+		where += " and path in (" + placeholders(len(paths)) + ")"
+		for _, p := range paths {
+			args = append(args, p)
+		}
+	}
+	return queryACLEntries(ctx, where, args...)
+}
+
+func queryACLEntries(ctx context.Context, where string, args ...any) ([]ACLEntry, error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return nil, fmt.Errorf("db connection is nil")
+	}
+
+	sqlStr := "select id,owner,path,principal,permission,inherit,create_by,create_at from tbl_acl where " + where + " order by id"
+	rows, err := conn.QueryContext(ctx, sqlStr, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query acl entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []ACLEntry
+	for rows.Next() {
+		var e ACLEntry
+		var createAt sql.NullTime
+		if err := rows.Scan(&e.ID, &e.Owner, &e.Path, &e.Principal, &e.Permission, &e.Inherit, &e.CreateBy, &createAt); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		if createAt.Valid {
+			e.CreateAt = createAt.Time.Format("2006-01-02 15:04:05")
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return entries, nil
+}
+
+// pathScopesWhere 把路径范围转换为 SQL 条件，多个范围之间为或的关系。
+func pathScopesWhere(scopes []PathScope) (string, []any) {
+	escape := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace
+
+	var conds []string
+	var args []any
+	for _, s := range scopes {
+		var cond []string
+		prefix := ""
+		if s.Path != "" {
+			cond = append(cond, "uf.file_name=?")
+			args = append(args, s.Path)
+			prefix = escape(s.Path) + "/"
+		}
+		if s.Recursive {
+			cond = append(cond, "uf.file_name like ?")
+			args = append(args, prefix+"%")
+		} else {
+			cond = append(cond, "(uf.file_name like ? and uf.file_name not like ?)")
+			args = append(args, prefix+"%", prefix+"%/%")
+		}
+		conds = append(conds, strings.Join(cond, " or "))
+	}
+	return "(" + strings.Join(conds, " or ") + ")", args
+}
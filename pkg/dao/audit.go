@@ -0,0 +1,67 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"filestore-server/pkg/db"
+	"fmt"
+)
+
+// AuditLog 是一条审计记录。
+type AuditLog struct {
+	// Actor 为操作者，系统自动执行的操作为 "system"。
+	Actor  string
+	Action string
+	// Target 为被操作的对象，如文件 sha1 或用户名。
+	Target   string
+	Detail   string
+	CreateAt string
+}
+
+// InsertAuditLog 写入一条审计记录。
+func InsertAuditLog(ctx context.Context, entry AuditLog) error {
+	const sqlStr = "insert into tbl_audit_log (`actor`,`action`,`target`,`detail`) values (?,?,?,?)"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	if _, err := conn.ExecContext(ctx, sqlStr, entry.Actor, entry.Action, entry.Target, entry.Detail); err != nil {
+		return fmt.Errorf("failed to insert audit log: %w", err)
+	}
+	return nil
+}
+
+// GetAuditLogs 按时间倒序返回某个对象的审计记录。
+func GetAuditLogs(ctx context.Context, target string, limit int) ([]AuditLog, error) {
+	const sqlStr = "select actor,action,target,detail,create_at from tbl_audit_log where target=? order by id desc limit ?"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return nil, fmt.Errorf("db connection is nil")
+	}
+
+	rows, err := conn.QueryContext(ctx, sqlStr, target, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit logs: %w", err)
+	}
+	defer rows.Close()
+
+	var logs []AuditLog
+	for rows.Next() {
+		var entry AuditLog
+		var createAt sql.NullTime
+		if err := rows.Scan(&entry.Actor, &entry.Action, &entry.Target, &entry.Detail, &createAt); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		if createAt.Valid {
+			entry.CreateAt = createAt.Time.Format("2006-01-02 15:04:05")
+		}
+		logs = append(logs, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return logs, nil
+}
@@ -0,0 +1,252 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"filestore-server/pkg/db"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrUserFileNotFound 表示用户没有该文件。
+	ErrUserFileNotFound = errors.New("file not found")
+	// ErrUserFileExists 表示用户在目标路径下已有文件。
+	ErrUserFileExists = errors.New("target file already exists")
+	// ErrIdempotencyKeyExists 表示幂等键已被另一个请求使用。
+	ErrIdempotencyKeyExists = errors.New("idempotency key already used")
+)
+
+// IdempotencyRecord 是一个幂等键对应的请求摘要和已返回的响应。
+type IdempotencyRecord struct {
+	RequestHash string
+	Response    []byte
+}
+
+// GetIdempotencyRecord 查询用户在 ttl 内保存的幂等记录，不存在时 ok 为 false。
+func GetIdempotencyRecord(ctx context.Context, username, key string, ttl time.Duration) (rec IdempotencyRecord, ok bool, err error) {
+	const sqlStr = "select request_hash,response from tbl_idempotency where user_name=? and idem_key=? and create_at>=now()-interval ? second limit 1"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return IdempotencyRecord{}, false, fmt.Errorf("db connection is nil")
+	}
+
+	err = conn.QueryRowContext(ctx, sqlStr, username, key, int64(ttl.Seconds())).Scan(&rec.RequestHash, &rec.Response)
+	if err == sql.ErrNoRows {
+		return IdempotencyRecord{}, false, nil
+	}
+	if err != nil {
+		return IdempotencyRecord{}, false, fmt.Errorf("failed to query idempotency record: %w", err)
+	}
+	return rec, true, nil
+}
+
+// UserFileTx 在一个事务内修改同一用户的多个文件，每个操作可以用保存点单独回滚。
+type UserFileTx struct {
+	tx       *sql.Tx
+	username string
+}
+
+// BeginUserFileTx 开始修改 username 文件的事务。
+func BeginUserFileTx(ctx context.Context, username string) (*UserFileTx, error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return nil, fmt.Errorf("db connection is nil")
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin tx: %w", err)
+	}
+	return &UserFileTx{tx: tx, username: username}, nil
+}
+
+// Savepoint 设置保存点，name 由调用方保证只含字母数字和下划线。
+func (t *UserFileTx) Savepoint(ctx context.Context, name string) error {
+	if _, err := t.tx.ExecContext(ctx, "savepoint "+name); err != nil {
+		return fmt.Errorf("failed to create savepoint: %w", err)
+	}
+	return nil
+}
+
+// RollbackTo 撤销保存点之后的修改。
+func (t *UserFileTx) RollbackTo(ctx context.Context, name string) error {
+	if _, err := t.tx.ExecContext(ctx, "rollback to savepoint "+name); err != nil {
+		return fmt.Errorf("failed to rollback to savepoint: %w", err)
+	}
+	return nil
+}
+
+func (t *UserFileTx) Commit() error {
+	if err := t.tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit tx: %w", err)
+	}
+	return nil
+}
+
+func (t *UserFileTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+// lockUserFile 锁定用户的有效文件，返回其 id 和路径。
+func (t *UserFileTx) lockUserFile(ctx context.Context, filehash string) (int64, string, error) {
+	const sqlStr = "select id,file_name from tbl_user_file where user_name=? and file_sha1=? and status=0 limit 1 for update"
+	var id int64
+	var name string
+	err := t.tx.QueryRowContext(ctx, sqlStr, t.username, filehash).Scan(&id, &name)
+	if err == sql.ErrNoRows {
+		return 0, "", ErrUserFileNotFound
+	}
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to query user file meta: %w", err)
+	}
+	return id, name, nil
+}
+
+// FileName 返回用户文件当前的路径。
+func (t *UserFileTx) FileName(ctx context.Context, filehash string) (string, error) {
+	_, name, err := t.lockUserFile(ctx, filehash)
+	return name, err
+}
+
+// DeleteUserFile 从用户的文件中删除 filehash，连同该路径的版本、标签和自定义属性，内容本身不受影响。
+// tbl_user_file 的唯一键包含已删除的记录，因此直接删除行，保证之后还能再次保存相同内容。
+// 返回本次删除的记录引用过的所有内容 sha1（当前内容和各历史版本），调用方提交后据此回收内容。
+func (t *UserFileTx) DeleteUserFile(ctx context.Context, filehash string) ([]string, error) {
+	id, name, err := t.lockUserFile(ctx, filehash)
+	if err != nil {
+		return nil, err
+	}
+
+	released := []string{filehash}
+	const versionsSQL = "select distinct file_sha1 from tbl_user_file_version where user_name=? and file_name=? and status=0 and file_sha1<>?"
+	rows, err := t.tx.QueryContext(ctx, versionsSQL, t.username, name, filehash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query file versions: %w", err)
+	}
+	for rows.Next() {
+		var sha1 string
+		if err := rows.Scan(&sha1); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		released = append(released, sha1)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	stmts := []struct {
+		sql  string
+		args []any
+	}{
+		{"delete from tbl_user_file where id=?", []any{id}},
+		{"delete from tbl_user_file_version where user_name=? and file_name=?", []any{t.username, name}},
+		{"delete from tbl_user_file_tag where user_name=? and file_sha1=?", []any{t.username, filehash}},
+		{"delete from tbl_user_file_attr where user_name=? and file_sha1=?", []any{t.username, filehash}},
+	}
+	for _, s := range stmts {
+		if _, err := t.tx.ExecContext(ctx, s.sql, s.args...); err != nil {
+			return nil, fmt.Errorf("failed to delete user file: %w", err)
+		}
+	}
+	return released, nil
+}
+
+// RenameUserFile 把用户文件移动到新路径，版本历史随之移动；目标路径已有文件时返回 ErrUserFileExists。
+func (t *UserFileTx) RenameUserFile(ctx context.Context, filehash, newName string) error {
+	id, oldName, err := t.lockUserFile(ctx, filehash)
+	if err != nil {
+		return err
+	}
+	if oldName == newName {
+		return nil
+	}
+
+	var exists int
+	const existsSQL = "select count(*) from tbl_user_file where user_name=? and file_name=? and status=0 and id<>?"
+	if err := t.tx.QueryRowContext(ctx, existsSQL, t.username, newName, id).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to query user file meta: %w", err)
+	}
+	if exists > 0 {
+		return ErrUserFileExists
+	}
+
+	stmts := []struct {
+		sql  string
+		args []any
+	}{
+		// 目标路径上没有有效文件，残留的版本记录不再属于任何文件。
+		{"delete from tbl_user_file_version where user_name=? and file_name=?", []any{t.username, newName}},
+		{"update tbl_user_file set file_name=? where id=?", []any{newName, id}},
+		{"update tbl_user_file_version set file_name=? where user_name=? and file_name=?", []any{newName, t.username, oldName}},
+	}
+	for _, s := range stmts {
+		if _, err := t.tx.ExecContext(ctx, s.sql, s.args...); err != nil {
+			return fmt.Errorf("failed to rename user file: %w", err)
+		}
+	}
+	return nil
+}
+
+// AddTags 给用户文件打上标签，返回操作后的标签数。
+func (t *UserFileTx) AddTags(ctx context.Context, filehash string, tags []string) (int, error) {
+	if _, _, err := t.lockUserFile(ctx, filehash); err != nil {
+		return 0, err
+	}
+	values := make([]string, 0, len(tags))
+	args := make([]any, 0, len(tags)*3)
+	for _, tag := range tags {
+		values = append(values, "(?,?,?)")
+		args = append(args, t.username, filehash, tag)
+	}
+	sqlStr := "insert ignore into tbl_user_file_tag (`user_name`,`file_sha1`,`tag`) values " + strings.Join(values, ",")
+	if _, err := t.tx.ExecContext(ctx, sqlStr, args...); err != nil {
+		return 0, fmt.Errorf("failed to add file tags: %w", err)
+	}
+
+	var count int
+	const countSQL = "select count(*) from tbl_user_file_tag where user_name=? and file_sha1=?"
+	if err := t.tx.QueryRowContext(ctx, countSQL, t.username, filehash).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count file tags: %w", err)
+	}
+	return count, nil
+}
+
+// RemoveTags 从用户文件上去掉标签。
+func (t *UserFileTx) RemoveTags(ctx context.Context, filehash string, tags []string) error {
+	if _, _, err := t.lockUserFile(ctx, filehash); err != nil {
+		return err
+	}
+	args := make([]any, 0, len(tags)+2)
+	args = append(args, t.username, filehash)
+	for _, tag := range tags {
+		args = append(args, tag)
+	}
+	sqlStr := "delete from tbl_user_file_tag where user_name=? and file_sha1=? and tag in (" + placeholders(len(tags)) + ")"
+	if _, err := t.tx.ExecContext(ctx, sqlStr, args...); err != nil {
+		return fmt.Errorf("failed to remove file tags: %w", err)
+	}
+	return nil
+}
+
+// SaveIdempotency 在同一事务中记录幂等键和响应，并清理该键超过 ttl 的旧记录。
+// 键已被并发请求使用时返回 ErrIdempotencyKeyExists。
+func (t *UserFileTx) SaveIdempotency(ctx context.Context, key, requestHash string, response []byte, ttl time.Duration) error {
+	const cleanSQL = "delete from tbl_idempotency where user_name=? and idem_key=? and create_at<now()-interval ? second"
+	if _, err := t.tx.ExecContext(ctx, cleanSQL, t.username, key, int64(ttl.Seconds())); err != nil {
+		return fmt.Errorf("failed to clean idempotency record: %w", err)
+	}
+
+	const sqlStr = "insert into tbl_idempotency (`user_name`,`idem_key`,`request_hash`,`response`) values (?,?,?,?)"
+	if _, err := t.tx.ExecContext(ctx, sqlStr, t.username, key, requestHash, response); err != nil {
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			return ErrIdempotencyKeyExists
+		}
+		return fmt.Errorf("failed to save idempotency record: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"filestore-server/pkg/db"
+	"fmt"
+)
+
+// ErrDerivedNotFound 表示文件还没有生成该类派生内容。
+var ErrDerivedNotFound = errors.New("derived blob not found")
+
+const derivedColumns = "blob_key,file_addr,file_size,stored_size,key_id,enc_key,compression,store_state"
+
+// GetDerivedBlob 返回文件 kind 类派生内容（如缩略图）的存储信息。
+// 返回值沿用 FileMeta 描述存储：FileSha1 为派生内容的存储键，FileSize 为明文大小。
+func GetDerivedBlob(ctx context.Context, fileSha1, kind string) (FileMeta, error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return FileMeta{}, fmt.Errorf("db connection is nil")
+	}
+
+	var blob FileMeta
+	err := conn.QueryRowContext(ctx, "select "+derivedColumns+" from tbl_file_derived where file_sha1=? and kind=?", fileSha1, kind).
+		Scan(&blob.FileSha1, &blob.Location, &blob.FileSize, &blob.StoredSize, &blob.KeyID, &blob.EncKey, &blob.Compression, &blob.StoreState)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return FileMeta{}, ErrDerivedNotFound
+		}
+		return FileMeta{}, fmt.Errorf("failed to query derived blob: %w", err)
+	}
+	return blob, nil
+}
+
+// SaveDerivedBlob 记录文件 kind 类派生内容的存储信息，覆盖之前生成的记录。
+func SaveDerivedBlob(ctx context.Context, fileSha1, kind string, blob FileMeta) error {
+	const sqlStr = "insert into tbl_file_derived (`file_sha1`,`kind`," + derivedColumns + ") values (?,?,?,?,?,?,?,?,?,?) " +
+		"on duplicate key update blob_key=values(blob_key),file_addr=values(file_addr),file_size=values(file_size),stored_size=values(stored_size)," +
+		"key_id=values(key_id),enc_key=values(enc_key),compression=values(compression),store_state=values(store_state)"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	_, err := conn.ExecContext(ctx, sqlStr, fileSha1, kind, blob.FileSha1, blob.Location, blob.FileSize, blob.StoredSize,
+		blob.KeyID, blob.EncKey, blob.Compression, blob.StoreState)
+	if err != nil {
+		return fmt.Errorf("failed to save derived blob: %w", err)
+	}
+	return nil
+}
+
+// GetDerivedBlobs 返回文件的全部派生内容。
+func GetDerivedBlobs(ctx context.Context, fileSha1 string) ([]FileMeta, error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return nil, fmt.Errorf("db connection is nil")
+	}
+
+	rows, err := conn.QueryContext(ctx, "select "+derivedColumns+" from tbl_file_derived where file_sha1=?", fileSha1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query derived blobs: %w", err)
+	}
+	defer rows.Close()
+
+	var blobs []FileMeta
+	for rows.Next() {
+		var blob FileMeta
+		if err := rows.Scan(&blob.FileSha1, &blob.Location, &blob.FileSize, &blob.StoredSize, &blob.KeyID, &blob.EncKey, &blob.Compression, &blob.StoreState); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		blobs = append(blobs, blob)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return blobs, nil
+}
+
+// DeleteDerivedBlobs 删除文件全部派生内容的记录。
+func DeleteDerivedBlobs(ctx context.Context, fileSha1 string) error {
+	conn := db.DBconn()
+	if conn == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	if _, err := conn.ExecContext(ctx, "delete from tbl_file_derived where file_sha1=?", fileSha1); err != nil {
+		return fmt.Errorf("failed to delete derived blobs: %w", err)
+	}
+	return nil
+}
@@ -5,6 +5,10 @@ import (
 	"database/sql"
 	"filestore-server/pkg/db"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type FileMeta struct {
@@ -13,17 +17,88 @@ type FileMeta struct {
 	FileSize int64
 	Location string
 	UploadAt string
+	// KeyID 为包装数据密钥的主密钥 ID，为空表示文件未加密。
+	KeyID  string `json:"-"`
+	EncKey string `json:"-"`
+	// Compression 为落盘时使用的压缩算法，为空表示未压缩。
+	Compression string
+	// StoredSize 为落盘后（压缩、加密后）的实际大小，FileSize 始终是原始大小。
+	StoredSize int64
+	// MimeType 为上传时按内容识别出的类型。
+	MimeType string
+	// StoreState 表示内容当前所在的存储位置，见 StoreLocal、StoreRemote。
+	StoreState int `json:"-"`
+	// ScanState 为病毒扫描状态，见 ScanClean、ScanPending、ScanInfected。
+	ScanState int
+	// Tags、Attrs 为当前用户给文件设置的标签和自定义属性，只在按用户查询时填充。
+	Tags  []string          `json:",omitempty"`
+	Attrs map[string]string `json:",omitempty"`
+	// LastUpdate 为用户文件的最后修改时间，只在按用户查询时填充。
+	LastUpdate time.Time `json:"-"`
+}
+
+const (
+	// ScanClean 表示扫描通过或未开启扫描，可以正常下载。
+	ScanClean = 0
+	// ScanPending 表示等待扫描，扫描通过前不允许下载。
+	ScanPending = 1
+	// ScanInfected 表示扫描发现病毒，内容已被隔离。
+	ScanInfected = 2
+)
+
+const (
+	// StoreLocal 表示内容在本地磁盘上，file_addr 为本地路径。
+	StoreLocal = 0
+	// StoreRemote 表示内容已转存到二级存储，file_addr 为对象地址，对象 key 为 file_sha1。
+	StoreRemote = 1
+)
+
+// fileMetaColumns 与 scanFileMeta 的字段顺序一一对应。
+const fileMetaColumns = "file_sha1,file_addr,file_name,file_size,key_id,enc_key,compression,stored_size,store_state,mime_type,scan_state"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanFileMeta(row rowScanner, f *FileMeta, extra ...any) error {
+	dest := append(extra, &f.FileSha1, &f.Location, &f.FileName, &f.FileSize, &f.KeyID, &f.EncKey, &f.Compression, &f.StoredSize, &f.StoreState, &f.MimeType, &f.ScanState)
+	return row.Scan(dest...)
 }
 
 func SaveFileMeta(ctx context.Context, fileHash string, filename string, filesize int64, fileaddr string) error {
-	const sqlStr = "insert ignore into tbl_file (`file_sha1`,`file_name`,`file_size`,`file_addr`,`status`) values(?,?,?,?,0)"
+	return InsertFileMeta(ctx, FileMeta{
+		FileSha1: fileHash,
+		FileName: filename,
+		FileSize: filesize,
+		Location: fileaddr,
+	})
+}
+
+// InsertFileMeta 写入完整的文件元信息，包括加密相关字段。同一 sha1 已有已删除或损坏的记录时用新的内容覆盖它，
+// 使回收或 fsck 下线过的内容可以重新上传；已有可用记录时返回错误。
+func InsertFileMeta(ctx context.Context, fmeta FileMeta) error {
+	// MySQL 按顺序执行赋值，status 必须放在最后，前面的条件才能看到原来的状态。
+	const sqlStr = "insert into tbl_file (`file_sha1`,`file_name`,`file_size`,`file_addr`,`key_id`,`enc_key`,`compression`,`stored_size`,`mime_type`,`scan_state`,`status`) values(?,?,?,?,?,?,?,?,?,?,0)" +
+		" on duplicate key update" +
+		" file_name=if(status<>0,values(file_name),file_name)," +
+		" file_size=if(status<>0,values(file_size),file_size)," +
+		" file_addr=if(status<>0,values(file_addr),file_addr)," +
+		" key_id=if(status<>0,values(key_id),key_id)," +
+		" enc_key=if(status<>0,values(enc_key),enc_key)," +
+		" compression=if(status<>0,values(compression),compression)," +
+		" stored_size=if(status<>0,values(stored_size),stored_size)," +
+		" store_state=if(status<>0,0,store_state)," +
+		" mime_type=if(status<>0,values(mime_type),mime_type)," +
+		" scan_state=if(status<>0,values(scan_state),scan_state)," +
+		" status=0"
 
 	conn := db.DBconn()
 	if conn == nil {
 		return fmt.Errorf("db connection is nil")
 	}
 
-	result, err := conn.ExecContext(ctx, sqlStr, fileHash, filename, filesize, fileaddr)
+	fileHash := fmeta.FileSha1
+	result, err := conn.ExecContext(ctx, sqlStr, fmeta.FileSha1, fmeta.FileName, fmeta.FileSize, fmeta.Location, fmeta.KeyID, fmeta.EncKey, fmeta.Compression, fmeta.StoredSize, fmeta.MimeType, fmeta.ScanState)
 	if err != nil {
 		return fmt.Errorf("failed to insert file meta: %w", err)
 	}
@@ -42,7 +117,7 @@ func SaveFileMeta(ctx context.Context, fileHash string, filename string, filesiz
 }
 
 func GetFileMeta(ctx context.Context, fileHash string) (FileMeta, error) {
-	const sqlStr = "select file_sha1,file_addr,file_name,file_size from tbl_file where file_sha1=? and status=0 limit 1"
+	const sqlStr = "select " + fileMetaColumns + " from tbl_file where file_sha1=? and status=0 limit 1"
 
 	conn := db.DBconn()
 	if conn == nil {
@@ -51,7 +126,7 @@ func GetFileMeta(ctx context.Context, fileHash string) (FileMeta, error) {
 
 	tableFile := FileMeta{}
 
-	err := conn.QueryRowContext(ctx, sqlStr, fileHash).Scan(&tableFile.FileSha1, &tableFile.Location, &tableFile.FileName, &tableFile.FileSize)
+	err := scanFileMeta(conn.QueryRowContext(ctx, sqlStr, fileHash), &tableFile)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// 查不到记录，返回空结构体和 nil 错误
@@ -137,6 +212,51 @@ func RestoreFileMeta(ctx context.Context, fileHash string) error {
 	return nil
 }
 
+// DeleteUnreferencedFileMeta 在内容没有任何引用时将其元信息标记为已删除，返回被删除前的元信息。
+// 计数和删除在同一事务内、持有 tbl_file 行锁进行，并发的秒传保存引用时会先对该行加共享锁，
+// 因此不会删掉刚被引用的内容。内容仍被引用或已不可用时 deleted 为 false。
+func DeleteUnreferencedFileMeta(ctx context.Context, fileHash string) (fmeta FileMeta, deleted bool, err error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return FileMeta{}, false, fmt.Errorf("db connection is nil")
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return FileMeta{}, false, fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	const lockSQL = "select " + fileMetaColumns + " from tbl_file where file_sha1=? and status=0 limit 1 for update"
+	err = scanFileMeta(tx.QueryRowContext(ctx, lockSQL, fileHash), &fmeta)
+	if err == sql.ErrNoRows {
+		return FileMeta{}, false, nil
+	}
+	if err != nil {
+		return FileMeta{}, false, fmt.Errorf("failed to query file meta: %w", err)
+	}
+
+	// 统计的一致性快照在拿到行锁之后才建立，能看到等锁期间已提交的引用。
+	const countSQL = "select (select count(*) from tbl_user_file where file_sha1=? and status=0)" +
+		"+(select count(*) from tbl_user_file_version where file_sha1=? and status=0)" +
+		"+(select count(*) from tbl_user_inbox where file_sha1=? and status=0)"
+	var refs int
+	if err := tx.QueryRowContext(ctx, countSQL, fileHash, fileHash, fileHash).Scan(&refs); err != nil {
+		return FileMeta{}, false, fmt.Errorf("failed to count file references: %w", err)
+	}
+	if refs > 0 {
+		return FileMeta{}, false, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, "update tbl_file set status=1 where file_sha1=? and status=0", fileHash); err != nil {
+		return FileMeta{}, false, fmt.Errorf("failed to delete file meta: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return FileMeta{}, false, fmt.Errorf("failed to commit tx: %w", err)
+	}
+	return fmeta, true, nil
+}
+
 func InsertUserFileMeta(ctx context.Context, username, fileSha1 string, fileSize int64, fileName string) error {
 	const sqlStr = "insert ignore into tbl_user_file (`user_name`,`file_sha1`,`file_size`,`file_name`,`status`) values (?,?,?,?,0)"
 	conn := db.DBconn()
@@ -162,46 +282,165 @@ func InsertUserFileMeta(ctx context.Context, username, fileSha1 string, fileSize
 	return nil
 }
 
-func GetUserFilelist(ctx context.Context, username string, limit, offset int) ([]FileMeta, int, error) {
+// 用户文件列表的排序字段。
+const (
+	SortByName       = "name"
+	SortBySize       = "size"
+	SortByUploadAt   = "upload_at"
+	SortByLastUpdate = "last_update"
+)
+
+var userFileSortColumns = map[string]string{
+	SortByName:       "uf.file_name",
+	SortBySize:       "uf.file_size",
+	SortByUploadAt:   "uf.upload_at",
+	SortByLastUpdate: "uf.last_update",
+}
+
+// ValidUserFileSort 判断 sortBy 是否为支持的排序字段。
+func ValidUserFileSort(sortBy string) bool {
+	_, ok := userFileSortColumns[sortBy]
+	return ok
+}
+
+// UserFileKey 是一条记录在排序中的位置：排序字段的值加上 id 作为并列时的次序。
+type UserFileKey struct {
+	Value string
+	ID    int64
+}
+
+// UserFileQuery 是查询用户文件列表的条件，零值字段表示不限。
+type UserFileQuery struct {
+	// SortBy 为排序字段，见 SortBy* 常量，为空时按 last_update 排序。
+	SortBy string
+	Desc   bool
+	// NamePrefix 为文件名前缀。
+	NamePrefix string
+	MinSize    int64
+	// MaxSize <= 0 表示不限。
+	MaxSize int64
+	// Scopes 非空时只返回位于这些路径范围内的文件，用于列出他人共享的部分文件。
+	Scopes []PathScope
+	// After、Before 非 nil 时按键集分页，只返回排在该位置之后或之前的记录，且不统计总数；
+	// 否则按 Offset 分页。
+	After  *UserFileKey
+	Before *UserFileKey
+	Limit  int
+	Offset int
+}
+
+// GetUserFilelist 按条件查询用户的文件列表，返回按排序的记录、每条记录的位置以及总数（键集分页时为 -1）。
+func GetUserFilelist(ctx context.Context, username string, q UserFileQuery) ([]FileMeta, []UserFileKey, int, error) {
 	conn := db.DBconn()
 	if conn == nil {
-		return nil, 0, fmt.Errorf("db connection is nil")
+		return nil, nil, 0, fmt.Errorf("db connection is nil")
+	}
+
+	if q.SortBy == "" {
+		q.SortBy = SortByLastUpdate
+	}
+	col, ok := userFileSortColumns[q.SortBy]
+	if !ok {
+		return nil, nil, 0, fmt.Errorf("invalid sort field %q", q.SortBy)
 	}
 
-	const countSQL = "select count(*) from tbl_user_file where user_name=? and status=0"
-	var total int
-	if err := conn.QueryRowContext(ctx, countSQL, username).Scan(&total); err != nil {
-		return nil, 0, fmt.Errorf("failed to count user files: %w", err)
+	where := []string{"uf.user_name=?", "uf.status=0"}
+	args := []any{username}
+	if q.NamePrefix != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(q.NamePrefix)
+		where = append(where, "uf.file_name like ?")
+		args = append(args, escaped+"%")
+	}
+	if q.MinSize > 0 {
+		where = append(where, "uf.file_size>=?")
+		args = append(args, q.MinSize)
+	}
+	if q.MaxSize > 0 {
+		where = append(where, "uf.file_size<=?")
+		args = append(args, q.MaxSize)
+	}
+	if len(q.Scopes) > 0 {
+		scopeWhere, scopeArgs := pathScopesWhere(q.Scopes)
+		where = append(where, scopeWhere)
+		args = append(args, scopeArgs...)
+	}
+	filterWhere, filterArgs := strings.Join(where, " and "), args
+
+	total := -1
+	if q.After == nil && q.Before == nil {
+		countSQL := "select count(*) from tbl_user_file uf where " + filterWhere
+		if err := conn.QueryRowContext(ctx, countSQL, filterArgs...).Scan(&total); err != nil {
+			return nil, nil, 0, fmt.Errorf("failed to count user files: %w", err)
+		}
+	}
+
+	// 向前翻页时反向排序取紧挨着 Before 的记录，读出后再翻转回来。
+	desc := q.Desc
+	key := q.After
+	if q.Before != nil {
+		desc = !desc
+		key = q.Before
+	}
+	cmp, dir := ">", "asc"
+	if desc {
+		cmp, dir = "<", "desc"
+	}
+	offset := q.Offset
+	if key != nil {
+		where = append(where, "("+col+cmp+"? or ("+col+"=? and uf.id"+cmp+"?))")
+		args = append(args, key.Value, key.Value, key.ID)
+		offset = 0
 	}
 
-	const sqlStr = "select file_sha1,file_name,file_size,last_update from tbl_user_file where user_name=? and status=0 order by last_update desc, id desc limit ? offset ?"
-	rows, err := conn.QueryContext(ctx, sqlStr, username, limit, offset)
+	sqlStr := "select uf.id,uf.file_sha1,uf.file_name,uf.file_size,uf.upload_at,uf.last_update,coalesce(f.mime_type,'') from tbl_user_file uf " +
+		"left join tbl_file f on f.file_sha1=uf.file_sha1 " +
+		"where " + strings.Join(where, " and ") + " order by " + col + " " + dir + ", uf.id " + dir + " limit ? offset ?"
+	rows, err := conn.QueryContext(ctx, sqlStr, append(args, q.Limit, offset)...)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to query user file list: %w", err)
+		return nil, nil, 0, fmt.Errorf("failed to query user file list: %w", err)
 	}
 	defer rows.Close()
 
 	var fileMetaList []FileMeta
+	var keys []UserFileKey
 	for rows.Next() {
 
 		var f FileMeta
-		var lastUpdate sql.NullTime
-		err := rows.Scan(&f.FileSha1, &f.FileName, &f.FileSize, &lastUpdate)
+		var id int64
+		var uploadAt, lastUpdate sql.NullTime
+		err := rows.Scan(&id, &f.FileSha1, &f.FileName, &f.FileSize, &uploadAt, &lastUpdate, &f.MimeType)
 		if err != nil {
-			return nil, 0, fmt.Errorf("failed to scan row: %w", err)
+			return nil, nil, 0, fmt.Errorf("failed to scan row: %w", err)
 		}
 		if lastUpdate.Valid {
 			f.UploadAt = lastUpdate.Time.Format("2006-01-02 15:04:05")
 		}
 		fileMetaList = append(fileMetaList, f)
 
+		k := UserFileKey{ID: id}
+		switch q.SortBy {
+		case SortByName:
+			k.Value = f.FileName
+		case SortBySize:
+			k.Value = strconv.FormatInt(f.FileSize, 10)
+		case SortByUploadAt:
+			k.Value = uploadAt.Time.Format("2006-01-02 15:04:05")
+		default:
+			k.Value = lastUpdate.Time.Format("2006-01-02 15:04:05")
+		}
+		keys = append(keys, k)
+
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, 0, fmt.Errorf("rows iteration error: %w", err)
+		return nil, nil, 0, fmt.Errorf("rows iteration error: %w", err)
 	}
 
-	return fileMetaList, total, nil
+	if q.Before != nil {
+		slices.Reverse(fileMetaList)
+		slices.Reverse(keys)
+	}
+	return fileMetaList, keys, total, nil
 }
 
 func GetFileExist(ctx context.Context, filehash string) (FileMeta, bool, error) {
@@ -211,10 +450,10 @@ func GetFileExist(ctx context.Context, filehash string) (FileMeta, bool, error)
 	}
 
 	// SQL 关键字不区分大小写，但表名 tbl_file 在 Linux 下通常区分
-	const sqlStr = "select file_sha1,file_name,file_size,file_addr from tbl_file where file_sha1=? and status=0 limit 1"
+	const sqlStr = "select " + fileMetaColumns + " from tbl_file where file_sha1=? and status=0 limit 1"
 
 	var fmeta FileMeta
-	err := conn.QueryRowContext(ctx, sqlStr, filehash).Scan(&fmeta.FileSha1, &fmeta.FileName, &fmeta.FileSize, &fmeta.Location)
+	err := scanFileMeta(conn.QueryRowContext(ctx, sqlStr, filehash), &fmeta)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return FileMeta{}, false, nil
@@ -224,3 +463,198 @@ func GetFileExist(ctx context.Context, filehash string) (FileMeta, bool, error)
 
 	return fmeta, true, nil
 }
+
+// FileStatusBroken 表示 fsck 发现内容缺失或校验失败，已停止对外提供；重新上传相同内容后恢复。
+const FileStatusBroken = 2
+
+// ListFileMetas 按 id 顺序分批返回可用的文件元信息，返回本批最后一条的 id 作为下一批的起点。
+func ListFileMetas(ctx context.Context, afterID int64, limit int) ([]FileMeta, int64, error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return nil, 0, fmt.Errorf("db connection is nil")
+	}
+
+	const sqlStr = "select id," + fileMetaColumns + " from tbl_file where id>? and status=0 order by id limit ?"
+	rows, err := conn.QueryContext(ctx, sqlStr, afterID, limit)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query file metas: %w", err)
+	}
+	defer rows.Close()
+
+	var fileMetaList []FileMeta
+	lastID := afterID
+	for rows.Next() {
+		var f FileMeta
+		if err := scanFileMeta(rows, &f, &lastID); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan row: %w", err)
+		}
+		fileMetaList = append(fileMetaList, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return fileMetaList, lastID, nil
+}
+
+// UpdateFileStatus 修改可用文件的状态，用于把损坏的文件下线。
+func UpdateFileStatus(ctx context.Context, fileHash string, status int) error {
+	const sqlStr = "update tbl_file set status=? where file_sha1=? and status=0"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	if _, err := conn.ExecContext(ctx, sqlStr, status, fileHash); err != nil {
+		return fmt.Errorf("failed to update file status: %w", err)
+	}
+	return nil
+}
+
+// UpdateFileKey 替换文件的包装数据密钥，只在主密钥仍为 oldKeyID 时生效，避免并发轮换互相覆盖。
+func UpdateFileKey(ctx context.Context, fileHash, oldKeyID, newKeyID, encKey string) error {
+	const sqlStr = "update tbl_file set key_id=?, enc_key=? where file_sha1=? and key_id=?"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	if _, err := conn.ExecContext(ctx, sqlStr, newKeyID, encKey, fileHash, oldKeyID); err != nil {
+		return fmt.Errorf("failed to update file key: %w", err)
+	}
+	return nil
+}
+
+// GetUserFilesByHashes 返回用户名下 filehashes 对应的可用文件，文件名取用户自己的文件名。
+// 用户未拥有的 filehash 不会出现在结果中。
+func GetUserFilesByHashes(ctx context.Context, username string, filehashes []string) ([]FileMeta, error) {
+	if len(filehashes) == 0 {
+		return nil, nil
+	}
+
+	conn := db.DBconn()
+	if conn == nil {
+		return nil, fmt.Errorf("db connection is nil")
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(filehashes)), ",")
+	sqlStr := "select file_sha1,file_name,file_size,last_update from tbl_user_file where user_name=? and status=0 and file_sha1 in (" + placeholders + ")"
+	args := make([]any, 0, len(filehashes)+1)
+	args = append(args, username)
+	for _, h := range filehashes {
+		args = append(args, h)
+	}
+
+	rows, err := conn.QueryContext(ctx, sqlStr, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user files: %w", err)
+	}
+	defer rows.Close()
+
+	return scanUserFiles(rows)
+}
+
+// GetUserFilesUnder 按文件名顺序返回用户目录 dir（含子目录）下的文件，dir 为空时返回全部文件，最多 limit 个。
+func GetUserFilesUnder(ctx context.Context, username, dir string, limit int) ([]FileMeta, error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return nil, fmt.Errorf("db connection is nil")
+	}
+
+	scopeWhere, scopeArgs := pathScopesWhere([]PathScope{{Path: dir, Recursive: true}})
+	sqlStr := "select uf.file_sha1,uf.file_name,uf.file_size,uf.last_update from tbl_user_file uf " +
+		"where uf.user_name=? and uf.status=0 and " + scopeWhere + " order by uf.file_name limit ?"
+	args := append([]any{username}, scopeArgs...)
+	rows, err := conn.QueryContext(ctx, sqlStr, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user files: %w", err)
+	}
+	defer rows.Close()
+	return scanUserFiles(rows)
+}
+
+// scanUserFiles 读取 file_sha1、file_name、file_size、last_update 四列的用户文件记录。
+func scanUserFiles(rows *sql.Rows) ([]FileMeta, error) {
+	var fileMetaList []FileMeta
+	for rows.Next() {
+		var f FileMeta
+		var lastUpdate sql.NullTime
+		if err := rows.Scan(&f.FileSha1, &f.FileName, &f.FileSize, &lastUpdate); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		f.LastUpdate = lastUpdate.Time
+		fileMetaList = append(fileMetaList, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return fileMetaList, nil
+}
+
+// UpdateFileStore 在文件转存完成后更新存储位置，只在 file_addr 仍为 oldAddr 时生效，返回是否更新成功。
+func UpdateFileStore(ctx context.Context, fileHash, oldAddr, newAddr string, state int) (bool, error) {
+	const sqlStr = "update tbl_file set file_addr=?, store_state=? where file_sha1=? and file_addr=? and status=0"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return false, fmt.Errorf("db connection is nil")
+	}
+
+	result, err := conn.ExecContext(ctx, sqlStr, newAddr, state, fileHash, oldAddr)
+	if err != nil {
+		return false, fmt.Errorf("failed to update file store: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	return rows > 0, nil
+}
+
+// UpdateFileScanState 记录文件的扫描结果，只在文件仍处于 fromState 时生效。
+func UpdateFileScanState(ctx context.Context, fileHash string, fromState, toState int) error {
+	const sqlStr = "update tbl_file set scan_state=? where file_sha1=? and scan_state=?"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	if _, err := conn.ExecContext(ctx, sqlStr, toState, fileHash, fromState); err != nil {
+		return fmt.Errorf("failed to update file scan state: %w", err)
+	}
+	return nil
+}
+
+// UserFileNameExists 判断用户在 filename 路径下是否已有文件。
+func UserFileNameExists(ctx context.Context, username, filename string) (bool, error) {
+	const sqlStr = "select count(*) from tbl_user_file where user_name=? and file_name=? and status=0"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return false, fmt.Errorf("db connection is nil")
+	}
+
+	var n int
+	if err := conn.QueryRowContext(ctx, sqlStr, username, filename).Scan(&n); err != nil {
+		return false, fmt.Errorf("failed to query user file meta: %w", err)
+	}
+	return n > 0, nil
+}
+
+// GetUserFileSizeByName 返回用户 filename 路径下当前文件的大小，路径下没有文件时返回 0。
+func GetUserFileSizeByName(ctx context.Context, username, filename string) (int64, error) {
+	const sqlStr = "select coalesce(sum(file_size),0) from tbl_user_file where user_name=? and file_name=? and status=0"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return 0, fmt.Errorf("db connection is nil")
+	}
+
+	var size int64
+	if err := conn.QueryRowContext(ctx, sqlStr, username, filename).Scan(&size); err != nil {
+		return 0, fmt.Errorf("failed to query user file meta: %w", err)
+	}
+	return size, nil
+}
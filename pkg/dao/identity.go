@@ -0,0 +1,104 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"filestore-server/pkg/db"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrIdentityNotFound 表示外部身份没有关联本地用户。
+	ErrIdentityNotFound = errors.New("identity not found")
+	// ErrIdentityExists 表示外部身份已关联其他用户。
+	ErrIdentityExists = errors.New("identity already linked")
+)
+
+// GetUserIdentity 返回外部身份关联的本地用户名，外部身份以身份提供方的 issuer 和 subject 唯一确定。
+func GetUserIdentity(ctx context.Context, issuer, subject string) (string, error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return "", fmt.Errorf("db connection is nil")
+	}
+
+	var username string
+	err := conn.QueryRowContext(ctx, "select user_name from tbl_user_identity where issuer=? and subject=?", issuer, subject).Scan(&username)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrIdentityNotFound
+		}
+		return "", fmt.Errorf("failed to query identity: %w", err)
+	}
+	return username, nil
+}
+
+// LinkUserIdentity 把外部身份关联到已有用户。
+func LinkUserIdentity(ctx context.Context, issuer, subject, username, email string) error {
+	const sqlStr = "insert into tbl_user_identity (`issuer`,`subject`,`user_name`,`email`,`create_at`,`last_login_at`) values (?,?,?,?,?,?)"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	now := time.Now()
+	if _, err := conn.ExecContext(ctx, sqlStr, issuer, subject, username, email, now, now); err != nil {
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			return ErrIdentityExists
+		}
+		return fmt.Errorf("failed to insert identity: %w", err)
+	}
+	return nil
+}
+
+// CreateUserWithIdentity 在同一事务中创建用户并关联外部身份。用户没有本地密码，email 非空时标记为已验证。
+func CreateUserWithIdentity(ctx context.Context, username, role, issuer, subject, email string) error {
+	conn := db.DBconn()
+	if conn == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, "insert into tbl_user (`user_name`,`user_pwd`,`email`,`email_validated`,`signup_at`,`status`,`role`) values (?,'',?,?,?,?,?)",
+		username, email, email != "", now, UserStatusActive, role)
+	if err != nil {
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			return fmt.Errorf("user already exists")
+		}
+		return fmt.Errorf("failed to insert user: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, "insert into tbl_user_identity (`issuer`,`subject`,`user_name`,`email`,`create_at`,`last_login_at`) values (?,?,?,?,?,?)",
+		issuer, subject, username, email, now, now)
+	if err != nil {
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			return ErrIdentityExists
+		}
+		return fmt.Errorf("failed to insert identity: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit: %w", err)
+	}
+	return nil
+}
+
+// TouchUserIdentity 记录外部身份的登录时间及身份提供方返回的邮箱。
+func TouchUserIdentity(ctx context.Context, issuer, subject, email string) error {
+	conn := db.DBconn()
+	if conn == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	if _, err := conn.ExecContext(ctx, "update tbl_user_identity set email=?,last_login_at=? where issuer=? and subject=?", email, time.Now(), issuer, subject); err != nil {
+		return fmt.Errorf("failed to update identity: %w", err)
+	}
+	return nil
+}
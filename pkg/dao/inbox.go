@@ -0,0 +1,147 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"filestore-server/pkg/db"
+	"fmt"
+)
+
+// 收件箱条目的状态。
+const (
+	InboxPending  = 0
+	InboxAccepted = 1
+	InboxDeclined = 2
+)
+
+// ErrInboxItemNotFound 表示收件箱条目不存在、不属于该用户或已处理。
+var ErrInboxItemNotFound = errors.New("inbox item not found")
+
+// InboxItem 是其他用户发送过来、等待接收的一个文件。
+type InboxItem struct {
+	ID        int64
+	Sender    string
+	Recipient string
+	FileSha1  string
+	FileName  string
+	FileSize  int64
+	Message   string
+	Status    int
+	CreateAt  string
+}
+
+// InsertInboxItem 新增一个待接收的文件，返回其 ID。
+func InsertInboxItem(ctx context.Context, item InboxItem) (int64, error) {
+	const sqlStr = "insert into tbl_user_inbox (`sender`,`recipient`,`file_sha1`,`file_name`,`file_size`,`message`,`status`) values (?,?,?,?,?,?,?)"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return 0, fmt.Errorf("db connection is nil")
+	}
+
+	result, err := conn.ExecContext(ctx, sqlStr, item.Sender, item.Recipient, item.FileSha1, item.FileName, item.FileSize, item.Message, InboxPending)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert inbox item: %w", err)
+	}
+	return result.LastInsertId()
+}
+
+// HasPendingInboxItem 判断 sender 是否已向 recipient 发送过同一内容且尚未处理。
+func HasPendingInboxItem(ctx context.Context, sender, recipient, fileSha1 string) (bool, error) {
+	const sqlStr = "select count(*) from tbl_user_inbox where sender=? and recipient=? and file_sha1=? and status=?"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return false, fmt.Errorf("db connection is nil")
+	}
+
+	var n int
+	if err := conn.QueryRowContext(ctx, sqlStr, sender, recipient, fileSha1, InboxPending).Scan(&n); err != nil {
+		return false, fmt.Errorf("failed to query inbox: %w", err)
+	}
+	return n > 0, nil
+}
+
+// GetPendingInboxItems 按发送时间倒序返回用户待处理的收件箱条目。
+func GetPendingInboxItems(ctx context.Context, recipient string, limit, offset int) ([]InboxItem, error) {
+	const sqlStr = "select id,sender,recipient,file_sha1,file_name,file_size,message,status,create_at from tbl_user_inbox " +
+		"where recipient=? and status=? order by id desc limit ? offset ?"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return nil, fmt.Errorf("db connection is nil")
+	}
+
+	rows, err := conn.QueryContext(ctx, sqlStr, recipient, InboxPending, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query inbox: %w", err)
+	}
+	defer rows.Close()
+
+	var items []InboxItem
+	for rows.Next() {
+		item, err := scanInboxItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return items, nil
+}
+
+// GetPendingInboxItem 返回 recipient 的一个待处理条目。
+func GetPendingInboxItem(ctx context.Context, recipient string, id int64) (InboxItem, error) {
+	const sqlStr = "select id,sender,recipient,file_sha1,file_name,file_size,message,status,create_at from tbl_user_inbox " +
+		"where id=? and recipient=? and status=? limit 1"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return InboxItem{}, fmt.Errorf("db connection is nil")
+	}
+
+	item, err := scanInboxItem(conn.QueryRowContext(ctx, sqlStr, id, recipient, InboxPending))
+	if errors.Is(err, sql.ErrNoRows) {
+		return InboxItem{}, ErrInboxItemNotFound
+	}
+	return item, err
+}
+
+// UpdateInboxItemStatus 把条目从 from 状态改为 to，返回是否更新成功，用于并发下只处理一次。
+func UpdateInboxItemStatus(ctx context.Context, id int64, from, to int) (bool, error) {
+	const sqlStr = "update tbl_user_inbox set status=? where id=? and status=?"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return false, fmt.Errorf("db connection is nil")
+	}
+
+	result, err := conn.ExecContext(ctx, sqlStr, to, id, from)
+	if err != nil {
+		return false, fmt.Errorf("failed to update inbox item: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	return rows > 0, nil
+}
+
+func scanInboxItem(row rowScanner) (InboxItem, error) {
+	var item InboxItem
+	var createAt sql.NullTime
+	err := row.Scan(&item.ID, &item.Sender, &item.Recipient, &item.FileSha1, &item.FileName, &item.FileSize, &item.Message, &item.Status, &createAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return InboxItem{}, err
+		}
+		return InboxItem{}, fmt.Errorf("failed to scan row: %w", err)
+	}
+	if createAt.Valid {
+		item.CreateAt = createAt.Time.Format("2006-01-02 15:04:05")
+	}
+	return item, nil
+}
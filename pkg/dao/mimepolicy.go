@@ -0,0 +1,86 @@
+package dao
+
+import (
+	"context"
+	"filestore-server/pkg/db"
+	"fmt"
+)
+
+const (
+	MimePolicyAllow = "allow"
+	MimePolicyDeny  = "deny"
+)
+
+// MimePolicy 是用户组的一条上传类型规则，MimeType 支持 type/* 通配。
+type MimePolicy struct {
+	GroupName string
+	MimeType  string
+	Action    string
+}
+
+// GetMimePolicies 返回用户组的全部上传类型规则。
+func GetMimePolicies(ctx context.Context, group string) ([]MimePolicy, error) {
+	const sqlStr = "select group_name,mime_type,action from tbl_mime_policy where group_name=? order by id"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return nil, fmt.Errorf("db connection is nil")
+	}
+
+	rows, err := conn.QueryContext(ctx, sqlStr, group)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query mime policies: %w", err)
+	}
+	defer rows.Close()
+
+	var policies []MimePolicy
+	for rows.Next() {
+		var p MimePolicy
+		if err := rows.Scan(&p.GroupName, &p.MimeType, &p.Action); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		policies = append(policies, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return policies, nil
+}
+
+// SaveMimePolicy 新增规则，同一用户组同一类型已有规则时覆盖其 action。
+func SaveMimePolicy(ctx context.Context, p MimePolicy) error {
+	const sqlStr = "insert into tbl_mime_policy (`group_name`,`mime_type`,`action`) values (?,?,?) on duplicate key update action=values(action)"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	if _, err := conn.ExecContext(ctx, sqlStr, p.GroupName, p.MimeType, p.Action); err != nil {
+		return fmt.Errorf("failed to save mime policy: %w", err)
+	}
+	return nil
+}
+
+// DeleteMimePolicy 删除用户组中某个类型的规则。
+func DeleteMimePolicy(ctx context.Context, group, mimeType string) error {
+	const sqlStr = "delete from tbl_mime_policy where group_name=? and mime_type=?"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	result, err := conn.ExecContext(ctx, sqlStr, group, mimeType)
+	if err != nil {
+		return fmt.Errorf("failed to delete mime policy: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rows == 0 {
+		return fmt.Errorf("mime policy not found")
+	}
+	return nil
+}
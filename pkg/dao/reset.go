@@ -0,0 +1,107 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"filestore-server/pkg/db"
+	"fmt"
+	"time"
+)
+
+// ErrResetTokenNotFound 表示重置密码的令牌不存在、已使用或已过期。
+var ErrResetTokenNotFound = errors.New("reset token not found")
+
+// InsertPasswordResetToken 保存重置密码令牌的哈希。
+func InsertPasswordResetToken(ctx context.Context, tokenHash, username string, expireAt time.Time) error {
+	const sqlStr = "insert into tbl_password_reset (`token_hash`,`user_name`,`expire_at`,`create_at`) values (?,?,?,?)"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	if _, err := conn.ExecContext(ctx, sqlStr, tokenHash, username, expireAt, time.Now()); err != nil {
+		return fmt.Errorf("failed to insert reset token: %w", err)
+	}
+	return nil
+}
+
+// GetLatestPasswordResetAt 返回用户最近一次申请重置密码的时间，没有时返回零值。
+func GetLatestPasswordResetAt(ctx context.Context, username string) (time.Time, error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return time.Time{}, fmt.Errorf("db connection is nil")
+	}
+
+	var latest sql.NullTime
+	if err := conn.QueryRowContext(ctx, "select max(create_at) from tbl_password_reset where user_name=?", username).Scan(&latest); err != nil {
+		return time.Time{}, fmt.Errorf("failed to query reset token: %w", err)
+	}
+	return latest.Time, nil
+}
+
+// GetPasswordResetTokenUser 返回未过期令牌所属的用户，不消耗令牌。
+func GetPasswordResetTokenUser(ctx context.Context, tokenHash string) (string, error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return "", fmt.Errorf("db connection is nil")
+	}
+
+	var username string
+	err := conn.QueryRowContext(ctx, "select user_name from tbl_password_reset where token_hash=? and expire_at>?", tokenHash, time.Now()).Scan(&username)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrResetTokenNotFound
+		}
+		return "", fmt.Errorf("failed to query reset token: %w", err)
+	}
+	return username, nil
+}
+
+// ConsumePasswordResetToken 在令牌有效时删除该用户的全部令牌并返回用户名，保证令牌只能使用一次。
+func ConsumePasswordResetToken(ctx context.Context, tokenHash string) (string, error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return "", fmt.Errorf("db connection is nil")
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var username string
+	var expireAt time.Time
+	err = tx.QueryRowContext(ctx, "select user_name,expire_at from tbl_password_reset where token_hash=? for update", tokenHash).Scan(&username, &expireAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrResetTokenNotFound
+		}
+		return "", fmt.Errorf("failed to query reset token: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "delete from tbl_password_reset where user_name=?", username); err != nil {
+		return "", fmt.Errorf("failed to delete reset tokens: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit: %w", err)
+	}
+	if time.Now().After(expireAt) {
+		return "", ErrResetTokenNotFound
+	}
+	return username, nil
+}
+
+// DeletePasswordResetTokens 删除用户的全部重置密码令牌。
+func DeletePasswordResetTokens(ctx context.Context, username string) error {
+	conn := db.DBconn()
+	if conn == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	if _, err := conn.ExecContext(ctx, "delete from tbl_password_reset where user_name=?", username); err != nil {
+		return fmt.Errorf("failed to delete reset tokens: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"filestore-server/pkg/db"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// FileFilter 是搜索用户文件的条件，零值字段表示不限。
+type FileFilter struct {
+	// NameLike 为 LIKE 模式，调用方负责转义。
+	NameLike string
+	MinSize  int64
+	// MaxSize <= 0 表示不限。
+	MaxSize int64
+	From    time.Time
+	// To 为上传时间的上界（不含）。
+	To time.Time
+	// MimeType 为精确类型或 type/*。
+	MimeType string
+	// Tags 非空时只返回同时带有这些标签的文件。
+	Tags []string
+	// Hashes 非 nil 时只返回其中的文件，并按其顺序排列。
+	Hashes []string
+	Limit  int
+	Offset int
+}
+
+// searchWhere 返回 filter 中除 Hashes、Limit、Offset 以外的条件对应的 where 条件和参数。
+func searchWhere(username string, filter FileFilter) ([]string, []any) {
+	where := []string{"uf.user_name=?", "uf.status=0"}
+	args := []any{username}
+	if filter.NameLike != "" {
+		where = append(where, "uf.file_name like ?")
+		args = append(args, filter.NameLike)
+	}
+	if filter.MinSize > 0 {
+		where = append(where, "uf.file_size>=?")
+		args = append(args, filter.MinSize)
+	}
+	if filter.MaxSize > 0 {
+		where = append(where, "uf.file_size<=?")
+		args = append(args, filter.MaxSize)
+	}
+	if !filter.From.IsZero() {
+		where = append(where, "uf.upload_at>=?")
+		args = append(args, filter.From)
+	}
+	if !filter.To.IsZero() {
+		where = append(where, "uf.upload_at<?")
+		args = append(args, filter.To)
+	}
+	if prefix, ok := strings.CutSuffix(filter.MimeType, "/*"); ok {
+		where = append(where, "f.mime_type like ?")
+		args = append(args, prefix+"/%")
+	} else if filter.MimeType != "" {
+		// 存储的类型可能带有 charset 等参数。
+		where = append(where, "(f.mime_type=? or f.mime_type like ?)")
+		args = append(args, filter.MimeType, filter.MimeType+";%")
+	}
+	if len(filter.Tags) > 0 {
+		where = append(where, "uf.file_sha1 in (select file_sha1 from tbl_user_file_tag where user_name=? and tag in ("+
+			placeholders(len(filter.Tags))+") group by file_sha1 having count(*)=?)")
+		args = append(args, username)
+		for _, tag := range filter.Tags {
+			args = append(args, tag)
+		}
+		args = append(args, len(filter.Tags))
+	}
+	return where, args
+}
+
+// SearchUserFiles 按条件搜索用户的文件，返回当前页和总数。
+func SearchUserFiles(ctx context.Context, username string, filter FileFilter) ([]FileMeta, int, error) {
+	if filter.Hashes != nil && len(filter.Hashes) == 0 {
+		return nil, 0, nil
+	}
+
+	conn := db.DBconn()
+	if conn == nil {
+		return nil, 0, fmt.Errorf("db connection is nil")
+	}
+
+	where, args := searchWhere(username, filter)
+
+	orderBy := "uf.upload_at desc, uf.id desc"
+	var orderArgs []any
+	if filter.Hashes != nil {
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(filter.Hashes)), ",")
+		where = append(where, "uf.file_sha1 in ("+placeholders+")")
+		orderBy = "field(uf.file_sha1," + placeholders + ")"
+		for _, h := range filter.Hashes {
+			args = append(args, h)
+			orderArgs = append(orderArgs, h)
+		}
+	}
+
+	from := " from tbl_user_file uf left join tbl_file f on f.file_sha1=uf.file_sha1 where " + strings.Join(where, " and ")
+
+	var total int
+	if err := conn.QueryRowContext(ctx, "select count(*)"+from, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count user files: %w", err)
+	}
+
+	sqlStr := "select uf.file_sha1,uf.file_name,uf.file_size,uf.upload_at,coalesce(f.mime_type,'')" + from +
+		" order by " + orderBy + " limit ? offset ?"
+	queryArgs := append(append(args, orderArgs...), filter.Limit, filter.Offset)
+	rows, err := conn.QueryContext(ctx, sqlStr, queryArgs...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search user files: %w", err)
+	}
+	defer rows.Close()
+
+	var fileMetaList []FileMeta
+	for rows.Next() {
+		var f FileMeta
+		var uploadAt sql.NullTime
+		if err := rows.Scan(&f.FileSha1, &f.FileName, &f.FileSize, &uploadAt, &f.MimeType); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan row: %w", err)
+		}
+		if uploadAt.Valid {
+			f.UploadAt = uploadAt.Time.Format("2006-01-02 15:04:05")
+		}
+		fileMetaList = append(fileMetaList, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return fileMetaList, total, nil
+}
+
+// SearchUserFileHashes 返回满足 filter 中除 Hashes、Limit、Offset 以外条件的全部文件的 sha1，
+// 用于在全文索引中只检索这些文件。
+func SearchUserFileHashes(ctx context.Context, username string, filter FileFilter) ([]string, error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return nil, fmt.Errorf("db connection is nil")
+	}
+
+	where, args := searchWhere(username, filter)
+	rows, err := conn.QueryContext(ctx, "select uf.file_sha1 from tbl_user_file uf left join tbl_file f on f.file_sha1=uf.file_sha1 where "+strings.Join(where, " and "), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search user files: %w", err)
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var h string
+		if err := rows.Scan(&h); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		hashes = append(hashes, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return hashes, nil
+}
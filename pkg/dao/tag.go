@@ -0,0 +1,211 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"filestore-server/pkg/db"
+	"fmt"
+	"strings"
+)
+
+// ErrAttrNotFound 表示用户文件上没有该自定义属性。
+var ErrAttrNotFound = errors.New("attr not found")
+
+// TagCount 是用户的一个标签及使用它的文件数。
+type TagCount struct {
+	Tag   string
+	Count int
+}
+
+// AddUserFileTags 给用户的多个文件批量打上多个标签，已存在的组合忽略。
+func AddUserFileTags(ctx context.Context, username string, filehashes, tags []string) error {
+	if len(filehashes) == 0 || len(tags) == 0 {
+		return nil
+	}
+
+	conn := db.DBconn()
+	if conn == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	values := make([]string, 0, len(filehashes)*len(tags))
+	args := make([]any, 0, len(filehashes)*len(tags)*3)
+	for _, h := range filehashes {
+		for _, tag := range tags {
+			values = append(values, "(?,?,?)")
+			args = append(args, username, h, tag)
+		}
+	}
+	sqlStr := "insert ignore into tbl_user_file_tag (`user_name`,`file_sha1`,`tag`) values " + strings.Join(values, ",")
+	if _, err := conn.ExecContext(ctx, sqlStr, args...); err != nil {
+		return fmt.Errorf("failed to add file tags: %w", err)
+	}
+	return nil
+}
+
+// RemoveUserFileTags 从用户的多个文件上批量去掉多个标签。
+func RemoveUserFileTags(ctx context.Context, username string, filehashes, tags []string) error {
+	if len(filehashes) == 0 || len(tags) == 0 {
+		return nil
+	}
+
+	conn := db.DBconn()
+	if conn == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	args := make([]any, 0, len(filehashes)+len(tags)+1)
+	args = append(args, username)
+	for _, h := range filehashes {
+		args = append(args, h)
+	}
+	for _, tag := range tags {
+		args = append(args, tag)
+	}
+	sqlStr := "delete from tbl_user_file_tag where user_name=? and file_sha1 in (" + placeholders(len(filehashes)) +
+		") and tag in (" + placeholders(len(tags)) + ")"
+	if _, err := conn.ExecContext(ctx, sqlStr, args...); err != nil {
+		return fmt.Errorf("failed to remove file tags: %w", err)
+	}
+	return nil
+}
+
+// GetUserFileTags 返回用户的这些文件各自的标签，按标签名排序。
+func GetUserFileTags(ctx context.Context, username string, filehashes []string) (map[string][]string, error) {
+	tags := make(map[string][]string)
+	if len(filehashes) == 0 {
+		return tags, nil
+	}
+
+	conn := db.DBconn()
+	if conn == nil {
+		return nil, fmt.Errorf("db connection is nil")
+	}
+
+	args := make([]any, 0, len(filehashes)+1)
+	args = append(args, username)
+	for _, h := range filehashes {
+		args = append(args, h)
+	}
+	sqlStr := "select file_sha1,tag from tbl_user_file_tag where user_name=? and file_sha1 in (" + placeholders(len(filehashes)) + ") order by tag"
+	rows, err := conn.QueryContext(ctx, sqlStr, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query file tags: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var h, tag string
+		if err := rows.Scan(&h, &tag); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		tags[h] = append(tags[h], tag)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return tags, nil
+}
+
+// GetUserTags 返回用户用过的全部标签及各自标记的有效文件数。
+func GetUserTags(ctx context.Context, username string) ([]TagCount, error) {
+	const sqlStr = "select t.tag,count(*) from tbl_user_file_tag t " +
+		"join tbl_user_file uf on uf.user_name=t.user_name and uf.file_sha1=t.file_sha1 and uf.status=0 " +
+		"where t.user_name=? group by t.tag order by t.tag"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return nil, fmt.Errorf("db connection is nil")
+	}
+
+	rows, err := conn.QueryContext(ctx, sqlStr, username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user tags: %w", err)
+	}
+	defer rows.Close()
+
+	var tags []TagCount
+	for rows.Next() {
+		var tc TagCount
+		if err := rows.Scan(&tc.Tag, &tc.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		tags = append(tags, tc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return tags, nil
+}
+
+// SetUserFileAttr 设置用户文件的一个自定义属性，已存在时覆盖。
+func SetUserFileAttr(ctx context.Context, username, filehash, key, value string) error {
+	const sqlStr = "insert into tbl_user_file_attr (`user_name`,`file_sha1`,`attr_key`,`attr_value`) values (?,?,?,?) " +
+		"on duplicate key update attr_value=values(attr_value)"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	if _, err := conn.ExecContext(ctx, sqlStr, username, filehash, key, value); err != nil {
+		return fmt.Errorf("failed to set file attr: %w", err)
+	}
+	return nil
+}
+
+// DeleteUserFileAttr 删除用户文件的一个自定义属性。
+func DeleteUserFileAttr(ctx context.Context, username, filehash, key string) error {
+	const sqlStr = "delete from tbl_user_file_attr where user_name=? and file_sha1=? and attr_key=?"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	result, err := conn.ExecContext(ctx, sqlStr, username, filehash, key)
+	if err != nil {
+		return fmt.Errorf("failed to delete file attr: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rows == 0 {
+		return ErrAttrNotFound
+	}
+	return nil
+}
+
+// GetUserFileAttrs 返回用户文件的全部自定义属性。
+func GetUserFileAttrs(ctx context.Context, username, filehash string) (map[string]string, error) {
+	const sqlStr = "select attr_key,attr_value from tbl_user_file_attr where user_name=? and file_sha1=?"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return nil, fmt.Errorf("db connection is nil")
+	}
+
+	rows, err := conn.QueryContext(ctx, sqlStr, username, filehash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query file attrs: %w", err)
+	}
+	defer rows.Close()
+
+	attrs := make(map[string]string)
+	for rows.Next() {
+		var k, v string
+		if err := rows.Scan(&k, &v); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		attrs[k] = v
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return attrs, nil
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
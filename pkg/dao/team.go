@@ -0,0 +1,441 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"filestore-server/pkg/db"
+	"fmt"
+	"strings"
+)
+
+// 团队邀请的状态。
+const (
+	TeamInvitePending  = 0
+	TeamInviteAccepted = 1
+	TeamInviteDeclined = 2
+)
+
+var (
+	// ErrTeamNotFound 表示团队不存在或调用者不是其成员。
+	ErrTeamNotFound = errors.New("team not found")
+	// ErrTeamMemberNotFound 表示用户不是团队成员。
+	ErrTeamMemberNotFound = errors.New("team member not found")
+	// ErrTeamMemberExists 表示用户已是团队成员。
+	ErrTeamMemberExists = errors.New("user is already a team member")
+	// ErrTeamInviteNotFound 表示邀请不存在、不属于该用户或已处理。
+	ErrTeamInviteNotFound = errors.New("team invite not found")
+)
+
+// Team 是一个团队，Role 为查询者在团队中的角色。
+type Team struct {
+	ID       int64
+	Name     string
+	Role     string `json:",omitempty"`
+	CreateAt string
+}
+
+// TeamMember 是团队的一个成员。
+type TeamMember struct {
+	TeamID   int64
+	UserName string
+	Role     string
+	JoinAt   string
+}
+
+// TeamInvite 是邀请用户加入团队的记录。
+type TeamInvite struct {
+	ID       int64
+	TeamID   int64
+	TeamName string
+	Inviter  string
+	Invitee  string
+	Role     string
+	Status   int
+	CreateAt string
+}
+
+// CreateTeam 创建团队并把 owner 加为所有者，返回团队 ID。
+func CreateTeam(ctx context.Context, name, owner, ownerRole string) (int64, error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return 0, fmt.Errorf("db connection is nil")
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "insert into tbl_team (`name`) values (?)", name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert team: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get team id: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "insert into tbl_team_member (`team_id`,`user_name`,`role`) values (?,?,?)", id, owner, ownerRole); err != nil {
+		return 0, fmt.Errorf("failed to insert team member: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit tx: %w", err)
+	}
+	return id, nil
+}
+
+// GetUserTeams 返回用户加入的全部团队。
+func GetUserTeams(ctx context.Context, username string) ([]Team, error) {
+	const sqlStr = "select t.id,t.name,m.role,t.create_at from tbl_team t join tbl_team_member m on m.team_id=t.id " +
+		"where m.user_name=? order by t.id"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return nil, fmt.Errorf("db connection is nil")
+	}
+
+	rows, err := conn.QueryContext(ctx, sqlStr, username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query teams: %w", err)
+	}
+	defer rows.Close()
+
+	var teams []Team
+	for rows.Next() {
+		var team Team
+		var createAt sql.NullTime
+		if err := rows.Scan(&team.ID, &team.Name, &team.Role, &createAt); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		if createAt.Valid {
+			team.CreateAt = createAt.Time.Format("2006-01-02 15:04:05")
+		}
+		teams = append(teams, team)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return teams, nil
+}
+
+// GetTeamName 返回团队名称。
+func GetTeamName(ctx context.Context, teamID int64) (string, error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return "", fmt.Errorf("db connection is nil")
+	}
+
+	var name string
+	err := conn.QueryRowContext(ctx, "select name from tbl_team where id=? limit 1", teamID).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrTeamNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to query team: %w", err)
+	}
+	return name, nil
+}
+
+// GetTeamMemberRole 返回用户在团队中的角色，不是成员时返回 ErrTeamMemberNotFound。
+func GetTeamMemberRole(ctx context.Context, teamID int64, username string) (string, error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return "", fmt.Errorf("db connection is nil")
+	}
+
+	var role string
+	err := conn.QueryRowContext(ctx, "select role from tbl_team_member where team_id=? and user_name=? limit 1", teamID, username).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrTeamMemberNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to query team member: %w", err)
+	}
+	return role, nil
+}
+
+// GetTeamMembers 按加入时间返回团队的全部成员。
+func GetTeamMembers(ctx context.Context, teamID int64) ([]TeamMember, error) {
+	const sqlStr = "select team_id,user_name,role,join_at from tbl_team_member where team_id=? order by id"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return nil, fmt.Errorf("db connection is nil")
+	}
+
+	rows, err := conn.QueryContext(ctx, sqlStr, teamID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query team members: %w", err)
+	}
+	defer rows.Close()
+
+	var members []TeamMember
+	for rows.Next() {
+		var m TeamMember
+		var joinAt sql.NullTime
+		if err := rows.Scan(&m.TeamID, &m.UserName, &m.Role, &joinAt); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		if joinAt.Valid {
+			m.JoinAt = joinAt.Time.Format("2006-01-02 15:04:05")
+		}
+		members = append(members, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return members, nil
+}
+
+// CountTeamMembersByRole 返回团队中某个角色的成员数。
+func CountTeamMembersByRole(ctx context.Context, teamID int64, role string) (int, error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return 0, fmt.Errorf("db connection is nil")
+	}
+
+	var n int
+	if err := conn.QueryRowContext(ctx, "select count(*) from tbl_team_member where team_id=? and role=?", teamID, role).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count team members: %w", err)
+	}
+	return n, nil
+}
+
+// UpdateTeamMemberRole 修改成员的角色。
+func UpdateTeamMemberRole(ctx context.Context, teamID int64, username, role string) error {
+	conn := db.DBconn()
+	if conn == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	result, err := conn.ExecContext(ctx, "update tbl_team_member set role=? where team_id=? and user_name=?", role, teamID, username)
+	if err != nil {
+		return fmt.Errorf("failed to update team member: %w", err)
+	}
+	return checkTeamMemberAffected(result)
+}
+
+// DeleteTeamMember 把用户移出团队。
+func DeleteTeamMember(ctx context.Context, teamID int64, username string) error {
+	conn := db.DBconn()
+	if conn == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	result, err := conn.ExecContext(ctx, "delete from tbl_team_member where team_id=? and user_name=?", teamID, username)
+	if err != nil {
+		return fmt.Errorf("failed to delete team member: %w", err)
+	}
+	return checkTeamMemberAffected(result)
+}
+
+func checkTeamMemberAffected(result sql.Result) error {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rows == 0 {
+		return ErrTeamMemberNotFound
+	}
+	return nil
+}
+
+// InsertTeamInvite 新增一个待处理的邀请，返回其 ID。
+func InsertTeamInvite(ctx context.Context, invite TeamInvite) (int64, error) {
+	const sqlStr = "insert into tbl_team_invite (`team_id`,`inviter`,`invitee`,`role`,`status`) values (?,?,?,?,?)"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return 0, fmt.Errorf("db connection is nil")
+	}
+
+	result, err := conn.ExecContext(ctx, sqlStr, invite.TeamID, invite.Inviter, invite.Invitee, invite.Role, TeamInvitePending)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert team invite: %w", err)
+	}
+	return result.LastInsertId()
+}
+
+// HasPendingTeamInvite 判断用户是否已有该团队尚未处理的邀请。
+func HasPendingTeamInvite(ctx context.Context, teamID int64, invitee string) (bool, error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return false, fmt.Errorf("db connection is nil")
+	}
+
+	var n int
+	err := conn.QueryRowContext(ctx, "select count(*) from tbl_team_invite where team_id=? and invitee=? and status=?",
+		teamID, invitee, TeamInvitePending).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("failed to query team invites: %w", err)
+	}
+	return n > 0, nil
+}
+
+// GetPendingTeamInvites 按时间倒序返回用户待处理的团队邀请。
+func GetPendingTeamInvites(ctx context.Context, invitee string) ([]TeamInvite, error) {
+	const sqlStr = "select i.id,i.team_id,t.name,i.inviter,i.invitee,i.role,i.status,i.create_at from tbl_team_invite i " +
+		"join tbl_team t on t.id=i.team_id where i.invitee=? and i.status=? order by i.id desc"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return nil, fmt.Errorf("db connection is nil")
+	}
+
+	rows, err := conn.QueryContext(ctx, sqlStr, invitee, TeamInvitePending)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query team invites: %w", err)
+	}
+	defer rows.Close()
+
+	var invites []TeamInvite
+	for rows.Next() {
+		invite, err := scanTeamInvite(rows)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, invite)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return invites, nil
+}
+
+// GetPendingTeamInvite 返回 invitee 的一个待处理邀请。
+func GetPendingTeamInvite(ctx context.Context, invitee string, id int64) (TeamInvite, error) {
+	const sqlStr = "select i.id,i.team_id,t.name,i.inviter,i.invitee,i.role,i.status,i.create_at from tbl_team_invite i " +
+		"join tbl_team t on t.id=i.team_id where i.id=? and i.invitee=? and i.status=? limit 1"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return TeamInvite{}, fmt.Errorf("db connection is nil")
+	}
+
+	invite, err := scanTeamInvite(conn.QueryRowContext(ctx, sqlStr, id, invitee, TeamInvitePending))
+	if errors.Is(err, sql.ErrNoRows) {
+		return TeamInvite{}, ErrTeamInviteNotFound
+	}
+	return invite, err
+}
+
+// AcceptTeamInvite 在一个事务中把邀请标记为已接受并加入团队，并发接受同一邀请时只有一次生效。
+func AcceptTeamInvite(ctx context.Context, invite TeamInvite) error {
+	conn := db.DBconn()
+	if conn == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "update tbl_team_invite set status=? where id=? and status=?",
+		TeamInviteAccepted, invite.ID, TeamInvitePending)
+	if err != nil {
+		return fmt.Errorf("failed to update team invite: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+		return ErrTeamInviteNotFound
+	}
+	_, err = tx.ExecContext(ctx, "insert into tbl_team_member (`team_id`,`user_name`,`role`) values (?,?,?)",
+		invite.TeamID, invite.Invitee, invite.Role)
+	if err != nil {
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			return ErrTeamMemberExists
+		}
+		return fmt.Errorf("failed to insert team member: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit tx: %w", err)
+	}
+	return nil
+}
+
+// UpdateTeamInviteStatus 把邀请从 from 状态改为 to，返回是否更新成功。
+func UpdateTeamInviteStatus(ctx context.Context, id int64, from, to int) (bool, error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return false, fmt.Errorf("db connection is nil")
+	}
+
+	result, err := conn.ExecContext(ctx, "update tbl_team_invite set status=? where id=? and status=?", to, id, from)
+	if err != nil {
+		return false, fmt.Errorf("failed to update team invite: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	return rows > 0, nil
+}
+
+// UserFileOwner 是拥有某个文件的空间（用户名或 "team:<id>"）及文件在其中的路径。
+type UserFileOwner struct {
+	UserName string
+	FileName string
+}
+
+// GetUserFileOwner 返回 owner 空间中的该文件，不存在时返回 ErrUserFileNotFound。
+func GetUserFileOwner(ctx context.Context, owner, fileSha1 string) (UserFileOwner, error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return UserFileOwner{}, fmt.Errorf("db connection is nil")
+	}
+
+	o := UserFileOwner{UserName: owner}
+	err := conn.QueryRowContext(ctx, "select file_name from tbl_user_file where user_name=? and file_sha1=? and status=0 limit 1", owner, fileSha1).Scan(&o.FileName)
+	if err == sql.ErrNoRows {
+		return UserFileOwner{}, ErrUserFileNotFound
+	}
+	if err != nil {
+		return UserFileOwner{}, fmt.Errorf("failed to query user file meta: %w", err)
+	}
+	return o, nil
+}
+
+// GetUserFileOwners 返回拥有某个文件的全部空间，按保存顺序排列，不含已删除的记录。
+func GetUserFileOwners(ctx context.Context, fileSha1 string) ([]UserFileOwner, error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return nil, fmt.Errorf("db connection is nil")
+	}
+
+	rows, err := conn.QueryContext(ctx, "select user_name,file_name from tbl_user_file where file_sha1=? and status=0 order by id", fileSha1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query file owners: %w", err)
+	}
+	defer rows.Close()
+
+	var owners []UserFileOwner
+	for rows.Next() {
+		var owner UserFileOwner
+		if err := rows.Scan(&owner.UserName, &owner.FileName); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		owners = append(owners, owner)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return owners, nil
+}
+
+func scanTeamInvite(row rowScanner) (TeamInvite, error) {
+	var invite TeamInvite
+	var createAt sql.NullTime
+	err := row.Scan(&invite.ID, &invite.TeamID, &invite.TeamName, &invite.Inviter, &invite.Invitee, &invite.Role, &invite.Status, &createAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return TeamInvite{}, err
+		}
+		return TeamInvite{}, fmt.Errorf("failed to scan row: %w", err)
+	}
+	if createAt.Valid {
+		invite.CreateAt = createAt.Time.Format("2006-01-02 15:04:05")
+	}
+	return invite, nil
+}
@@ -0,0 +1,208 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"filestore-server/pkg/db"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrTOTPNotFound 表示用户没有保存 TOTP 密钥。
+var ErrTOTPNotFound = errors.New("totp secret not found")
+
+// TOTPSecret 是用户的 TOTP 密钥，确认前为待启用状态。
+type TOTPSecret struct {
+	// KeyID 为加密密钥所用主密钥的 ID，空表示 Secret 为未加密的 base32。
+	KeyID  string
+	Secret string
+	// LastStep 为最近一次验证成功的时间步，不大于它的验证码不再接受。
+	LastStep int64
+}
+
+// SaveTOTPSecret 保存用户的待启用密钥，覆盖之前未确认的密钥。
+func SaveTOTPSecret(ctx context.Context, username, keyID, secret string) error {
+	const sqlStr = "insert into tbl_user_totp (`user_name`,`key_id`,`secret`,`last_step`,`create_at`) values (?,?,?,0,?) " +
+		"on duplicate key update key_id=values(key_id),secret=values(secret),last_step=0,create_at=values(create_at)"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	if _, err := conn.ExecContext(ctx, sqlStr, username, keyID, secret, time.Now()); err != nil {
+		return fmt.Errorf("failed to save totp secret: %w", err)
+	}
+	return nil
+}
+
+// GetTOTPSecret 返回用户的 TOTP 密钥。
+func GetTOTPSecret(ctx context.Context, username string) (TOTPSecret, error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return TOTPSecret{}, fmt.Errorf("db connection is nil")
+	}
+
+	var s TOTPSecret
+	err := conn.QueryRowContext(ctx, "select key_id,secret,last_step from tbl_user_totp where user_name=?", username).Scan(&s.KeyID, &s.Secret, &s.LastStep)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return TOTPSecret{}, ErrTOTPNotFound
+		}
+		return TOTPSecret{}, fmt.Errorf("failed to query totp secret: %w", err)
+	}
+	return s, nil
+}
+
+// AdvanceTOTPStep 在 step 大于已使用的时间步时记录它，返回是否记录成功；
+// 并发提交同一验证码时只有一个请求成功。
+func AdvanceTOTPStep(ctx context.Context, username string, step int64) (bool, error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return false, fmt.Errorf("db connection is nil")
+	}
+
+	result, err := conn.ExecContext(ctx, "update tbl_user_totp set last_step=? where user_name=? and last_step<?", step, username, step)
+	if err != nil {
+		return false, fmt.Errorf("failed to update totp step: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	return rows > 0, nil
+}
+
+// EnableUserTOTP 启用用户的两步验证，并把恢复码替换为 codeHashes。
+func EnableUserTOTP(ctx context.Context, username string, codeHashes []string) error {
+	conn := db.DBconn()
+	if conn == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "update tbl_user set totp_enabled=1 where user_name=?", username); err != nil {
+		return fmt.Errorf("failed to enable totp: %w", err)
+	}
+	if err := replaceRecoveryCodes(ctx, tx, username, codeHashes); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit: %w", err)
+	}
+	return nil
+}
+
+// DisableUserTOTP 关闭用户的两步验证，删除密钥和恢复码，并递增会话版本使已有会话和待完成的两步登录失效。
+func DisableUserTOTP(ctx context.Context, username string) error {
+	conn := db.DBconn()
+	if conn == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "update tbl_user set totp_enabled=0,session_version=session_version+1 where user_name=?", username); err != nil {
+		return fmt.Errorf("failed to disable totp: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "delete from tbl_user_totp where user_name=?", username); err != nil {
+		return fmt.Errorf("failed to delete totp secret: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "delete from tbl_user_recovery_code where user_name=?", username); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit: %w", err)
+	}
+	return nil
+}
+
+// UpdateUserTOTPRequired 设置管理员是否要求用户启用两步验证。
+func UpdateUserTOTPRequired(ctx context.Context, username string, required bool) error {
+	return updateUser(ctx, "update tbl_user set totp_required=? where user_name=?", required, username)
+}
+
+// ReplaceRecoveryCodes 删除用户现有的恢复码并保存新的恢复码哈希。
+func ReplaceRecoveryCodes(ctx context.Context, username string, codeHashes []string) error {
+	conn := db.DBconn()
+	if conn == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(ctx, tx, username, codeHashes); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit: %w", err)
+	}
+	return nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, username string, codeHashes []string) error {
+	if _, err := tx.ExecContext(ctx, "delete from tbl_user_recovery_code where user_name=?", username); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	if len(codeHashes) == 0 {
+		return nil
+	}
+	now := time.Now()
+	args := make([]any, 0, len(codeHashes)*3)
+	for _, h := range codeHashes {
+		args = append(args, username, h, now)
+	}
+	sqlStr := "insert into tbl_user_recovery_code (`user_name`,`code_hash`,`create_at`) values " +
+		strings.TrimSuffix(strings.Repeat("(?,?,?),", len(codeHashes)), ",")
+	if _, err := tx.ExecContext(ctx, sqlStr, args...); err != nil {
+		return fmt.Errorf("failed to insert recovery codes: %w", err)
+	}
+	return nil
+}
+
+// ConsumeRecoveryCode 删除用户的一个恢复码，返回恢复码是否存在；恢复码只能使用一次。
+func ConsumeRecoveryCode(ctx context.Context, username, codeHash string) (bool, error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return false, fmt.Errorf("db connection is nil")
+	}
+
+	result, err := conn.ExecContext(ctx, "delete from tbl_user_recovery_code where user_name=? and code_hash=?", username, codeHash)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete recovery code: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	return rows > 0, nil
+}
+
+// CountRecoveryCodes 返回用户剩余的恢复码数量。
+func CountRecoveryCodes(ctx context.Context, username string) (int, error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return 0, fmt.Errorf("db connection is nil")
+	}
+
+	var n int
+	if err := conn.QueryRowContext(ctx, "select count(*) from tbl_user_recovery_code where user_name=?", username).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+	return n, nil
+}
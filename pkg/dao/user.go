@@ -20,6 +20,35 @@ type User struct {
 	LastActive sql.NullTime
 	Profile    sql.NullString
 	Status     int
+	// Role 为用户角色，RoleAdmin 可以访问管理接口。
+	Role string
+	// GroupName 为用户所属的用户组，用于按组配置上传策略等。
+	GroupName string
+	// SessionVersion 在修改或重置密码时递增，登录时写入会话，版本不一致的会话失效。
+	SessionVersion int
+	// TOTPEnabled 表示用户已启用 TOTP 两步验证，登录时需要提交验证码。
+	TOTPEnabled bool
+	// TOTPRequired 表示管理员要求该用户启用两步验证。
+	TOTPRequired bool
+}
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+	// DefaultUserGroup 是新用户所属的用户组。
+	DefaultUserGroup = "default"
+)
+
+// 用户账户的状态，禁用和锁定的账户不能登录。
+const (
+	UserStatusActive   = 1
+	UserStatusDisabled = 2
+	UserStatusLocked   = 3
+)
+
+// Enabled 判断账户是否允许登录和访问，早期创建的账户 status 为 0，视为启用。
+func (u User) Enabled() bool {
+	return u.Status != UserStatusDisabled && u.Status != UserStatusLocked
 }
 
 // CreateUser 插入新用户，假设 user_name 唯一。
@@ -31,7 +60,7 @@ func CreateUser(ctx context.Context, username, hashedPwd string) error {
 		return fmt.Errorf("db connection is nil")
 	}
 
-	_, err := conn.ExecContext(ctx, sqlStr, username, hashedPwd, time.Now(), 1)
+	_, err := conn.ExecContext(ctx, sqlStr, username, hashedPwd, time.Now(), UserStatusActive)
 	if err != nil {
 		if strings.Contains(err.Error(), "Duplicate entry") {
 			return fmt.Errorf("user already exists")
@@ -45,7 +74,8 @@ func CreateUser(ctx context.Context, username, hashedPwd string) error {
 func GetUserByName(ctx context.Context, username string) (User, error) {
 	const sqlStr = `
 select user_name, user_pwd, email, phone, email_validated, phone_validated,
-       signup_at, last_active, profile, status
+       signup_at, last_active, profile, status, role, group_name, session_version,
+       totp_enabled, totp_required
 from tbl_user where user_name=? limit 1`
 
 	conn := db.DBconn()
@@ -65,6 +95,11 @@ from tbl_user where user_name=? limit 1`
 		&u.LastActive,
 		&u.Profile,
 		&u.Status,
+		&u.Role,
+		&u.GroupName,
+		&u.SessionVersion,
+		&u.TOTPEnabled,
+		&u.TOTPRequired,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -74,3 +109,297 @@ from tbl_user where user_name=? limit 1`
 	}
 	return u, nil
 }
+
+// UpdateUserGroup 修改用户所属的用户组。
+func UpdateUserGroup(ctx context.Context, username, group string) error {
+	const sqlStr = "update tbl_user set group_name=? where user_name=?"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	result, err := conn.ExecContext(ctx, sqlStr, group, username)
+	if err != nil {
+		return fmt.Errorf("failed to update user group: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rows == 0 {
+		// 组名未变化时 MySQL 也会返回 0，需要确认用户是否存在。
+		if _, err := GetUserByName(ctx, username); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetUserQuota 返回用户的存储配额（字节），0 表示使用系统默认值。
+func GetUserQuota(ctx context.Context, username string) (int64, error) {
+	const sqlStr = "select quota from tbl_user where user_name=? limit 1"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return 0, fmt.Errorf("db connection is nil")
+	}
+
+	var quota int64
+	if err := conn.QueryRowContext(ctx, sqlStr, username).Scan(&quota); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("user not found")
+		}
+		return 0, fmt.Errorf("failed to query user quota: %w", err)
+	}
+	return quota, nil
+}
+
+// GetUserStorageUsage 返回用户当前有效文件的总大小（字节）。
+func GetUserStorageUsage(ctx context.Context, username string) (int64, error) {
+	const sqlStr = "select coalesce(sum(file_size),0) from tbl_user_file where user_name=? and status=0"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return 0, fmt.Errorf("db connection is nil")
+	}
+
+	var used int64
+	if err := conn.QueryRowContext(ctx, sqlStr, username).Scan(&used); err != nil {
+		return 0, fmt.Errorf("failed to query storage usage: %w", err)
+	}
+	return used, nil
+}
+
+// UserSummary 是管理接口中展示的用户信息，包括存储用量。
+type UserSummary struct {
+	UserName  string
+	Email     string
+	Phone     string
+	Status    int
+	Role      string
+	GroupName string
+	// Quota 为单独设置的配额（字节），0 表示使用系统默认值。
+	Quota       int64
+	StorageUsed int64
+	FileCount   int
+	SignupAt    string
+	LastActive  string
+	// TOTPEnabled 与 TOTPRequired 为两步验证的启用和强制状态。
+	TOTPEnabled  bool
+	TOTPRequired bool
+}
+
+// UserQuery 是管理员查询用户列表的条件。
+type UserQuery struct {
+	// Keyword 匹配用户名、邮箱或手机号中的子串。
+	Keyword string
+	// Status 为 0 时不限状态。
+	Status int
+	Limit  int
+	Offset int
+}
+
+const userSummaryColumns = "u.user_name,coalesce(u.email,''),coalesce(u.phone,''),u.status,u.role,u.group_name,u.quota," +
+	"coalesce(s.used,0),coalesce(s.files,0),u.signup_at,u.last_active,u.totp_enabled,u.totp_required from tbl_user u " +
+	"left join (select user_name,sum(file_size) used,count(*) files from tbl_user_file where status=0 group by user_name) s on s.user_name=u.user_name"
+
+// ListUsers 按注册顺序返回符合条件的用户及总数。
+func ListUsers(ctx context.Context, q UserQuery) ([]UserSummary, int, error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return nil, 0, fmt.Errorf("db connection is nil")
+	}
+
+	var where []string
+	var args []any
+	if q.Keyword != "" {
+		like := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(q.Keyword) + "%"
+		where = append(where, "(u.user_name like ? or u.email like ? or u.phone like ?)")
+		args = append(args, like, like, like)
+	}
+	if q.Status != 0 {
+		where = append(where, "u.status=?")
+		args = append(args, q.Status)
+	}
+	whereSQL := ""
+	if len(where) > 0 {
+		whereSQL = " where " + strings.Join(where, " and ")
+	}
+
+	var total int
+	if err := conn.QueryRowContext(ctx, "select count(*) from tbl_user u"+whereSQL, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count users: %w", err)
+	}
+
+	rows, err := conn.QueryContext(ctx, "select "+userSummaryColumns+whereSQL+" order by u.id limit ? offset ?", append(args, q.Limit, q.Offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []UserSummary
+	for rows.Next() {
+		u, err := scanUserSummary(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return users, total, nil
+}
+
+// GetUserSummary 返回单个用户的管理信息。
+func GetUserSummary(ctx context.Context, username string) (UserSummary, error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return UserSummary{}, fmt.Errorf("db connection is nil")
+	}
+
+	u, err := scanUserSummary(conn.QueryRowContext(ctx, "select "+userSummaryColumns+" where u.user_name=? limit 1", username))
+	if err == sql.ErrNoRows {
+		return UserSummary{}, fmt.Errorf("user not found")
+	}
+	return u, err
+}
+
+func scanUserSummary(row rowScanner) (UserSummary, error) {
+	var u UserSummary
+	var signupAt, lastActive sql.NullTime
+	err := row.Scan(&u.UserName, &u.Email, &u.Phone, &u.Status, &u.Role, &u.GroupName, &u.Quota,
+		&u.StorageUsed, &u.FileCount, &signupAt, &lastActive, &u.TOTPEnabled, &u.TOTPRequired)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return UserSummary{}, err
+		}
+		return UserSummary{}, fmt.Errorf("failed to scan row: %w", err)
+	}
+	if signupAt.Valid {
+		u.SignupAt = signupAt.Time.Format("2006-01-02 15:04:05")
+	}
+	if lastActive.Valid {
+		u.LastActive = lastActive.Time.Format("2006-01-02 15:04:05")
+	}
+	return u, nil
+}
+
+// UpdateUserStatus 修改用户的账户状态。
+func UpdateUserStatus(ctx context.Context, username string, status int) error {
+	return updateUser(ctx, "update tbl_user set status=? where user_name=?", status, username)
+}
+
+// UpdateUserPassword 修改用户的密码哈希，不影响已有会话，用于以新的代价重新哈希同一密码。
+func UpdateUserPassword(ctx context.Context, username, hashedPwd string) error {
+	return updateUser(ctx, "update tbl_user set user_pwd=? where user_name=?", hashedPwd, username)
+}
+
+// ChangeUserPassword 修改用户的密码哈希并递增会话版本，使已有会话全部失效。
+func ChangeUserPassword(ctx context.Context, username, hashedPwd string) error {
+	return updateUser(ctx, "update tbl_user set user_pwd=?,session_version=session_version+1 where user_name=?", hashedPwd, username)
+}
+
+// GetUserNamesByVerifiedEmail 返回已验证邮箱为 email 的用户。
+func GetUserNamesByVerifiedEmail(ctx context.Context, email string) ([]string, error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return nil, fmt.Errorf("db connection is nil")
+	}
+
+	rows, err := conn.QueryContext(ctx, "select user_name from tbl_user where email=? and email_validated=1 order by id", email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users: %w", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return names, nil
+}
+
+// UpdateUserQuota 修改用户的存储配额，0 表示使用系统默认值。
+func UpdateUserQuota(ctx context.Context, username string, quota int64) error {
+	return updateUser(ctx, "update tbl_user set quota=? where user_name=?", quota, username)
+}
+
+// UpdateUserRole 修改用户的角色。
+func UpdateUserRole(ctx context.Context, username, role string) error {
+	return updateUser(ctx, "update tbl_user set role=? where user_name=?", role, username)
+}
+
+// updateUser 执行针对单个用户的更新，用户不存在时返回 "user not found"。
+func updateUser(ctx context.Context, sqlStr string, args ...any) error {
+	conn := db.DBconn()
+	if conn == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	result, err := conn.ExecContext(ctx, sqlStr, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rows == 0 {
+		// 值未变化时 MySQL 也会返回 0，需要确认用户是否存在。
+		if _, err := GetUserByName(ctx, args[len(args)-1].(string)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateUserEmail 修改用户邮箱并把邮箱标记为未验证。
+func UpdateUserEmail(ctx context.Context, username, email string) error {
+	return updateUser(ctx, "update tbl_user set email=?,email_validated=0 where user_name=?", email, username)
+}
+
+// UpdateUserPhone 修改用户手机号并把手机号标记为未验证。
+func UpdateUserPhone(ctx context.Context, username, phone string) error {
+	return updateUser(ctx, "update tbl_user set phone=?,phone_validated=0 where user_name=?", phone, username)
+}
+
+// UpdateUserProfile 保存用户资料（JSON 文本）。
+func UpdateUserProfile(ctx context.Context, username, profile string) error {
+	return updateUser(ctx, "update tbl_user set profile=? where user_name=?", profile, username)
+}
+
+// MarkUserEmailValidated 在用户邮箱仍为 email 时把它标记为已验证，返回是否标记成功。
+func MarkUserEmailValidated(ctx context.Context, username, email string) (bool, error) {
+	return markUserContactValidated(ctx, "update tbl_user set email_validated=1 where user_name=? and email=?", username, email)
+}
+
+// MarkUserPhoneValidated 在用户手机号仍为 phone 时把它标记为已验证，返回是否标记成功。
+func MarkUserPhoneValidated(ctx context.Context, username, phone string) (bool, error) {
+	return markUserContactValidated(ctx, "update tbl_user set phone_validated=1 where user_name=? and phone=?", username, phone)
+}
+
+func markUserContactValidated(ctx context.Context, sqlStr, username, target string) (bool, error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return false, fmt.Errorf("db connection is nil")
+	}
+
+	result, err := conn.ExecContext(ctx, sqlStr, username, target)
+	if err != nil {
+		return false, fmt.Errorf("failed to update user: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	return rows > 0, nil
+}
@@ -0,0 +1,96 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"filestore-server/pkg/db"
+	"fmt"
+	"time"
+)
+
+// ErrVerifyCodeNotFound 表示没有待验证的验证码。
+var ErrVerifyCodeNotFound = errors.New("verify code not found")
+
+// VerifyCode 是发给用户、用于确认邮箱或手机号的一次性验证码，只保存哈希。
+type VerifyCode struct {
+	UserName string
+	// Channel 为 "email" 或 "sms"，每个用户每个渠道同时只有一个有效验证码。
+	Channel  string
+	Target   string
+	CodeHash string
+	Attempts int
+	ExpireAt time.Time
+	CreateAt time.Time
+}
+
+// SaveVerifyCode 保存验证码，覆盖同一用户同一渠道的旧验证码并重置尝试次数。
+func SaveVerifyCode(ctx context.Context, code VerifyCode) error {
+	const sqlStr = "insert into tbl_user_verify_code (`user_name`,`channel`,`target`,`code_hash`,`attempts`,`expire_at`,`create_at`) values (?,?,?,?,0,?,?) " +
+		"on duplicate key update `target`=values(`target`),`code_hash`=values(`code_hash`),`attempts`=0,`expire_at`=values(`expire_at`),`create_at`=values(`create_at`)"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	if _, err := conn.ExecContext(ctx, sqlStr, code.UserName, code.Channel, code.Target, code.CodeHash, code.ExpireAt, code.CreateAt); err != nil {
+		return fmt.Errorf("failed to save verify code: %w", err)
+	}
+	return nil
+}
+
+// GetVerifyCode 返回用户在某个渠道上的验证码。
+func GetVerifyCode(ctx context.Context, username, channel string) (VerifyCode, error) {
+	const sqlStr = "select user_name,channel,target,code_hash,attempts,expire_at,create_at from tbl_user_verify_code where user_name=? and channel=? limit 1"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return VerifyCode{}, fmt.Errorf("db connection is nil")
+	}
+
+	var code VerifyCode
+	err := conn.QueryRowContext(ctx, sqlStr, username, channel).Scan(
+		&code.UserName, &code.Channel, &code.Target, &code.CodeHash, &code.Attempts, &code.ExpireAt, &code.CreateAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return VerifyCode{}, ErrVerifyCodeNotFound
+		}
+		return VerifyCode{}, fmt.Errorf("failed to query verify code: %w", err)
+	}
+	return code, nil
+}
+
+// IncrVerifyCodeAttempts 在验证码仍为 codeHash 且尝试次数小于 max 时原子地记录一次验证尝试，返回是否记录成功。
+// 只有记录成功的请求才能继续比对验证码，并发请求不会超出尝试次数上限。
+func IncrVerifyCodeAttempts(ctx context.Context, username, channel, codeHash string, max int) (bool, error) {
+	const sqlStr = "update tbl_user_verify_code set attempts=attempts+1 where user_name=? and channel=? and code_hash=? and attempts<?"
+
+	conn := db.DBconn()
+	if conn == nil {
+		return false, fmt.Errorf("db connection is nil")
+	}
+
+	result, err := conn.ExecContext(ctx, sqlStr, username, channel, codeHash, max)
+	if err != nil {
+		return false, fmt.Errorf("failed to update verify code: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	return rows > 0, nil
+}
+
+// DeleteVerifyCode 删除用户在某个渠道上的验证码。
+func DeleteVerifyCode(ctx context.Context, username, channel string) error {
+	conn := db.DBconn()
+	if conn == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	if _, err := conn.ExecContext(ctx, "delete from tbl_user_verify_code where user_name=? and channel=?", username, channel); err != nil {
+		return fmt.Errorf("failed to delete verify code: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"filestore-server/pkg/db"
+	"fmt"
+	"strings"
+)
+
+// ErrVersionNotFound 表示用户路径下不存在指定版本。
+var ErrVersionNotFound = errors.New("version not found")
+
+// ErrDuplicateUserFile 表示用户已在其他文件名下保存了相同内容。
+var ErrDuplicateUserFile = errors.New("same content already stored under another name")
+
+// FileVersion 是用户某个路径下的一个历史版本。
+type FileVersion struct {
+	Version  int
+	FileSha1 string
+	FileName string
+	FileSize int64
+	CreateAt string
+	Current  bool
+}
+
+// SaveUserFileVersion 把 fileSha1 记为用户路径 filename 的当前版本：
+// 路径不存在时新建用户文件，内容变化时追加一个版本，并只保留最近 keep 个版本。
+// 用户已在其他路径下保存了相同内容时返回 ErrDuplicateUserFile。
+// 返回本次清理掉的版本的内容 sha1，调用方据此回收不再被引用的内容。
+func SaveUserFileVersion(ctx context.Context, username, filename, fileSha1 string, fileSize int64, keep int) ([]string, error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return nil, fmt.Errorf("db connection is nil")
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	// 对内容加共享锁直到提交，回收内容时要先拿到排他锁，因此不会删掉这里正在引用的内容。
+	var status int
+	err = tx.QueryRowContext(ctx, "select status from tbl_file where file_sha1=? lock in share mode", fileSha1).Scan(&status)
+	if err == sql.ErrNoRows || (err == nil && status != 0) {
+		return nil, fmt.Errorf("file %s not found", fileSha1)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query file meta: %w", err)
+	}
+
+	var (
+		curID   int64
+		curSha1 string
+		curSize int64
+	)
+	const curSQL = "select id,file_sha1,file_size from tbl_user_file where user_name=? and file_name=? and status=0 order by id desc limit 1 for update"
+	err = tx.QueryRowContext(ctx, curSQL, username, filename).Scan(&curID, &curSha1, &curSize)
+	switch {
+	case err == sql.ErrNoRows:
+		const insertSQL = "insert ignore into tbl_user_file (`user_name`,`file_sha1`,`file_size`,`file_name`,`status`) values (?,?,?,?,0)"
+		result, err := tx.ExecContext(ctx, insertSQL, username, fileSha1, fileSize, filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert user file meta: %w", err)
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get affected rows: %w", err)
+		}
+		if rows <= 0 {
+			// tbl_user_file 按 user_name+file_sha1 唯一，相同内容已在其他文件名下时无法再建一条。
+			return nil, ErrDuplicateUserFile
+		}
+	case err != nil:
+		return nil, fmt.Errorf("failed to query user file meta: %w", err)
+	case curSha1 == fileSha1:
+		return nil, tx.Commit()
+	default:
+		const updateSQL = "update tbl_user_file set file_sha1=?, file_size=? where id=?"
+		if _, err := tx.ExecContext(ctx, updateSQL, fileSha1, fileSize, curID); err != nil {
+			if strings.Contains(err.Error(), "Duplicate entry") {
+				return nil, ErrDuplicateUserFile
+			}
+			return nil, fmt.Errorf("failed to update user file meta: %w", err)
+		}
+	}
+
+	var latest int
+	const latestSQL = "select coalesce(max(version),0) from tbl_user_file_version where user_name=? and file_name=?"
+	if err := tx.QueryRowContext(ctx, latestSQL, username, filename).Scan(&latest); err != nil {
+		return nil, fmt.Errorf("failed to query latest version: %w", err)
+	}
+
+	const insertVersionSQL = "insert into tbl_user_file_version (`user_name`,`file_name`,`version`,`file_sha1`,`file_size`,`status`) values (?,?,?,?,?,0)"
+	if latest == 0 && curSha1 != "" {
+		// 启用版本管理之前上传的文件没有版本记录，先补一条。
+		latest++
+		if _, err := tx.ExecContext(ctx, insertVersionSQL, username, filename, latest, curSha1, curSize); err != nil {
+			return nil, fmt.Errorf("failed to insert file version: %w", err)
+		}
+	}
+	latest++
+	if _, err := tx.ExecContext(ctx, insertVersionSQL, username, filename, latest, fileSha1, fileSize); err != nil {
+		return nil, fmt.Errorf("failed to insert file version: %w", err)
+	}
+
+	var pruned []string
+	if keep > 0 && latest > keep {
+		const prunedSQL = "select distinct file_sha1 from tbl_user_file_version where user_name=? and file_name=? and status=0 and version<=?"
+		rows, err := tx.QueryContext(ctx, prunedSQL, username, filename, latest-keep)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query pruned versions: %w", err)
+		}
+		for rows.Next() {
+			var sha1 string
+			if err := rows.Scan(&sha1); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan row: %w", err)
+			}
+			pruned = append(pruned, sha1)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("rows iteration error: %w", err)
+		}
+
+		const pruneSQL = "update tbl_user_file_version set status=1 where user_name=? and file_name=? and status=0 and version<=?"
+		if _, err := tx.ExecContext(ctx, pruneSQL, username, filename, latest-keep); err != nil {
+			return nil, fmt.Errorf("failed to prune file versions: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit tx: %w", err)
+	}
+	return pruned, nil
+}
+
+// GetUserFileVersions 按版本号倒序返回用户路径下保留的所有版本。
+func GetUserFileVersions(ctx context.Context, username, filename string) ([]FileVersion, error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return nil, fmt.Errorf("db connection is nil")
+	}
+
+	var current string
+	const curSQL = "select file_sha1 from tbl_user_file where user_name=? and file_name=? and status=0 order by id desc limit 1"
+	if err := conn.QueryRowContext(ctx, curSQL, username, filename).Scan(&current); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("file not found")
+		}
+		return nil, fmt.Errorf("failed to query user file meta: %w", err)
+	}
+
+	const sqlStr = "select version,file_sha1,file_name,file_size,create_at from tbl_user_file_version where user_name=? and file_name=? and status=0 order by version desc"
+	rows, err := conn.QueryContext(ctx, sqlStr, username, filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query file versions: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []FileVersion
+	for rows.Next() {
+		var v FileVersion
+		var createAt sql.NullTime
+		if err := rows.Scan(&v.Version, &v.FileSha1, &v.FileName, &v.FileSize, &createAt); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		if createAt.Valid {
+			v.CreateAt = createAt.Time.Format("2006-01-02 15:04:05")
+		}
+		// 同一内容可能因恢复出现多次，只把最新的那条标记为当前版本。
+		if v.FileSha1 == current && len(versions) == 0 {
+			v.Current = true
+		}
+		versions = append(versions, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return versions, nil
+}
+
+// GetUserFileVersion 查询用户路径下内容为 fileSha1 的最新版本。
+func GetUserFileVersion(ctx context.Context, username, filename, fileSha1 string) (FileVersion, error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return FileVersion{}, fmt.Errorf("db connection is nil")
+	}
+
+	const sqlStr = "select version,file_sha1,file_name,file_size from tbl_user_file_version where user_name=? and file_name=? and file_sha1=? and status=0 order by version desc limit 1"
+	var v FileVersion
+	err := conn.QueryRowContext(ctx, sqlStr, username, filename, fileSha1).Scan(&v.Version, &v.FileSha1, &v.FileName, &v.FileSize)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return FileVersion{}, ErrVersionNotFound
+		}
+		return FileVersion{}, fmt.Errorf("failed to query file version: %w", err)
+	}
+	return v, nil
+}
+
+// GetUserMaxVersions 返回用户设置的版本保留数，0 表示使用系统默认值。
+func GetUserMaxVersions(ctx context.Context, username string) (int, error) {
+	conn := db.DBconn()
+	if conn == nil {
+		return 0, fmt.Errorf("db connection is nil")
+	}
+
+	var maxVersions int
+	const sqlStr = "select max_versions from tbl_user where user_name=? limit 1"
+	if err := conn.QueryRowContext(ctx, sqlStr, username).Scan(&maxVersions); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("user not found")
+		}
+		return 0, fmt.Errorf("failed to query max versions: %w", err)
+	}
+	return maxVersions, nil
+}
+
+// UpdateUserMaxVersions 更新用户的版本保留数。
+func UpdateUserMaxVersions(ctx context.Context, username string, maxVersions int) error {
+	conn := db.DBconn()
+	if conn == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	const sqlStr = "update tbl_user set max_versions=? where user_name=?"
+	if _, err := conn.ExecContext(ctx, sqlStr, maxVersions, username); err != nil {
+		return fmt.Errorf("failed to update max versions: %w", err)
+	}
+	return nil
+}
@@ -1,7 +1,9 @@
 package mw
 
 import (
+	"filestore-server/pkg/dao"
 	"net/http"
+	"strings"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
@@ -9,16 +11,73 @@ import (
 
 const SessionUserKey = "user"
 
+// SessionVersionKey 保存登录时用户的会话版本，与 tbl_user.session_version 不一致的会话视为已撤销。
+const SessionVersionKey = "session_version"
+
+// 密码校验通过、等待提交两步验证码的登录状态，此时会话中没有 SessionUserKey。
+const (
+	SessionPendingUserKey    = "pending_2fa_user"
+	SessionPendingVersionKey = "pending_2fa_version"
+	SessionPendingAtKey      = "pending_2fa_at"
+)
+
+// OIDC 登录跳转到身份提供方前保存的参数，回调时校验后删除。
+const (
+	SessionOIDCStateKey    = "oidc_state"
+	SessionOIDCNonceKey    = "oidc_nonce"
+	SessionOIDCVerifierKey = "oidc_verifier"
+	SessionOIDCAtKey       = "oidc_at"
+)
+
+// twoFactorSetupPrefix 是要求启用两步验证但尚未启用的用户仍可访问的接口前缀。
+const twoFactorSetupPrefix = "/user/2fa"
+
 // AuthMiddleware 校验 session。
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		session := sessions.Default(c)
-		user := session.Get(SessionUserKey)
-		if user == nil {
+		user, ok := session.Get(SessionUserKey).(string)
+		if !ok || user == "" {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 			return
 		}
+		// 会话保存在 cookie 中无法主动撤销，每次请求都确认账户仍然可用。
+		u, err := dao.GetUserByName(c.Request.Context(), user)
+		if err != nil {
+			if err.Error() == "user not found" {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to load user"})
+			return
+		}
+		if !u.Enabled() {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "account disabled"})
+			return
+		}
+		// 修改密码前的会话没有版本或版本较旧。
+		if version, _ := session.Get(SessionVersionKey).(int); version != u.SessionVersion {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "session revoked"})
+			return
+		}
+		// 管理员要求启用两步验证时，未启用的用户只能访问两步验证的设置接口。
+		if u.TOTPRequired && !u.TOTPEnabled && !strings.HasPrefix(c.Request.URL.Path, twoFactorSetupPrefix) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "two-factor enrollment required"})
+			return
+		}
 		c.Set(SessionUserKey, user)
 		c.Next()
 	}
 }
+
+// RequireAdmin 只允许管理员访问，需在 AuthMiddleware 之后使用。
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := dao.GetUserByName(c.Request.Context(), c.GetString(SessionUserKey))
+		if err != nil || user.Role != dao.RoleAdmin {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+		c.Next()
+	}
+}
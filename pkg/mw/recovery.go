@@ -0,0 +1,18 @@
+package mw
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Recovery 与 gin.Recovery 相同，但不吞掉 http.ErrAbortHandler：
+// 响应写到一半时 handler 以它 panic，交给 net/http 直接断开连接，客户端不会把截断的响应当作完整响应。
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, err any) {
+		if err == http.ErrAbortHandler {
+			panic(err)
+		}
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
@@ -2,6 +2,9 @@ package mw
 
 import (
 	"encoding/hex"
+	util "filestore-server/pkg/utils"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"path"
 	"strings"
@@ -10,12 +13,17 @@ import (
 )
 
 const (
-	CtxFileHashKey = "filehash"
-	CtxFilenameKey = "filename"
-	CtxOpKey       = "op"
-	CtxUsernameKey = "user_name"
+	CtxFileHashKey   = "filehash"
+	CtxFileHashesKey = "filehashes"
+	CtxFilenameKey   = "filename"
+	CtxOpKey         = "op"
+	CtxUsernameKey   = "user_name"
+	CtxMimeTypeKey   = "mime_type"
 )
 
+// MaxBatchFileHashes 是一次批量请求最多允许的 filehash 个数。
+const MaxBatchFileHashes = 1000
+
 // paramFromQueryOrPost 从 gin.Context 中按优先级获取给定键的参数值。
 // 优先从 POST 表单（c.PostForm(key)）读取；如果该值非空则返回之。
 // 否则退回到 URL 查询参数（c.Query(key)）并返回其值。
@@ -51,6 +59,71 @@ func RequireFileHash() gin.HandlerFunc {
 	}
 }
 
+// RequireFileHashes 校验批量请求中的 filehash 列表（可重复传参或逗号分隔），去重后写入 gin context。
+func RequireFileHashes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var raw []string
+		raw = append(raw, c.PostFormArray("filehash")...)
+		raw = append(raw, c.QueryArray("filehash")...)
+
+		seen := make(map[string]bool)
+		var hashes []string
+		for _, item := range raw {
+			for _, filehash := range strings.Split(item, ",") {
+				normalized := strings.ToLower(strings.TrimSpace(filehash))
+				if normalized == "" || seen[normalized] {
+					continue
+				}
+				if !isSha1Hex(normalized) {
+					c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid filehash"})
+					return
+				}
+				seen[normalized] = true
+				hashes = append(hashes, normalized)
+			}
+		}
+
+		if len(hashes) == 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing filehash parameter"})
+			return
+		}
+		if len(hashes) > MaxBatchFileHashes {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "too many filehashes"})
+			return
+		}
+
+		c.Set(CtxFileHashesKey, hashes)
+		c.Next()
+	}
+}
+
+// RequireArchiveSelection 校验打包下载的范围：传了 dir 时打包该目录，不能再传 filehash；
+// 否则按 RequireFileHashes 校验 filehash 列表。dir 的路径由 service 校验。
+func RequireArchiveSelection() gin.HandlerFunc {
+	requireHashes := RequireFileHashes()
+	return func(c *gin.Context) {
+		_, inQuery := c.GetQuery("dir")
+		_, inForm := c.GetPostForm("dir")
+		if !inQuery && !inForm {
+			requireHashes(c)
+			return
+		}
+		if len(c.QueryArray("filehash")) > 0 || len(c.PostFormArray("filehash")) > 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "use either dir or filehash"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func isSha1Hex(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
 // RequireFilename 校验 filename 必填，并写入 gin context。
 func RequireFilename() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -104,11 +177,33 @@ func RequireUploadFile(fieldName string) gin.HandlerFunc {
 			return
 		}
 
+		mimeType, err := sniffMimeType(fileHeader, safe)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "failed to read file from form"})
+			return
+		}
+
 		c.Set(CtxFilenameKey, safe)
+		c.Set(CtxMimeTypeKey, mimeType)
 		c.Next()
 	}
 }
 
+// sniffMimeType 读取上传文件的文件头识别类型。
+func sniffMimeType(fileHeader *multipart.FileHeader, filename string) (string, error) {
+	f, err := fileHeader.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sample := make([]byte, util.MimeSampleSize)
+	n, err := io.ReadFull(f, sample)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return util.DetectMimeType(filename, sample[:n]), nil
+}
+
 func RequireUsername() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		username := strings.TrimSpace(paramFromQueryOrPost(c, "user_name"))
@@ -0,0 +1,20 @@
+package notify
+
+import (
+	"context"
+	"log"
+)
+
+// LogNotifier 把通知写入日志而不实际投递，用于开发环境和没有配置短信网关的部署。
+type LogNotifier struct {
+	Logger *log.Logger
+}
+
+func (n LogNotifier) Send(ctx context.Context, msg Message) error {
+	logf := log.Printf
+	if n.Logger != nil {
+		logf = n.Logger.Printf
+	}
+	logf("notify %s to %s: %s\n%s", msg.Channel, msg.To, msg.Subject, msg.Body)
+	return nil
+}
@@ -0,0 +1,28 @@
+package notify
+
+import (
+	"context"
+	"errors"
+)
+
+// 消息的投递渠道。
+const (
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+)
+
+// ErrUnsupportedChannel 表示通知器不支持消息的投递渠道。
+var ErrUnsupportedChannel = errors.New("unsupported notification channel")
+
+// Message 是一条发给用户的通知，To 为邮箱地址或手机号。
+type Message struct {
+	Channel string
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier 投递通知。投递失败时返回 error，由调用方决定是否重试。
+type Notifier interface {
+	Send(ctx context.Context, msg Message) error
+}
@@ -0,0 +1,18 @@
+package objstore
+
+import (
+	"context"
+	"io"
+)
+
+// Store 是文件内容的二级存储后端，对象以 key 寻址。
+type Store interface {
+	// Put 上传 size 字节的对象，同名对象会被覆盖。
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get 打开对象，对象不存在时返回的错误满足 errors.Is(err, fs.ErrNotExist)。
+	Get(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	// Delete 删除对象，对象不存在时不报错。
+	Delete(ctx context.Context, key string) error
+	// Location 返回对象的地址，用于记录在 tbl_file.file_addr 中。
+	Location(key string) string
+}
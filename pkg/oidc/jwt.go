@@ -0,0 +1,244 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	// clockSkew 为校验 exp、iat 时容许的时钟误差。
+	clockSkew = time.Minute
+	// jwksRefreshInterval 是两次读取 JWKS 的最短间隔，避免伪造的 kid 让每个请求都访问身份提供方。
+	jwksRefreshInterval = 10 * time.Second
+	// minRSABits 是接受的 RSA 公钥最小长度。
+	minRSABits = 2048
+)
+
+// Claims 是 ID Token 中用到的声明，Raw 保留全部声明以便读取自定义的组声明。
+type Claims struct {
+	Issuer            string         `json:"iss"`
+	Subject           string         `json:"sub"`
+	Nonce             string         `json:"nonce"`
+	Email             string         `json:"email"`
+	EmailVerified     bool           `json:"email_verified"`
+	PreferredUsername string         `json:"preferred_username"`
+	Name              string         `json:"name"`
+	Raw               map[string]any `json:"-"`
+}
+
+// StringList 返回声明 name 中的字符串列表，声明为单个字符串时返回只含它的列表。
+func (c *Claims) StringList(name string) []string {
+	switch v := c.Raw[name].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var list []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+
+	key *rsa.PublicKey
+}
+
+// VerifyIDToken 校验 ID Token 的 RS256 签名、iss、aud、exp、iat 及 nonce，返回其中的声明。
+func (c *Client) VerifyIDToken(ctx context.Context, raw, nonce string) (*Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: bad header", ErrInvalidToken)
+	}
+	// 只接受 RS256，避免 alg=none 或以公钥作为 HMAC 密钥的攻击。
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("%w: unsupported alg %q", ErrInvalidToken, header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad signature encoding", ErrInvalidToken)
+	}
+	key, err := c.publicKey(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return nil, fmt.Errorf("%w: signature mismatch", ErrInvalidToken)
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: bad payload", ErrInvalidToken)
+	}
+	if err := decodeSegment(parts[1], &claims.Raw); err != nil {
+		return nil, fmt.Errorf("%w: bad payload", ErrInvalidToken)
+	}
+	if err := c.checkClaims(&claims, nonce, time.Now()); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+func (c *Client) checkClaims(claims *Claims, nonce string, now time.Time) error {
+	if strings.TrimSuffix(claims.Issuer, "/") != strings.TrimSuffix(c.meta.Issuer, "/") {
+		return fmt.Errorf("%w: issuer mismatch", ErrInvalidToken)
+	}
+	if claims.Subject == "" {
+		return fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	}
+	aud := claims.StringList("aud")
+	found := false
+	for _, a := range aud {
+		found = found || a == c.cfg.ClientID
+	}
+	if !found {
+		return fmt.Errorf("%w: audience mismatch", ErrInvalidToken)
+	}
+	// 多个受众时 azp 必须是本客户端。
+	if azp, _ := claims.Raw["azp"].(string); len(aud) > 1 && azp != c.cfg.ClientID {
+		return fmt.Errorf("%w: authorized party mismatch", ErrInvalidToken)
+	}
+	exp, ok := claims.Raw["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return fmt.Errorf("%w: token expired", ErrInvalidToken)
+	}
+	if iat, ok := claims.Raw["iat"].(float64); ok && time.Unix(int64(iat), 0).After(now.Add(clockSkew)) {
+		return fmt.Errorf("%w: token issued in the future", ErrInvalidToken)
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+	}
+	return nil
+}
+
+// publicKey 返回 kid 对应的公钥，未知的 kid 会重新读取 JWKS，以支持身份提供方轮换签名密钥。
+// 每 jwksRefreshInterval 最多读取一次，读取时不持有锁，同时到来的请求等待这次读取的结果。
+func (c *Client) publicKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	for {
+		c.mu.Lock()
+		if k := findKey(c.keys, kid); k != nil {
+			c.mu.Unlock()
+			return k, nil
+		}
+		if wait := c.refreshing; wait != nil {
+			c.mu.Unlock()
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		if !c.fetchedAt.IsZero() && time.Since(c.fetchedAt) < jwksRefreshInterval {
+			c.mu.Unlock()
+			return nil, fmt.Errorf("%w: unknown key id %q", ErrInvalidToken, kid)
+		}
+		done := make(chan struct{})
+		c.refreshing, c.fetchedAt = done, time.Now()
+		c.mu.Unlock()
+
+		keys, err := c.fetchKeys(ctx)
+
+		c.mu.Lock()
+		if err == nil {
+			c.keys = keys
+		}
+		c.refreshing = nil
+		close(done)
+		c.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// fetchKeys 读取 JWKS，只保留用于签名的 RSA 密钥，跳过无法解析或长度不足的密钥。
+func (c *Client) fetchKeys(ctx context.Context) (map[string]*jwk, error) {
+	var set struct {
+		Keys []*jwk `json:"keys"`
+	}
+	if err := c.getJSON(ctx, c.meta.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch jwks: %w", err)
+	}
+	keys := make(map[string]*jwk)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		pub, err := k.rsaKey()
+		if err != nil {
+			continue
+		}
+		k.key = pub
+		keys[k.Kid] = k
+	}
+	return keys, nil
+}
+
+// findKey 按 kid 查找公钥；令牌没有 kid 且只有一个密钥时使用该密钥。
+func findKey(keys map[string]*jwk, kid string) *rsa.PublicKey {
+	if k, ok := keys[kid]; ok {
+		return k.key
+	}
+	if kid == "" && len(keys) == 1 {
+		for _, k := range keys {
+			return k.key
+		}
+	}
+	return nil
+}
+
+func (k *jwk) rsaKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	exp := new(big.Int).SetBytes(e)
+	if !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("invalid rsa exponent")
+	}
+	modulus := new(big.Int).SetBytes(n)
+	if modulus.BitLen() < minRSABits {
+		return nil, fmt.Errorf("rsa key too small: %d bits", modulus.BitLen())
+	}
+	return &rsa.PublicKey{N: modulus, E: int(exp.Int64())}, nil
+}
+
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
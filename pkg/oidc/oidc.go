@@ -0,0 +1,191 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidToken 表示 ID Token 的签名或声明校验失败。
+var ErrInvalidToken = errors.New("invalid id token")
+
+// Config 是在身份提供方注册的客户端配置。
+type Config struct {
+	// Issuer 为身份提供方的地址，发现文档位于 Issuer + "/.well-known/openid-configuration"。
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// Scopes 为空时使用 openid、email、profile。
+	Scopes []string
+	// HTTPClient 为空时使用超时 10 秒的默认客户端。
+	HTTPClient *http.Client
+}
+
+// Metadata 是发现文档中用到的字段。
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Token 是授权码换取的令牌。
+type Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// Client 实现授权码 + PKCE 流程，并校验 RS256 签名的 ID Token。
+type Client struct {
+	cfg  Config
+	meta Metadata
+	hc   *http.Client
+
+	mu         sync.Mutex
+	keys       map[string]*jwk
+	fetchedAt  time.Time
+	refreshing chan struct{}
+}
+
+// NewClient 读取身份提供方的发现文档并创建 Client，发现文档中的 issuer 必须与配置一致。
+func NewClient(ctx context.Context, cfg Config) (*Client, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" {
+		return nil, fmt.Errorf("oidc issuer and client id are required")
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	c := &Client{cfg: cfg, hc: cfg.HTTPClient}
+	if c.hc == nil {
+		c.hc = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	issuer := strings.TrimSuffix(cfg.Issuer, "/")
+	if err := c.getJSON(ctx, issuer+"/.well-known/openid-configuration", &c.meta); err != nil {
+		return nil, fmt.Errorf("failed to discover oidc provider: %w", err)
+	}
+	if strings.TrimSuffix(c.meta.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("oidc issuer mismatch: %q", c.meta.Issuer)
+	}
+	if c.meta.AuthorizationEndpoint == "" || c.meta.TokenEndpoint == "" || c.meta.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc discovery document is incomplete")
+	}
+	return c, nil
+}
+
+// Metadata 返回发现文档。
+func (c *Client) Metadata() Metadata {
+	return c.meta
+}
+
+// AuthCodeURL 返回跳转到身份提供方登录的地址，challenge 为 S256 方式的 PKCE 挑战。
+func (c *Client) AuthCodeURL(state, nonce, challenge string) string {
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", c.cfg.ClientID)
+	q.Set("redirect_uri", c.cfg.RedirectURL)
+	q.Set("scope", strings.Join(c.cfg.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", challenge)
+	q.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(c.meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return c.meta.AuthorizationEndpoint + sep + q.Encode()
+}
+
+// Exchange 用授权码和 PKCE 校验码换取令牌，配置了 ClientSecret 时使用 HTTP Basic 认证客户端。
+func (c *Client) Exchange(ctx context.Context, code, verifier string) (*Token, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", c.cfg.RedirectURL)
+	form.Set("code_verifier", verifier)
+	if c.cfg.ClientSecret == "" {
+		form.Set("client_id", c.cfg.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.cfg.ClientID), url.QueryEscape(c.cfg.ClientSecret))
+	}
+
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange code: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		json.Unmarshal(body, &e)
+		return nil, fmt.Errorf("token endpoint returned %d: %s %s", resp.StatusCode, e.Error, e.Description)
+	}
+
+	var tok Token
+	if err := json.Unmarshal(body, &tok); err != nil {
+		return nil, fmt.Errorf("invalid token response: %w", err)
+	}
+	if tok.IDToken == "" {
+		return nil, fmt.Errorf("token response has no id_token")
+	}
+	return &tok, nil
+}
+
+func (c *Client) getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", u, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// RandomString 返回 n 字节随机数的 base64url 编码，用于 state、nonce 和 PKCE 校验码。
+func RandomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate random string: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Challenge 返回 PKCE 校验码的 S256 挑战。
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
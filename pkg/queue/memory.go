@@ -0,0 +1,133 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memorySweepInterval 是清理过期任务的最短间隔。
+const memorySweepInterval = time.Minute
+
+// MemoryBackend 是进程内的任务存储，Redis 不可用时使用；进程退出后任务丢失。
+// 与 Redis 一致，已结束的任务在 jobTTL 后删除，死信队列最多保留 maxDeadJobs 个任务。
+type MemoryBackend struct {
+	mu        sync.Mutex
+	jobs      map[string]Job
+	ready     []string
+	dead      []string
+	notify    chan struct{}
+	lastSweep time.Time
+}
+
+// NewMemoryBackend 创建进程内任务存储。
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		jobs:   make(map[string]Job),
+		notify: make(chan struct{}, 1),
+	}
+}
+
+func (m *MemoryBackend) Push(ctx context.Context, job Job) error {
+	m.mu.Lock()
+	m.jobs[job.ID] = job
+	m.ready = append(m.ready, job.ID)
+	m.mu.Unlock()
+
+	select {
+	case m.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (m *MemoryBackend) Pop(ctx context.Context, timeout time.Duration) (Job, bool, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		m.mu.Lock()
+		if len(m.ready) > 0 {
+			id := m.ready[0]
+			m.ready = m.ready[1:]
+			job := m.jobs[id]
+			m.mu.Unlock()
+			return job, true, nil
+		}
+		m.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return Job{}, false, nil
+		case <-timer.C:
+			return Job{}, false, nil
+		case <-m.notify:
+		}
+	}
+}
+
+func (m *MemoryBackend) Schedule(ctx context.Context, job Job, at time.Time) error {
+	if err := m.Save(ctx, job); err != nil {
+		return err
+	}
+	time.AfterFunc(time.Until(at), func() {
+		m.mu.Lock()
+		job, ok := m.jobs[job.ID]
+		m.mu.Unlock()
+		if ok {
+			m.Push(context.Background(), job)
+		}
+	})
+	return nil
+}
+
+func (m *MemoryBackend) Save(ctx context.Context, job Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[job.ID] = job
+	m.sweep(time.Now())
+	return nil
+}
+
+func (m *MemoryBackend) Get(ctx context.Context, id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+	return job, nil
+}
+
+func (m *MemoryBackend) Dead(ctx context.Context, job Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[job.ID] = job
+	m.dead = append(m.dead, job.ID)
+	if len(m.dead) > maxDeadJobs {
+		delete(m.jobs, m.dead[0])
+		m.dead = m.dead[1:]
+	}
+	m.sweep(time.Now())
+	return nil
+}
+
+// sweep 删除已结束且超过 jobTTL 未更新的任务，每 memorySweepInterval 最多执行一次。调用方需持有 m.mu。
+func (m *MemoryBackend) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < memorySweepInterval {
+		return
+	}
+	m.lastSweep = now
+
+	for id, job := range m.jobs {
+		if (job.Status == StatusDone || job.Status == StatusDead) && now.Sub(job.UpdateAt) > jobTTL {
+			delete(m.jobs, id)
+		}
+	}
+	dead := m.dead[:0]
+	for _, id := range m.dead {
+		if _, ok := m.jobs[id]; ok {
+			dead = append(dead, id)
+		}
+	}
+	m.dead = dead
+}
@@ -0,0 +1,276 @@
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	StatusPending  = "pending"
+	StatusRunning  = "running"
+	StatusRetrying = "retrying"
+	StatusDone     = "done"
+	// StatusDead 表示重试次数用尽或遇到不可重试的错误，任务已进入死信队列。
+	StatusDead = "dead"
+
+	defaultMaxAttempts = 5
+	defaultBaseBackoff = time.Second
+	maxBackoff         = 10 * time.Minute
+	popTimeout         = time.Second
+
+	// jobTTL 是任务状态最后一次更新后保留的时长。
+	jobTTL = 7 * 24 * time.Hour
+	// maxDeadJobs 是死信队列保留的任务数，超出时丢弃最早进入的任务。
+	maxDeadJobs = 1000
+
+	// Unlimited 作为 maxAttempts 注册时任务失败后一直重试（退避时间有上限），只有不可重试的错误才进入死信队列。
+	Unlimited = -1
+)
+
+// ErrJobNotFound 表示任务不存在或已过期。
+var ErrJobNotFound = errors.New("job not found")
+
+// Job 是队列中的一个任务及其当前状态。
+type Job struct {
+	ID          string
+	Type        string
+	Owner       string `json:",omitempty"`
+	Payload     json.RawMessage
+	Result      json.RawMessage `json:",omitempty"`
+	Status      string
+	Attempts    int
+	MaxAttempts int
+	Error       string `json:",omitempty"`
+	CreateAt    time.Time
+	UpdateAt    time.Time
+}
+
+// Decode 把任务参数解析到 v。
+func (j *Job) Decode(v any) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+// SetResult 记录任务结果，会随任务状态一起保存。
+func (j *Job) SetResult(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	j.Result = data
+	return nil
+}
+
+// Handler 处理一种类型的任务，返回错误时任务会按退避策略重试。
+type Handler func(ctx context.Context, job *Job) error
+
+type permanentError struct{ err error }
+
+func (p permanentError) Error() string { return p.err.Error() }
+func (p permanentError) Unwrap() error { return p.err }
+
+// Permanent 包装不可重试的错误，任务会直接进入死信队列。
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// Backend 负责任务的存储和分发。
+type Backend interface {
+	// Push 保存任务并放入就绪队列。
+	Push(ctx context.Context, job Job) error
+	// Pop 取出一个就绪任务，timeout 内没有任务时返回 ok=false。
+	Pop(ctx context.Context, timeout time.Duration) (job Job, ok bool, err error)
+	// Schedule 保存任务并在 at 时刻重新放入就绪队列。
+	Schedule(ctx context.Context, job Job, at time.Time) error
+	// Save 更新任务状态。
+	Save(ctx context.Context, job Job) error
+	// Get 查询任务状态。
+	Get(ctx context.Context, id string) (Job, error)
+	// Dead 保存任务并放入死信队列。
+	Dead(ctx context.Context, job Job) error
+}
+
+// Options 控制队列的重试与并发。
+type Options struct {
+	Workers     int
+	MaxAttempts int
+	BaseBackoff time.Duration
+}
+
+// Queue 是带重试、退避和死信队列的任务队列。
+type Queue struct {
+	backend  Backend
+	opts     Options
+	mu       sync.RWMutex
+	handlers map[string]Handler
+	attempts map[string]int
+}
+
+// New 创建队列，调用 Start 后开始消费任务。
+func New(backend Backend, opts Options) *Queue {
+	if opts.Workers <= 0 {
+		opts.Workers = 4
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultMaxAttempts
+	}
+	if opts.BaseBackoff <= 0 {
+		opts.BaseBackoff = defaultBaseBackoff
+	}
+	return &Queue{
+		backend:  backend,
+		opts:     opts,
+		handlers: make(map[string]Handler),
+		attempts: make(map[string]int),
+	}
+}
+
+// Register 注册任务处理函数；maxAttempts 为 0 时使用队列默认值，为 Unlimited 时不限重试次数。
+func (q *Queue) Register(jobType string, maxAttempts int, h Handler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[jobType] = h
+	q.attempts[jobType] = maxAttempts
+}
+
+// Enqueue 创建任务并放入队列，返回任务 ID。
+func (q *Queue) Enqueue(ctx context.Context, jobType, owner string, payload any) (string, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode job payload: %w", err)
+	}
+
+	q.mu.RLock()
+	maxAttempts := q.attempts[jobType]
+	q.mu.RUnlock()
+	if maxAttempts == 0 {
+		maxAttempts = q.opts.MaxAttempts
+	}
+
+	now := time.Now()
+	job := Job{
+		ID:          newID(),
+		Type:        jobType,
+		Owner:       owner,
+		Payload:     data,
+		Status:      StatusPending,
+		MaxAttempts: maxAttempts,
+		CreateAt:    now,
+		UpdateAt:    now,
+	}
+	if err := q.backend.Push(ctx, job); err != nil {
+		return "", fmt.Errorf("failed to enqueue job: %w", err)
+	}
+	return job.ID, nil
+}
+
+// Get 查询任务状态。
+func (q *Queue) Get(ctx context.Context, id string) (Job, error) {
+	return q.backend.Get(ctx, id)
+}
+
+// Start 启动 worker 池，直到 ctx 取消。
+func (q *Queue) Start(ctx context.Context) {
+	for i := 0; i < q.opts.Workers; i++ {
+		go q.work(ctx)
+	}
+}
+
+func (q *Queue) work(ctx context.Context) {
+	for ctx.Err() == nil {
+		job, ok, err := q.backend.Pop(ctx, popTimeout)
+		if err != nil {
+			log.Printf("queue pop failed: %v", err)
+			time.Sleep(popTimeout)
+			continue
+		}
+		if ok {
+			q.run(ctx, job)
+		}
+	}
+}
+
+func (q *Queue) run(ctx context.Context, job Job) {
+	q.mu.RLock()
+	h, ok := q.handlers[job.Type]
+	q.mu.RUnlock()
+
+	job.Attempts++
+	job.Status = StatusRunning
+	job.UpdateAt = time.Now()
+	if !ok {
+		q.fail(ctx, job, Permanent(fmt.Errorf("no handler for job type %s", job.Type)))
+		return
+	}
+	if err := q.backend.Save(ctx, job); err != nil {
+		log.Printf("queue save job %s failed: %v", job.ID, err)
+	}
+
+	err := safeRun(ctx, h, &job)
+	job.UpdateAt = time.Now()
+	if err != nil {
+		q.fail(ctx, job, err)
+		return
+	}
+
+	job.Status = StatusDone
+	job.Error = ""
+	if err := q.backend.Save(ctx, job); err != nil {
+		log.Printf("queue save job %s failed: %v", job.ID, err)
+	}
+}
+
+// fail 按剩余次数决定重试还是进入死信队列。
+func (q *Queue) fail(ctx context.Context, job Job, err error) {
+	job.Error = err.Error()
+
+	var perm permanentError
+	if errors.As(err, &perm) || (job.MaxAttempts != Unlimited && job.Attempts >= job.MaxAttempts) {
+		job.Status = StatusDead
+		if err := q.backend.Dead(ctx, job); err != nil {
+			log.Printf("queue dead-letter job %s failed: %v", job.ID, err)
+		}
+		log.Printf("job %s (%s) moved to dead letter queue: %s", job.ID, job.Type, job.Error)
+		return
+	}
+
+	job.Status = StatusRetrying
+	if err := q.backend.Schedule(ctx, job, time.Now().Add(q.backoff(job.Attempts))); err != nil {
+		log.Printf("queue schedule retry of job %s failed: %v", job.ID, err)
+	}
+}
+
+// backoff 返回第 attempt 次失败后的等待时间，按指数增长并设置上限。
+func (q *Queue) backoff(attempt int) time.Duration {
+	d := q.opts.BaseBackoff
+	for i := 1; i < attempt && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
+
+func safeRun(ctx context.Context, h Handler, job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return h(ctx, job)
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,260 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	keyReady   = "filestore:jobs:ready"
+	keyDelayed = "filestore:jobs:delayed"
+	keyDead    = "filestore:jobs:dead"
+	keyJob     = "filestore:job:"
+	// keyProcessing 加实例 ID 为该实例已取出、尚未结束的任务列表。
+	keyProcessing = "filestore:jobs:processing:"
+	// keyWorkers 记录所有实例 ID，keyWorker 加实例 ID 为实例的心跳。
+	keyWorkers = "filestore:jobs:workers"
+	keyWorker  = "filestore:jobs:worker:"
+
+	// workerTTL 是实例心跳的有效期，心跳过期的实例视为已退出，其未结束的任务重新放回就绪队列。
+	workerTTL = 30 * time.Second
+)
+
+// RedisBackend 把任务保存在 Redis：就绪队列为 list，延迟重试为 sorted set，任务状态为带过期时间的 string。
+// 取出的任务先移到本实例的处理中列表，结束后才删除，实例崩溃时由其他实例或重启后的实例重新投递。
+type RedisBackend struct {
+	pool       *redis.Pool
+	id         string
+	processing string
+}
+
+// NewRedisBackend 创建 Redis 任务存储，重新投递已退出实例未完成的任务，
+// 并启动维持心跳、把到期的延迟任务移回就绪队列的协程。
+func NewRedisBackend(ctx context.Context, pool *redis.Pool) *RedisBackend {
+	id := newID()
+	b := &RedisBackend{pool: pool, id: id, processing: keyProcessing + id}
+
+	conn := pool.Get()
+	b.heartbeat(conn)
+	b.requeueOrphans(conn)
+	conn.Close()
+
+	go b.maintain(ctx)
+	return b
+}
+
+// Available 检查 Redis 是否可用。
+func Available(pool *redis.Pool) bool {
+	if pool == nil {
+		return false
+	}
+	conn := pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("PING")
+	return err == nil
+}
+
+func (b *RedisBackend) Push(ctx context.Context, job Job) error {
+	conn := b.pool.Get()
+	defer conn.Close()
+
+	if err := saveJob(conn, job); err != nil {
+		return err
+	}
+	_, err := conn.Do("LPUSH", keyReady, job.ID)
+	return err
+}
+
+func (b *RedisBackend) Pop(ctx context.Context, timeout time.Duration) (Job, bool, error) {
+	conn := b.pool.Get()
+	defer conn.Close()
+
+	id, err := redis.String(conn.Do("BRPOPLPUSH", keyReady, b.processing, int(timeout.Seconds())))
+	if err == redis.ErrNil {
+		return Job{}, false, nil
+	}
+	if err != nil {
+		return Job{}, false, err
+	}
+	job, err := loadJob(conn, id)
+	if err == ErrJobNotFound {
+		// 状态已过期的任务直接丢弃。
+		b.ack(conn, id)
+		return Job{}, false, nil
+	}
+	if err != nil {
+		return Job{}, false, err
+	}
+	return job, true, nil
+}
+
+func (b *RedisBackend) Schedule(ctx context.Context, job Job, at time.Time) error {
+	conn := b.pool.Get()
+	defer conn.Close()
+
+	if err := saveJob(conn, job); err != nil {
+		return err
+	}
+	if _, err := conn.Do("ZADD", keyDelayed, at.UnixMilli(), job.ID); err != nil {
+		return err
+	}
+	b.ack(conn, job.ID)
+	return nil
+}
+
+func (b *RedisBackend) Save(ctx context.Context, job Job) error {
+	conn := b.pool.Get()
+	defer conn.Close()
+	if err := saveJob(conn, job); err != nil {
+		return err
+	}
+	if job.Status == StatusDone {
+		b.ack(conn, job.ID)
+	}
+	return nil
+}
+
+func (b *RedisBackend) Get(ctx context.Context, id string) (Job, error) {
+	conn := b.pool.Get()
+	defer conn.Close()
+	return loadJob(conn, id)
+}
+
+func (b *RedisBackend) Dead(ctx context.Context, job Job) error {
+	conn := b.pool.Get()
+	defer conn.Close()
+
+	if err := saveJob(conn, job); err != nil {
+		return err
+	}
+	if _, err := conn.Do("LPUSH", keyDead, job.ID); err != nil {
+		return err
+	}
+	if _, err := conn.Do("LTRIM", keyDead, 0, maxDeadJobs-1); err != nil {
+		return err
+	}
+	b.ack(conn, job.ID)
+	return nil
+}
+
+// ack 把结束的任务从本实例的处理中列表删除，失败只记录日志：任务状态已保存，重复投递时仍会按状态处理。
+func (b *RedisBackend) ack(conn redis.Conn, id string) {
+	if _, err := conn.Do("LREM", b.processing, 1, id); err != nil {
+		log.Printf("queue ack job %s failed: %v", id, err)
+	}
+}
+
+// maintain 每秒维持心跳并把到期的延迟任务移回就绪队列，每 workerTTL 检查一次已退出的实例。
+func (b *RedisBackend) maintain(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	lastCheck := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		conn := b.pool.Get()
+		b.heartbeat(conn)
+		b.promoteDelayed(conn)
+		if time.Since(lastCheck) >= workerTTL {
+			lastCheck = time.Now()
+			b.requeueOrphans(conn)
+		}
+		conn.Close()
+	}
+}
+
+// heartbeat 登记本实例并刷新心跳。
+func (b *RedisBackend) heartbeat(conn redis.Conn) {
+	if _, err := conn.Do("SADD", keyWorkers, b.id); err != nil {
+		log.Printf("queue register worker failed: %v", err)
+		return
+	}
+	if _, err := conn.Do("SET", keyWorker+b.id, time.Now().Unix(), "EX", int(workerTTL.Seconds())); err != nil {
+		log.Printf("queue heartbeat failed: %v", err)
+	}
+}
+
+// promoteDelayed 把到期的延迟任务移回就绪队列；ZREM 成功的实例才负责移动，避免多实例重复投递。
+func (b *RedisBackend) promoteDelayed(conn redis.Conn) {
+	ids, err := redis.Strings(conn.Do("ZRANGEBYSCORE", keyDelayed, "-inf", time.Now().UnixMilli(), "LIMIT", 0, 100))
+	if err != nil {
+		log.Printf("queue load delayed jobs failed: %v", err)
+	}
+	for _, id := range ids {
+		removed, err := redis.Int(conn.Do("ZREM", keyDelayed, id))
+		if err != nil || removed == 0 {
+			continue
+		}
+		if _, err := conn.Do("LPUSH", keyReady, id); err != nil {
+			log.Printf("queue promote job %s failed: %v", id, err)
+		}
+	}
+}
+
+// requeueOrphans 把心跳已过期的实例处理中列表里的任务放回就绪队列，并注销这些实例。
+// RPOPLPUSH 逐个移动任务，多个实例同时检查时每个任务也只会被放回一次。
+func (b *RedisBackend) requeueOrphans(conn redis.Conn) {
+	ids, err := redis.Strings(conn.Do("SMEMBERS", keyWorkers))
+	if err != nil {
+		log.Printf("queue load workers failed: %v", err)
+		return
+	}
+	for _, id := range ids {
+		if id == b.id {
+			continue
+		}
+		alive, err := redis.Bool(conn.Do("EXISTS", keyWorker+id))
+		if err != nil || alive {
+			continue
+		}
+		n := 0
+		for {
+			_, err = redis.String(conn.Do("RPOPLPUSH", keyProcessing+id, keyReady))
+			if err != nil {
+				break
+			}
+			n++
+		}
+		if err == redis.ErrNil {
+			conn.Do("SREM", keyWorkers, id)
+		} else {
+			log.Printf("queue requeue jobs of worker %s failed: %v", id, err)
+		}
+		if n > 0 {
+			log.Printf("queue requeued %d unfinished jobs of stopped worker %s", n, id)
+		}
+	}
+}
+
+func saveJob(conn redis.Conn, job Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to encode job: %w", err)
+	}
+	_, err = conn.Do("SET", keyJob+job.ID, data, "EX", int(jobTTL.Seconds()))
+	return err
+}
+
+func loadJob(conn redis.Conn, id string) (Job, error) {
+	data, err := redis.Bytes(conn.Do("GET", keyJob+id))
+	if err == redis.ErrNil {
+		return Job{}, ErrJobNotFound
+	}
+	if err != nil {
+		return Job{}, err
+	}
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return Job{}, fmt.Errorf("failed to decode job: %w", err)
+	}
+	return job, nil
+}
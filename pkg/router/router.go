@@ -11,7 +11,8 @@ import (
 
 // New 构建 gin.Engine，注册路由与 session 中间件。
 func New() *gin.Engine {
-	r := gin.Default()
+	r := gin.New()
+	r.Use(gin.Logger(), mw.Recovery())
 
 	store := cookie.NewStore([]byte("filestore-session-secret"))
 	store.Options(sessions.Options{
@@ -24,7 +25,12 @@ func New() *gin.Engine {
 
 	r.POST("/user/signup", api.Signup)
 	r.POST("/user/login", api.Login)
+	r.POST("/user/login/2fa", api.LoginSecondFactor)
+	r.GET("/user/oidc/login", api.OIDCLogin)
+	r.GET("/user/oidc/callback", api.OIDCCallback)
 	r.POST("/user/logout", api.Logout)
+	r.POST("/user/password/forgot", api.PasswordForgot)
+	r.POST("/user/password/reset", api.PasswordReset)
 
 	auth := r.Group("/")
 	auth.Use(mw.AuthMiddleware())
@@ -35,5 +41,71 @@ func New() *gin.Engine {
 	auth.POST("/file/update", mw.RequireFileHash(), mw.RequireOp("0"), mw.RequireFilename(), api.FileMetaUpdate)
 	auth.POST("/file/delete", mw.RequireFileHash(), api.FileDelete)
 	auth.POST("/user/filelist",mw.RequireUsername(),api.UserFilelistQuery)
+	auth.GET("/file/versions", mw.RequireFilename(), api.FileVersionList)
+	auth.GET("/file/version/download", mw.RequireFilename(), mw.RequireFileHash(), api.FileVersionDownload)
+	auth.POST("/file/version/restore", mw.RequireFilename(), mw.RequireFileHash(), api.FileVersionRestore)
+	auth.POST("/user/version/policy", api.VersionPolicyUpdate)
+	auth.GET("/file/batch/download", mw.RequireArchiveSelection(), api.BatchDownload)
+	auth.POST("/file/batch/download", mw.RequireArchiveSelection(), api.BatchDownload)
+	auth.POST("/file/batch", api.FileBatch)
+	auth.POST("/file/move", mw.RequireFileHash(), api.FileMove)
+	auth.POST("/file/send", mw.RequireFileHash(), api.FileSend)
+	auth.GET("/user/inbox", api.InboxList)
+	auth.POST("/user/inbox/accept", api.InboxAccept)
+	auth.POST("/user/inbox/decline", api.InboxDecline)
+	auth.POST("/file/extract", mw.RequireFileHash(), api.FileExtract)
+	auth.GET("/file/thumbnail", mw.RequireFileHash(), api.FileThumbnail)
+	auth.GET("/file/search", api.FileSearch)
+	auth.POST("/file/tags", mw.RequireFileHashes(), api.FileTagAdd)
+	auth.POST("/file/tags/remove", mw.RequireFileHashes(), api.FileTagRemove)
+	auth.GET("/user/tags", api.UserTagList)
+	auth.POST("/file/attr", mw.RequireFileHash(), api.FileAttrUpdate)
+	auth.POST("/file/attr/delete", mw.RequireFileHash(), api.FileAttrDelete)
+	auth.GET("/jobs/:id", api.JobStatus)
+	auth.POST("/user/password", api.PasswordChange)
+	auth.GET("/user/profile", api.UserProfileGet)
+	auth.POST("/user/profile", api.UserProfileUpdate)
+	auth.POST("/user/email", api.UserEmailUpdate)
+	auth.POST("/user/email/verify", api.UserEmailVerify)
+	auth.POST("/user/email/resend", api.UserEmailResend)
+	auth.POST("/user/phone", api.UserPhoneUpdate)
+	auth.POST("/user/phone/verify", api.UserPhoneVerify)
+	auth.POST("/user/phone/resend", api.UserPhoneResend)
+	auth.GET("/user/2fa", api.TwoFactorStatus)
+	auth.POST("/user/2fa/enroll", api.TwoFactorEnroll)
+	auth.POST("/user/2fa/confirm", api.TwoFactorConfirm)
+	auth.POST("/user/2fa/disable", api.TwoFactorDisable)
+	auth.POST("/user/2fa/recovery", api.TwoFactorRecoveryCodes)
+	auth.GET("/file/acl", api.FileACLList)
+	auth.POST("/file/acl", api.FileACLUpdate)
+	auth.POST("/file/acl/delete", api.FileACLDelete)
+	auth.GET("/file/permissions", mw.RequireFileHash(), api.FilePermissions)
+	auth.POST("/team/create", api.TeamCreate)
+	auth.GET("/team/list", api.TeamList)
+	auth.GET("/team/members", api.TeamMemberList)
+	auth.POST("/team/invite", api.TeamInvite)
+	auth.GET("/team/invites", api.TeamInviteList)
+	auth.POST("/team/invite/accept", api.TeamInviteAccept)
+	auth.POST("/team/invite/decline", api.TeamInviteDecline)
+	auth.POST("/team/member/role", api.TeamMemberRoleUpdate)
+	auth.POST("/team/member/remove", api.TeamMemberRemove)
+
+	admin := auth.Group("/admin")
+	admin.Use(mw.RequireAdmin())
+	admin.GET("/mime/policy", api.MimePolicyList)
+	admin.POST("/mime/policy", api.MimePolicyUpdate)
+	admin.POST("/mime/policy/delete", api.MimePolicyDelete)
+	admin.POST("/user/group", api.UserGroupUpdate)
+	admin.GET("/users", api.AdminUserList)
+	admin.GET("/users/:name", api.AdminUserGet)
+	admin.POST("/users/:name/disable", api.AdminUserDisable)
+	admin.POST("/users/:name/enable", api.AdminUserEnable)
+	admin.POST("/users/:name/lock", api.AdminUserLock)
+	admin.POST("/users/:name/unlock", api.AdminUserUnlock)
+	admin.POST("/users/:name/password", api.AdminUserPasswordReset)
+	admin.POST("/users/:name/quota", api.AdminUserQuotaUpdate)
+	admin.POST("/users/:name/role", api.AdminUserRoleUpdate)
+	admin.POST("/users/:name/2fa", api.AdminUserTwoFactorRequire)
+	admin.POST("/users/:name/2fa/reset", api.AdminUserTwoFactorReset)
 	return r
 }
@@ -0,0 +1,100 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const clamdChunkSize = 64 << 10
+
+// ClamdScanner 通过 clamd 的 INSTREAM 命令扫描数据流。
+type ClamdScanner struct {
+	// Network 为 "tcp" 或 "unix"。
+	Network string
+	Address string
+	Timeout time.Duration
+}
+
+// NewClamdScanner 解析 tcp://host:port、unix:///path 或 host:port 形式的地址。
+func NewClamdScanner(addr string, timeout time.Duration) *ClamdScanner {
+	s := &ClamdScanner{Network: "tcp", Address: addr, Timeout: timeout}
+	if rest, ok := strings.CutPrefix(addr, "unix://"); ok {
+		s.Network, s.Address = "unix", rest
+	} else if rest, ok := strings.CutPrefix(addr, "tcp://"); ok {
+		s.Address = rest
+	}
+	return s
+}
+
+func (s *ClamdScanner) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	dialer := net.Dialer{Timeout: s.Timeout}
+	conn, err := dialer.DialContext(ctx, s.Network, s.Address)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to connect clamd: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return Result{}, fmt.Errorf("failed to send clamd command: %w", err)
+	}
+
+	buf := make([]byte, clamdChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return Result{}, fmt.Errorf("failed to stream to clamd: %w", err)
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return Result{}, fmt.Errorf("failed to stream to clamd: %w", err)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return Result{}, readErr
+		}
+		if err := ctx.Err(); err != nil {
+			return Result{}, err
+		}
+	}
+	// 长度为 0 的块表示数据结束。
+	binary.BigEndian.PutUint32(size, 0)
+	if _, err := conn.Write(size); err != nil {
+		return Result{}, fmt.Errorf("failed to stream to clamd: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && err != io.EOF {
+		return Result{}, fmt.Errorf("failed to read clamd reply: %w", err)
+	}
+	return parseClamdReply(string(bytes.TrimRight(reply, "\x00\n")))
+}
+
+// parseClamdReply 解析形如 "stream: OK"、"stream: Eicar-Signature FOUND" 的应答。
+func parseClamdReply(reply string) (Result, error) {
+	_, status, ok := strings.Cut(reply, ": ")
+	if !ok {
+		return Result{}, fmt.Errorf("unexpected clamd reply: %q", reply)
+	}
+	switch {
+	case status == "OK":
+		return Result{}, nil
+	case strings.HasSuffix(status, " FOUND"):
+		return Result{Infected: true, Signature: strings.TrimSuffix(status, " FOUND")}, nil
+	}
+	return Result{}, fmt.Errorf("clamd error: %s", status)
+}
@@ -0,0 +1,18 @@
+package scan
+
+import (
+	"context"
+	"io"
+)
+
+// Result 是一次扫描的结果。
+type Result struct {
+	Infected bool
+	// Signature 为命中的病毒特征名，未感染时为空。
+	Signature string
+}
+
+// Scanner 扫描文件内容。扫描器自身出错（如无法连接）时返回 error，而不是把文件判为感染。
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (Result, error)
+}
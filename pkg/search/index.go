@@ -0,0 +1,94 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// document 是索引中的一条记录，只索引正文。
+type document struct {
+	Content string
+}
+
+// Index 是嵌入式全文索引，文档以调用方给定的 id 寻址。
+type Index struct {
+	idx bleve.Index
+}
+
+// Open 打开 path 处的索引，不存在时新建。
+func Open(path string) (*Index, error) {
+	idx, err := bleve.Open(path)
+	if errors.Is(err, bleve.ErrorIndexPathDoesNotExist) {
+		idx, err = bleve.New(path, indexMapping())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open search index %s: %w", path, err)
+	}
+	return &Index{idx: idx}, nil
+}
+
+// indexMapping 只把 Content 作为全文字段建立倒排索引，不保存原文，也不生成 _all 字段和词向量，
+// 避免索引目录里留下一份未加密的文件正文。
+func indexMapping() mapping.IndexMapping {
+	content := bleve.NewTextFieldMapping()
+	content.Store = false
+	content.IncludeInAll = false
+	content.IncludeTermVectors = false
+
+	doc := bleve.NewDocumentStaticMapping()
+	doc.AddFieldMappingsAt("Content", content)
+
+	m := bleve.NewIndexMapping()
+	m.DefaultMapping = doc
+	m.StoreDynamic = false
+	m.IndexDynamic = false
+	return m
+}
+
+// Put 写入或覆盖 id 对应的正文。
+func (i *Index) Put(id, content string) error {
+	if err := i.idx.Index(id, document{Content: content}); err != nil {
+		return fmt.Errorf("failed to index %s: %w", id, err)
+	}
+	return nil
+}
+
+// Delete 删除 id 对应的文档，不存在时不报错。
+func (i *Index) Delete(id string) error {
+	if err := i.idx.Delete(id); err != nil {
+		return fmt.Errorf("failed to delete %s from index: %w", id, err)
+	}
+	return nil
+}
+
+// Search 在 ids 指定的文档中查找正文包含 text 中所有词的文档，按相关度从高到低排列，
+// 返回跳过 offset 个之后的最多 limit 个 id，以及命中的总数。
+func (i *Index) Search(text string, ids []string, offset, limit int) ([]string, int, error) {
+	if len(ids) == 0 {
+		return nil, 0, nil
+	}
+	match := bleve.NewMatchQuery(text)
+	match.SetField("Content")
+	match.SetOperator(query.MatchQueryOperatorAnd)
+	q := bleve.NewConjunctionQuery(match, bleve.NewDocIDQuery(ids))
+	req := bleve.NewSearchRequestOptions(q, limit, offset, false)
+
+	res, err := i.idx.Search(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search index: %w", err)
+	}
+	hits := make([]string, 0, len(res.Hits))
+	for _, hit := range res.Hits {
+		hits = append(hits, hit.ID)
+	}
+	return hits, int(res.Total), nil
+}
+
+// Close 关闭索引。
+func (i *Index) Close() error {
+	return i.idx.Close()
+}
@@ -0,0 +1,109 @@
+package throttle
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval 是清理过期记录的最短间隔。
+const sweepInterval = time.Minute
+
+// MemoryStore 是进程内的 Store，Redis 不可用时使用；多实例部署时各实例分别计数。
+// 写入时每 sweepInterval 清理一次所有过期的失败记录和封禁，不再出现的 key 不会一直占用内存。
+type MemoryStore struct {
+	mu        sync.Mutex
+	failures  map[string][]time.Time
+	windows   map[string]time.Duration
+	blocks    map[string]time.Time
+	lastSweep time.Time
+}
+
+// NewMemoryStore 创建进程内 Store。
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		failures: make(map[string][]time.Time),
+		windows:  make(map[string]time.Duration),
+		blocks:   make(map[string]time.Time),
+	}
+}
+
+func (m *MemoryStore) RecordFailure(ctx context.Context, key string, at time.Time, window time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep(at)
+	m.failures[key] = append(m.prune(key, at, window), at)
+	m.windows[key] = window
+	return len(m.failures[key]), nil
+}
+
+func (m *MemoryStore) Failures(ctx context.Context, key string, now time.Time, window time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.prune(key, now, window)), nil
+}
+
+// prune 丢弃窗口之外的失败记录，调用方须持有锁。
+func (m *MemoryStore) prune(key string, now time.Time, window time.Duration) []time.Time {
+	times := m.failures[key]
+	cutoff := now.Add(-window)
+	i := 0
+	for i < len(times) && !times[i].After(cutoff) {
+		i++
+	}
+	times = times[i:]
+	if len(times) == 0 {
+		delete(m.failures, key)
+		delete(m.windows, key)
+		return nil
+	}
+	m.failures[key] = times
+	return times
+}
+
+func (m *MemoryStore) Block(ctx context.Context, key string, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep(time.Now())
+	m.blocks[key] = until
+	return nil
+}
+
+// sweep 按各 key 最近一次记录时的窗口丢弃过期的失败记录，并删除已到期的封禁。调用方须持有锁。
+func (m *MemoryStore) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = now
+	for key := range m.failures {
+		m.prune(key, now, m.windows[key])
+	}
+	for key, until := range m.blocks {
+		if !until.After(now) {
+			delete(m.blocks, key)
+		}
+	}
+}
+
+func (m *MemoryStore) BlockedUntil(ctx context.Context, key string, now time.Time) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	until, ok := m.blocks[key]
+	if !ok {
+		return time.Time{}, nil
+	}
+	if !until.After(now) {
+		delete(m.blocks, key)
+		return time.Time{}, nil
+	}
+	return until, nil
+}
+
+func (m *MemoryStore) Reset(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.failures, key)
+	delete(m.windows, key)
+	delete(m.blocks, key)
+	return nil
+}
@@ -0,0 +1,106 @@
+package throttle
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	keyFailures = "filestore:throttle:failures:"
+	keyBlock    = "filestore:throttle:block:"
+)
+
+// RedisStore 把失败记录保存在 Redis：每个 key 的失败时间为一个 sorted set（分数为 Unix 毫秒），
+// 阻止状态为带过期时间的 string，多个实例共享计数。
+type RedisStore struct {
+	pool *redis.Pool
+}
+
+// NewRedisStore 创建 Redis Store。
+func NewRedisStore(pool *redis.Pool) *RedisStore {
+	return &RedisStore{pool: pool}
+}
+
+func (s *RedisStore) RecordFailure(ctx context.Context, key string, at time.Time, window time.Duration) (int, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	// 同一毫秒内的多次失败需要不同的成员。
+	nonce := make([]byte, 4)
+	rand.Read(nonce)
+	member := at.Format(time.RFC3339Nano) + "-" + hex.EncodeToString(nonce)
+
+	k := keyFailures + key
+	conn.Send("MULTI")
+	conn.Send("ZREMRANGEBYSCORE", k, "-inf", at.Add(-window).UnixMilli())
+	conn.Send("ZADD", k, at.UnixMilli(), member)
+	conn.Send("ZCARD", k)
+	conn.Send("PEXPIRE", k, window.Milliseconds())
+	reply, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return 0, err
+	}
+	return redis.Int(reply[2], nil)
+}
+
+func (s *RedisStore) Failures(ctx context.Context, key string, now time.Time, window time.Duration) (int, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	return redis.Int(conn.Do("ZCOUNT", keyFailures+key, "("+strconv.FormatInt(now.Add(-window).UnixMilli(), 10), "+inf"))
+}
+
+func (s *RedisStore) Block(ctx context.Context, key string, until time.Time) error {
+	ttl := time.Until(until).Milliseconds()
+	if ttl <= 0 {
+		return nil
+	}
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Do("SET", keyBlock+key, until.UnixMilli(), "PX", ttl)
+	return err
+}
+
+func (s *RedisStore) BlockedUntil(ctx context.Context, key string, now time.Time) (time.Time, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer conn.Close()
+
+	ms, err := redis.Int64(conn.Do("GET", keyBlock+key))
+	if err == redis.ErrNil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	until := time.UnixMilli(ms)
+	if !until.After(now) {
+		return time.Time{}, nil
+	}
+	return until, nil
+}
+
+func (s *RedisStore) Reset(ctx context.Context, key string) error {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Do("DEL", keyFailures+key, keyBlock+key)
+	return err
+}
@@ -0,0 +1,21 @@
+package throttle
+
+import (
+	"context"
+	"time"
+)
+
+// Store 记录按 key（如用户名或 IP）统计的失败次数和阻止状态。
+// 失败次数按滑动窗口统计：只计入 window 内发生的失败。
+type Store interface {
+	// RecordFailure 记录一次发生在 at 的失败，返回 window 内的失败次数（含本次）。
+	RecordFailure(ctx context.Context, key string, at time.Time, window time.Duration) (int, error)
+	// Failures 返回截至 now 的 window 内的失败次数。
+	Failures(ctx context.Context, key string, now time.Time, window time.Duration) (int, error)
+	// Block 阻止 key 直到 until。
+	Block(ctx context.Context, key string, until time.Time) error
+	// BlockedUntil 返回 key 被阻止到的时间，没有被阻止时返回零值。
+	BlockedUntil(ctx context.Context, key string, now time.Time) (time.Time, error)
+	// Reset 清除 key 的失败记录和阻止状态。
+	Reset(ctx context.Context, key string) error
+}
@@ -0,0 +1,63 @@
+package util
+
+import (
+	"mime"
+	"path/filepath"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// MimeSampleSize 是按内容识别类型时需要读取的文件头字节数。
+const MimeSampleSize = 3072
+
+// DetectMimeType 按文件头的魔数识别类型；只能识别为通用类型时再参考扩展名。
+func DetectMimeType(filename string, sample []byte) string {
+	detected := mimetype.Detect(sample)
+	if !detected.Is("application/octet-stream") && !detected.Is("text/plain") {
+		return detected.String()
+	}
+	if byExt := mime.TypeByExtension(strings.ToLower(filepath.Ext(filename))); byExt != "" {
+		// 扩展名声称是文本但内容不是文本时，不采信扩展名。
+		if detected.Is("text/plain") || !strings.HasPrefix(byExt, "text/") {
+			return byExt
+		}
+	}
+	return detected.String()
+}
+
+// BaseMimeType 去掉 charset 等参数，返回小写的 type/subtype。
+func BaseMimeType(mimeType string) string {
+	base, _, _ := strings.Cut(mimeType, ";")
+	return strings.ToLower(strings.TrimSpace(base))
+}
+
+// MimeTypeMatches 判断类型是否匹配模式，模式支持精确类型、type/* 和 */*。
+func MimeTypeMatches(pattern, mimeType string) bool {
+	pattern = BaseMimeType(pattern)
+	mimeType = BaseMimeType(mimeType)
+	if pattern == "*/*" || pattern == mimeType {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+		return strings.HasPrefix(mimeType, prefix+"/")
+	}
+	return false
+}
+
+// inlineSafeMimeTypes 是浏览器直接展示也不会执行脚本的类型。
+var inlineSafeMimeTypes = []string{
+	"image/png", "image/jpeg", "image/gif", "image/webp", "image/bmp",
+	"audio/*", "video/*",
+	"text/plain", "application/pdf",
+}
+
+// InlineSafeMimeType 判断下载时能否以 inline 方式展示；HTML、SVG 等可能执行脚本的类型一律作为附件。
+func InlineSafeMimeType(mimeType string) bool {
+	for _, pattern := range inlineSafeMimeTypes {
+		if MimeTypeMatches(pattern, mimeType) {
+			return true
+		}
+	}
+	return false
+}
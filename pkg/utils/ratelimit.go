@@ -0,0 +1,44 @@
+package util
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// RateLimitedReader 把读取速度限制在每秒 bytesPerSec 字节以内，用于后台任务避免占满磁盘 IO。
+type RateLimitedReader struct {
+	ctx         context.Context
+	r           io.Reader
+	bytesPerSec int64
+	start       time.Time
+	read        int64
+}
+
+// NewRateLimitedReader 创建限速 reader；bytesPerSec <= 0 表示不限速。
+func NewRateLimitedReader(ctx context.Context, r io.Reader, bytesPerSec int64) *RateLimitedReader {
+	return &RateLimitedReader{ctx: ctx, r: r, bytesPerSec: bytesPerSec, start: time.Now()}
+}
+
+func (l *RateLimitedReader) Read(p []byte) (int, error) {
+	if l.bytesPerSec > 0 && int64(len(p)) > l.bytesPerSec {
+		p = p[:l.bytesPerSec]
+	}
+	n, err := l.r.Read(p)
+	if n <= 0 || l.bytesPerSec <= 0 {
+		return n, err
+	}
+
+	l.read += int64(n)
+	expected := time.Duration(float64(l.read) / float64(l.bytesPerSec) * float64(time.Second))
+	if wait := expected - time.Since(l.start); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-l.ctx.Done():
+			return n, l.ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,311 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"filestore-server/pkg/dao"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrInvalidACL 表示访问控制条目的路径、主体或权限不合法。
+	ErrInvalidACL = errors.New("invalid acl entry")
+	// ErrUnknownPrincipal 表示 ACL 授权的用户或团队不存在。
+	ErrUnknownPrincipal = errors.New("unknown principal")
+)
+
+var aclPerm = map[string]Permission{
+	dao.ACLRead:  PermRead,
+	dao.ACLWrite: PermWrite,
+}
+
+var permissionNames = map[Permission]string{
+	PermNone:   "none",
+	PermRead:   "read",
+	PermWrite:  "write",
+	PermManage: "manage",
+	PermOwn:    "own",
+}
+
+func (p Permission) String() string {
+	if name, ok := permissionNames[p]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// FileAccess 是一个用户对文件的有效权限及其来源，用于排查权限问题。
+type FileAccess struct {
+	User       string   `json:"user"`
+	Owner      string   `json:"owner"`
+	Path       string   `json:"path"`
+	Permission string   `json:"permission"`
+	Sources    []string `json:"sources"`
+}
+
+// FilePermission 计算 username 对 owner 空间中 path 的有效权限，是所有文件授权判断的唯一入口。
+// 权限取以下来源的最大值：空间属于本人；空间属于团队时用户的角色；授予用户或其所在团队、设置在该路径或其上级目录的 ACL。
+// 同时返回每个生效来源的说明。
+func FilePermission(ctx context.Context, username, owner, path string) (Permission, []string, error) {
+	if owner == username {
+		return PermOwn, []string{"owner"}, nil
+	}
+
+	best := PermNone
+	var sources []string
+	grant := func(p Permission, source string) {
+		if p > best {
+			best = p
+		}
+		sources = append(sources, source)
+	}
+
+	principals, roles, err := userPrincipals(ctx, username)
+	if err != nil {
+		return PermNone, nil, err
+	}
+	if role, ok := roles[owner]; ok {
+		grant(teamRolePerm[role], "team role "+role)
+	}
+
+	entries, err := dao.GetPrincipalACLEntries(ctx, owner, principals, aclCandidatePaths(path))
+	if err != nil {
+		return PermNone, nil, err
+	}
+	for _, e := range entries {
+		if aclApplies(e, path) {
+			grant(aclPerm[e.Permission], fmt.Sprintf("acl %s on %q for %s", e.Permission, "/"+e.Path, e.Principal))
+		}
+	}
+	return best, sources, nil
+}
+
+// aclCandidatePaths 返回可能对 path 生效的条目所在的路径：path 本身及其全部上级目录，根目录为空字符串。
+func aclCandidatePaths(path string) []string {
+	paths := []string{path}
+	for path != "" {
+		path, _ = userPathSplit(path)
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// aclApplies 判断条目是否对 path 生效：设置在 path 本身上，或设置在其上级目录上且可继承，
+// 不可继承的目录条目只对直接位于该目录中的文件生效。
+func aclApplies(e dao.ACLEntry, path string) bool {
+	if e.Path == path {
+		return true
+	}
+	if e.Path != "" && !strings.HasPrefix(path, e.Path+"/") {
+		return false
+	}
+	dir, _ := userPathSplit(path)
+	return e.Inherit || dir == e.Path
+}
+
+// AuthorizeFile 确认 username 对文件具有 perm 权限，返回文件所在的空间。
+// 同一内容可能属于多个空间，任一空间中的路径满足权限即可。用户没有任何权限的文件返回 dao.ErrUserFileNotFound，
+// 有权限但不足时返回 ErrForbidden。
+func AuthorizeFile(ctx context.Context, username, filehash string, perm Permission) (string, error) {
+	o, err := authorizeFileEntry(ctx, username, filehash, perm)
+	return o.UserName, err
+}
+
+// authorizeFileEntry 同 AuthorizeFile，同时返回文件在该空间中的路径。
+func authorizeFileEntry(ctx context.Context, username, filehash string, perm Permission) (dao.UserFileOwner, error) {
+	owners, err := dao.GetUserFileOwners(ctx, filehash)
+	if err != nil {
+		return dao.UserFileOwner{}, err
+	}
+
+	denied := false
+	for _, o := range owners {
+		p, _, err := FilePermission(ctx, username, o.UserName, o.FileName)
+		if err != nil {
+			return dao.UserFileOwner{}, err
+		}
+		if p >= perm {
+			return o, nil
+		}
+		if p > PermNone {
+			denied = true
+		}
+	}
+	if denied {
+		return dao.UserFileOwner{}, ErrForbidden
+	}
+	return dao.UserFileOwner{}, dao.ErrUserFileNotFound
+}
+
+// authorizeFileIn 确认 username 对 owner 空间中的文件具有 perm 权限，返回文件在该空间中的路径。
+// 只检查这一个空间，其他空间中相同内容的文件不影响结果；错误与 AuthorizeFile 相同。
+func authorizeFileIn(ctx context.Context, username, owner, filehash string, perm Permission) (dao.UserFileOwner, error) {
+	o, err := dao.GetUserFileOwner(ctx, owner, filehash)
+	if err != nil {
+		return dao.UserFileOwner{}, err
+	}
+	p, _, err := FilePermission(ctx, username, o.UserName, o.FileName)
+	if err != nil {
+		return dao.UserFileOwner{}, err
+	}
+	switch {
+	case p >= perm:
+		return o, nil
+	case p > PermNone:
+		return dao.UserFileOwner{}, ErrForbidden
+	}
+	return dao.UserFileOwner{}, dao.ErrUserFileNotFound
+}
+
+// EffectiveFileAccess 返回 target 对文件的有效权限及来源。查询他人的权限需要对文件有 manage 权限。
+func EffectiveFileAccess(ctx context.Context, username, filehash, target string) ([]FileAccess, error) {
+	if target == "" {
+		target = username
+	}
+	if target != username {
+		if _, err := AuthorizeFile(ctx, username, filehash, PermManage); err != nil {
+			return nil, err
+		}
+	} else if _, err := AuthorizeFile(ctx, username, filehash, PermRead); err != nil {
+		return nil, err
+	}
+
+	owners, err := dao.GetUserFileOwners(ctx, filehash)
+	if err != nil {
+		return nil, err
+	}
+	access := make([]FileAccess, 0, len(owners))
+	for _, o := range owners {
+		p, sources, err := FilePermission(ctx, target, o.UserName, o.FileName)
+		if err != nil {
+			return nil, err
+		}
+		if p == PermNone {
+			continue
+		}
+		access = append(access, FileAccess{User: target, Owner: o.UserName, Path: o.FileName, Permission: p.String(), Sources: sources})
+	}
+	return access, nil
+}
+
+// SetACL 在 owner 空间中把 path 的 permission 权限授予 principal，同一主体在同一路径上的条目会被覆盖。
+// 调用方须已确认 actor 对 owner 空间有 manage 权限。
+func SetACL(ctx context.Context, actor, owner, path, principal, permission string, inherit bool) (dao.ACLEntry, error) {
+	path, err := cleanACLPath(path)
+	if err != nil {
+		return dao.ACLEntry{}, err
+	}
+	if _, ok := aclPerm[permission]; !ok {
+		return dao.ACLEntry{}, fmt.Errorf("%w: permission must be read or write", ErrInvalidACL)
+	}
+	principal = strings.TrimSpace(principal)
+	if principal == "" || principal == owner {
+		return dao.ACLEntry{}, fmt.Errorf("%w: invalid principal", ErrInvalidACL)
+	}
+	if err := checkPrincipal(ctx, principal); err != nil {
+		return dao.ACLEntry{}, err
+	}
+
+	entry := dao.ACLEntry{Owner: owner, Path: path, Principal: principal, Permission: permission, Inherit: inherit, CreateBy: actor}
+	if err := dao.SetACLEntry(ctx, entry); err != nil {
+		return dao.ACLEntry{}, err
+	}
+	audit(ctx, actor, "acl.set", owner, fmt.Sprintf("path=/%s principal=%s permission=%s inherit=%t", path, principal, permission, inherit))
+	return entry, nil
+}
+
+// DeleteACL 删除 owner 空间中 path 上授予 principal 的条目。
+func DeleteACL(ctx context.Context, actor, owner, path, principal string) error {
+	path, err := cleanACLPath(path)
+	if err != nil {
+		return err
+	}
+	if err := dao.DeleteACLEntry(ctx, owner, path, principal); err != nil {
+		return err
+	}
+	audit(ctx, actor, "acl.delete", owner, fmt.Sprintf("path=/%s principal=%s", path, principal))
+	return nil
+}
+
+// ListACL 列出 owner 空间中设置在 path 上的条目。
+func ListACL(ctx context.Context, owner, path string) ([]dao.ACLEntry, error) {
+	path, err := cleanACLPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return dao.GetACLEntries(ctx, owner, path)
+}
+
+// GetVisibleFilelist 列出 owner 空间中 username 有读权限的文件，分页与排序同 GetUserFilelist：
+// 本人或团队成员可以看到全部文件，其他用户只能看到 ACL 授权的部分。用户在该空间没有任何读权限时返回 ErrForbidden。
+func GetVisibleFilelist(ctx context.Context, username, owner string, opts ListOptions) (FileListPage, error) {
+	principals, roles, err := userPrincipals(ctx, username)
+	if err != nil {
+		return FileListPage{}, err
+	}
+	if owner == username || teamRolePerm[roles[owner]] >= PermRead {
+		return listFiles(ctx, owner, opts, nil)
+	}
+
+	entries, err := dao.GetPrincipalACLEntries(ctx, owner, principals, nil)
+	if err != nil {
+		return FileListPage{}, err
+	}
+	// 与 aclApplies 的规则一致：条目覆盖路径本身，以及作为目录时其中的文件。
+	var scopes []dao.PathScope
+	for _, e := range entries {
+		if aclPerm[e.Permission] >= PermRead {
+			scopes = append(scopes, dao.PathScope{Path: e.Path, Recursive: e.Inherit})
+		}
+	}
+	if len(scopes) == 0 {
+		return FileListPage{}, ErrForbidden
+	}
+	return listFiles(ctx, owner, opts, scopes)
+}
+
+// userPrincipals 返回可以作为 ACL 主体代表 username 的名称（本人及所在团队），以及用户在各团队空间中的角色。
+func userPrincipals(ctx context.Context, username string) ([]string, map[string]string, error) {
+	teams, err := dao.GetUserTeams(ctx, username)
+	if err != nil {
+		return nil, nil, err
+	}
+	principals := []string{username}
+	roles := make(map[string]string, len(teams))
+	for _, team := range teams {
+		ns := TeamNamespace(team.ID)
+		principals = append(principals, ns)
+		roles[ns] = team.Role
+	}
+	return principals, roles, nil
+}
+
+// checkPrincipal 确认主体是存在的用户或团队。
+func checkPrincipal(ctx context.Context, principal string) error {
+	if teamID, ok := teamIDFromNamespace(principal); ok {
+		if _, err := dao.GetTeamName(ctx, teamID); err != nil {
+			if errors.Is(err, dao.ErrTeamNotFound) {
+				return ErrUnknownPrincipal
+			}
+			return err
+		}
+		return nil
+	}
+	if _, err := dao.GetUserByName(ctx, principal); err != nil {
+		if err.Error() == "user not found" {
+			return ErrUnknownPrincipal
+		}
+		return err
+	}
+	return nil
+}
+
+// cleanACLPath 规范化 ACL 路径，空字符串或 "/" 表示根目录。
+func cleanACLPath(path string) (string, error) {
+	path, err := cleanUserDir(path)
+	if err != nil || len(path) > maxUserPathLen {
+		return "", fmt.Errorf("%w: invalid path", ErrInvalidACL)
+	}
+	return path, nil
+}
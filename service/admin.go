@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"filestore-server/pkg/dao"
+	"fmt"
+	"strings"
+)
+
+const (
+	defaultUserListLimit = 50
+	maxUserListLimit     = 200
+)
+
+var (
+	// ErrUserDisabled 表示账户已被管理员禁用。
+	ErrUserDisabled = errors.New("account disabled")
+	// ErrUserLocked 表示账户已被锁定。
+	ErrUserLocked = errors.New("account locked")
+	// ErrInvalidUserStatus 表示账户状态不合法。
+	ErrInvalidUserStatus = errors.New("invalid user status")
+	// ErrInvalidUserRole 表示用户角色不合法。
+	ErrInvalidUserRole = errors.New("invalid user role")
+	// ErrInvalidQuota 表示配额不合法。
+	ErrInvalidQuota = errors.New("invalid quota")
+	// ErrSelfModify 表示管理员试图禁用、锁定自己或取消自己的管理员角色。
+	ErrSelfModify = errors.New("cannot change your own status or role")
+)
+
+var userStatusNames = map[int]string{
+	0:                      "active",
+	dao.UserStatusActive:   "active",
+	dao.UserStatusDisabled: "disabled",
+	dao.UserStatusLocked:   "locked",
+}
+
+// UserStatusByName 把 active、disabled、locked 转换为账户状态。
+func UserStatusByName(name string) (int, bool) {
+	switch name {
+	case "active":
+		return dao.UserStatusActive, true
+	case "disabled":
+		return dao.UserStatusDisabled, true
+	case "locked":
+		return dao.UserStatusLocked, true
+	}
+	return 0, false
+}
+
+// UserAccount 是管理接口返回的用户信息。
+type UserAccount struct {
+	UserName    string `json:"user_name"`
+	Email       string `json:"email"`
+	Phone       string `json:"phone"`
+	Status      string `json:"status"`
+	Role        string `json:"role"`
+	Group       string `json:"group"`
+	Quota       int64  `json:"quota"`
+	StorageUsed int64  `json:"storage_used"`
+	FileCount   int    `json:"file_count"`
+	SignupAt    string `json:"signup_at"`
+	LastActive  string `json:"last_active"`
+	// TwoFactorEnabled 与 TwoFactorRequired 为 TOTP 两步验证的启用和强制状态。
+	TwoFactorEnabled  bool `json:"two_factor_enabled"`
+	TwoFactorRequired bool `json:"two_factor_required"`
+	// Login 为登录失败限制的状态，只在查询单个用户时返回。
+	Login *LoginLockState `json:"login,omitempty"`
+}
+
+func newUserAccount(u dao.UserSummary) UserAccount {
+	return UserAccount{
+		UserName:    u.UserName,
+		Email:       u.Email,
+		Phone:       u.Phone,
+		Status:      userStatusNames[u.Status],
+		Role:        u.Role,
+		Group:       u.GroupName,
+		Quota:       u.Quota,
+		StorageUsed: u.StorageUsed,
+		FileCount:   u.FileCount,
+		SignupAt:    u.SignupAt,
+		LastActive:  u.LastActive,
+
+		TwoFactorEnabled:  u.TOTPEnabled,
+		TwoFactorRequired: u.TOTPRequired,
+	}
+}
+
+// checkUserEnabled 把禁用或锁定的账户转换为对应的错误。
+func checkUserEnabled(u dao.User) error {
+	switch u.Status {
+	case dao.UserStatusDisabled:
+		return ErrUserDisabled
+	case dao.UserStatusLocked:
+		return ErrUserLocked
+	}
+	return nil
+}
+
+// ListUsers 按关键字和状态查询用户，返回当前页及总数，Quota 为生效的配额。
+func ListUsers(ctx context.Context, keyword, status string, limit, offset int) ([]UserAccount, int, error) {
+	q := dao.UserQuery{Keyword: strings.TrimSpace(keyword), Limit: limit, Offset: offset}
+	if status != "" {
+		s, ok := UserStatusByName(status)
+		if !ok {
+			return nil, 0, ErrInvalidUserStatus
+		}
+		q.Status = s
+	}
+	if q.Limit <= 0 {
+		q.Limit = defaultUserListLimit
+	}
+	if q.Limit > maxUserListLimit {
+		q.Limit = maxUserListLimit
+	}
+	if q.Offset < 0 {
+		q.Offset = 0
+	}
+
+	users, total, err := dao.ListUsers(ctx, q)
+	if err != nil {
+		return nil, 0, err
+	}
+	accounts := make([]UserAccount, 0, len(users))
+	for _, u := range users {
+		accounts = append(accounts, withEffectiveQuota(newUserAccount(u)))
+	}
+	return accounts, total, nil
+}
+
+// GetUserAccount 返回单个用户的信息、存储用量及登录失败限制状态。
+func GetUserAccount(ctx context.Context, username string) (UserAccount, error) {
+	u, err := dao.GetUserSummary(ctx, username)
+	if err != nil {
+		return UserAccount{}, err
+	}
+	account := withEffectiveQuota(newUserAccount(u))
+	state, err := GetLoginLockState(ctx, username)
+	if err != nil {
+		return UserAccount{}, err
+	}
+	account.Login = &state
+	return account, nil
+}
+
+// withEffectiveQuota 把未单独设置的配额替换为系统默认值。
+func withEffectiveQuota(a UserAccount) UserAccount {
+	if a.Quota == 0 {
+		a.Quota = defaultUserQuota()
+	}
+	return a
+}
+
+// SetUserStatus 修改账户状态，禁用或锁定后用户的现有会话立即失效；启用时同时清除登录失败造成的临时锁定。
+// 管理员不能禁用或锁定自己。
+func SetUserStatus(ctx context.Context, actor, username string, status int) error {
+	if _, ok := userStatusNames[status]; !ok || status == 0 {
+		return ErrInvalidUserStatus
+	}
+	if username == actor && status != dao.UserStatusActive {
+		return ErrSelfModify
+	}
+	if err := dao.UpdateUserStatus(ctx, username, status); err != nil {
+		return err
+	}
+	if status == dao.UserStatusActive {
+		if err := clearLoginLock(ctx, username); err != nil {
+			return err
+		}
+	}
+	audit(ctx, actor, "user.status", username, "status="+userStatusNames[status])
+	return nil
+}
+
+// ResetUserPassword 由管理员重置用户密码，用户的现有会话随之失效；password 为空时生成随机密码并返回。
+func ResetUserPassword(ctx context.Context, actor, username, password string) (string, error) {
+	generated := ""
+	if password == "" {
+		buf := make([]byte, 12)
+		if _, err := rand.Read(buf); err != nil {
+			return "", fmt.Errorf("failed to generate password: %w", err)
+		}
+		password = base64.RawURLEncoding.EncodeToString(buf)
+		generated = password
+	} else if err := ValidatePassword(username, password); err != nil {
+		return "", err
+	}
+
+	if err := setPassword(ctx, username, password); err != nil {
+		return "", err
+	}
+	audit(ctx, actor, "user.password_reset", username, "")
+	return generated, nil
+}
+
+// SetUserQuota 修改用户的存储配额（字节），0 表示使用系统默认值。
+func SetUserQuota(ctx context.Context, actor, username string, quota int64) error {
+	if quota < 0 {
+		return ErrInvalidQuota
+	}
+	if err := dao.UpdateUserQuota(ctx, username, quota); err != nil {
+		return err
+	}
+	audit(ctx, actor, "user.quota", username, fmt.Sprintf("quota=%d", quota))
+	return nil
+}
+
+// SetUserRole 修改用户角色（user 或 admin）。管理员不能取消自己的管理员角色，避免系统失去管理员。
+func SetUserRole(ctx context.Context, actor, username, role string) error {
+	if role != dao.RoleUser && role != dao.RoleAdmin {
+		return ErrInvalidUserRole
+	}
+	if username == actor && role != dao.RoleAdmin {
+		return ErrSelfModify
+	}
+	if err := dao.UpdateUserRole(ctx, username, role); err != nil {
+		return err
+	}
+	audit(ctx, actor, "user.role", username, "role="+role)
+	return nil
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"filestore-server/pkg/dao"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ArchiveZip   = "zip"
+	ArchiveTarGz = "tar.gz"
+)
+
+// ErrUnsupportedArchive 表示请求了不支持的打包格式。
+var ErrUnsupportedArchive = errors.New("unsupported archive format")
+
+// ErrFilesNotOwned 表示批量请求中包含调用者不拥有的文件。
+var ErrFilesNotOwned = errors.New("some files not found")
+
+// ErrTooManyArchiveFiles 表示目录中的文件数超过单次打包的上限。
+var ErrTooManyArchiveFiles = errors.New("too many files to archive")
+
+// maxArchiveDirFiles 是按目录打包时最多包含的文件数。
+const maxArchiveDirFiles = 1000
+
+// ArchiveEntry 是打包下载中的一个文件，Modified 为用户文件的最后修改时间。
+type ArchiveEntry struct {
+	Name     string
+	Meta     dao.FileMeta
+	Modified time.Time
+}
+
+// PrepareArchive 校验 filehashes 都属于 username，并为打包内的文件分配不重复的名称。
+// 在开始写出响应之前调用，便于在出错时仍能返回普通的错误响应。
+func PrepareArchive(ctx context.Context, username string, filehashes []string) ([]ArchiveEntry, error) {
+	owned, err := dao.GetUserFilesByHashes(ctx, username, filehashes)
+	if err != nil {
+		return nil, err
+	}
+	if len(owned) != len(filehashes) {
+		return nil, ErrFilesNotOwned
+	}
+
+	byHash := make(map[string]dao.FileMeta, len(owned))
+	for _, f := range owned {
+		byHash[f.FileSha1] = f
+	}
+
+	used := make(map[string]bool, len(filehashes))
+	entries := make([]ArchiveEntry, 0, len(filehashes))
+	for _, h := range filehashes {
+		entry, err := archiveEntry(ctx, byHash[h], byHash[h].FileName, used)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// PrepareDirArchive 打包 username 目录 dir（含子目录）下的全部文件，打包内的名称为相对 dir 的路径；
+// dir 为空或 "/" 时打包全部文件。目录下没有文件时返回 ErrFilesNotOwned。
+func PrepareDirArchive(ctx context.Context, username, dir string) ([]ArchiveEntry, error) {
+	dir, err := cleanUserDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files, err := dao.GetUserFilesUnder(ctx, username, dir, maxArchiveDirFiles+1)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, ErrFilesNotOwned
+	}
+	if len(files) > maxArchiveDirFiles {
+		return nil, ErrTooManyArchiveFiles
+	}
+
+	used := make(map[string]bool, len(files))
+	entries := make([]ArchiveEntry, 0, len(files))
+	for _, f := range files {
+		name := f.FileName
+		if dir != "" {
+			name = strings.TrimPrefix(name, dir+"/")
+		}
+		entry, err := archiveEntry(ctx, f, name, used)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// archiveEntry 读取用户文件 owned 的内容信息，确认可以下载后生成名为 name 的打包条目。
+func archiveEntry(ctx context.Context, owned dao.FileMeta, name string, used map[string]bool) (ArchiveEntry, error) {
+	fmeta, err := dao.GetFileMeta(ctx, owned.FileSha1)
+	if err != nil {
+		return ArchiveEntry{}, err
+	}
+	if err := checkScanState(fmeta); err != nil {
+		return ArchiveEntry{}, err
+	}
+	return ArchiveEntry{
+		Name:     uniqueEntryName(name, used),
+		Meta:     fmeta,
+		Modified: owned.LastUpdate,
+	}, nil
+}
+
+// uniqueEntryName 为重名文件追加 " (n)" 后缀，例如 a.txt、a (1).txt。
+func uniqueEntryName(name string, used map[string]bool) string {
+	name = strings.TrimLeft(strings.ReplaceAll(name, "\\", "/"), "/")
+	if name == "" {
+		name = "file"
+	}
+	candidate := name
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; used[strings.ToLower(candidate)]; i++ {
+		candidate = base + " (" + strconv.Itoa(i) + ")" + ext
+	}
+	used[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+// WriteArchive 把 entries 按 format 边读边写到 w，不在磁盘上生成临时文件。
+func WriteArchive(ctx context.Context, w io.Writer, format string, entries []ArchiveEntry) error {
+	switch format {
+	case ArchiveZip:
+		return writeZip(ctx, w, entries)
+	case ArchiveTarGz:
+		return writeTarGz(ctx, w, entries)
+	}
+	return ErrUnsupportedArchive
+}
+
+// writeZip 写出 zip；单个文件或整体超过 4GB 时 archive/zip 会自动使用 ZIP64。
+func writeZip(ctx context.Context, w io.Writer, entries []ArchiveEntry) error {
+	zw := zip.NewWriter(w)
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     entry.Name,
+			Method:   zip.Deflate,
+			Modified: entry.modTime(),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create zip entry: %w", err)
+		}
+		if err := copyBlob(ctx, fw, entry.Meta); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func writeTarGz(ctx context.Context, w io.Writer, entries []ArchiveEntry) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := tw.WriteHeader(&tar.Header{
+			Name:    entry.Name,
+			Mode:    0o644,
+			Size:    entry.Meta.FileSize,
+			ModTime: entry.modTime(),
+			Format:  tar.FormatPAX,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write tar header: %w", err)
+		}
+		if err := copyBlob(ctx, tw, entry.Meta); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// modTime 返回写入压缩包的修改时间，没有记录时使用当前时间。
+func (e ArchiveEntry) modTime() time.Time {
+	if e.Modified.IsZero() {
+		return time.Now()
+	}
+	return e.Modified
+}
+
+func copyBlob(ctx context.Context, w io.Writer, fmeta dao.FileMeta) error {
+	r, err := openBlob(ctx, fmeta)
+	if err != nil {
+		return fmt.Errorf("failed to read file %s: %w", fmeta.FileSha1, err)
+	}
+	defer r.Close()
+	if _, err := io.Copy(w, r); err != nil {
+		return fmt.Errorf("failed to write file %s: %w", fmeta.FileSha1, err)
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"filestore-server/pkg/config"
+	"filestore-server/pkg/dao"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// 批量操作的类型。
+const (
+	BatchOpDelete = "delete"
+	BatchOpRename = "rename"
+	BatchOpMove   = "move"
+	BatchOpTag    = "tag"
+	BatchOpUntag  = "untag"
+
+	// idempotencyTTL 是幂等键的有效期，过期后相同的键视为新请求。
+	idempotencyTTL = 24 * time.Hour
+	maxIdemKeyLen  = 128
+	maxUserPathLen = 256
+)
+
+var (
+	// ErrInvalidBatch 表示批量请求格式不合法。
+	ErrInvalidBatch = errors.New("invalid batch request")
+	// ErrBatchTooLarge 表示批量请求的操作数超过上限。
+	ErrBatchTooLarge = errors.New("too many operations in batch")
+	// ErrIdempotencyConflict 表示幂等键已用于内容不同的请求。
+	ErrIdempotencyConflict = errors.New("idempotency key reused with a different request")
+
+	errRolledBack = errors.New("rolled back")
+)
+
+// BatchOp 是批量请求中的一个操作。
+type BatchOp struct {
+	Op       string `json:"op"`
+	FileHash string `json:"filehash"`
+	// Name 为 rename 的新文件名，不含目录。
+	Name string `json:"name,omitempty"`
+	// Dir 为 move 的目标目录，空字符串表示根目录。
+	Dir string `json:"dir,omitempty"`
+	// Tags 为 tag、untag 的标签。
+	Tags []string `json:"tags,omitempty"`
+}
+
+// BatchRequest 是批量操作请求。Atomic 为 true 时任一操作失败则全部撤销，
+// 否则每个操作单独生效，失败的操作不影响其他操作。
+type BatchRequest struct {
+	Operations []BatchOp `json:"operations"`
+	Atomic     bool      `json:"atomic"`
+}
+
+// BatchItemResult 是单个操作的结果。
+type BatchItemResult struct {
+	Index    int    `json:"index"`
+	Op       string `json:"op"`
+	FileHash string `json:"filehash"`
+	OK       bool   `json:"ok"`
+	// FileName 为 rename、move 之后的路径。
+	FileName string `json:"filename,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// BatchResult 是批量操作的结果，Replayed 表示结果来自同一幂等键的上一次请求。
+type BatchResult struct {
+	Results   []BatchItemResult `json:"results"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Replayed  bool              `json:"-"`
+}
+
+// MaxBatchOps 返回一次批量请求允许的最大操作数。
+func MaxBatchOps() int {
+	return config.Int("FILESTORE_BATCH_MAX_OPS", 500)
+}
+
+// RunBatch 在一个事务中执行 username 对自己文件的批量操作，返回每个操作的结果。
+// idemKey 非空时，相同的键和请求在有效期内只执行一次，重试直接返回第一次的结果；
+// 原子模式下整体失败的请求不会记录，可以用同一个键重试。
+func RunBatch(ctx context.Context, username, idemKey string, req BatchRequest) (BatchResult, error) {
+	if len(req.Operations) == 0 {
+		return BatchResult{}, ErrInvalidBatch
+	}
+	if len(req.Operations) > MaxBatchOps() {
+		return BatchResult{}, ErrBatchTooLarge
+	}
+	if len(idemKey) > maxIdemKeyLen || strings.ContainsFunc(idemKey, unicode.IsControl) {
+		return BatchResult{}, fmt.Errorf("%w: invalid idempotency key", ErrInvalidBatch)
+	}
+
+	reqHash := batchRequestHash(req)
+	if idemKey != "" {
+		if result, ok, err := replayBatch(ctx, username, idemKey, reqHash); ok || err != nil {
+			return result, err
+		}
+	}
+
+	tx, err := dao.BeginUserFileTx(ctx, username)
+	if err != nil {
+		return BatchResult{}, err
+	}
+	defer tx.Rollback()
+
+	result := BatchResult{Results: make([]BatchItemResult, len(req.Operations))}
+	var released []string
+	for i, op := range req.Operations {
+		item := BatchItemResult{Index: i, Op: op.Op, FileHash: strings.ToLower(op.FileHash)}
+		op.FileHash = item.FileHash
+
+		savepoint := "op_" + strconv.Itoa(i)
+		if err := tx.Savepoint(ctx, savepoint); err != nil {
+			return BatchResult{}, err
+		}
+		var opReleased []string
+		item.FileName, opReleased, err = applyBatchOp(ctx, tx, op)
+		if err != nil {
+			if err := tx.RollbackTo(ctx, savepoint); err != nil {
+				return BatchResult{}, err
+			}
+			item.Error = batchErrorMessage(err)
+			result.Failed++
+		} else {
+			item.OK = true
+			result.Succeeded++
+			released = append(released, opReleased...)
+		}
+		result.Results[i] = item
+	}
+
+	if req.Atomic && result.Failed > 0 {
+		// 回滚整个事务，之前成功的操作也一并撤销。
+		for i := range result.Results {
+			if result.Results[i].OK {
+				result.Results[i].OK = false
+				result.Results[i].FileName = ""
+				result.Results[i].Error = errRolledBack.Error()
+			}
+		}
+		result.Failed, result.Succeeded = len(result.Results), 0
+		return result, nil
+	}
+
+	if idemKey != "" {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return BatchResult{}, err
+		}
+		if err := tx.SaveIdempotency(ctx, idemKey, reqHash, data, idempotencyTTL); err != nil {
+			if errors.Is(err, dao.ErrIdempotencyKeyExists) {
+				// 并发的相同请求已先提交，放弃本次修改并返回它的结果。
+				tx.Rollback()
+				result, ok, err := replayBatch(ctx, username, idemKey, reqHash)
+				if err == nil && !ok {
+					err = ErrIdempotencyConflict
+				}
+				return result, err
+			}
+			return BatchResult{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return BatchResult{}, err
+	}
+	reclaimBlobs(ctx, released)
+	return result, nil
+}
+
+// replayBatch 返回幂等键之前保存的结果；键对应的请求内容不同时返回 ErrIdempotencyConflict。
+func replayBatch(ctx context.Context, username, idemKey, reqHash string) (BatchResult, bool, error) {
+	rec, ok, err := dao.GetIdempotencyRecord(ctx, username, idemKey, idempotencyTTL)
+	if err != nil || !ok {
+		return BatchResult{}, false, err
+	}
+	if rec.RequestHash != reqHash {
+		return BatchResult{}, true, ErrIdempotencyConflict
+	}
+	var result BatchResult
+	if err := json.Unmarshal(rec.Response, &result); err != nil {
+		return BatchResult{}, true, fmt.Errorf("failed to decode idempotency record: %w", err)
+	}
+	result.Replayed = true
+	return result, true, nil
+}
+
+// applyBatchOp 执行单个操作，rename、move 返回新路径，delete 返回被删除的记录引用过的内容 sha1。
+func applyBatchOp(ctx context.Context, tx *dao.UserFileTx, op BatchOp) (name string, released []string, err error) {
+	if !isSha1Hex(op.FileHash) {
+		return "", nil, fmt.Errorf("%w: invalid filehash", ErrInvalidBatch)
+	}
+
+	switch op.Op {
+	case BatchOpDelete:
+		released, err = tx.DeleteUserFile(ctx, op.FileHash)
+		return "", released, err
+
+	case BatchOpRename, BatchOpMove:
+		oldName, err := tx.FileName(ctx, op.FileHash)
+		if err != nil {
+			return "", nil, err
+		}
+		dir, base := userPathSplit(oldName)
+		if op.Op == BatchOpRename {
+			if !validPathSegment(op.Name) {
+				return "", nil, fmt.Errorf("%w: invalid name", ErrInvalidBatch)
+			}
+			base = op.Name
+		} else {
+			if dir, err = cleanUserDir(op.Dir); err != nil {
+				return "", nil, err
+			}
+		}
+		newName := userPathJoin(dir, base)
+		if len(newName) > maxUserPathLen {
+			return "", nil, fmt.Errorf("%w: path too long", ErrInvalidBatch)
+		}
+		return newName, nil, tx.RenameUserFile(ctx, op.FileHash, newName)
+
+	case BatchOpTag, BatchOpUntag:
+		tags, err := NormalizeTags(op.Tags)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(tags) == 0 {
+			return "", nil, ErrInvalidTag
+		}
+		if op.Op == BatchOpUntag {
+			return "", nil, tx.RemoveTags(ctx, op.FileHash, tags)
+		}
+		count, err := tx.AddTags(ctx, op.FileHash, tags)
+		if err == nil && count > MaxTagsPerFile {
+			err = ErrTooManyTags
+		}
+		return "", nil, err
+	}
+	return "", nil, fmt.Errorf("%w: unknown op %q", ErrInvalidBatch, op.Op)
+}
+
+// batchErrorMessage 把操作失败的原因转换为返回给客户端的信息，内部错误不暴露细节。
+func batchErrorMessage(err error) string {
+	for _, known := range []error{ErrInvalidBatch, dao.ErrUserFileNotFound, dao.ErrUserFileExists, ErrInvalidTag, ErrTooManyTags} {
+		if errors.Is(err, known) {
+			return err.Error()
+		}
+	}
+	return "internal error"
+}
+
+func batchRequestHash(req BatchRequest) string {
+	data, _ := json.Marshal(req)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// userPathSplit 把用户文件路径拆成目录和文件名，根目录下的文件目录为空。
+func userPathSplit(name string) (dir, base string) {
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return "", name
+}
+
+func userPathJoin(dir, base string) string {
+	if dir == "" {
+		return base
+	}
+	return dir + "/" + base
+}
+
+// cleanUserDir 规范化目标目录，拒绝 .. 等不安全的路径段。
+func cleanUserDir(dir string) (string, error) {
+	dir = strings.Trim(strings.ReplaceAll(dir, "\\", "/"), "/")
+	if dir == "" {
+		return "", nil
+	}
+	for _, seg := range strings.Split(dir, "/") {
+		if !validPathSegment(seg) {
+			return "", fmt.Errorf("%w: invalid dir", ErrInvalidBatch)
+		}
+	}
+	return path.Clean(dir), nil
+}
+
+func validPathSegment(s string) bool {
+	if s == "" || s == "." || s == ".." || len(s) > maxUserPathLen {
+		return false
+	}
+	return !strings.ContainsFunc(s, func(r rune) bool { return r == '/' || r == '\\' || unicode.IsControl(r) })
+}
+
+func isSha1Hex(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"context"
+	"filestore-server/pkg/compress"
+	"filestore-server/pkg/config"
+	"filestore-server/pkg/crypt"
+	"filestore-server/pkg/dao"
+	"fmt"
+	"io"
+	"sync"
+)
+
+var (
+	keyringOnce sync.Once
+	keyring     *crypt.Keyring
+	keyringErr  error
+)
+
+// encryptionKeyring 按配置加载主密钥环；没有配置任何主密钥时返回 nil。
+func encryptionKeyring() (*crypt.Keyring, error) {
+	keyringOnce.Do(func() {
+		key := config.String("FILESTORE_MASTER_KEY", "")
+		keyFile := config.String("FILESTORE_MASTER_KEY_FILE", "")
+		if key == "" && keyFile == "" {
+			return
+		}
+		keyring, keyringErr = crypt.LoadKeyring(key, keyFile, config.String("FILESTORE_MASTER_KEY_ID", ""))
+	})
+	return keyring, keyringErr
+}
+
+// newBlobWriter 按配置为 dst 依次包上加密和压缩，并把对应的元信息写入 fmeta。
+// sample 为文件开头的采样，用于判断是否值得压缩。返回的 writer 必须在 dst 关闭前 Close。
+func newBlobWriter(dst io.Writer, fmeta *dao.FileMeta, sample []byte) (io.WriteCloser, error) {
+	w, err := newEncryptWriter(dst, fmeta)
+	if err != nil {
+		return nil, err
+	}
+
+	alg := config.String("FILESTORE_COMPRESSION", "")
+	if !compress.Supported(alg) || !compress.ShouldCompress(fmeta.FileName, sample) {
+		return w, nil
+	}
+	cw, err := compress.NewWriter(alg, w)
+	if err != nil {
+		return nil, err
+	}
+	fmeta.Compression = alg
+	return chainWriteCloser{WriteCloser: cw, next: w}, nil
+}
+
+// newEncryptWriter 在开启服务端加密时为 dst 包一层加密，并把包装后的数据密钥写入 fmeta。
+func newEncryptWriter(dst io.Writer, fmeta *dao.FileMeta) (io.WriteCloser, error) {
+	if !config.Bool("FILESTORE_ENCRYPTION", false) {
+		return nopWriteCloser{dst}, nil
+	}
+
+	kr, err := encryptionKeyring()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load master key: %w", err)
+	}
+	if kr == nil {
+		return nil, fmt.Errorf("encryption enabled but no master key configured")
+	}
+
+	dataKey, keyID, wrapped, err := kr.NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+	w, err := crypt.NewEncryptWriter(dst, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	fmeta.KeyID = keyID
+	fmeta.EncKey = wrapped
+	return w, nil
+}
+
+// openStored 打开落盘的数据并透明解密；压缩文件返回的仍是压缩后的数据。
+func openStored(ctx context.Context, fmeta dao.FileMeta) (io.ReadSeekCloser, error) {
+	f, err := openRaw(ctx, fmeta)
+	if err != nil {
+		return nil, err
+	}
+	if fmeta.KeyID == "" {
+		return f, nil
+	}
+
+	kr, err := encryptionKeyring()
+	if err == nil && kr == nil {
+		err = fmt.Errorf("no master key configured")
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to load master key: %w", err)
+	}
+	dataKey, err := kr.Unwrap(fmeta.KeyID, fmeta.EncKey)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r, err := crypt.NewDecryptReader(f, dataKey, payloadSize(fmeta))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// payloadSize 返回加密层内部数据（压缩后的数据）的长度。
+func payloadSize(fmeta dao.FileMeta) int64 {
+	if fmeta.Compression == "" {
+		return fmeta.FileSize
+	}
+	if fmeta.KeyID != "" {
+		return crypt.PlainSize(fmeta.StoredSize)
+	}
+	return fmeta.StoredSize
+}
+
+// openBlob 打开文件的原始内容，加密和压缩都会被透明处理。
+func openBlob(ctx context.Context, fmeta dao.FileMeta) (io.ReadCloser, error) {
+	r, err := openStored(ctx, fmeta)
+	if err != nil {
+		return nil, err
+	}
+	if fmeta.Compression == "" {
+		return r, nil
+	}
+	dec, err := compress.NewReader(fmeta.Compression, r)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return dec, nil
+}
+
+// RotateKeys 用当前主密钥重新包装所有旧主密钥包装的数据密钥，文件内容不需要重新加密。
+func RotateKeys(ctx context.Context) (int, error) {
+	kr, err := encryptionKeyring()
+	if err != nil {
+		return 0, fmt.Errorf("failed to load master key: %w", err)
+	}
+	if kr == nil {
+		return 0, fmt.Errorf("no master key configured")
+	}
+
+	rotated := 0
+	var afterID int64
+	for {
+		metas, lastID, err := dao.ListFileMetas(ctx, afterID, fsckBatchSize)
+		if err != nil {
+			return rotated, err
+		}
+		if len(metas) == 0 {
+			return rotated, nil
+		}
+		afterID = lastID
+
+		for _, fmeta := range metas {
+			if fmeta.KeyID == "" || fmeta.KeyID == kr.ActiveID() {
+				continue
+			}
+			keyID, wrapped, err := kr.Rewrap(fmeta.KeyID, fmeta.EncKey)
+			if err != nil {
+				return rotated, fmt.Errorf("failed to rewrap key of %s: %w", fmeta.FileSha1, err)
+			}
+			if err := dao.UpdateFileKey(ctx, fmeta.FileSha1, fmeta.KeyID, keyID, wrapped); err != nil {
+				return rotated, err
+			}
+			rotated++
+		}
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// chainWriteCloser 关闭时先关闭自身再关闭下一层 writer。
+type chainWriteCloser struct {
+	io.WriteCloser
+	next io.WriteCloser
+}
+
+func (c chainWriteCloser) Close() error {
+	if err := c.WriteCloser.Close(); err != nil {
+		return err
+	}
+	return c.next.Close()
+}
+
+// countingWriter 统计写入的字节数。
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"filestore-server/pkg/compress"
+	"filestore-server/pkg/dao"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// derivedDir 存放本地保存的派生内容（缩略图等），文件名为派生内容的存储键。
+const derivedDir = storageDir + "/derived"
+
+// saveDerivedBlob 把由文件 filehash 生成的 kind 类内容保存为派生内容，加密和压缩方式与上传的文件相同；
+// 配置了二级存储时直接保存到二级存储。已有的同类派生内容会被覆盖。
+func saveDerivedBlob(ctx context.Context, filehash, kind string, data []byte) error {
+	if err := os.MkdirAll(derivedDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create derived dir: %w", err)
+	}
+
+	key := filehash + "_" + kind
+	blob := dao.FileMeta{FileSha1: key, FileName: key, FileSize: int64(len(data))}
+	location := filepath.Join(derivedDir, key)
+	tmp := location + ".tmp." + strconv.FormatInt(time.Now().UnixNano(), 10)
+	if err := writeDerivedFile(tmp, &blob, data); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	defer os.Remove(tmp)
+
+	store, err := remoteStore(ctx)
+	if err != nil {
+		return err
+	}
+	if store != nil {
+		f, err := os.Open(tmp)
+		if err != nil {
+			return err
+		}
+		err = store.Put(ctx, key, f, blob.StoredSize)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		blob.Location = store.Location(key)
+		blob.StoreState = dao.StoreRemote
+	} else {
+		if err := os.Rename(tmp, location); err != nil {
+			return fmt.Errorf("failed to move derived blob: %w", err)
+		}
+		blob.Location = location
+	}
+	return dao.SaveDerivedBlob(ctx, filehash, kind, blob)
+}
+
+// writeDerivedFile 把 data 经 newBlobWriter 写入 location，并把存储信息写入 blob。
+func writeDerivedFile(location string, blob *dao.FileMeta, data []byte) error {
+	f, err := os.Create(location)
+	if err != nil {
+		return fmt.Errorf("failed to create derived blob: %w", err)
+	}
+	defer f.Close()
+
+	stored := &countingWriter{w: f}
+	w, err := newBlobWriter(stored, blob, data[:min(len(data), compress.SampleSize)])
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("failed to write derived blob: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to write derived blob: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write derived blob: %w", err)
+	}
+	blob.StoredSize = stored.n
+	return nil
+}
+
+// readDerivedBlob 读取文件 filehash 的 kind 类派生内容，没有生成过时返回 dao.ErrDerivedNotFound。
+func readDerivedBlob(ctx context.Context, filehash, kind string) ([]byte, error) {
+	blob, err := dao.GetDerivedBlob(ctx, filehash, kind)
+	if err != nil {
+		return nil, err
+	}
+	r, err := openBlob(ctx, blob)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, r); err != nil {
+		return nil, fmt.Errorf("failed to read derived blob: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// removeDerivedBlobs 删除文件的全部派生内容，失败只记录日志。
+func removeDerivedBlobs(ctx context.Context, filehash string) {
+	blobs, err := dao.GetDerivedBlobs(ctx, filehash)
+	if err != nil {
+		log.Printf("failed to list derived blobs of %s: %v", filehash, err)
+		return
+	}
+	for _, blob := range blobs {
+		if blob.StoreState != dao.StoreRemote {
+			err = os.Remove(blob.Location)
+			if os.IsNotExist(err) {
+				err = nil
+			}
+		} else if store, storeErr := remoteStore(ctx); storeErr != nil || store == nil {
+			err = fmt.Errorf("remote store unavailable: %v", storeErr)
+		} else {
+			err = store.Delete(ctx, blob.FileSha1)
+		}
+		if err != nil {
+			log.Printf("failed to remove derived blob %s: %v", blob.FileSha1, err)
+		}
+	}
+	if err := dao.DeleteDerivedBlobs(ctx, filehash); err != nil {
+		log.Printf("failed to delete derived blobs of %s: %v", filehash, err)
+	}
+}
@@ -0,0 +1,370 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"filestore-server/pkg/config"
+	"filestore-server/pkg/dao"
+	"filestore-server/pkg/queue"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const extractTimeout = 30 * time.Minute
+
+var (
+	// ErrNotArchive 表示文件不是支持解压的压缩包格式。
+	ErrNotArchive = errors.New("unsupported archive type")
+
+	errTooManyEntries = errors.New("archive has too many entries")
+	errTooLarge       = errors.New("archive expands beyond size limit")
+	errRatioTooHigh   = errors.New("archive compression ratio too high")
+	errUnsafePath     = errors.New("archive entry has unsafe path")
+)
+
+// ExtractLimits 是解压时的防护阈值，用来防御 zip bomb。
+type ExtractLimits struct {
+	MaxEntries int
+	MaxBytes   int64
+	// MaxRatio 是解压后大小与压缩大小之比的上限。
+	MaxRatio int64
+}
+
+// extractPayload 是解压任务的参数，Space 为解压出的文件保存到的空间（用户名或 "team:<id>"）。
+type extractPayload struct {
+	Space       string
+	FileSha1    string
+	ArchiveName string
+}
+
+// ExtractResult 是解压任务的结果，失败时包含失败前已保存的文件。
+type ExtractResult struct {
+	Extracted []string
+}
+
+func defaultExtractLimits() ExtractLimits {
+	return ExtractLimits{
+		MaxEntries: config.Int("FILESTORE_EXTRACT_MAX_ENTRIES", 10000),
+		MaxBytes:   config.Int64("FILESTORE_EXTRACT_MAX_BYTES", 1<<30),
+		MaxRatio:   config.Int64("FILESTORE_EXTRACT_MAX_RATIO", 100),
+	}
+}
+
+// StartExtract 校验 actor 对压缩包有读权限后投递后台解压任务，解压出的文件保存到 space，立即返回任务 ID。
+// 调用方负责确认 actor 对 space 有写权限；任务属于 actor，解压到团队空间时也由 actor 查询进度。
+func StartExtract(ctx context.Context, actor, space, filehash string) (string, error) {
+	entry, err := authorizeFileEntry(ctx, actor, filehash, PermRead)
+	if err != nil {
+		return "", err
+	}
+	if archiveType(entry.FileName) == "" {
+		return "", ErrNotArchive
+	}
+	fmeta, err := dao.GetFileMeta(ctx, filehash)
+	if err != nil {
+		return "", err
+	}
+	if err := checkScanState(fmeta); err != nil {
+		return "", err
+	}
+
+	return Jobs().Enqueue(ctx, JobExtract, actor, extractPayload{
+		Space:       space,
+		FileSha1:    filehash,
+		ArchiveName: entry.FileName,
+	})
+}
+
+func extractJob(ctx context.Context, job *queue.Job) error {
+	var p extractPayload
+	if err := job.Decode(&p); err != nil {
+		return queue.Permanent(err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, extractTimeout)
+	defer cancel()
+
+	var result ExtractResult
+	err := ExtractArchive(ctx, job.Owner, p.Space, p.FileSha1, p.ArchiveName, defaultExtractLimits(), func(name string) {
+		result.Extracted = append(result.Extracted, name)
+	})
+	job.SetResult(result)
+	if err != nil && isUnsafeArchive(err) {
+		return queue.Permanent(err)
+	}
+	return err
+}
+
+// isUnsafeArchive 判断错误是否来自防护规则、类型规则、配额不足或文件已被判定感染，这类错误重试也不会成功。
+func isUnsafeArchive(err error) bool {
+	for _, target := range []error{ErrNotArchive, ErrFileInfected, ErrQuotaExceeded, ErrFileTypeNotAllowed, errTooManyEntries, errTooLarge, errRatioTooHigh, errUnsafePath} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return strings.HasPrefix(err.Error(), "invalid ")
+}
+
+// ExtractArchive 把压缩包中的文件保存到 space 中以压缩包命名的新目录下，保留包内的目录结构，内容照常经过 tbl_file 去重。
+// 目录名与 space 中已有的文件或目录重名时追加序号，解压不会覆盖或变成已有文件的新版本。
+// 每个文件与 actor 直接上传一样受其所在组的类型规则和 space 的配额限制。每保存一个文件调用一次 onFile。
+func ExtractArchive(ctx context.Context, actor, space, filehash, archiveName string, limits ExtractLimits, onFile func(name string)) error {
+	fmeta, err := dao.GetFileMeta(ctx, filehash)
+	if err != nil {
+		return err
+	}
+	if err := checkScanState(fmeta); err != nil {
+		return err
+	}
+
+	// zip 需要随机读取，先把压缩包的明文落到临时文件。
+	tmp, err := os.CreateTemp("", "filestore-extract-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+	if err := copyBlob(ctx, tmp, fmeta); err != nil {
+		return err
+	}
+
+	dir, err := extractDir(ctx, space, archiveName)
+	if err != nil {
+		return err
+	}
+	e := &extractor{
+		ctx:    ctx,
+		actor:  actor,
+		space:  space,
+		dir:    dir,
+		limits: limits,
+		used:   make(map[string]bool),
+		onFile: onFile,
+	}
+	switch archiveType(archiveName) {
+	case "zip":
+		return e.extractZip(tmp, fmeta.FileSize)
+	case "tar.gz":
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		gr, err := gzip.NewReader(tmp)
+		if err != nil {
+			return fmt.Errorf("invalid gzip: %w", err)
+		}
+		defer gr.Close()
+		return e.extractTar(gr, fmeta.FileSize)
+	case "tar":
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		return e.extractTar(tmp, fmeta.FileSize)
+	}
+	return ErrNotArchive
+}
+
+type extractor struct {
+	ctx      context.Context
+	actor    string
+	space    string
+	dir      string
+	limits   ExtractLimits
+	entries  int
+	expanded int64
+	used     map[string]bool
+	onFile   func(name string)
+}
+
+func (e *extractor) extractZip(r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("invalid zip: %w", err)
+	}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if f.UncompressedSize64 > 0 && f.CompressedSize64 > 0 &&
+			f.UncompressedSize64/f.CompressedSize64 > uint64(e.limits.MaxRatio) {
+			return fmt.Errorf("%w: %s", errRatioTooHigh, f.Name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("failed to open zip entry %s: %w", f.Name, err)
+		}
+		err = e.save(f.Name, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *extractor) extractTar(r io.Reader, archiveSize int64) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid tar: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := e.save(hdr.Name, tr); err != nil {
+			return err
+		}
+		// tar.gz 无法拿到单个文件的压缩大小，只能按整体比例限制。
+		if archiveSize > 0 && e.expanded/archiveSize > e.limits.MaxRatio {
+			return errRatioTooHigh
+		}
+	}
+}
+
+// save 校验路径、类型规则与配额后把一个条目保存为用户文件。
+func (e *extractor) save(entryName string, r io.Reader) error {
+	if err := e.ctx.Err(); err != nil {
+		return err
+	}
+	e.entries++
+	if e.entries > e.limits.MaxEntries {
+		return errTooManyEntries
+	}
+
+	name, err := safeEntryName(entryName)
+	if err != nil {
+		return err
+	}
+	name = uniqueEntryName(e.dir+"/"+name, e.used)
+	if len(name) > maxUserPathLen {
+		return fmt.Errorf("%w: %s", errUnsafePath, entryName)
+	}
+
+	// 不信任条目头里声明的大小，按实际读出的字节数限制；超限时上传会失败并清理临时文件。
+	budget := &budgetReader{r: r, remaining: e.limits.MaxBytes - e.expanded}
+	defer func() { e.expanded += budget.read }()
+	content, err := checkUploadPolicy(e.ctx, e.actor, name, budget)
+	if err != nil {
+		return err
+	}
+	fmeta, err := UploadFile(e.ctx, content, name)
+	if err != nil {
+		return err
+	}
+
+	fmeta.FileName = name
+	if err := SaveUserFileVersion(e.ctx, e.space, fmeta); err != nil {
+		// 刚写入的内容没有被任何空间引用时回收。
+		if reclaimErr := reclaimBlob(e.ctx, fmeta.FileSha1); reclaimErr != nil {
+			log.Printf("failed to reclaim blob %s: %v", fmeta.FileSha1, reclaimErr)
+		}
+		if errors.Is(err, dao.ErrDuplicateUserFile) {
+			// 空间里已有相同内容，跳过该条目。
+			return nil
+		}
+		return err
+	}
+	if e.onFile != nil {
+		e.onFile(name)
+	}
+	return nil
+}
+
+// safeEntryName 拒绝绝对路径和包含 .. 的条目（zip slip），返回规范化后的包内相对路径。
+func safeEntryName(name string) (string, error) {
+	normalized := strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(normalized, "/") || path.IsAbs(normalized) || strings.Contains(normalized, ":") {
+		return "", fmt.Errorf("%w: %s", errUnsafePath, name)
+	}
+	var parts []string
+	for _, part := range strings.Split(normalized, "/") {
+		if part == "" || part == "." {
+			continue
+		}
+		if !validPathSegment(part) {
+			return "", fmt.Errorf("%w: %s", errUnsafePath, name)
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return "", fmt.Errorf("%w: %s", errUnsafePath, name)
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+// extractDir 返回压缩包所在目录下以压缩包名（去掉扩展名）命名、且 space 中尚不存在的目录，
+// 已有同名文件或目录时依次尝试 "name (1)"、"name (2)"……
+func extractDir(ctx context.Context, space, archiveName string) (string, error) {
+	parent, base := userPathSplit(strings.Trim(archiveName, "/"))
+	lower := strings.ToLower(base)
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+		if strings.HasSuffix(lower, ext) {
+			base = base[:len(base)-len(ext)]
+			break
+		}
+	}
+	if !validPathSegment(base) {
+		base = "archive"
+	}
+	for i := 0; ; i++ {
+		dir := userPathJoin(parent, base)
+		if i > 0 {
+			dir = userPathJoin(parent, base+" ("+strconv.Itoa(i)+")")
+		}
+		// GetUserFilesUnder 同时匹配路径等于 dir 的文件和 dir 下的文件。
+		files, err := dao.GetUserFilesUnder(ctx, space, dir, 1)
+		if err != nil {
+			return "", err
+		}
+		if len(files) == 0 {
+			return dir, nil
+		}
+	}
+}
+
+// archiveType 根据文件名判断压缩包类型，不支持时返回空字符串。
+func archiveType(filename string) string {
+	lower := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip"
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(lower, ".tar"):
+		return "tar"
+	}
+	return ""
+}
+
+// budgetReader 在读出的字节数超过 remaining 时返回 errTooLarge。
+type budgetReader struct {
+	r         io.Reader
+	remaining int64
+	read      int64
+}
+
+func (b *budgetReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > b.remaining-b.read+1 {
+		p = p[:b.remaining-b.read+1]
+	}
+	n, err := b.r.Read(p)
+	b.read += int64(n)
+	if b.read > b.remaining {
+		return n, errTooLarge
+	}
+	return n, err
+}
@@ -1,12 +1,19 @@
 package service
 
 import (
+	"bufio"
 	"context"
 	"crypto/sha1"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"filestore-server/pkg/compress"
 	"filestore-server/pkg/dao"
+	util "filestore-server/pkg/utils"
 	"fmt"
 	"io"
+	"log"
 	"os"
 	"strconv"
 	"time"
@@ -15,26 +22,61 @@ import (
 const (
 	defaultListLimit = 10
 	maxListLimit     = 100
+
+	// storageDir 是文件内容的落盘目录。
+	storageDir = "./tmp"
+	// uploadTmpPrefix 是上传过程中临时文件的前缀，进程崩溃时可能残留。
+	uploadTmpPrefix = ".upload."
+)
+
+var (
+	// ErrInvalidListOptions 表示列表的排序或过滤条件不合法。
+	ErrInvalidListOptions = errors.New("invalid list options")
+	// ErrInvalidCursor 表示游标无法解析，或与本次请求的排序方式不一致。
+	ErrInvalidCursor = errors.New("invalid cursor")
 )
 
 // ListOptions 定义列表查询的选项。
 type ListOptions struct {
 	Limit  int
 	Offset int
+	// SortBy 为排序字段（name、size、upload_at、last_update），默认 last_update。
+	SortBy string
+	// Asc 为 true 时升序，默认降序。
+	Asc bool
+	// NamePrefix、MinSize、MaxSize 过滤文件名前缀和大小，零值表示不限。
+	NamePrefix string
+	MinSize    int64
+	MaxSize    int64
+	// Cursor 为上一页返回的 NextCursor 或 PrevCursor，非空时忽略 Offset。
+	Cursor string
+}
+
+// FileListPage 是文件列表的一页。
+type FileListPage struct {
+	Files []dao.FileMeta
+	// Total 为符合条件的总数，按游标翻页时不统计，为 -1。
+	Total int
+	// NextCursor、PrevCursor 用于翻到下一页、上一页，没有更多记录时为空。
+	NextCursor string
+	PrevCursor string
 }
 
 // UploadFile 编排上传用例：落盘 + 写入元信息；DB 失败会回滚文件。
+// 内容先写入临时文件，算出 sha1 后再移动到最终位置，避免同名文件覆盖已有版本的内容；
+// 相同内容已存在时直接返回已有的元信息。
 func UploadFile(ctx context.Context, src io.Reader, filename string) (dao.FileMeta, error) {
-	if err := os.MkdirAll("./tmp", 0o755); err != nil {
+	if err := os.MkdirAll(storageDir, 0o755); err != nil {
 		return dao.FileMeta{}, fmt.Errorf("failed to create tmp dir: %w", err)
 	}
 
-	location := "./tmp/" + filename
-	dst, err := os.Create(location)
+	tmpPath := storageDir + "/" + uploadTmpPrefix + strconv.FormatInt(time.Now().UnixNano(), 10)
+	dst, err := os.Create(tmpPath)
 	if err != nil {
 		return dao.FileMeta{}, fmt.Errorf("failed to create file: %w", err)
 	}
 
+	location := tmpPath
 	shouldCleanup := true
 	defer func() {
 		_ = dst.Close()
@@ -43,88 +85,276 @@ func UploadFile(ctx context.Context, src io.Reader, filename string) (dao.FileMe
 		}
 	}()
 
+	br := bufio.NewReaderSize(src, compress.SampleSize)
+	sample, _ := br.Peek(compress.SampleSize)
+
+	fmeta := dao.FileMeta{FileName: filename, MimeType: util.DetectMimeType(filename, sample)}
+	if scanEnabled() {
+		fmeta.ScanState = dao.ScanPending
+	}
+	stored := &countingWriter{w: dst}
+	blobWriter, err := newBlobWriter(stored, &fmeta, sample)
+	if err != nil {
+		return dao.FileMeta{}, err
+	}
+
+	// sha1 始终基于明文计算，保证秒传和加密互不影响。
 	hash := sha1.New()
-	filesize, err := io.Copy(io.MultiWriter(dst, hash), src)
+	filesize, err := io.Copy(io.MultiWriter(blobWriter, hash), br)
 	if err != nil {
 		return dao.FileMeta{}, fmt.Errorf("failed to save file: %w", err)
 	}
+	if err := blobWriter.Close(); err != nil {
+		return dao.FileMeta{}, fmt.Errorf("failed to save file: %w", err)
+	}
 	fileSha1 := hex.EncodeToString(hash.Sum(nil))
 
-	fmeta := dao.FileMeta{
-		FileSha1: fileSha1,
-		FileName: filename,
-		FileSize: filesize,
-		Location: location,
-		UploadAt: time.Now().Format("2006-01-02 15:04:05"),
+	if err := dst.Close(); err != nil {
+		return dao.FileMeta{}, fmt.Errorf("failed to save file: %w", err)
 	}
 
-	if err := dao.SaveFileMeta(ctx, fmeta.FileSha1, fmeta.FileName, fmeta.FileSize, fmeta.Location); err != nil {
+	// 内容已存在时丢弃刚写入的临时文件，直接复用已有记录。
+	existing, exists, err := dao.GetFileExist(ctx, fileSha1)
+	if err != nil {
+		return dao.FileMeta{}, err
+	}
+	if exists {
+		return existing, nil
+	}
+
+	finalPath, err := blobLocation(filename, fileSha1)
+	if err != nil {
+		return dao.FileMeta{}, err
+	}
+	if err := os.Rename(tmpPath, finalPath); err != nil {
+		return dao.FileMeta{}, fmt.Errorf("failed to move file: %w", err)
+	}
+	location = finalPath
+
+	fmeta.FileSha1 = fileSha1
+	fmeta.FileSize = filesize
+	fmeta.StoredSize = stored.n
+	fmeta.Location = location
+	fmeta.UploadAt = time.Now().Format("2006-01-02 15:04:05")
+
+	if err := dao.InsertFileMeta(ctx, fmeta); err != nil {
 		return dao.FileMeta{}, err
 	}
 
 	shouldCleanup = false
+	enqueuePostUpload(ctx, fmeta)
 	return fmeta, nil
 }
 
-// DownloadFile 编排下载用例：查询元信息 + 读取文件内容。
-func DownloadFile(ctx context.Context, filehash string) (dao.FileMeta, []byte, error) {
+// blobLocation 返回文件内容的落盘路径：优先使用原文件名，已被其他内容占用时加上 sha1 前缀。
+// SaveUpload 编排上传用例：按 actor 所在组的类型规则检查内容、检查 owner 空间的配额，
+// 内容已存在时秒传，否则写入新内容，最后记为 owner 空间中 filename 的当前版本。
+// 类型不允许时返回 ErrFileTypeNotAllowed，超过配额时返回 ErrQuotaExceeded。
+func SaveUpload(ctx context.Context, actor, owner string, src io.ReadSeeker, filename string, size int64) (dao.FileMeta, error) {
+	sample := make([]byte, util.MimeSampleSize)
+	n, err := io.ReadFull(src, sample)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return dao.FileMeta{}, fmt.Errorf("failed to read file: %w", err)
+	}
+	if err := checkMimePolicy(ctx, actor, util.DetectMimeType(filename, sample[:n])); err != nil {
+		return dao.FileMeta{}, err
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return dao.FileMeta{}, fmt.Errorf("failed to read file: %w", err)
+	}
+	if err := CheckUploadQuota(ctx, owner, filename, size); err != nil {
+		return dao.FileMeta{}, err
+	}
+
+	fmeta, exists, err := GetFileExist(ctx, util.FileSha1ReadSeeker(src))
+	if err != nil {
+		return dao.FileMeta{}, err
+	}
+	if !exists {
+		if fmeta, err = UploadFile(ctx, src, filename); err != nil {
+			return dao.FileMeta{}, err
+		}
+	}
+	fmeta.FileName = filename
+
+	if err := SaveUserFileVersion(ctx, owner, fmeta); err != nil {
+		return dao.FileMeta{}, err
+	}
+	return fmeta, nil
+}
+
+func blobLocation(filename, fileSha1 string) (string, error) {
+	location := storageDir + "/" + filename
+	exists, err := util.PathExists(location)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat file: %w", err)
+	}
+	if exists {
+		location = storageDir + "/" + fileSha1 + "_" + filename
+	}
+	return location, nil
+}
+
+// FileContent 是下载时返回的文件内容。
+type FileContent struct {
+	io.ReadCloser
+	// Encoding 非空时表示内容是按该 Content-Encoding 压缩的数据，可直接发给客户端。
+	Encoding string
+}
+
+// Seeker 在内容支持随机读取时返回它，调用方可据此支持 Range 请求。
+func (c FileContent) Seeker() (io.ReadSeeker, bool) {
+	rs, ok := c.ReadCloser.(io.ReadSeeker)
+	return rs, ok
+}
+
+// DownloadFile 编排下载用例：校验 username 对 owner 空间中该文件的权限 + 查询元信息 + 打开文件内容，
+// FileName 为文件在该空间中的名称。
+// 文件以客户端可接受的编码（acceptEncoding 判定）压缩存储时直接返回压缩数据，否则透明解压。调用方负责关闭。
+func DownloadFile(ctx context.Context, username, owner, filehash string, acceptEncoding func(string) bool) (dao.FileMeta, FileContent, error) {
+	entry, err := authorizeFileIn(ctx, username, owner, filehash, PermRead)
+	if err != nil {
+		return dao.FileMeta{}, FileContent{}, err
+	}
+	fmeta, content, err := openFile(ctx, filehash, acceptEncoding)
+	if err != nil {
+		return dao.FileMeta{}, FileContent{}, err
+	}
+	// 同一内容在各空间中的名称可能不同，下载时使用该空间中的文件名。
+	_, fmeta.FileName = userPathSplit(entry.FileName)
+	return fmeta, content, nil
+}
+
+// openFile 查询元信息并打开文件内容，不校验权限。
+func openFile(ctx context.Context, filehash string, acceptEncoding func(string) bool) (dao.FileMeta, FileContent, error) {
 	fmeta, err := dao.GetFileMeta(ctx, filehash)
 	if err != nil {
-		return dao.FileMeta{}, nil, err
+		return dao.FileMeta{}, FileContent{}, err
+	}
+	if err := checkScanState(fmeta); err != nil {
+		return dao.FileMeta{}, FileContent{}, err
 	}
 
-	data, err := os.ReadFile(fmeta.Location)
+	var content FileContent
+	if fmeta.Compression != "" && acceptEncoding != nil && acceptEncoding(fmeta.Compression) {
+		content.ReadCloser, err = openStored(ctx, fmeta)
+		content.Encoding = fmeta.Compression
+	} else {
+		content.ReadCloser, err = openBlob(ctx, fmeta)
+	}
 	if err != nil {
-		return dao.FileMeta{}, nil, fmt.Errorf("failed to read file: %w", err)
+		return dao.FileMeta{}, FileContent{}, fmt.Errorf("failed to read file: %w", err)
 	}
 
-	return fmeta, data, nil
+	return fmeta, content, nil
 }
 
-// RenameFile 编排重命名用例：读取元信息 + 更新文件名。
-func RenameFile(ctx context.Context, filehash, newFilename string) (dao.FileMeta, error) {
+// RenameFile 编排重命名用例：校验 username 对 owner 空间中该文件的写权限后修改其名称，目录不变。
+// 其他空间中相同内容的文件不受影响。返回的元信息中 FileName 为重命名后的路径。
+func RenameFile(ctx context.Context, username, owner, filehash, newFilename string) (dao.FileMeta, error) {
+	if _, err := authorizeFileIn(ctx, username, owner, filehash, PermWrite); err != nil {
+		return dao.FileMeta{}, err
+	}
 	fmeta, err := dao.GetFileMeta(ctx, filehash)
 	if err != nil {
 		return dao.FileMeta{}, err
 	}
-	fmeta.FileName = newFilename
 
-	if err := dao.UpdateFileMeta(ctx, fmeta); err != nil {
+	tx, err := dao.BeginUserFileTx(ctx, owner)
+	if err != nil {
+		return dao.FileMeta{}, err
+	}
+	defer tx.Rollback()
+
+	name, _, err := applyBatchOp(ctx, tx, BatchOp{Op: BatchOpRename, FileHash: filehash, Name: newFilename})
+	if err != nil {
 		return dao.FileMeta{}, err
 	}
+	if err := tx.Commit(); err != nil {
+		return dao.FileMeta{}, err
+	}
+	fmeta.FileName = name
 	return fmeta, nil
 }
 
-// DeleteFile 编排删除用例：先移走文件再删除元信息，失败可回滚。
-func DeleteFile(ctx context.Context, filehash string) error {
-	fmeta, err := dao.GetFileMeta(ctx, filehash)
+// DeleteFile 编排删除用例：校验 username 对 owner 空间中该文件的写权限后从该空间中删除文件，
+// 内容不再被任何空间、历史版本或收件箱引用时才回收。
+func DeleteFile(ctx context.Context, username, owner, filehash string) error {
+	if _, err := authorizeFileIn(ctx, username, owner, filehash, PermWrite); err != nil {
+		return err
+	}
+
+	tx, err := dao.BeginUserFileTx(ctx, owner)
 	if err != nil {
 		return err
 	}
-	if fmeta.Location == "" {
-		return fmt.Errorf("file location is empty")
+	defer tx.Rollback()
+
+	released, err := tx.DeleteUserFile(ctx, filehash)
+	if err != nil {
+		return err
 	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	reclaimBlobs(ctx, released)
+	return nil
+}
 
-	trashPath := fmeta.Location + ".trash." + strconv.FormatInt(time.Now().UnixNano(), 10)
-	if err := os.Rename(fmeta.Location, trashPath); err != nil {
-		return fmt.Errorf("failed to move file: %w", err)
+// reclaimBlobs 在用户文件删除提交后依次回收 hashes 中不再被引用的内容。
+// 用户的文件已删除，回收失败只记录日志，留下的内容由 fsck 处理。
+func reclaimBlobs(ctx context.Context, hashes []string) {
+	for _, filehash := range hashes {
+		if err := reclaimBlob(ctx, filehash); err != nil {
+			log.Printf("failed to reclaim blob %s: %v", filehash, err)
+		}
 	}
+}
 
-	if err := dao.DeleteFileMeta(ctx, filehash); err != nil {
-		_ = os.Rename(trashPath, fmeta.Location)
+// reclaimBlob 在内容没有任何引用时删除元信息和文件内容。元信息在行锁下先下线，
+// 删除内容失败时再恢复，期间并发的秒传会因内容不可用而失败，不会引用到被删除的内容。
+func reclaimBlob(ctx context.Context, filehash string) error {
+	fmeta, deleted, err := dao.DeleteUnreferencedFileMeta(ctx, filehash)
+	if err != nil || !deleted {
 		return err
 	}
-
-	if err := os.Remove(trashPath); err != nil {
-		// 尝试补偿：恢复元信息并把文件放回去。
+	if fmeta.StoreState == dao.StoreRemote {
+		err = deleteRemoteFile(ctx, fmeta)
+	} else {
+		err = deleteLocalFile(fmeta)
+	}
+	if err != nil {
 		_ = dao.RestoreFileMeta(ctx, filehash)
-		_ = os.Rename(trashPath, fmeta.Location)
-		return fmt.Errorf("failed to remove file: %w", err)
+		return err
 	}
+	removeThumbnails(ctx, filehash)
+	unindexFile(filehash)
+	return nil
+}
 
+// deleteLocalFile 删除本地存储的内容，文件已不存在时视为成功。
+func deleteLocalFile(fmeta dao.FileMeta) error {
+	if fmeta.Location == "" {
+		return fmt.Errorf("file location is empty")
+	}
+	if err := os.Remove(fmeta.Location); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove file: %w", err)
+	}
 	return nil
 }
 
+// deleteRemoteFile 删除二级存储中的对象。
+func deleteRemoteFile(ctx context.Context, fmeta dao.FileMeta) error {
+	store, err := remoteStore(ctx)
+	if err != nil {
+		return err
+	}
+	if store == nil {
+		return fmt.Errorf("no remote store configured")
+	}
+	return store.Delete(ctx, fmeta.FileSha1)
+}
+
 func InsertUserFileMeta(ctx context.Context, username, fileSha1 string, fileSize int64, fileName string) error {
 	if err := dao.InsertUserFileMeta(ctx, username, fileSha1, fileSize, fileName); err != nil {
 		return fmt.Errorf("failed to update user file meta: %w", err)
@@ -132,8 +362,14 @@ func InsertUserFileMeta(ctx context.Context, username, fileSha1 string, fileSize
 	return nil
 }
 
-// GetUserFilelist 获取用户文件列表，支持分页并返回总数。
-func GetUserFilelist(ctx context.Context, username string, opts ListOptions) ([]dao.FileMeta, int, error) {
+// GetUserFilelist 获取用户文件列表，支持排序、过滤，以及按偏移量或游标分页。
+// 按偏移量分页时返回总数；游标基于排序位置，翻页期间新增的文件不会造成重复或遗漏。
+func GetUserFilelist(ctx context.Context, username string, opts ListOptions) (FileListPage, error) {
+	return listFiles(ctx, username, opts, nil)
+}
+
+// listFiles 列出 owner 空间的文件，scopes 非空时只包括这些路径范围内的文件。
+func listFiles(ctx context.Context, owner string, opts ListOptions, scopes []dao.PathScope) (FileListPage, error) {
 	if opts.Limit <= 0 {
 		opts.Limit = defaultListLimit
 	}
@@ -143,12 +379,106 @@ func GetUserFilelist(ctx context.Context, username string, opts ListOptions) ([]
 	if opts.Offset < 0 {
 		opts.Offset = 0
 	}
+	if opts.SortBy == "" {
+		opts.SortBy = dao.SortByLastUpdate
+	}
+	if !dao.ValidUserFileSort(opts.SortBy) {
+		return FileListPage{}, ErrInvalidListOptions
+	}
+	if opts.MinSize < 0 || opts.MaxSize < 0 || (opts.MaxSize > 0 && opts.MinSize > opts.MaxSize) {
+		return FileListPage{}, ErrInvalidListOptions
+	}
+
+	q := dao.UserFileQuery{
+		SortBy:     opts.SortBy,
+		Desc:       !opts.Asc,
+		NamePrefix: opts.NamePrefix,
+		MinSize:    opts.MinSize,
+		MaxSize:    opts.MaxSize,
+		Scopes:     scopes,
+		Limit:      opts.Limit,
+		Offset:     opts.Offset,
+	}
+	var cur listCursor
+	if opts.Cursor != "" {
+		var err error
+		if cur, err = decodeListCursor(opts.Cursor); err != nil {
+			return FileListPage{}, err
+		}
+		if cur.Sort != q.SortBy || cur.Desc != q.Desc {
+			return FileListPage{}, ErrInvalidCursor
+		}
+		key := &dao.UserFileKey{Value: cur.Value, ID: cur.ID}
+		if cur.Prev {
+			q.Before = key
+		} else {
+			q.After = key
+		}
+		// 多取一条判断这个方向上是否还有记录。
+		q.Limit++
+	}
+
+	files, keys, total, err := dao.GetUserFilelist(ctx, owner, q)
+	if err != nil {
+		return FileListPage{}, fmt.Errorf("failed to get user file list: %w", err)
+	}
+	if err := attachTags(ctx, owner, files); err != nil {
+		return FileListPage{}, fmt.Errorf("failed to get file tags: %w", err)
+	}
+
+	var hasNext, hasPrev bool
+	if opts.Cursor == "" {
+		hasNext = opts.Offset+len(files) < total
+		hasPrev = opts.Offset > 0
+	} else {
+		more := len(files) > opts.Limit
+		if more && cur.Prev {
+			// 向前翻页时多出的一条排在最前面。
+			files, keys = files[1:], keys[1:]
+		} else if more {
+			files, keys = files[:opts.Limit], keys[:opts.Limit]
+		}
+		hasNext = cur.Prev || more
+		hasPrev = !cur.Prev || more
+	}
+
+	page := FileListPage{Files: files, Total: total}
+	if len(files) > 0 {
+		if hasNext {
+			page.NextCursor = encodeListCursor(listCursor{Sort: q.SortBy, Desc: q.Desc, Value: keys[len(keys)-1].Value, ID: keys[len(keys)-1].ID})
+		}
+		if hasPrev {
+			page.PrevCursor = encodeListCursor(listCursor{Sort: q.SortBy, Desc: q.Desc, Value: keys[0].Value, ID: keys[0].ID, Prev: true})
+		}
+	}
+	return page, nil
+}
+
+// listCursor 是游标的内容，对客户端不透明。
+type listCursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d,omitempty"`
+	Value string `json:"v"`
+	ID    int64  `json:"i"`
+	// Prev 表示取排在该位置之前的一页。
+	Prev bool `json:"p,omitempty"`
+}
+
+func encodeListCursor(c listCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
 
-	fileMetaList, total, err := dao.GetUserFilelist(ctx, username, opts.Limit, opts.Offset)
+func decodeListCursor(s string) (listCursor, error) {
+	var c listCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to get user file list: %w", err)
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &c); err != nil || !dao.ValidUserFileSort(c.Sort) {
+		return c, ErrInvalidCursor
 	}
-	return fileMetaList, total, nil
+	return c, nil
 }
 
 func GetFileExist(ctx context.Context, filehash string) (dao.FileMeta, bool, error) {
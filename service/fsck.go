@@ -0,0 +1,207 @@
+package service
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"filestore-server/pkg/config"
+	"filestore-server/pkg/dao"
+	util "filestore-server/pkg/utils"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// quarantineDir 存放被隔离的孤儿文件和损坏文件。
+	quarantineDir = storageDir + "/quarantine"
+	fsckBatchSize = 500
+)
+
+// FsckOptions 控制一次完整性检查的行为。
+type FsckOptions struct {
+	// Repair 为 true 时处理发现的问题：删除孤儿文件，下线缺失或损坏的文件记录。
+	Repair bool
+	// Quarantine 为 true 时孤儿文件和损坏文件移入隔离目录而不是直接删除。
+	Quarantine bool
+	// BytesPerSec 限制校验 sha1 时的读盘速度，<= 0 表示不限速。
+	BytesPerSec int64
+	// OrphanGrace 内修改过的孤儿文件会被跳过，避免误伤正在进行的上传。
+	OrphanGrace time.Duration
+}
+
+// FsckReport 是一次完整性检查的结果。
+type FsckReport struct {
+	StartAt      string
+	Checked      int
+	OrphanBlobs  []string
+	MissingBlobs []string
+	CorruptBlobs []string
+	Repaired     int
+	Errors       []string
+}
+
+// Clean 表示没有发现任何问题。
+func (r FsckReport) Clean() bool {
+	return len(r.OrphanBlobs) == 0 && len(r.MissingBlobs) == 0 && len(r.CorruptBlobs) == 0 && len(r.Errors) == 0
+}
+
+// DefaultFsckOptions 从环境变量读取定时任务使用的检查选项。
+func DefaultFsckOptions() FsckOptions {
+	return FsckOptions{
+		Repair:      config.Bool("FILESTORE_FSCK_REPAIR", false),
+		Quarantine:  config.Bool("FILESTORE_FSCK_QUARANTINE", true),
+		BytesPerSec: config.Int64("FILESTORE_FSCK_BYTES_PER_SEC", 20<<20),
+		OrphanGrace: config.Duration("FILESTORE_FSCK_ORPHAN_GRACE", time.Hour),
+	}
+}
+
+// Fsck 对比磁盘与 tbl_file：找出没有记录引用的孤儿文件、记录存在但文件丢失的条目，以及 sha1 不匹配的文件。
+func Fsck(ctx context.Context, opts FsckOptions) (FsckReport, error) {
+	report := FsckReport{StartAt: time.Now().Format("2006-01-02 15:04:05")}
+	referenced := make(map[string]bool)
+
+	var afterID int64
+	for {
+		metas, lastID, err := dao.ListFileMetas(ctx, afterID, fsckBatchSize)
+		if err != nil {
+			return report, err
+		}
+		if len(metas) == 0 {
+			break
+		}
+		afterID = lastID
+
+		for _, fmeta := range metas {
+			if err := ctx.Err(); err != nil {
+				return report, err
+			}
+			report.Checked++
+			referenced[filepath.Clean(fmeta.Location)] = true
+			// 感染文件的内容已被隔离，不再检查。
+			if fmeta.ScanState == dao.ScanInfected {
+				continue
+			}
+			checkBlob(ctx, fmeta, opts, &report)
+		}
+	}
+
+	entries, err := os.ReadDir(storageDir)
+	if err != nil && !os.IsNotExist(err) {
+		return report, fmt.Errorf("failed to read storage dir: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		location := filepath.Clean(filepath.Join(storageDir, entry.Name()))
+		if referenced[location] {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", location, err))
+			continue
+		}
+		if time.Since(info.ModTime()) < opts.OrphanGrace {
+			continue
+		}
+
+		report.OrphanBlobs = append(report.OrphanBlobs, location)
+		if opts.Repair {
+			if err := discardBlob(location, opts.Quarantine); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", location, err))
+				continue
+			}
+			report.Repaired++
+		}
+	}
+
+	return report, nil
+}
+
+func checkBlob(ctx context.Context, fmeta dao.FileMeta, opts FsckOptions, report *FsckReport) {
+	f, err := openBlob(ctx, fmeta)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", fmeta.FileSha1, err))
+			return
+		}
+		report.MissingBlobs = append(report.MissingBlobs, fmeta.FileSha1)
+		if opts.Repair {
+			if err := dao.UpdateFileStatus(ctx, fmeta.FileSha1, dao.FileStatusBroken); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", fmeta.FileSha1, err))
+				return
+			}
+			report.Repaired++
+		}
+		return
+	}
+
+	hash := sha1.New()
+	_, err = io.Copy(hash, util.NewRateLimitedReader(ctx, f, opts.BytesPerSec))
+	f.Close()
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", fmeta.FileSha1, err))
+		return
+	}
+	if hex.EncodeToString(hash.Sum(nil)) == fmeta.FileSha1 {
+		return
+	}
+
+	report.CorruptBlobs = append(report.CorruptBlobs, fmeta.FileSha1)
+	if opts.Repair {
+		if err := dao.UpdateFileStatus(ctx, fmeta.FileSha1, dao.FileStatusBroken); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", fmeta.FileSha1, err))
+			return
+		}
+		// 二级存储中的对象保留原样，只下线记录。
+		if fmeta.StoreState != dao.StoreRemote {
+			if err := discardBlob(fmeta.Location, opts.Quarantine); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", fmeta.FileSha1, err))
+				return
+			}
+		}
+		report.Repaired++
+	}
+}
+
+// discardBlob 删除文件，或在 quarantine 为 true 时移入隔离目录。
+func discardBlob(location string, quarantine bool) error {
+	if !quarantine {
+		return os.Remove(location)
+	}
+	if err := os.MkdirAll(quarantineDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create quarantine dir: %w", err)
+	}
+	target := filepath.Join(quarantineDir, filepath.Base(location)+"."+time.Now().Format("20060102150405"))
+	return os.Rename(location, target)
+}
+
+// StartFsckScheduler 按 interval 周期性执行 Fsck，直到 ctx 取消。
+func StartFsckScheduler(ctx context.Context, interval time.Duration, opts FsckOptions) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				report, err := Fsck(ctx, opts)
+				if err != nil {
+					log.Printf("fsck failed: %v", err)
+					continue
+				}
+				log.Printf("fsck checked=%d orphan=%d missing=%d corrupt=%d repaired=%d errors=%d",
+					report.Checked, len(report.OrphanBlobs), len(report.MissingBlobs),
+					len(report.CorruptBlobs), report.Repaired, len(report.Errors))
+			}
+		}
+	}()
+}
@@ -0,0 +1,245 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"filestore-server/pkg/config"
+	"filestore-server/pkg/dao"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxSendMessageLen = 500
+	// maxRenameAttempts 是接收文件时为避免重名尝试追加序号的次数。
+	maxRenameAttempts = 100
+)
+
+var (
+	// ErrQuotaExceeded 表示操作后用户的存储用量会超过配额。
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+	// ErrInvalidRecipient 表示接收者不存在或是发送者本人。
+	ErrInvalidRecipient = errors.New("invalid recipient")
+	// ErrAlreadySent 表示同一文件已发送给该用户且对方尚未处理。
+	ErrAlreadySent = errors.New("file already sent to this user")
+	// ErrMessageTooLong 表示附言超过长度限制。
+	ErrMessageTooLong = errors.New("message too long")
+)
+
+// userQuota 返回用户的存储配额，用户未单独设置时使用 FILESTORE_USER_QUOTA，0 表示不限。团队空间不限配额。
+func userQuota(ctx context.Context, username string) (int64, error) {
+	if _, ok := teamIDFromNamespace(username); ok {
+		return 0, nil
+	}
+	quota, err := dao.GetUserQuota(ctx, username)
+	if err != nil {
+		return 0, err
+	}
+	if quota == 0 {
+		quota = defaultUserQuota()
+	}
+	return quota, nil
+}
+
+// defaultUserQuota 返回未单独设置配额的用户的配额，0 表示不限。
+func defaultUserQuota() int64 {
+	return config.Int64("FILESTORE_USER_QUOTA", 0)
+}
+
+// checkQuota 确认用户再增加 extra 字节后不超过配额。
+func checkQuota(ctx context.Context, username string, extra int64) error {
+	quota, err := userQuota(ctx, username)
+	if err != nil || quota <= 0 {
+		return err
+	}
+	used, err := dao.GetUserStorageUsage(ctx, username)
+	if err != nil {
+		return err
+	}
+	if used+extra > quota {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// CheckUploadQuota 确认把 size 字节的内容保存到 owner 的 filename 路径后不超过配额，
+// 覆盖路径下已有的文件时只计算增加的部分。上传在写入内容之前调用，避免超额的内容落盘。
+func CheckUploadQuota(ctx context.Context, owner, filename string, size int64) error {
+	current, err := dao.GetUserFileSizeByName(ctx, owner, filename)
+	if err != nil {
+		return err
+	}
+	return checkQuota(ctx, owner, size-current)
+}
+
+// SendFile 把 sender 的文件发送到 recipient 的收件箱，对方接收后才会出现在其文件列表中。
+// 内容按 sha1 共享，不复制数据。返回收件箱条目 ID。
+func SendFile(ctx context.Context, sender, recipient, filehash, message string) (int64, error) {
+	recipient = strings.TrimSpace(recipient)
+	if recipient == "" || recipient == sender {
+		return 0, ErrInvalidRecipient
+	}
+	if utf8.RuneCountInString(message) > maxSendMessageLen {
+		return 0, ErrMessageTooLong
+	}
+	if _, err := dao.GetUserByName(ctx, recipient); err != nil {
+		if err.Error() == "user not found" {
+			return 0, ErrInvalidRecipient
+		}
+		return 0, err
+	}
+
+	owned, err := dao.GetUserFilesByHashes(ctx, sender, []string{filehash})
+	if err != nil {
+		return 0, err
+	}
+	if len(owned) == 0 {
+		return 0, ErrFilesNotOwned
+	}
+	fmeta, err := dao.GetFileMeta(ctx, filehash)
+	if err != nil {
+		return 0, err
+	}
+	if fmeta.ScanState == dao.ScanInfected {
+		return 0, ErrFileInfected
+	}
+
+	pending, err := dao.HasPendingInboxItem(ctx, sender, recipient, filehash)
+	if err != nil {
+		return 0, err
+	}
+	if pending {
+		return 0, ErrAlreadySent
+	}
+
+	_, base := userPathSplit(owned[0].FileName)
+	id, err := dao.InsertInboxItem(ctx, dao.InboxItem{
+		Sender:    sender,
+		Recipient: recipient,
+		FileSha1:  filehash,
+		FileName:  base,
+		FileSize:  owned[0].FileSize,
+		Message:   message,
+	})
+	if err != nil {
+		return 0, err
+	}
+	audit(ctx, sender, "file.send", recipient, "filehash="+filehash)
+	return id, nil
+}
+
+// ListInbox 列出用户待接收的文件。
+func ListInbox(ctx context.Context, username string, limit, offset int) ([]dao.InboxItem, error) {
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return dao.GetPendingInboxItems(ctx, username, limit, offset)
+}
+
+// AcceptInboxItem 接收收件箱中的文件，保存到 username 的 dir 目录下，重名时追加序号。
+// 接收前检查配额；用户已有相同内容时返回 dao.ErrDuplicateUserFile。
+func AcceptInboxItem(ctx context.Context, username string, id int64, dir string) (dao.FileMeta, error) {
+	item, err := dao.GetPendingInboxItem(ctx, username, id)
+	if err != nil {
+		return dao.FileMeta{}, err
+	}
+	if dir, err = cleanUserDir(dir); err != nil {
+		return dao.FileMeta{}, err
+	}
+
+	owned, err := dao.GetUserFilesByHashes(ctx, username, []string{item.FileSha1})
+	if err != nil {
+		return dao.FileMeta{}, err
+	}
+	if len(owned) > 0 {
+		return dao.FileMeta{}, dao.ErrDuplicateUserFile
+	}
+	fmeta, err := dao.GetFileMeta(ctx, item.FileSha1)
+	if err != nil {
+		return dao.FileMeta{}, err
+	}
+	if err := checkQuota(ctx, username, item.FileSize); err != nil {
+		return dao.FileMeta{}, err
+	}
+	name, err := uniqueUserFileName(ctx, username, userPathJoin(dir, item.FileName))
+	if err != nil {
+		return dao.FileMeta{}, err
+	}
+
+	// 先占用条目，避免并发请求重复接收。
+	ok, err := dao.UpdateInboxItemStatus(ctx, id, dao.InboxPending, dao.InboxAccepted)
+	if err != nil {
+		return dao.FileMeta{}, err
+	}
+	if !ok {
+		return dao.FileMeta{}, dao.ErrInboxItemNotFound
+	}
+
+	fmeta.FileName = name
+	fmeta.FileSize = item.FileSize
+	if err := SaveUserFileVersion(ctx, username, fmeta); err != nil {
+		_, _ = dao.UpdateInboxItemStatus(ctx, id, dao.InboxAccepted, dao.InboxPending)
+		return dao.FileMeta{}, err
+	}
+	return fmeta, nil
+}
+
+// DeclineInboxItem 拒收收件箱中的文件。
+func DeclineInboxItem(ctx context.Context, username string, id int64) error {
+	if _, err := dao.GetPendingInboxItem(ctx, username, id); err != nil {
+		return err
+	}
+	ok, err := dao.UpdateInboxItemStatus(ctx, id, dao.InboxPending, dao.InboxDeclined)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return dao.ErrInboxItemNotFound
+	}
+	return nil
+}
+
+// MoveFile 校验 username 对 owner 空间中该文件的写权限后把它移动到该空间的 dir 目录，
+// 只修改路径，不读取内容。返回新路径。
+func MoveFile(ctx context.Context, username, owner, filehash, dir string) (string, error) {
+	if _, err := authorizeFileIn(ctx, username, owner, filehash, PermWrite); err != nil {
+		return "", err
+	}
+	tx, err := dao.BeginUserFileTx(ctx, owner)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	name, _, err := applyBatchOp(ctx, tx, BatchOp{Op: BatchOpMove, FileHash: filehash, Dir: dir})
+	if err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// uniqueUserFileName 在 name 已被占用时追加 " (n)" 后缀，例如 a.txt、a (1).txt。
+func uniqueUserFileName(ctx context.Context, username, name string) (string, error) {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 1; i <= maxRenameAttempts; i++ {
+		exists, err := dao.UserFileNameExists(ctx, username, candidate)
+		if err != nil || !exists {
+			return candidate, err
+		}
+		candidate = base + " (" + strconv.Itoa(i) + ")" + ext
+	}
+	return "", dao.ErrUserFileExists
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"filestore-server/pkg/config"
+	"filestore-server/pkg/dao"
+	"filestore-server/pkg/queue"
+	"filestore-server/pkg/redis"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+)
+
+const (
+	JobVerifyHash = "verify_hash"
+	JobExtract    = "extract"
+	JobTransfer   = "transfer"
+	JobThumbnail  = "thumbnail"
+	JobScan       = "scan"
+	JobIndex      = "index"
+)
+
+type jobRegistration struct {
+	maxAttempts int
+	handler     queue.Handler
+}
+
+var (
+	jobsOnce sync.Once
+	jobs     *queue.Queue
+
+	jobHandlers    = make(map[string]jobRegistration)
+	postUploadJobs []postUploadJob
+)
+
+type postUploadJob struct {
+	jobType string
+	enabled func() bool
+}
+
+// fileJobPayload 是针对单个文件的任务参数。
+type fileJobPayload struct {
+	FileSha1 string
+}
+
+func init() {
+	registerJob(JobVerifyHash, 0, verifyHashJob)
+	registerPostUploadJob(JobVerifyHash, nil)
+	// 扫描器长时间不可用时文件会一直停在待扫描状态，因此扫描任务不进入死信队列，持续按上限退避重试。
+	registerJob(JobScan, queue.Unlimited, scanJob)
+	registerPostUploadJob(JobScan, scanEnabled)
+	registerJob(JobExtract, 3, extractJob)
+	registerJob(JobTransfer, 10, transferJob)
+	registerPostUploadJob(JobTransfer, remoteEnabled)
+	registerJob(JobThumbnail, 3, thumbnailJob)
+	registerPostUploadJob(JobThumbnail, nil)
+	registerJob(JobIndex, 3, indexJob)
+	registerPostUploadJob(JobIndex, fullTextEnabled)
+}
+
+// registerJob 注册一种后台任务的处理函数，需在首次使用队列前（init 中）调用。
+func registerJob(jobType string, maxAttempts int, h queue.Handler) {
+	jobHandlers[jobType] = jobRegistration{maxAttempts: maxAttempts, handler: h}
+}
+
+// registerPostUploadJob 让每个新上传的文件（非秒传）都触发一次 jobType 任务；
+// enabled 非 nil 时只在其返回 true 时投递。
+func registerPostUploadJob(jobType string, enabled func() bool) {
+	postUploadJobs = append(postUploadJobs, postUploadJob{jobType: jobType, enabled: enabled})
+}
+
+// Jobs 返回全局任务队列，首次调用时选择后端并启动 worker：Redis 可用时使用 Redis，否则退回进程内队列。
+func Jobs() *queue.Queue {
+	jobsOnce.Do(func() {
+		ctx := context.Background()
+
+		var backend queue.Backend
+		pool := redis.GetRedisConnectionPool()
+		if config.String("FILESTORE_QUEUE", "redis") == "redis" && queue.Available(pool) {
+			backend = queue.NewRedisBackend(ctx, pool)
+		} else {
+			log.Printf("job queue falls back to in-process backend")
+			backend = queue.NewMemoryBackend()
+		}
+
+		jobs = queue.New(backend, queue.Options{
+			Workers:     config.Int("FILESTORE_JOB_WORKERS", 4),
+			MaxAttempts: config.Int("FILESTORE_JOB_MAX_ATTEMPTS", 5),
+			BaseBackoff: config.Duration("FILESTORE_JOB_BACKOFF", 0),
+		})
+		for jobType, reg := range jobHandlers {
+			jobs.Register(jobType, reg.maxAttempts, reg.handler)
+		}
+		jobs.Start(ctx)
+	})
+	return jobs
+}
+
+// GetJob 返回 username 自己创建的任务状态。
+func GetJob(ctx context.Context, username, id string) (queue.Job, error) {
+	job, err := Jobs().Get(ctx, id)
+	if err != nil {
+		return queue.Job{}, err
+	}
+	if job.Owner == "" || job.Owner != username {
+		return queue.Job{}, queue.ErrJobNotFound
+	}
+	return job, nil
+}
+
+// enqueuePostUpload 为新文件投递上传后处理任务；投递失败只记录日志，不影响上传结果。
+func enqueuePostUpload(ctx context.Context, fmeta dao.FileMeta) {
+	for _, j := range postUploadJobs {
+		if j.enabled != nil && !j.enabled() {
+			continue
+		}
+		if _, err := Jobs().Enqueue(ctx, j.jobType, "", fileJobPayload{FileSha1: fmeta.FileSha1}); err != nil {
+			log.Printf("failed to enqueue %s for %s: %v", j.jobType, fmeta.FileSha1, err)
+		}
+	}
+}
+
+// verifyHashJob 重新读取落盘内容校验 sha1，不一致时将文件下线。
+func verifyHashJob(ctx context.Context, job *queue.Job) error {
+	var p fileJobPayload
+	if err := job.Decode(&p); err != nil {
+		return queue.Permanent(err)
+	}
+	fmeta, err := dao.GetFileMeta(ctx, p.FileSha1)
+	if err != nil {
+		return err
+	}
+
+	r, err := openBlob(ctx, fmeta)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	hash := sha1.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != fmeta.FileSha1 {
+		if err := dao.UpdateFileStatus(ctx, fmeta.FileSha1, dao.FileStatusBroken); err != nil {
+			return err
+		}
+		return queue.Permanent(fmt.Errorf("sha1 mismatch for %s", fmeta.FileSha1))
+	}
+	return nil
+}
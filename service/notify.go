@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"filestore-server/pkg/config"
+	"filestore-server/pkg/notify"
+	"sync"
+	"time"
+)
+
+var (
+	notifierMu     sync.Mutex
+	notifierLoaded bool
+	notifiers      map[string]notify.Notifier
+)
+
+// userNotifier 按配置返回渠道对应的通知器：配置了 FILESTORE_SMTP_ADDR 时邮件通过 SMTP 发送，
+// 其余渠道（以及未配置 SMTP 时的邮件）只写入日志。
+func userNotifier(channel string) notify.Notifier {
+	notifierMu.Lock()
+	defer notifierMu.Unlock()
+	if !notifierLoaded {
+		notifiers = map[string]notify.Notifier{}
+		if addr := config.String("FILESTORE_SMTP_ADDR", ""); addr != "" {
+			notifiers[notify.ChannelEmail] = &notify.SMTPNotifier{
+				Addr:     addr,
+				Username: config.String("FILESTORE_SMTP_USER", ""),
+				Password: config.String("FILESTORE_SMTP_PASSWORD", ""),
+				From:     config.String("FILESTORE_SMTP_FROM", "filestore@localhost"),
+				Timeout:  config.Duration("FILESTORE_SMTP_TIMEOUT", 10*time.Second),
+			}
+		}
+		notifierLoaded = true
+	}
+	if n, ok := notifiers[channel]; ok {
+		return n
+	}
+	return notify.LogNotifier{}
+}
+
+// SetNotifier 替换某个渠道的通知器，传入 nil 表示恢复为写日志。
+func SetNotifier(channel string, n notify.Notifier) {
+	notifierMu.Lock()
+	defer notifierMu.Unlock()
+	if !notifierLoaded {
+		notifiers, notifierLoaded = map[string]notify.Notifier{}, true
+	}
+	if n == nil {
+		delete(notifiers, channel)
+		return
+	}
+	notifiers[channel] = n
+}
+
+// sendNotification 通过渠道对应的通知器投递消息。
+func sendNotification(ctx context.Context, msg notify.Message) error {
+	return userNotifier(msg.Channel).Send(ctx, msg)
+}
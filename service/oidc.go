@@ -0,0 +1,259 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"filestore-server/pkg/config"
+	"filestore-server/pkg/dao"
+	"filestore-server/pkg/oidc"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// oidcUsernameAttempts 为创建用户时遇到重名后追加随机后缀重试的次数。
+const oidcUsernameAttempts = 5
+
+var (
+	// ErrOIDCNotConfigured 表示没有配置 OIDC 身份提供方。
+	ErrOIDCNotConfigured = errors.New("oidc login is not configured")
+	// ErrOIDCLogin 表示授权码换取令牌或校验 ID Token 失败。
+	ErrOIDCLogin = errors.New("oidc login failed")
+	// ErrOIDCNoAccount 表示外部身份没有对应的本地用户，且不允许自动创建。
+	ErrOIDCNoAccount = errors.New("no local account for this identity")
+)
+
+// OIDCAuthRequest 是跳转到身份提供方前生成的参数，State、Nonce、Verifier 需保存在会话中供回调时校验。
+type OIDCAuthRequest struct {
+	URL      string
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+var (
+	oidcMu     sync.Mutex
+	oidcKey    string
+	oidcClient *oidc.Client
+)
+
+// oidcConfig 读取 FILESTORE_OIDC_* 配置，没有配置 issuer 时返回 ErrOIDCNotConfigured。
+func oidcConfig() (oidc.Config, error) {
+	cfg := oidc.Config{
+		Issuer:       config.String("FILESTORE_OIDC_ISSUER", ""),
+		ClientID:     config.String("FILESTORE_OIDC_CLIENT_ID", ""),
+		ClientSecret: config.String("FILESTORE_OIDC_CLIENT_SECRET", ""),
+		RedirectURL:  config.String("FILESTORE_OIDC_REDIRECT_URL", ""),
+		Scopes:       strings.Fields(config.String("FILESTORE_OIDC_SCOPES", "openid email profile")),
+	}
+	if cfg.Issuer == "" || cfg.ClientID == "" {
+		return oidc.Config{}, ErrOIDCNotConfigured
+	}
+	return cfg, nil
+}
+
+// oidcProvider 返回身份提供方的客户端。发现文档在首次使用时读取并缓存，配置变化后重新读取；读取失败不缓存。
+func oidcProvider(ctx context.Context) (*oidc.Client, error) {
+	cfg, err := oidcConfig()
+	if err != nil {
+		return nil, err
+	}
+	key := strings.Join([]string{cfg.Issuer, cfg.ClientID, cfg.ClientSecret, cfg.RedirectURL, strings.Join(cfg.Scopes, " ")}, "\x00")
+
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	if oidcClient != nil && oidcKey == key {
+		return oidcClient, nil
+	}
+	client, err := oidc.NewClient(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	oidcKey, oidcClient = key, client
+	return client, nil
+}
+
+// BeginOIDCLogin 生成 state、nonce 和 PKCE 校验码，返回跳转到身份提供方登录的地址。
+func BeginOIDCLogin(ctx context.Context) (OIDCAuthRequest, error) {
+	client, err := oidcProvider(ctx)
+	if err != nil {
+		return OIDCAuthRequest{}, err
+	}
+	var req OIDCAuthRequest
+	for _, p := range []*string{&req.State, &req.Nonce, &req.Verifier} {
+		if *p, err = oidc.RandomString(32); err != nil {
+			return OIDCAuthRequest{}, err
+		}
+	}
+	req.URL = client.AuthCodeURL(req.State, req.Nonce, oidc.Challenge(req.Verifier))
+	return req, nil
+}
+
+// CompleteOIDCLogin 用回调中的授权码换取并校验 ID Token，返回外部身份对应的本地用户。
+// 首次登录时，邮箱已验证且恰好对应一个本地用户的身份关联到该用户，否则创建新用户；
+// 配置了 FILESTORE_OIDC_ROLE_MAP 时每次登录都按身份提供方的组同步角色。
+func CompleteOIDCLogin(ctx context.Context, code, verifier, nonce string) (dao.User, error) {
+	client, err := oidcProvider(ctx)
+	if err != nil {
+		return dao.User{}, err
+	}
+	if code == "" {
+		return dao.User{}, fmt.Errorf("%w: missing authorization code", ErrOIDCLogin)
+	}
+	tok, err := client.Exchange(ctx, code, verifier)
+	if err != nil {
+		return dao.User{}, fmt.Errorf("%w: %v", ErrOIDCLogin, err)
+	}
+	claims, err := client.VerifyIDToken(ctx, tok.IDToken, nonce)
+	if err != nil {
+		return dao.User{}, fmt.Errorf("%w: %v", ErrOIDCLogin, err)
+	}
+
+	// 未经身份提供方验证的邮箱不用于关联账户，也不写入新用户。
+	email := ""
+	if claims.EmailVerified {
+		email = claims.Email
+	}
+	role, syncRole := oidcRole(claims.StringList(config.String("FILESTORE_OIDC_GROUPS_CLAIM", "groups")))
+
+	username, err := resolveOIDCUser(ctx, claims, email, role)
+	if err != nil {
+		return dao.User{}, err
+	}
+	u, err := dao.GetUserByName(ctx, username)
+	if err != nil {
+		return dao.User{}, err
+	}
+	if err := checkUserEnabled(u); err != nil {
+		return dao.User{}, err
+	}
+	if syncRole && u.Role != role {
+		if err := dao.UpdateUserRole(ctx, username, role); err != nil {
+			return dao.User{}, err
+		}
+		audit(ctx, auditActorSystem, "user.role", username, "role="+role+" source=oidc")
+		u.Role = role
+	}
+	return u, nil
+}
+
+// resolveOIDCUser 返回外部身份关联的用户名，首次登录时关联或创建用户。
+func resolveOIDCUser(ctx context.Context, claims *oidc.Claims, email, role string) (string, error) {
+	username, err := dao.GetUserIdentity(ctx, claims.Issuer, claims.Subject)
+	if err == nil {
+		if err := dao.TouchUserIdentity(ctx, claims.Issuer, claims.Subject, email); err != nil {
+			log.Printf("failed to update identity of %s: %v", username, err)
+		}
+		return username, nil
+	}
+	if !errors.Is(err, dao.ErrIdentityNotFound) {
+		return "", err
+	}
+
+	if email != "" && config.Bool("FILESTORE_OIDC_LINK_BY_EMAIL", true) {
+		names, err := dao.GetUserNamesByVerifiedEmail(ctx, email)
+		if err != nil {
+			return "", err
+		}
+		if len(names) == 1 {
+			if err := dao.LinkUserIdentity(ctx, claims.Issuer, claims.Subject, names[0], email); err != nil {
+				if errors.Is(err, dao.ErrIdentityExists) {
+					return dao.GetUserIdentity(ctx, claims.Issuer, claims.Subject)
+				}
+				return "", err
+			}
+			audit(ctx, auditActorSystem, "user.oidc_linked", names[0], "issuer="+claims.Issuer)
+			return names[0], nil
+		}
+	}
+
+	if !config.Bool("FILESTORE_OIDC_AUTO_CREATE", true) {
+		return "", ErrOIDCNoAccount
+	}
+	return createOIDCUser(ctx, claims, email, role)
+}
+
+// createOIDCUser 以 preferred_username 或邮箱前缀为基础创建用户，名称不符合规则或已被占用时追加随机后缀。
+func createOIDCUser(ctx context.Context, claims *oidc.Claims, email, role string) (string, error) {
+	base := oidcBaseUsername(claims)
+	for i := 0; i < oidcUsernameAttempts; i++ {
+		name := base
+		if i > 0 || len(usernameViolations(name)) > 0 {
+			suffix := make([]byte, 3)
+			if _, err := rand.Read(suffix); err != nil {
+				return "", fmt.Errorf("failed to generate username: %w", err)
+			}
+			name = base + "_" + hex.EncodeToString(suffix)
+		}
+		if len(usernameViolations(name)) > 0 {
+			continue
+		}
+
+		err := dao.CreateUserWithIdentity(ctx, name, role, claims.Issuer, claims.Subject, email)
+		switch {
+		case err == nil:
+			audit(ctx, auditActorSystem, "user.oidc_created", name, "issuer="+claims.Issuer)
+			return name, nil
+		case errors.Is(err, dao.ErrIdentityExists):
+			// 同一身份的并发首次登录，另一个请求已经创建了用户。
+			return dao.GetUserIdentity(ctx, claims.Issuer, claims.Subject)
+		case err.Error() != "user already exists":
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("failed to choose a username for %s", claims.Subject)
+}
+
+// oidcBaseUsername 从声明中取用户名的基础部分，只保留用户名允许的字符。
+func oidcBaseUsername(claims *oidc.Claims) string {
+	raw := claims.PreferredUsername
+	if raw == "" {
+		raw, _, _ = strings.Cut(claims.Email, "@")
+	}
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			return r
+		case r == '_' || r == '.' || r == '-':
+			return r
+		}
+		return '_'
+	}, raw)
+	name = strings.TrimLeft(name, "_.-")
+	// 预留随机后缀的长度，使结果不超过 tbl_user.user_name 的 64 个字符。
+	if len(name) > 56 {
+		name = name[:56]
+	}
+	if name == "" {
+		name = "user"
+	}
+	return name
+}
+
+// oidcRole 按 FILESTORE_OIDC_ROLE_MAP（如 "fs-admins=admin,staff=user"）把身份提供方的组映射为角色，
+// 属于多个组时取权限最高的角色。没有配置映射时返回 false，不修改用户角色。
+func oidcRole(groups []string) (string, bool) {
+	v := config.String("FILESTORE_OIDC_ROLE_MAP", "")
+	if v == "" {
+		return dao.RoleUser, false
+	}
+	member := make(map[string]bool, len(groups))
+	for _, g := range groups {
+		member[g] = true
+	}
+	role := dao.RoleUser
+	for _, pair := range strings.Split(v, ",") {
+		group, mapped, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || !member[strings.TrimSpace(group)] {
+			continue
+		}
+		if strings.TrimSpace(mapped) == dao.RoleAdmin {
+			role = dao.RoleAdmin
+		}
+	}
+	return role, true
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"filestore-server/pkg/dao"
+	util "filestore-server/pkg/utils"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	// ErrFileTypeNotAllowed 表示用户所在组的规则不允许上传该类型。
+	ErrFileTypeNotAllowed = errors.New("file type not allowed")
+	// ErrInvalidMimePolicy 表示策略的类型模式或动作不合法。
+	ErrInvalidMimePolicy = errors.New("invalid mime policy")
+	// ErrInvalidUserGroup 表示用户组名为空。
+	ErrInvalidUserGroup = errors.New("invalid user group")
+)
+
+// ListMimePolicies 列出用户组的上传类型规则。
+func ListMimePolicies(ctx context.Context, group string) ([]dao.MimePolicy, error) {
+	return dao.GetMimePolicies(ctx, group)
+}
+
+// SetMimePolicy 新增或覆盖一条上传类型规则。
+func SetMimePolicy(ctx context.Context, p dao.MimePolicy) error {
+	p.GroupName = strings.TrimSpace(p.GroupName)
+	p.MimeType = util.BaseMimeType(p.MimeType)
+	p.Action = strings.ToLower(strings.TrimSpace(p.Action))
+	if p.GroupName == "" || !validMimePattern(p.MimeType) {
+		return ErrInvalidMimePolicy
+	}
+	if p.Action != dao.MimePolicyAllow && p.Action != dao.MimePolicyDeny {
+		return ErrInvalidMimePolicy
+	}
+	return dao.SaveMimePolicy(ctx, p)
+}
+
+// DeleteMimePolicy 删除一条上传类型规则。
+func DeleteMimePolicy(ctx context.Context, group, mimeType string) error {
+	return dao.DeleteMimePolicy(ctx, group, util.BaseMimeType(mimeType))
+}
+
+// SetUserGroup 修改用户所属的用户组。
+func SetUserGroup(ctx context.Context, username, group string) error {
+	group = strings.TrimSpace(group)
+	if group == "" {
+		return ErrInvalidUserGroup
+	}
+	return dao.UpdateUserGroup(ctx, username, group)
+}
+
+// UploadAllowed 按用户所在组的规则判断能否上传该类型：命中 deny 规则时拒绝；
+// 组内配置了 allow 规则时必须命中其中之一；没有任何规则时放行。
+func UploadAllowed(ctx context.Context, username, mimeType string) (bool, error) {
+	group := dao.DefaultUserGroup
+	if username != "" {
+		user, err := dao.GetUserByName(ctx, username)
+		if err != nil {
+			return false, err
+		}
+		group = user.GroupName
+	}
+	policies, err := dao.GetMimePolicies(ctx, group)
+	if err != nil {
+		return false, err
+	}
+
+	hasAllow, allowed := false, false
+	for _, p := range policies {
+		matched := util.MimeTypeMatches(p.MimeType, mimeType)
+		switch p.Action {
+		case dao.MimePolicyDeny:
+			if matched {
+				return false, nil
+			}
+		case dao.MimePolicyAllow:
+			hasAllow = true
+			allowed = allowed || matched
+		}
+	}
+	return !hasAllow || allowed, nil
+}
+
+// checkUploadPolicy 读取 r 开头的采样识别类型，username 所在组不允许上传该类型时返回 ErrFileTypeNotAllowed。
+// 返回的 reader 包含已读取的采样，调用方应使用它代替 r。
+func checkUploadPolicy(ctx context.Context, username, filename string, r io.Reader) (io.Reader, error) {
+	br := bufio.NewReaderSize(r, util.MimeSampleSize)
+	sample, err := br.Peek(util.MimeSampleSize)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if err := checkMimePolicy(ctx, username, util.DetectMimeType(filename, sample)); err != nil {
+		return nil, err
+	}
+	return br, nil
+}
+
+// checkMimePolicy 在 username 所在组不允许上传 mimeType 时返回 ErrFileTypeNotAllowed。
+func checkMimePolicy(ctx context.Context, username, mimeType string) error {
+	allowed, err := UploadAllowed(ctx, username, mimeType)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return fmt.Errorf("%w: %s", ErrFileTypeNotAllowed, mimeType)
+	}
+	return nil
+}
+
+// validMimePattern 接受 type/subtype、type/* 和 */*。
+func validMimePattern(pattern string) bool {
+	typ, sub, ok := strings.Cut(pattern, "/")
+	if !ok || typ == "" || sub == "" || strings.ContainsAny(pattern, " ;,") {
+		return false
+	}
+	return typ != "*" || sub == "*"
+}
@@ -0,0 +1,310 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"filestore-server/pkg/config"
+	"filestore-server/pkg/dao"
+	"filestore-server/pkg/notify"
+	"fmt"
+	"math/big"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxEmailLen       = 64
+	maxNicknameLen    = 64
+	maxBioLen         = 1024
+	maxLocationLen    = 128
+	maxWebsiteLen     = 256
+	verifyCodeDigits  = 6
+	maxVerifyAttempts = 5
+)
+
+var (
+	// ErrInvalidProfile 表示资料字段不合法。
+	ErrInvalidProfile = errors.New("invalid profile")
+	// ErrInvalidEmail 表示邮箱格式不合法。
+	ErrInvalidEmail = errors.New("invalid email")
+	// ErrInvalidPhone 表示手机号格式不合法。
+	ErrInvalidPhone = errors.New("invalid phone")
+	// ErrInvalidVerifyCode 表示验证码错误、已过期或错误次数过多。
+	ErrInvalidVerifyCode = errors.New("invalid or expired verify code")
+	// ErrVerifyCodeTooSoon 表示距离上次发送验证码的时间太短。
+	ErrVerifyCodeTooSoon = errors.New("verify code requested too frequently")
+	// ErrNothingToVerify 表示邮箱或手机号未设置或已经验证。
+	ErrNothingToVerify = errors.New("nothing to verify")
+)
+
+// phonePattern 接受可选 "+" 开头的 6 到 20 位数字，空格和短横线在校验前去掉。
+var phonePattern = regexp.MustCompile(`^\+?[0-9]{6,20}$`)
+
+// Profile 是用户可以自行编辑的资料，以 JSON 保存在 tbl_user.profile 中。
+type Profile struct {
+	Nickname string `json:"nickname"`
+	Bio      string `json:"bio"`
+	Location string `json:"location"`
+	Website  string `json:"website"`
+}
+
+// UserProfile 是用户查看自己资料时返回的信息。
+type UserProfile struct {
+	UserName      string  `json:"user_name"`
+	Email         string  `json:"email"`
+	EmailVerified bool    `json:"email_verified"`
+	Phone         string  `json:"phone"`
+	PhoneVerified bool    `json:"phone_verified"`
+	Role          string  `json:"role"`
+	SignupAt      string  `json:"signup_at"`
+	Profile       Profile `json:"profile"`
+}
+
+// GetUserProfile 返回用户的资料和联系方式。
+func GetUserProfile(ctx context.Context, username string) (UserProfile, error) {
+	u, err := dao.GetUserByName(ctx, username)
+	if err != nil {
+		return UserProfile{}, err
+	}
+	p := UserProfile{
+		UserName:      u.UserName,
+		Email:         u.Email,
+		EmailVerified: u.EmailValid,
+		Phone:         u.Phone,
+		PhoneVerified: u.PhoneValid,
+		Role:          u.Role,
+	}
+	if u.SignupAt.Valid {
+		p.SignupAt = u.SignupAt.Time.Format("2006-01-02 15:04:05")
+	}
+	if u.Profile.Valid && u.Profile.String != "" {
+		// 无法解析的旧数据按空资料处理，下次保存时覆盖。
+		json.Unmarshal([]byte(u.Profile.String), &p.Profile)
+	}
+	return p, nil
+}
+
+// UpdateProfile 保存用户资料，整体覆盖原有内容。
+func UpdateProfile(ctx context.Context, username string, p Profile) error {
+	p.Nickname = strings.TrimSpace(p.Nickname)
+	p.Bio = strings.TrimSpace(p.Bio)
+	p.Location = strings.TrimSpace(p.Location)
+	p.Website = strings.TrimSpace(p.Website)
+	for _, f := range []struct {
+		name  string
+		value string
+		max   int
+	}{
+		{"nickname", p.Nickname, maxNicknameLen},
+		{"bio", p.Bio, maxBioLen},
+		{"location", p.Location, maxLocationLen},
+		{"website", p.Website, maxWebsiteLen},
+	} {
+		if utf8.RuneCountInString(f.value) > f.max || !utf8.ValidString(f.value) {
+			return fmt.Errorf("%w: %s is too long", ErrInvalidProfile, f.name)
+		}
+		if f.name != "bio" && strings.ContainsFunc(f.value, isControlRune) {
+			return fmt.Errorf("%w: %s contains control characters", ErrInvalidProfile, f.name)
+		}
+	}
+	if p.Website != "" {
+		u, err := url.Parse(p.Website)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w: website must be an http(s) url", ErrInvalidProfile)
+		}
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("failed to encode profile: %w", err)
+	}
+	return dao.UpdateUserProfile(ctx, username, string(data))
+}
+
+func isControlRune(r rune) bool {
+	return r < 0x20 || r == 0x7f
+}
+
+// SetUserEmail 修改用户邮箱并向新邮箱发送验证码，邮箱在验证前保持未验证状态。
+func SetUserEmail(ctx context.Context, username, email string) error {
+	email = strings.TrimSpace(email)
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" || len(email) > maxEmailLen {
+		return ErrInvalidEmail
+	}
+	if err := checkVerifyResend(ctx, username, notify.ChannelEmail); err != nil {
+		return err
+	}
+	if err := dao.UpdateUserEmail(ctx, username, email); err != nil {
+		return err
+	}
+	audit(ctx, username, "user.email", username, "email="+email)
+	return sendVerifyCode(ctx, username, notify.ChannelEmail, email)
+}
+
+// SetUserPhone 修改用户手机号并通过短信发送验证码。
+func SetUserPhone(ctx context.Context, username, phone string) error {
+	phone = strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(phone))
+	if !phonePattern.MatchString(phone) {
+		return ErrInvalidPhone
+	}
+	if err := checkVerifyResend(ctx, username, notify.ChannelSMS); err != nil {
+		return err
+	}
+	if err := dao.UpdateUserPhone(ctx, username, phone); err != nil {
+		return err
+	}
+	audit(ctx, username, "user.phone", username, "phone="+phone)
+	return sendVerifyCode(ctx, username, notify.ChannelSMS, phone)
+}
+
+// ResendVerifyCode 向尚未验证的邮箱（email）或手机号（sms）重新发送验证码。
+func ResendVerifyCode(ctx context.Context, username, channel string) error {
+	u, err := dao.GetUserByName(ctx, username)
+	if err != nil {
+		return err
+	}
+	target, verified := contactOf(u, channel)
+	if target == "" || verified {
+		return ErrNothingToVerify
+	}
+	if err := checkVerifyResend(ctx, username, channel); err != nil {
+		return err
+	}
+	return sendVerifyCode(ctx, username, channel, target)
+}
+
+// VerifyContact 校验邮箱（email）或手机号（sms）的验证码，成功后把对应联系方式标记为已验证。
+// 验证码一次有效，错误次数达到上限后作废。
+func VerifyContact(ctx context.Context, username, channel, code string) error {
+	if channel != notify.ChannelEmail && channel != notify.ChannelSMS {
+		return ErrNothingToVerify
+	}
+	vc, err := dao.GetVerifyCode(ctx, username, channel)
+	if err != nil {
+		if errors.Is(err, dao.ErrVerifyCodeNotFound) {
+			return ErrInvalidVerifyCode
+		}
+		return err
+	}
+	if time.Now().After(vc.ExpireAt) {
+		dao.DeleteVerifyCode(ctx, username, channel)
+		return ErrInvalidVerifyCode
+	}
+	// 先占用一次尝试机会再比对，并发的猜测请求也不会超过上限。
+	ok, err := dao.IncrVerifyCodeAttempts(ctx, username, channel, vc.CodeHash, maxVerifyAttempts)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		// 次数已用完，或验证码已被重新发送的新验证码替换。
+		return ErrInvalidVerifyCode
+	}
+	if subtle.ConstantTimeCompare([]byte(hashVerifyCode(username, channel, strings.TrimSpace(code))), []byte(vc.CodeHash)) != 1 {
+		return ErrInvalidVerifyCode
+	}
+	if err := dao.DeleteVerifyCode(ctx, username, channel); err != nil {
+		return err
+	}
+
+	if channel == notify.ChannelEmail {
+		ok, err = dao.MarkUserEmailValidated(ctx, username, vc.Target)
+	} else {
+		ok, err = dao.MarkUserPhoneValidated(ctx, username, vc.Target)
+	}
+	if err != nil {
+		return err
+	}
+	if !ok {
+		// 验证码发出后联系方式又被修改，旧验证码不能验证新地址。
+		return ErrInvalidVerifyCode
+	}
+	audit(ctx, username, "user.verify", username, channel+"="+vc.Target)
+	return nil
+}
+
+// contactOf 返回用户在渠道上的联系方式及是否已验证。
+func contactOf(u dao.User, channel string) (string, bool) {
+	switch channel {
+	case notify.ChannelEmail:
+		return u.Email, u.EmailValid
+	case notify.ChannelSMS:
+		return u.Phone, u.PhoneValid
+	}
+	return "", false
+}
+
+// checkVerifyResend 限制同一渠道发送验证码的频率。
+func checkVerifyResend(ctx context.Context, username, channel string) error {
+	vc, err := dao.GetVerifyCode(ctx, username, channel)
+	if err != nil {
+		if errors.Is(err, dao.ErrVerifyCodeNotFound) {
+			return nil
+		}
+		return err
+	}
+	if time.Since(vc.CreateAt) < config.Duration("FILESTORE_VERIFY_RESEND_INTERVAL", time.Minute) {
+		return ErrVerifyCodeTooSoon
+	}
+	return nil
+}
+
+// sendVerifyCode 生成验证码，保存其哈希并发送给 target。
+func sendVerifyCode(ctx context.Context, username, channel, target string) error {
+	code, err := randomDigits(verifyCodeDigits)
+	if err != nil {
+		return err
+	}
+	ttl := config.Duration("FILESTORE_VERIFY_CODE_TTL", 10*time.Minute)
+	now := time.Now()
+	err = dao.SaveVerifyCode(ctx, dao.VerifyCode{
+		UserName: username,
+		Channel:  channel,
+		Target:   target,
+		CodeHash: hashVerifyCode(username, channel, code),
+		ExpireAt: now.Add(ttl),
+		CreateAt: now,
+	})
+	if err != nil {
+		return err
+	}
+
+	msg := notify.Message{
+		Channel: channel,
+		To:      target,
+		Subject: "Your verification code",
+		Body:    fmt.Sprintf("Your verification code is %s. It expires in %d minutes.", code, int(ttl.Minutes())),
+	}
+	if err := sendNotification(ctx, msg); err != nil {
+		return fmt.Errorf("failed to send verify code: %w", err)
+	}
+	return nil
+}
+
+// hashVerifyCode 把验证码和用户、渠道绑定后取哈希，数据库泄露时不能直接得到验证码。
+func hashVerifyCode(username, channel, code string) string {
+	sum := sha256.Sum256([]byte(username + "\x00" + channel + "\x00" + code))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomDigits 返回 n 位随机数字。
+func randomDigits(n int) (string, error) {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		d, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", fmt.Errorf("failed to generate code: %w", err)
+		}
+		b.WriteByte(byte('0' + d.Int64()))
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"filestore-server/pkg/config"
+	"filestore-server/pkg/dao"
+	"filestore-server/pkg/queue"
+	"filestore-server/pkg/scan"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// auditActorSystem 是系统自动执行的操作在审计日志中的操作者。
+	auditActorSystem = "system"
+	scanTimeout      = 10 * time.Minute
+)
+
+var (
+	// ErrFileNotScanned 表示文件还在等待病毒扫描，暂不能下载。
+	ErrFileNotScanned = errors.New("file is pending malware scan")
+	// ErrFileInfected 表示文件被判定为感染，已被隔离。
+	ErrFileInfected = errors.New("file is infected")
+)
+
+var (
+	scannerMu     sync.Mutex
+	scannerLoaded bool
+	scanner       scan.Scanner
+)
+
+// fileScanner 按配置返回扫描器；未配置 FILESTORE_CLAMD_ADDR 时返回 nil，表示不扫描。
+func fileScanner() scan.Scanner {
+	scannerMu.Lock()
+	defer scannerMu.Unlock()
+	if !scannerLoaded {
+		if addr := config.String("FILESTORE_CLAMD_ADDR", ""); addr != "" {
+			scanner = scan.NewClamdScanner(addr, config.Duration("FILESTORE_CLAMD_TIMEOUT", 10*time.Second))
+		}
+		scannerLoaded = true
+	}
+	return scanner
+}
+
+// SetScanner 替换扫描器，传入 nil 表示关闭扫描。
+func SetScanner(s scan.Scanner) {
+	scannerMu.Lock()
+	defer scannerMu.Unlock()
+	scanner, scannerLoaded = s, true
+}
+
+func scanEnabled() bool {
+	return fileScanner() != nil
+}
+
+// checkScanState 只放行扫描通过的文件。
+func checkScanState(fmeta dao.FileMeta) error {
+	switch fmeta.ScanState {
+	case dao.ScanPending:
+		return ErrFileNotScanned
+	case dao.ScanInfected:
+		return ErrFileInfected
+	}
+	return nil
+}
+
+// scanJob 扫描新上传的文件，通过时放行下载，感染时隔离内容并写入审计日志。
+// 扫描器不可用时返回错误由队列不限次数地重试，期间文件保持待扫描状态；内容已不存在时不再重试。
+func scanJob(ctx context.Context, job *queue.Job) error {
+	var p fileJobPayload
+	if err := job.Decode(&p); err != nil {
+		return queue.Permanent(err)
+	}
+	fmeta, err := dao.GetFileMeta(ctx, p.FileSha1)
+	if err != nil {
+		return err
+	}
+	if fmeta.ScanState != dao.ScanPending {
+		return nil
+	}
+	s := fileScanner()
+	if s == nil {
+		return queue.Permanent(fmt.Errorf("scanner not configured"))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, scanTimeout)
+	defer cancel()
+
+	r, err := openBlob(ctx, fmeta)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return queue.Permanent(err)
+		}
+		return err
+	}
+	result, err := s.Scan(ctx, r)
+	r.Close()
+	if err != nil {
+		return err
+	}
+
+	if !result.Infected {
+		return dao.UpdateFileScanState(ctx, fmeta.FileSha1, dao.ScanPending, dao.ScanClean)
+	}
+
+	if err := dao.UpdateFileScanState(ctx, fmeta.FileSha1, dao.ScanPending, dao.ScanInfected); err != nil {
+		return err
+	}
+	detail := "signature=" + result.Signature
+	if err := quarantineBlob(ctx, fmeta); err != nil {
+		// 状态已标记为感染，下载已被阻止；隔离失败只记录下来，由人工处理。
+		log.Printf("failed to quarantine infected file %s: %v", fmeta.FileSha1, err)
+		detail += " quarantine_error=" + err.Error()
+	}
+	removeThumbnails(ctx, fmeta.FileSha1)
+	unindexFile(fmeta.FileSha1)
+	audit(ctx, auditActorSystem, "file.infected", fmeta.FileSha1, detail)
+	return nil
+}
+
+// quarantineBlob 把文件的落盘数据移入隔离目录；已转存的文件会先下载到隔离目录再删除远端对象。
+func quarantineBlob(ctx context.Context, fmeta dao.FileMeta) error {
+	if fmeta.StoreState != dao.StoreRemote {
+		return discardBlob(fmeta.Location, true)
+	}
+
+	src, err := openRaw(ctx, fmeta)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := os.MkdirAll(quarantineDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create quarantine dir: %w", err)
+	}
+	target := filepath.Join(quarantineDir, fmeta.FileSha1+"."+time.Now().Format("20060102150405"))
+	dst, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(target)
+		return err
+	}
+
+	store, err := remoteStore(ctx)
+	if err != nil {
+		return err
+	}
+	return store.Delete(ctx, fmeta.FileSha1)
+}
+
+// audit 写入审计日志，失败只记录到进程日志。
+func audit(ctx context.Context, actor, action, target, detail string) {
+	err := dao.InsertAuditLog(ctx, dao.AuditLog{Actor: actor, Action: action, Target: target, Detail: detail})
+	if err != nil {
+		log.Printf("failed to write audit log %s %s: %v", action, target, err)
+	}
+}
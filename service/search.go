@@ -0,0 +1,297 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"filestore-server/pkg/config"
+	"filestore-server/pkg/dao"
+	"filestore-server/pkg/queue"
+	"filestore-server/pkg/search"
+	util "filestore-server/pkg/utils"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ledongthuc/pdf"
+)
+
+const (
+	// maxIndexedText 是每个文件最多索引的正文字节数。
+	maxIndexedText = 1 << 20
+	// maxIndexedPDF 是参与提取正文的 PDF 文件大小上限。
+	maxIndexedPDF = 64 << 20
+)
+
+// ErrInvalidSearch 表示搜索条件不合法。
+var ErrInvalidSearch = errors.New("invalid search")
+
+// SearchOptions 是搜索用户文件的条件，零值字段表示不限。
+type SearchOptions struct {
+	// Name 为文件名子串；包含 * 或 ? 时按通配符匹配整个文件名。
+	Name    string
+	MinSize int64
+	MaxSize int64
+	From    time.Time
+	To      time.Time
+	// MimeType 为精确类型或 type/*。
+	MimeType string
+	// Tags 非空时只返回同时带有这些标签的文件。
+	Tags []string
+	// Query 非空时在文本和 PDF 文件的正文中全文检索，结果按相关度排序。
+	Query  string
+	Limit  int
+	Offset int
+}
+
+var (
+	searchOnce sync.Once
+	searchIdx  *search.Index
+)
+
+// fullTextIndex 打开全文索引；关闭全文检索或索引打不开时返回 nil。
+func fullTextIndex() *search.Index {
+	searchOnce.Do(func() {
+		if !config.Bool("FILESTORE_SEARCH_FULLTEXT", true) {
+			return
+		}
+		idx, err := search.Open(config.String("FILESTORE_SEARCH_INDEX", "./search.bleve"))
+		if err != nil {
+			log.Printf("full-text search disabled: %v", err)
+			return
+		}
+		searchIdx = idx
+	})
+	return searchIdx
+}
+
+func fullTextEnabled() bool {
+	return fullTextIndex() != nil
+}
+
+// SearchUserFiles 按文件名、大小、上传时间、类型以及正文搜索用户的文件，返回当前页和总数。
+func SearchUserFiles(ctx context.Context, username string, opts SearchOptions) ([]dao.FileMeta, int, error) {
+	if opts.MinSize < 0 || opts.MaxSize < 0 || (opts.MaxSize > 0 && opts.MinSize > opts.MaxSize) {
+		return nil, 0, ErrInvalidSearch
+	}
+	if !opts.From.IsZero() && !opts.To.IsZero() && !opts.From.Before(opts.To) {
+		return nil, 0, ErrInvalidSearch
+	}
+	if opts.MimeType != "" && !validMimePattern(util.BaseMimeType(opts.MimeType)) {
+		return nil, 0, ErrInvalidSearch
+	}
+	tags, err := NormalizeTags(opts.Tags)
+	if err != nil {
+		return nil, 0, ErrInvalidSearch
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = defaultListLimit
+	}
+	if opts.Limit > maxListLimit {
+		opts.Limit = maxListLimit
+	}
+	if opts.Offset < 0 {
+		opts.Offset = 0
+	}
+
+	filter := dao.FileFilter{
+		NameLike: nameLikePattern(strings.TrimSpace(opts.Name)),
+		MinSize:  opts.MinSize,
+		MaxSize:  opts.MaxSize,
+		From:     opts.From,
+		To:       opts.To,
+		MimeType: util.BaseMimeType(opts.MimeType),
+		Tags:     tags,
+		Limit:    opts.Limit,
+		Offset:   opts.Offset,
+	}
+	var (
+		files []dao.FileMeta
+		total int
+	)
+	if q := strings.TrimSpace(opts.Query); q != "" {
+		files, total, err = searchFullText(ctx, username, q, filter)
+	} else {
+		files, total, err = dao.SearchUserFiles(ctx, username, filter)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search user files: %w", err)
+	}
+	if err := attachTags(ctx, username, files); err != nil {
+		return nil, 0, fmt.Errorf("failed to get file tags: %w", err)
+	}
+	return files, total, nil
+}
+
+// searchFullText 先在数据库中找出用户满足其他条件的全部文件，再只在这些文件中全文检索并按相关度分页，
+// 因此其他用户的文件不会占用命中数，返回的总数也只包含用户自己的文件。
+func searchFullText(ctx context.Context, username, text string, filter dao.FileFilter) ([]dao.FileMeta, int, error) {
+	idx := fullTextIndex()
+	if idx == nil {
+		return nil, 0, fmt.Errorf("full-text search is disabled")
+	}
+	candidates, err := dao.SearchUserFileHashes(ctx, username, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	hits, total, err := idx.Search(text, candidates, filter.Offset, filter.Limit)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	filter.Hashes = hits
+	if filter.Hashes == nil {
+		filter.Hashes = []string{}
+	}
+	filter.Offset = 0
+	files, _, err := dao.SearchUserFiles(ctx, username, filter)
+	return files, total, err
+}
+
+// nameLikePattern 把文件名条件转换为 LIKE 模式：通配符 * ? 分别对应 % _，否则按子串匹配。
+func nameLikePattern(name string) string {
+	if name == "" {
+		return ""
+	}
+	escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(name)
+	if !strings.ContainsAny(name, "*?") {
+		return "%" + escaped + "%"
+	}
+	return strings.NewReplacer("*", "%", "?", "_").Replace(escaped)
+}
+
+// indexJob 提取新文件的正文写入全文索引，不支持的类型直接跳过。
+// 索引以内容 sha1 为 id，文件名和归属在查询时由数据库过滤，因此重命名不需要更新索引。
+func indexJob(ctx context.Context, job *queue.Job) error {
+	var p fileJobPayload
+	if err := job.Decode(&p); err != nil {
+		return queue.Permanent(err)
+	}
+	fmeta, err := dao.GetFileMeta(ctx, p.FileSha1)
+	if err != nil {
+		return err
+	}
+	return indexFile(ctx, fmeta)
+}
+
+func indexFile(ctx context.Context, fmeta dao.FileMeta) error {
+	idx := fullTextIndex()
+	if idx == nil {
+		return nil
+	}
+	text, err := extractText(ctx, fmeta)
+	if err != nil || text == "" {
+		return err
+	}
+	return idx.Put(fmeta.FileSha1, text)
+}
+
+// unindexFile 把被删除的文件移出全文索引，失败只记录日志。
+func unindexFile(filehash string) {
+	idx := fullTextIndex()
+	if idx == nil {
+		return
+	}
+	if err := idx.Delete(filehash); err != nil {
+		log.Printf("failed to remove %s from search index: %v", filehash, err)
+	}
+}
+
+// ReindexAll 为所有文件重建全文索引，用于开启全文检索之前已上传的文件。返回写入索引的文件数。
+func ReindexAll(ctx context.Context) (int, error) {
+	if fullTextIndex() == nil {
+		return 0, fmt.Errorf("full-text search is disabled")
+	}
+	indexed := 0
+	var afterID int64
+	for {
+		metas, lastID, err := dao.ListFileMetas(ctx, afterID, fsckBatchSize)
+		if err != nil {
+			return indexed, err
+		}
+		if len(metas) == 0 {
+			return indexed, nil
+		}
+		afterID = lastID
+
+		for _, fmeta := range metas {
+			if !textIndexable(fmeta.MimeType) || fmeta.ScanState == dao.ScanInfected {
+				continue
+			}
+			if err := indexFile(ctx, fmeta); err != nil {
+				log.Printf("failed to index %s: %v", fmeta.FileSha1, err)
+				continue
+			}
+			indexed++
+		}
+	}
+}
+
+// textIndexable 判断该类型的文件是否提取正文。
+func textIndexable(mimeType string) bool {
+	base := util.BaseMimeType(mimeType)
+	switch base {
+	case "application/pdf", "application/json", "application/xml":
+		return true
+	}
+	return strings.HasPrefix(base, "text/")
+}
+
+// extractText 提取文本文件或 PDF 的正文，最多 maxIndexedText 字节；其他类型返回空字符串。
+func extractText(ctx context.Context, fmeta dao.FileMeta) (string, error) {
+	if !textIndexable(fmeta.MimeType) {
+		return "", nil
+	}
+	if util.BaseMimeType(fmeta.MimeType) != "application/pdf" {
+		r, err := openBlob(ctx, fmeta)
+		if err != nil {
+			return "", err
+		}
+		defer r.Close()
+		data, err := io.ReadAll(io.LimitReader(r, maxIndexedText))
+		return string(data), err
+	}
+
+	if fmeta.FileSize > maxIndexedPDF {
+		return "", nil
+	}
+	// PDF 需要随机读取，先把明文落到临时文件。
+	tmp, err := os.CreateTemp("", "filestore-index-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+	if err := copyBlob(ctx, tmp, fmeta); err != nil {
+		return "", err
+	}
+	return pdfText(tmp, fmeta.FileSize)
+}
+
+// pdfText 提取 PDF 的纯文本；损坏的 PDF 不算错误，只是没有正文可索引。
+func pdfText(r io.ReaderAt, size int64) (text string, err error) {
+	defer func() {
+		// pdf 库在遇到畸形文件时可能 panic。
+		if p := recover(); p != nil {
+			text, err = "", nil
+		}
+	}()
+	reader, err := pdf.NewReader(r, size)
+	if err != nil {
+		return "", nil
+	}
+	plain, err := reader.GetPlainText()
+	if err != nil {
+		return "", nil
+	}
+	data, err := io.ReadAll(io.LimitReader(plain, maxIndexedText))
+	if err != nil {
+		return "", nil
+	}
+	return string(data), nil
+}
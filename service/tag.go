@@ -0,0 +1,199 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"filestore-server/pkg/dao"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// MaxTagsPerFile 是单个文件最多的标签数。
+	MaxTagsPerFile = 50
+	// MaxAttrsPerFile 是单个文件最多的自定义属性数。
+	MaxAttrsPerFile = 50
+
+	maxTagLen       = 64
+	maxAttrKeyLen   = 64
+	maxAttrValueLen = 1024
+)
+
+var (
+	// ErrInvalidTag 表示标签为空、过长或包含不允许的字符。
+	ErrInvalidTag = errors.New("invalid tag")
+	// ErrTooManyTags 表示操作后文件的标签数会超过 MaxTagsPerFile。
+	ErrTooManyTags = errors.New("too many tags")
+	// ErrInvalidAttr 表示自定义属性的键或值不合法。
+	ErrInvalidAttr = errors.New("invalid attr")
+	// ErrTooManyAttrs 表示文件的自定义属性数会超过 MaxAttrsPerFile。
+	ErrTooManyAttrs = errors.New("too many attrs")
+)
+
+// NormalizeTags 校验标签并统一为小写、去重；标签不能包含逗号和控制字符。
+func NormalizeTags(raw []string) ([]string, error) {
+	seen := make(map[string]bool, len(raw))
+	tags := make([]string, 0, len(raw))
+	for _, tag := range raw {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || utf8.RuneCountInString(tag) > maxTagLen || !utf8.ValidString(tag) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidTag, tag)
+		}
+		if strings.ContainsFunc(tag, func(r rune) bool { return r == ',' || unicode.IsControl(r) }) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidTag, tag)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+// TagFiles 给用户的多个文件批量打上标签，文件必须都属于 username。
+func TagFiles(ctx context.Context, username string, filehashes, tags []string) error {
+	tags, err := NormalizeTags(tags)
+	if err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		return ErrInvalidTag
+	}
+	if err := checkFilesOwned(ctx, username, filehashes); err != nil {
+		return err
+	}
+
+	existing, err := dao.GetUserFileTags(ctx, username, filehashes)
+	if err != nil {
+		return err
+	}
+	for _, h := range filehashes {
+		merged := make(map[string]bool, len(existing[h])+len(tags))
+		for _, tag := range existing[h] {
+			merged[tag] = true
+		}
+		for _, tag := range tags {
+			merged[tag] = true
+		}
+		if len(merged) > MaxTagsPerFile {
+			return fmt.Errorf("%w: %s", ErrTooManyTags, h)
+		}
+	}
+	return dao.AddUserFileTags(ctx, username, filehashes, tags)
+}
+
+// UntagFiles 从用户的多个文件上批量去掉标签，文件没有该标签时忽略。
+func UntagFiles(ctx context.Context, username string, filehashes, tags []string) error {
+	tags, err := NormalizeTags(tags)
+	if err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		return ErrInvalidTag
+	}
+	if err := checkFilesOwned(ctx, username, filehashes); err != nil {
+		return err
+	}
+	return dao.RemoveUserFileTags(ctx, username, filehashes, tags)
+}
+
+// ListUserTags 返回用户的全部标签及各自的文件数。
+func ListUserTags(ctx context.Context, username string) ([]dao.TagCount, error) {
+	return dao.GetUserTags(ctx, username)
+}
+
+// SetFileAttr 设置用户文件的自定义属性；键只能包含字母、数字和 . _ -。
+func SetFileAttr(ctx context.Context, username, filehash, key, value string) error {
+	if !validAttrKey(key) || len(value) > maxAttrValueLen || !utf8.ValidString(value) {
+		return ErrInvalidAttr
+	}
+	if err := checkFilesOwned(ctx, username, []string{filehash}); err != nil {
+		return err
+	}
+
+	attrs, err := dao.GetUserFileAttrs(ctx, username, filehash)
+	if err != nil {
+		return err
+	}
+	if _, ok := attrs[key]; !ok && len(attrs) >= MaxAttrsPerFile {
+		return ErrTooManyAttrs
+	}
+	return dao.SetUserFileAttr(ctx, username, filehash, key, value)
+}
+
+// DeleteFileAttr 删除用户文件的自定义属性。
+func DeleteFileAttr(ctx context.Context, username, filehash, key string) error {
+	if !validAttrKey(key) {
+		return ErrInvalidAttr
+	}
+	if err := checkFilesOwned(ctx, username, []string{filehash}); err != nil {
+		return err
+	}
+	return dao.DeleteUserFileAttr(ctx, username, filehash, key)
+}
+
+// GetUserFileMeta 返回 owner 空间中 username 可查看的文件的元信息，附带该空间设置的标签和自定义属性。
+func GetUserFileMeta(ctx context.Context, username, owner, filehash string) (dao.FileMeta, error) {
+	if _, err := authorizeFileIn(ctx, username, owner, filehash, PermRead); err != nil {
+		return dao.FileMeta{}, err
+	}
+	fmeta, err := dao.GetFileMeta(ctx, filehash)
+	if err != nil {
+		return dao.FileMeta{}, err
+	}
+
+	tags, err := dao.GetUserFileTags(ctx, owner, []string{filehash})
+	if err != nil {
+		return dao.FileMeta{}, err
+	}
+	fmeta.Tags = tags[filehash]
+	if fmeta.Attrs, err = dao.GetUserFileAttrs(ctx, owner, filehash); err != nil {
+		return dao.FileMeta{}, err
+	}
+	return fmeta, nil
+}
+
+// attachTags 为列表中的文件填充 username 设置的标签。
+func attachTags(ctx context.Context, username string, files []dao.FileMeta) error {
+	if len(files) == 0 {
+		return nil
+	}
+	hashes := make([]string, 0, len(files))
+	for _, f := range files {
+		hashes = append(hashes, f.FileSha1)
+	}
+	tags, err := dao.GetUserFileTags(ctx, username, hashes)
+	if err != nil {
+		return err
+	}
+	for i := range files {
+		files[i].Tags = tags[files[i].FileSha1]
+	}
+	return nil
+}
+
+// checkFilesOwned 确认 filehashes 都是 username 的有效文件。
+func checkFilesOwned(ctx context.Context, username string, filehashes []string) error {
+	owned, err := dao.GetUserFilesByHashes(ctx, username, filehashes)
+	if err != nil {
+		return err
+	}
+	if len(owned) != len(filehashes) {
+		return ErrFilesNotOwned
+	}
+	return nil
+}
+
+func validAttrKey(key string) bool {
+	if key == "" || len(key) > maxAttrKeyLen {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
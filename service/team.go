@@ -0,0 +1,291 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"filestore-server/pkg/dao"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 团队成员的角色，权限依次递减。
+const (
+	TeamRoleOwner  = "owner"
+	TeamRoleAdmin  = "admin"
+	TeamRoleEditor = "editor"
+	TeamRoleViewer = "viewer"
+
+	// teamNamespacePrefix 是团队文件在 tbl_user_file 中使用的所有者前缀，完整形式为 "team:<id>"。
+	teamNamespacePrefix = "team:"
+	maxTeamNameLen      = 64
+)
+
+// Permission 是对文件空间的操作权限。
+type Permission int
+
+const (
+	// PermNone 表示没有任何权限。
+	PermNone Permission = iota
+	// PermRead 允许查看、下载文件，viewer 及以上角色拥有。
+	PermRead
+	// PermWrite 允许上传、修改、删除文件，editor 及以上角色拥有。
+	PermWrite
+	// PermManage 允许管理成员和邀请，admin 及以上角色拥有。
+	PermManage
+	// PermOwn 只有 owner 拥有，可以授予或收回 owner 角色。
+	PermOwn
+)
+
+var teamRolePerm = map[string]Permission{
+	TeamRoleViewer: PermRead,
+	TeamRoleEditor: PermWrite,
+	TeamRoleAdmin:  PermManage,
+	TeamRoleOwner:  PermOwn,
+}
+
+var (
+	// ErrForbidden 表示调用者在团队中的角色不足以执行该操作。
+	ErrForbidden = errors.New("permission denied")
+	// ErrInvalidTeamName 表示团队名称为空、过长或包含控制字符。
+	ErrInvalidTeamName = errors.New("invalid team name")
+	// ErrInvalidTeamRole 表示角色不是 owner、admin、editor、viewer 之一。
+	ErrInvalidTeamRole = errors.New("invalid team role")
+	// ErrLastTeamOwner 表示操作会使团队没有 owner。
+	ErrLastTeamOwner = errors.New("team must keep at least one owner")
+	// ErrAlreadyInvited 表示用户已有该团队尚未处理的邀请。
+	ErrAlreadyInvited = errors.New("user already invited")
+)
+
+// TeamNamespace 返回团队文件在文件表中的所有者名称。
+func TeamNamespace(teamID int64) string {
+	return teamNamespacePrefix + strconv.FormatInt(teamID, 10)
+}
+
+// teamIDFromNamespace 解析 "team:<id>" 形式的所有者名称，不是团队空间时返回 false。
+func teamIDFromNamespace(owner string) (int64, bool) {
+	rest, ok := strings.CutPrefix(owner, teamNamespacePrefix)
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(rest, 10, 64)
+	return id, err == nil && id > 0
+}
+
+// ValidTeamRole 判断 role 是否为有效的团队角色。
+func ValidTeamRole(role string) bool {
+	_, ok := teamRolePerm[role]
+	return ok
+}
+
+// teamRole 返回用户在团队中的角色，不是成员时返回 dao.ErrTeamNotFound，避免暴露团队是否存在。
+func teamRole(ctx context.Context, username string, teamID int64) (string, error) {
+	role, err := dao.GetTeamMemberRole(ctx, teamID, username)
+	if errors.Is(err, dao.ErrTeamMemberNotFound) {
+		return "", dao.ErrTeamNotFound
+	}
+	return role, err
+}
+
+// ResolveOwner 返回本次操作的文件空间：teamID 为 0 时为用户本人，否则为团队空间，
+// 此时用户在团队中的角色须具有 perm 权限。
+func ResolveOwner(ctx context.Context, username string, teamID int64, perm Permission) (string, error) {
+	if teamID == 0 {
+		return username, nil
+	}
+	role, err := teamRole(ctx, username, teamID)
+	if err != nil {
+		return "", err
+	}
+	if teamRolePerm[role] < perm {
+		return "", ErrForbidden
+	}
+	return TeamNamespace(teamID), nil
+}
+
+// CreateTeam 创建团队，创建者成为 owner。
+func CreateTeam(ctx context.Context, username, name string) (dao.Team, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxTeamNameLen || strings.ContainsFunc(name, unicode.IsControl) {
+		return dao.Team{}, ErrInvalidTeamName
+	}
+	id, err := dao.CreateTeam(ctx, name, username, TeamRoleOwner)
+	if err != nil {
+		return dao.Team{}, err
+	}
+	audit(ctx, username, "team.create", TeamNamespace(id), "name="+name)
+	return dao.Team{ID: id, Name: name, Role: TeamRoleOwner}, nil
+}
+
+// ListTeams 列出用户加入的团队及其角色。
+func ListTeams(ctx context.Context, username string) ([]dao.Team, error) {
+	return dao.GetUserTeams(ctx, username)
+}
+
+// ListTeamMembers 列出团队成员，团队的任何成员都可以查看。
+func ListTeamMembers(ctx context.Context, username string, teamID int64) ([]dao.TeamMember, error) {
+	if _, err := ResolveOwner(ctx, username, teamID, PermRead); err != nil {
+		return nil, err
+	}
+	return dao.GetTeamMembers(ctx, teamID)
+}
+
+// checkRoleGrant 确认 actor 的角色可以授予 role：owner 可以授予任何角色，admin 只能授予比自己低的角色。
+func checkRoleGrant(actorRole, role string) error {
+	if !ValidTeamRole(role) {
+		return ErrInvalidTeamRole
+	}
+	return checkManageMember(actorRole, role)
+}
+
+// InviteTeamMember 邀请用户以 role 角色加入团队，需要 admin 及以上角色。返回邀请 ID。
+func InviteTeamMember(ctx context.Context, inviter string, teamID int64, invitee, role string) (int64, error) {
+	actorRole, err := teamRole(ctx, inviter, teamID)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkRoleGrant(actorRole, role); err != nil {
+		return 0, err
+	}
+
+	invitee = strings.TrimSpace(invitee)
+	if invitee == "" || invitee == inviter {
+		return 0, ErrInvalidRecipient
+	}
+	if _, err := dao.GetUserByName(ctx, invitee); err != nil {
+		if err.Error() == "user not found" {
+			return 0, ErrInvalidRecipient
+		}
+		return 0, err
+	}
+	if _, err := dao.GetTeamMemberRole(ctx, teamID, invitee); err == nil {
+		return 0, dao.ErrTeamMemberExists
+	} else if !errors.Is(err, dao.ErrTeamMemberNotFound) {
+		return 0, err
+	}
+	pending, err := dao.HasPendingTeamInvite(ctx, teamID, invitee)
+	if err != nil {
+		return 0, err
+	}
+	if pending {
+		return 0, ErrAlreadyInvited
+	}
+
+	id, err := dao.InsertTeamInvite(ctx, dao.TeamInvite{TeamID: teamID, Inviter: inviter, Invitee: invitee, Role: role})
+	if err != nil {
+		return 0, err
+	}
+	audit(ctx, inviter, "team.invite", TeamNamespace(teamID), "invitee="+invitee+" role="+role)
+	return id, nil
+}
+
+// ListTeamInvites 列出用户待处理的团队邀请。
+func ListTeamInvites(ctx context.Context, username string) ([]dao.TeamInvite, error) {
+	return dao.GetPendingTeamInvites(ctx, username)
+}
+
+// AcceptTeamInvite 接受邀请并以邀请中的角色加入团队。
+func AcceptTeamInvite(ctx context.Context, username string, id int64) (dao.TeamInvite, error) {
+	invite, err := dao.GetPendingTeamInvite(ctx, username, id)
+	if err != nil {
+		return dao.TeamInvite{}, err
+	}
+	if err := dao.AcceptTeamInvite(ctx, invite); err != nil {
+		return dao.TeamInvite{}, err
+	}
+	audit(ctx, username, "team.join", TeamNamespace(invite.TeamID), "role="+invite.Role)
+	return invite, nil
+}
+
+// DeclineTeamInvite 拒绝团队邀请。
+func DeclineTeamInvite(ctx context.Context, username string, id int64) error {
+	if _, err := dao.GetPendingTeamInvite(ctx, username, id); err != nil {
+		return err
+	}
+	ok, err := dao.UpdateTeamInviteStatus(ctx, id, dao.TeamInvitePending, dao.TeamInviteDeclined)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return dao.ErrTeamInviteNotFound
+	}
+	return nil
+}
+
+// SetTeamMemberRole 修改成员的角色。owner 可以修改任何成员；admin 只能修改比自己低的成员，且不能授予 admin 及以上角色。
+func SetTeamMemberRole(ctx context.Context, actor string, teamID int64, member, role string) error {
+	actorRole, err := teamRole(ctx, actor, teamID)
+	if err != nil {
+		return err
+	}
+	if err := checkRoleGrant(actorRole, role); err != nil {
+		return err
+	}
+	memberRole, err := dao.GetTeamMemberRole(ctx, teamID, member)
+	if err != nil {
+		return err
+	}
+	if err := checkManageMember(actorRole, memberRole); err != nil {
+		return err
+	}
+	if memberRole == TeamRoleOwner && role != TeamRoleOwner {
+		if err := checkNotLastOwner(ctx, teamID); err != nil {
+			return err
+		}
+	}
+
+	if err := dao.UpdateTeamMemberRole(ctx, teamID, member, role); err != nil {
+		return err
+	}
+	audit(ctx, actor, "team.role", TeamNamespace(teamID), "member="+member+" role="+role)
+	return nil
+}
+
+// RemoveTeamMember 把成员移出团队；member 为 actor 本人时表示退出团队，任何角色都可以退出。
+func RemoveTeamMember(ctx context.Context, actor string, teamID int64, member string) error {
+	actorRole, err := teamRole(ctx, actor, teamID)
+	if err != nil {
+		return err
+	}
+	memberRole := actorRole
+	if member != actor {
+		if memberRole, err = dao.GetTeamMemberRole(ctx, teamID, member); err != nil {
+			return err
+		}
+		if err := checkManageMember(actorRole, memberRole); err != nil {
+			return err
+		}
+	}
+	if memberRole == TeamRoleOwner {
+		if err := checkNotLastOwner(ctx, teamID); err != nil {
+			return err
+		}
+	}
+
+	if err := dao.DeleteTeamMember(ctx, teamID, member); err != nil {
+		return err
+	}
+	audit(ctx, actor, "team.remove", TeamNamespace(teamID), "member="+member)
+	return nil
+}
+
+// checkManageMember 确认 actor 可以管理角色为 memberRole 的成员：owner 可以管理所有人，admin 只能管理比自己低的成员。
+func checkManageMember(actorRole, memberRole string) error {
+	actor := teamRolePerm[actorRole]
+	if actor < PermManage || (actor < PermOwn && teamRolePerm[memberRole] >= actor) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+func checkNotLastOwner(ctx context.Context, teamID int64) error {
+	owners, err := dao.CountTeamMembersByRole(ctx, teamID, TeamRoleOwner)
+	if err != nil {
+		return err
+	}
+	if owners <= 1 {
+		return ErrLastTeamOwner
+	}
+	return nil
+}
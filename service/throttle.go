@@ -0,0 +1,222 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"filestore-server/pkg/config"
+	"filestore-server/pkg/dao"
+	"filestore-server/pkg/queue"
+	"filestore-server/pkg/redis"
+	"filestore-server/pkg/throttle"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	throttleUserPrefix = "login:user:"
+	throttleIPPrefix   = "login:ip:"
+)
+
+// ErrLoginThrottled 表示失败次数过多，需要等待后再尝试登录。
+var ErrLoginThrottled = errors.New("too many failed login attempts")
+
+// ThrottleError 说明登录被限制的原因及可以重试的时间。
+type ThrottleError struct {
+	// Locked 为 true 表示达到锁定阈值，否则只是失败后的递增延迟。
+	Locked     bool
+	RetryAfter time.Duration
+}
+
+func (e *ThrottleError) Error() string {
+	if e.Locked {
+		return "account temporarily locked due to too many failed login attempts"
+	}
+	return ErrLoginThrottled.Error()
+}
+
+func (e *ThrottleError) Unwrap() error { return ErrLoginThrottled }
+
+var (
+	throttleMu    sync.Mutex
+	throttleStore throttle.Store
+)
+
+// loginThrottle 返回登录失败计数的存储：Redis 可用时使用 Redis，使多个实例共享计数，否则退回进程内存储。
+func loginThrottle() throttle.Store {
+	throttleMu.Lock()
+	defer throttleMu.Unlock()
+	if throttleStore == nil {
+		if pool := redis.GetRedisConnectionPool(); queue.Available(pool) {
+			throttleStore = throttle.NewRedisStore(pool)
+		} else {
+			log.Printf("login throttle falls back to in-process store")
+			throttleStore = throttle.NewMemoryStore()
+		}
+	}
+	return throttleStore
+}
+
+// SetLoginThrottle 替换登录失败计数的存储。
+func SetLoginThrottle(s throttle.Store) {
+	throttleMu.Lock()
+	defer throttleMu.Unlock()
+	throttleStore = s
+}
+
+// throttlePolicy 是按用户名或按 IP 统计失败次数时的阈值。
+type throttlePolicy struct {
+	prefix string
+	// delayAfter 次失败后开始要求等待，此后每多失败一次等待时间翻倍。
+	delayAfter int
+	// lockAfter 次失败后锁定 lockFor。
+	lockAfter int
+}
+
+func loginThrottlePolicies() []throttlePolicy {
+	return []throttlePolicy{
+		{
+			prefix:     throttleUserPrefix,
+			delayAfter: config.Int("FILESTORE_LOGIN_DELAY_AFTER", 3),
+			lockAfter:  config.Int("FILESTORE_LOGIN_LOCK_AFTER", 10),
+		},
+		{
+			// 同一 IP 可能对应多个用户（NAT），阈值更高。
+			prefix:     throttleIPPrefix,
+			delayAfter: config.Int("FILESTORE_LOGIN_IP_DELAY_AFTER", 20),
+			lockAfter:  config.Int("FILESTORE_LOGIN_IP_LOCK_AFTER", 100),
+		},
+	}
+}
+
+func loginWindow() time.Duration {
+	return config.Duration("FILESTORE_LOGIN_WINDOW", 15*time.Minute)
+}
+
+// Login 在登录失败限制之内校验用户名密码。用户名或 IP 处于等待或锁定期间直接返回 *ThrottleError，不校验密码；
+// 校验失败时按用户名和 IP 分别计数，达到阈值后要求指数递增的等待时间，最终临时锁定。
+// 未知用户名与密码错误同样计数，并返回相同的 ErrInvalidCredentials。
+// 启用了两步验证的用户在密码正确后仍保留失败记录，直到 LoginSecondFactor 验证成功。
+func Login(ctx context.Context, username, password, ip string) (dao.User, error) {
+	store := loginThrottle()
+	now := time.Now()
+	if err := checkLoginThrottle(ctx, store, username, ip, now); err != nil {
+		return dao.User{}, err
+	}
+
+	u, err := AuthenticateUser(ctx, username, password)
+	if err == nil {
+		if !u.TOTPEnabled {
+			resetLoginFailures(ctx, store, username)
+		}
+		return u, nil
+	}
+	if !errors.Is(err, ErrInvalidCredentials) {
+		return dao.User{}, err
+	}
+	recordLoginFailures(ctx, store, username, ip, now)
+	return dao.User{}, err
+}
+
+// checkLoginThrottle 在用户名或 IP 处于等待或锁定期间返回 *ThrottleError。
+func checkLoginThrottle(ctx context.Context, store throttle.Store, username, ip string, now time.Time) error {
+	keys := map[string]string{throttleUserPrefix: username, throttleIPPrefix: ip}
+	for _, p := range loginThrottlePolicies() {
+		until, err := store.BlockedUntil(ctx, p.prefix+keys[p.prefix], now)
+		if err != nil {
+			log.Printf("login throttle check failed: %v", err)
+			continue
+		}
+		if !until.IsZero() {
+			locked, _ := store.Failures(ctx, p.prefix+keys[p.prefix], now, loginWindow())
+			return &ThrottleError{Locked: p.lockAfter > 0 && locked >= p.lockAfter, RetryAfter: until.Sub(now)}
+		}
+	}
+	return nil
+}
+
+// recordLoginFailures 按用户名和 IP 分别记录一次登录失败。
+func recordLoginFailures(ctx context.Context, store throttle.Store, username, ip string, now time.Time) {
+	keys := map[string]string{throttleUserPrefix: username, throttleIPPrefix: ip}
+	for _, p := range loginThrottlePolicies() {
+		recordLoginFailure(ctx, store, p, keys[p.prefix], username, ip, now)
+	}
+}
+
+// resetLoginFailures 在登录完成后清除用户名的失败记录，IP 的计数不受影响。
+func resetLoginFailures(ctx context.Context, store throttle.Store, username string) {
+	if err := store.Reset(ctx, throttleUserPrefix+username); err != nil {
+		log.Printf("failed to reset login failures for %s: %v", username, err)
+	}
+}
+
+// recordLoginFailure 记录一次失败，并在达到阈值时设置等待或锁定。
+func recordLoginFailure(ctx context.Context, store throttle.Store, p throttlePolicy, key, username, ip string, now time.Time) {
+	n, err := store.RecordFailure(ctx, p.prefix+key, now, loginWindow())
+	if err != nil {
+		log.Printf("failed to record login failure: %v", err)
+		return
+	}
+	if p.prefix == throttleUserPrefix {
+		audit(ctx, auditActorSystem, "user.login_failed", username, fmt.Sprintf("ip=%s failures=%d", ip, n))
+	}
+
+	var until time.Time
+	switch {
+	case p.lockAfter > 0 && n >= p.lockAfter:
+		until = now.Add(config.Duration("FILESTORE_LOGIN_LOCK_DURATION", 15*time.Minute))
+		if n == p.lockAfter {
+			audit(ctx, auditActorSystem, "user.login_locked", p.prefix+key, fmt.Sprintf("ip=%s failures=%d until=%s", ip, n, until.Format(time.RFC3339)))
+		}
+	case p.delayAfter > 0 && n >= p.delayAfter:
+		until = now.Add(loginDelay(n - p.delayAfter))
+	default:
+		return
+	}
+	if err := store.Block(ctx, p.prefix+key, until); err != nil {
+		log.Printf("failed to block login: %v", err)
+	}
+}
+
+// loginDelay 返回第 extra 次超出阈值的失败后需要等待的时间：基础延迟按 2 的幂递增，不超过上限。
+func loginDelay(extra int) time.Duration {
+	base := config.Duration("FILESTORE_LOGIN_DELAY_BASE", time.Second)
+	limit := config.Duration("FILESTORE_LOGIN_DELAY_MAX", time.Minute)
+	d := base
+	for i := 0; i < extra && d < limit; i++ {
+		d *= 2
+	}
+	return min(d, limit)
+}
+
+// LoginLockState 是用户名在登录失败限制中的状态。
+type LoginLockState struct {
+	Failures int `json:"login_failures"`
+	// BlockedUntil 为空表示当前可以登录。
+	BlockedUntil string `json:"login_blocked_until"`
+}
+
+// GetLoginLockState 返回用户名当前窗口内的失败次数及被阻止到的时间。
+func GetLoginLockState(ctx context.Context, username string) (LoginLockState, error) {
+	store := loginThrottle()
+	now := time.Now()
+	n, err := store.Failures(ctx, throttleUserPrefix+username, now, loginWindow())
+	if err != nil {
+		return LoginLockState{}, err
+	}
+	until, err := store.BlockedUntil(ctx, throttleUserPrefix+username, now)
+	if err != nil {
+		return LoginLockState{}, err
+	}
+	state := LoginLockState{Failures: n}
+	if !until.IsZero() {
+		state.BlockedUntil = until.Format(time.RFC3339)
+	}
+	return state, nil
+}
+
+// clearLoginLock 清除用户名的登录失败记录和锁定。
+func clearLoginLock(ctx context.Context, username string) error {
+	return loginThrottle().Reset(ctx, throttleUserPrefix+username)
+}
@@ -0,0 +1,260 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"filestore-server/pkg/dao"
+	"filestore-server/pkg/queue"
+	util "filestore-server/pkg/utils"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+
+	"github.com/ledongthuc/pdf"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// DefaultThumbnailSize 是未指定尺寸时使用的缩略图边长。
+	DefaultThumbnailSize = 256
+	// maxThumbnailPixels 限制源图像素数，避免解码超大图片耗尽内存。
+	maxThumbnailPixels = 50_000_000
+	thumbnailQuality   = 85
+	// maxPreviewPDF 是生成预览的 PDF 文件大小上限。
+	maxPreviewPDF = 64 << 20
+	// maxPreviewPageSide 是绘制 PDF 页面时的最大边长（像素）。
+	maxPreviewPageSide = 2048.0
+)
+
+// ThumbnailSizes 是支持的缩略图边长（像素），缩略图按比例缩放到不超过该边长。
+var ThumbnailSizes = []int{128, 256, 512}
+
+var (
+	// ErrInvalidThumbnailSize 表示请求了不支持的缩略图尺寸。
+	ErrInvalidThumbnailSize = errors.New("invalid thumbnail size")
+	// ErrNoPreview 表示文件不是支持生成缩略图的图片或 PDF。
+	ErrNoPreview = errors.New("preview not available")
+)
+
+// ValidThumbnailSize 判断 size 是否为支持的缩略图尺寸。
+func ValidThumbnailSize(size int) bool {
+	for _, s := range ThumbnailSizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+// GetThumbnail 返回 username 可查看的文件指定尺寸的缩略图（JPEG），还没有生成时同步生成。
+func GetThumbnail(ctx context.Context, username, filehash string, size int) ([]byte, error) {
+	if !ValidThumbnailSize(size) {
+		return nil, ErrInvalidThumbnailSize
+	}
+	if _, err := AuthorizeFile(ctx, username, filehash, PermRead); err != nil {
+		return nil, err
+	}
+	fmeta, err := dao.GetFileMeta(ctx, filehash)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkScanState(fmeta); err != nil {
+		return nil, err
+	}
+
+	data, err := readDerivedBlob(ctx, filehash, thumbnailKind(size))
+	if err == nil {
+		return data, nil
+	}
+	// 记录存在但内容丢失时重新生成。
+	if !errors.Is(err, dao.ErrDerivedNotFound) && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	if err := generateThumbnails(ctx, fmeta, []int{size}); err != nil {
+		return nil, err
+	}
+	return readDerivedBlob(ctx, filehash, thumbnailKind(size))
+}
+
+// thumbnailJob 在上传后为图片和 PDF 预先生成所有尺寸的缩略图，其他文件直接跳过。
+// 等待扫描或已感染的文件不读取内容，扫描通过后在首次请求时生成。
+func thumbnailJob(ctx context.Context, job *queue.Job) error {
+	var p fileJobPayload
+	if err := job.Decode(&p); err != nil {
+		return queue.Permanent(err)
+	}
+	fmeta, err := dao.GetFileMeta(ctx, p.FileSha1)
+	if err != nil {
+		return err
+	}
+	if checkScanState(fmeta) != nil {
+		return nil
+	}
+	err = generateThumbnails(ctx, fmeta, ThumbnailSizes)
+	if errors.Is(err, ErrNoPreview) {
+		return nil
+	}
+	return err
+}
+
+// generateThumbnails 解码一次源图并生成 sizes 中的每个尺寸，保存为派生内容。
+func generateThumbnails(ctx context.Context, fmeta dao.FileMeta, sizes []int) error {
+	var (
+		src image.Image
+		err error
+	)
+	if util.BaseMimeType(fmeta.MimeType) == "application/pdf" {
+		src, err = renderPDFPreview(ctx, fmeta)
+	} else {
+		src, err = decodeImage(ctx, fmeta)
+	}
+	if err != nil {
+		return err
+	}
+	for _, size := range sizes {
+		data, err := encodeThumbnail(src, size)
+		if err != nil {
+			return err
+		}
+		if err := saveDerivedBlob(ctx, fmeta.FileSha1, thumbnailKind(size), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeImage 先读取图片头校验格式和像素数，再完整解码。
+func decodeImage(ctx context.Context, fmeta dao.FileMeta) (image.Image, error) {
+	r, err := openBlob(ctx, fmeta)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	cfg, _, err := image.DecodeConfig(r)
+	r.Close()
+	if err != nil {
+		return nil, ErrNoPreview
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxThumbnailPixels {
+		return nil, ErrNoPreview
+	}
+
+	// 解密和解压后的流不一定支持 Seek，重新打开一次读取完整图片。
+	r, err = openBlob(ctx, fmeta)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	defer r.Close()
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoPreview, err)
+	}
+	return img, nil
+}
+
+// renderPDFPreview 把 PDF 第一页的文字按其位置绘制在白底页面上作为预览，页面中的图形和图片不绘制。
+func renderPDFPreview(ctx context.Context, fmeta dao.FileMeta) (image.Image, error) {
+	if fmeta.FileSize > maxPreviewPDF {
+		return nil, ErrNoPreview
+	}
+	// PDF 需要随机读取，先把明文落到临时文件。
+	tmp, err := os.CreateTemp("", "filestore-preview-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+	if err := copyBlob(ctx, tmp, fmeta); err != nil {
+		return nil, err
+	}
+	return pdfFirstPage(tmp, fmeta.FileSize)
+}
+
+// pdfFirstPage 以 1 点对应 1 像素绘制第一页，超过 maxPreviewPageSide 的页面等比缩小。
+func pdfFirstPage(r io.ReaderAt, size int64) (img image.Image, err error) {
+	defer func() {
+		// pdf 库在遇到畸形文件时可能 panic。
+		if p := recover(); p != nil {
+			img, err = nil, ErrNoPreview
+		}
+	}()
+	reader, err := pdf.NewReader(r, size)
+	if err != nil || reader.NumPage() < 1 {
+		return nil, ErrNoPreview
+	}
+	page := reader.Page(1)
+	if page.V.IsNull() {
+		return nil, ErrNoPreview
+	}
+
+	// MediaBox 可以继承自上级页面树节点，都没有时按 Letter 尺寸处理。
+	x0, y0, w, h := 0.0, 0.0, 612.0, 792.0
+	var box pdf.Value
+	for v := page.V; !v.IsNull() && box.IsNull(); v = v.Key("Parent") {
+		box = v.Key("MediaBox")
+	}
+	if box.Len() == 4 {
+		bx0, by0 := box.Index(0).Float64(), box.Index(1).Float64()
+		bw, bh := box.Index(2).Float64()-bx0, box.Index(3).Float64()-by0
+		if bw > 0 && bh > 0 {
+			x0, y0, w, h = bx0, by0, bw, bh
+		}
+	}
+	scale := min(1, maxPreviewPageSide/max(w, h))
+	dst := image.NewRGBA(image.Rect(0, 0, max(1, int(w*scale)), max(1, int(h*scale))))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+
+	d := font.Drawer{Dst: dst, Src: image.NewUniform(color.Black), Face: basicfont.Face7x13}
+	for _, t := range page.Content().Text {
+		// PDF 坐标原点在左下角。
+		d.Dot = fixed.P(int((t.X-x0)*scale), int((h-(t.Y-y0))*scale))
+		d.DrawString(t.S)
+	}
+	return dst, nil
+}
+
+// encodeThumbnail 把 src 等比缩放到不超过 size 的边长，透明区域以白色填充，编码为 JPEG。
+func encodeThumbnail(src image.Image, size int) ([]byte, error) {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, max(1, h*size/w)
+		} else {
+			w, h = max(1, w*size/h), size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// removeThumbnails 删除文件的所有缩略图。
+func removeThumbnails(ctx context.Context, filehash string) {
+	removeDerivedBlobs(ctx, filehash)
+}
+
+func thumbnailKind(size int) string {
+	return "thumb_" + strconv.Itoa(size)
+}
@@ -0,0 +1,341 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"filestore-server/pkg/config"
+	"filestore-server/pkg/dao"
+	"filestore-server/pkg/totp"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	recoveryCodeCount = 10
+	// recoveryCodeBytes 为每个恢复码的随机字节数，编码为 10 个 base32 字符。
+	recoveryCodeBytes = 6
+	// totpSkew 为校验时前后容许的时间步数，容忍客户端时钟误差。
+	totpSkew = 1
+)
+
+var (
+	// ErrTOTPAlreadyEnabled 表示用户已启用两步验证。
+	ErrTOTPAlreadyEnabled = errors.New("two-factor authentication already enabled")
+	// ErrTOTPNotEnabled 表示用户没有启用两步验证。
+	ErrTOTPNotEnabled = errors.New("two-factor authentication not enabled")
+	// ErrTOTPNotEnrolled 表示确认前没有生成密钥。
+	ErrTOTPNotEnrolled = errors.New("two-factor enrollment not started")
+	// ErrInvalidTOTPCode 表示验证码或恢复码不正确、已使用或已过期。
+	ErrInvalidTOTPCode = errors.New("invalid two-factor code")
+	// ErrTOTPRequired 表示管理员要求该用户启用两步验证，用户不能自行关闭。
+	ErrTOTPRequired = errors.New("two-factor authentication is required by administrator")
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPEnrollment 是开始启用两步验证时返回的密钥，用户把它添加到验证器应用后提交验证码确认。
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// TOTPStatus 是用户两步验证的状态。
+type TOTPStatus struct {
+	Enabled           bool `json:"enabled"`
+	Required          bool `json:"required"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+// GetTOTPStatus 返回用户两步验证的启用状态及剩余恢复码数量。
+func GetTOTPStatus(ctx context.Context, username string) (TOTPStatus, error) {
+	u, err := dao.GetUserByName(ctx, username)
+	if err != nil {
+		return TOTPStatus{}, err
+	}
+	status := TOTPStatus{Enabled: u.TOTPEnabled, Required: u.TOTPRequired}
+	if u.TOTPEnabled {
+		if status.RecoveryCodesLeft, err = dao.CountRecoveryCodes(ctx, username); err != nil {
+			return TOTPStatus{}, err
+		}
+	}
+	return status, nil
+}
+
+// EnrollTOTP 为用户生成新的待启用密钥，覆盖之前未确认的密钥；确认前不影响登录。
+func EnrollTOTP(ctx context.Context, username string) (TOTPEnrollment, error) {
+	u, err := dao.GetUserByName(ctx, username)
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+	if u.TOTPEnabled {
+		return TOTPEnrollment{}, ErrTOTPAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+	keyID, stored, err := sealTOTPSecret(secret)
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+	if err := dao.SaveTOTPSecret(ctx, username, keyID, stored); err != nil {
+		return TOTPEnrollment{}, err
+	}
+	issuer := config.String("FILESTORE_TOTP_ISSUER", "filestore")
+	return TOTPEnrollment{Secret: secret, URI: totp.URI(issuer, username, secret)}, nil
+}
+
+// ConfirmTOTP 用验证器应用生成的验证码确认密钥并启用两步验证，返回一次性恢复码。恢复码只在此时返回明文。
+func ConfirmTOTP(ctx context.Context, username, code string) ([]string, error) {
+	u, err := dao.GetUserByName(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if u.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if err := verifyTOTPCode(ctx, username, code); err != nil {
+		if errors.Is(err, dao.ErrTOTPNotFound) {
+			return nil, ErrTOTPNotEnrolled
+		}
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes(username)
+	if err != nil {
+		return nil, err
+	}
+	if err := dao.EnableUserTOTP(ctx, username, hashes); err != nil {
+		return nil, err
+	}
+	audit(ctx, username, "user.2fa_enabled", username, "")
+	return codes, nil
+}
+
+// DisableTOTP 校验密码和验证码（或恢复码）后关闭两步验证。管理员要求启用时不能关闭。
+// 用户的其他会话随之失效；返回更新后的用户记录，供调用方刷新当前会话。
+func DisableTOTP(ctx context.Context, username, password, code string) (dao.User, error) {
+	if password == "" {
+		return dao.User{}, ErrPasswordRequired
+	}
+	u, err := dao.GetUserByName(ctx, username)
+	if err != nil {
+		return dao.User{}, err
+	}
+	if !u.TOTPEnabled {
+		return dao.User{}, ErrTOTPNotEnabled
+	}
+	if u.TOTPRequired {
+		return dao.User{}, ErrTOTPRequired
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)); err != nil {
+		return dao.User{}, ErrWrongPassword
+	}
+	if err := verifySecondFactor(ctx, username, code); err != nil {
+		return dao.User{}, err
+	}
+	if err := dao.DisableUserTOTP(ctx, username); err != nil {
+		return dao.User{}, err
+	}
+	audit(ctx, username, "user.2fa_disabled", username, "")
+	return dao.GetUserByName(ctx, username)
+}
+
+// RegenerateRecoveryCodes 校验验证码后生成新的恢复码，原有恢复码全部失效。
+func RegenerateRecoveryCodes(ctx context.Context, username, code string) ([]string, error) {
+	u, err := dao.GetUserByName(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if !u.TOTPEnabled {
+		return nil, ErrTOTPNotEnabled
+	}
+	if err := verifyTOTPCode(ctx, username, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes(username)
+	if err != nil {
+		return nil, err
+	}
+	if err := dao.ReplaceRecoveryCodes(ctx, username, hashes); err != nil {
+		return nil, err
+	}
+	audit(ctx, username, "user.2fa_recovery_regenerated", username, "")
+	return codes, nil
+}
+
+// LoginSecondFactor 完成两步登录的第二步：校验验证码或恢复码，成功时返回用户记录。
+// 失败与密码错误一样计入登录失败限制，处于等待或锁定期间直接返回 *ThrottleError。
+func LoginSecondFactor(ctx context.Context, username, code, ip string) (dao.User, error) {
+	store := loginThrottle()
+	now := time.Now()
+	if err := checkLoginThrottle(ctx, store, username, ip, now); err != nil {
+		return dao.User{}, err
+	}
+
+	u, err := dao.GetUserByName(ctx, username)
+	if err != nil {
+		return dao.User{}, err
+	}
+	if err := checkUserEnabled(u); err != nil {
+		return dao.User{}, err
+	}
+	if !u.TOTPEnabled {
+		return dao.User{}, ErrTOTPNotEnabled
+	}
+	if err := verifySecondFactor(ctx, username, code); err != nil {
+		if errors.Is(err, ErrInvalidTOTPCode) {
+			recordLoginFailures(ctx, store, username, ip, now)
+		}
+		return dao.User{}, err
+	}
+	resetLoginFailures(ctx, store, username)
+	return u, nil
+}
+
+// SetUserTOTPRequired 由管理员设置是否要求用户启用两步验证。要求后尚未启用的用户只能访问两步验证的设置接口。
+func SetUserTOTPRequired(ctx context.Context, actor, username string, required bool) error {
+	if err := dao.UpdateUserTOTPRequired(ctx, username, required); err != nil {
+		return err
+	}
+	audit(ctx, actor, "user.2fa_required", username, fmt.Sprintf("required=%t", required))
+	return nil
+}
+
+// ResetUserTOTP 由管理员关闭用户的两步验证，用于用户丢失验证器且没有恢复码的情况。
+// 用户的全部会话随之失效，仍要求两步验证的用户需要在下次登录后重新启用。
+func ResetUserTOTP(ctx context.Context, actor, username string) error {
+	if _, err := dao.GetUserByName(ctx, username); err != nil {
+		return err
+	}
+	if err := dao.DisableUserTOTP(ctx, username); err != nil {
+		return err
+	}
+	audit(ctx, actor, "user.2fa_reset", username, "")
+	return nil
+}
+
+// verifySecondFactor 校验 6 位数字验证码，其他输入按恢复码校验。
+func verifySecondFactor(ctx context.Context, username, code string) error {
+	code = strings.TrimSpace(code)
+	if len(code) == totp.Digits && strings.Trim(code, "0123456789") == "" {
+		err := verifyTOTPCode(ctx, username, code)
+		if errors.Is(err, dao.ErrTOTPNotFound) {
+			return ErrInvalidTOTPCode
+		}
+		return err
+	}
+
+	normalized := normalizeRecoveryCode(code)
+	if normalized == "" {
+		return ErrInvalidTOTPCode
+	}
+	ok, err := dao.ConsumeRecoveryCode(ctx, username, hashRecoveryCode(username, normalized))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidTOTPCode
+	}
+	audit(ctx, username, "user.2fa_recovery_used", username, "")
+	return nil
+}
+
+// verifyTOTPCode 校验验证码并记录其时间步，同一时间步内的验证码不能重复使用。
+func verifyTOTPCode(ctx context.Context, username, code string) error {
+	s, err := dao.GetTOTPSecret(ctx, username)
+	if err != nil {
+		return err
+	}
+	secret, err := openTOTPSecret(s)
+	if err != nil {
+		return err
+	}
+	step, ok := totp.Validate(secret, strings.TrimSpace(code), time.Now(), totpSkew)
+	if !ok || step <= s.LastStep {
+		return ErrInvalidTOTPCode
+	}
+	advanced, err := dao.AdvanceTOTPStep(ctx, username, step)
+	if err != nil {
+		return err
+	}
+	if !advanced {
+		return ErrInvalidTOTPCode
+	}
+	return nil
+}
+
+// sealTOTPSecret 在配置了主密钥时加密密钥，返回主密钥 ID 及保存的内容。
+func sealTOTPSecret(secret string) (string, string, error) {
+	keyring, err := encryptionKeyring()
+	if err != nil {
+		return "", "", err
+	}
+	if keyring == nil {
+		return "", secret, nil
+	}
+	wrapped, err := keyring.Wrap(keyring.ActiveID(), []byte(secret))
+	if err != nil {
+		return "", "", err
+	}
+	return keyring.ActiveID(), wrapped, nil
+}
+
+// openTOTPSecret 返回 base32 编码的密钥明文。
+func openTOTPSecret(s dao.TOTPSecret) (string, error) {
+	if s.KeyID == "" {
+		return s.Secret, nil
+	}
+	keyring, err := encryptionKeyring()
+	if err != nil {
+		return "", err
+	}
+	if keyring == nil {
+		return "", fmt.Errorf("totp secret is encrypted but no master key is configured")
+	}
+	secret, err := keyring.Unwrap(s.KeyID, s.Secret)
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// newRecoveryCodes 生成恢复码，返回明文（xxxxx-xxxxx 格式）及保存用的哈希。
+func newRecoveryCodes(username string) ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	buf := make([]byte, recoveryCodeBytes)
+	for len(codes) < recoveryCodeCount {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		raw := strings.ToLower(recoveryCodeEncoding.EncodeToString(buf))
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+		hashes = append(hashes, hashRecoveryCode(username, raw))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode 去掉分隔符并转换为小写，输入不是恢复码格式时返回空字符串。
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if _, err := recoveryCodeEncoding.DecodeString(strings.ToUpper(code)); err != nil || len(code) != 10 {
+		return ""
+	}
+	return code
+}
+
+// hashRecoveryCode 返回恢复码的哈希，包含用户名使相同恢复码在不同用户间的哈希不同。
+func hashRecoveryCode(username, code string) string {
+	sum := sha256.Sum256([]byte(username + "\x00" + code))
+	return hex.EncodeToString(sum[:])
+}
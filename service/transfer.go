@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"filestore-server/pkg/config"
+	"filestore-server/pkg/dao"
+	"filestore-server/pkg/objstore"
+	"filestore-server/pkg/queue"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"sync"
+)
+
+var (
+	remoteMu     sync.Mutex
+	remoteLoaded bool
+	remote       objstore.Store
+)
+
+// remoteStore 按配置连接二级存储；未配置 FILESTORE_REMOTE_ENDPOINT 时返回 nil。
+// 连接失败不会被缓存，下次调用会重试。
+func remoteStore(ctx context.Context) (objstore.Store, error) {
+	remoteMu.Lock()
+	defer remoteMu.Unlock()
+	if remoteLoaded {
+		return remote, nil
+	}
+
+	endpoint := config.String("FILESTORE_REMOTE_ENDPOINT", "")
+	if endpoint == "" {
+		remoteLoaded = true
+		return nil, nil
+	}
+	s, err := objstore.NewMinioStore(ctx, objstore.MinioConfig{
+		Endpoint:  endpoint,
+		AccessKey: config.String("FILESTORE_REMOTE_ACCESS_KEY", ""),
+		SecretKey: config.String("FILESTORE_REMOTE_SECRET_KEY", ""),
+		Bucket:    config.String("FILESTORE_REMOTE_BUCKET", "filestore"),
+		UseSSL:    config.Bool("FILESTORE_REMOTE_USE_SSL", false),
+	})
+	if err != nil {
+		return nil, err
+	}
+	remote, remoteLoaded = s, true
+	return remote, nil
+}
+
+// SetRemoteStore 替换二级存储，传入 nil 表示关闭转存。
+func SetRemoteStore(s objstore.Store) {
+	remoteMu.Lock()
+	defer remoteMu.Unlock()
+	remote, remoteLoaded = s, true
+}
+
+// remoteEnabled 表示新上传的文件是否需要转存到二级存储。
+func remoteEnabled() bool {
+	remoteMu.Lock()
+	defer remoteMu.Unlock()
+	if remoteLoaded {
+		return remote != nil
+	}
+	return config.String("FILESTORE_REMOTE_ENDPOINT", "") != ""
+}
+
+// transferJob 把本地落盘的数据原样（压缩、加密后的字节）上传到二级存储，更新 tbl_file 后再删除本地文件。
+// 任何一步失败都会保留本地文件并由队列重试。
+func transferJob(ctx context.Context, job *queue.Job) error {
+	var p fileJobPayload
+	if err := job.Decode(&p); err != nil {
+		return queue.Permanent(err)
+	}
+	fmeta, err := dao.GetFileMeta(ctx, p.FileSha1)
+	if err != nil {
+		return err
+	}
+	if fmeta.StoreState == dao.StoreRemote {
+		return nil
+	}
+
+	store, err := remoteStore(ctx)
+	if err != nil {
+		return err
+	}
+	if store == nil {
+		return queue.Permanent(fmt.Errorf("remote store not configured"))
+	}
+
+	f, err := os.Open(fmeta.Location)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return queue.Permanent(err)
+		}
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := store.Put(ctx, fmeta.FileSha1, f, info.Size()); err != nil {
+		return err
+	}
+
+	updated, err := dao.UpdateFileStore(ctx, fmeta.FileSha1, fmeta.Location, store.Location(fmeta.FileSha1), dao.StoreRemote)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		// 文件在转存期间被删除或修改，本次上传的对象作废。
+		_ = store.Delete(ctx, fmeta.FileSha1)
+		return nil
+	}
+	// 已打开本地文件的下载不受影响；此后打开的会读取二级存储。
+	if err := os.Remove(fmeta.Location); err != nil {
+		log.Printf("failed to remove transferred file %s: %v", fmeta.Location, err)
+	}
+	return nil
+}
+
+// openRaw 打开落盘的原始字节，不做解密和解压。
+// 本地文件不存在时重新读取元信息，以覆盖读取期间刚好完成转存的情况。
+func openRaw(ctx context.Context, fmeta dao.FileMeta) (io.ReadSeekCloser, error) {
+	if fmeta.StoreState != dao.StoreRemote {
+		f, err := os.Open(fmeta.Location)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) || !remoteEnabled() {
+			return nil, err
+		}
+		latest, metaErr := dao.GetFileMeta(ctx, fmeta.FileSha1)
+		if metaErr != nil || latest.StoreState != dao.StoreRemote {
+			return nil, err
+		}
+	}
+
+	store, err := remoteStore(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if store == nil {
+		return nil, fmt.Errorf("file %s is in remote store but no remote store configured", fmeta.FileSha1)
+	}
+	return store.Get(ctx, fmeta.FileSha1)
+}
@@ -2,39 +2,272 @@ package service
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"filestore-server/pkg/config"
 	"filestore-server/pkg/dao"
+	"filestore-server/pkg/notify"
 	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
-// RegisterUser 创建新用户并存储哈希密码。
+var (
+	// ErrInvalidCredentials 表示用户名不存在或密码错误，两种情况不作区分。
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrPasswordRequired 表示没有提供新密码或旧密码。
+	ErrPasswordRequired = errors.New("password is required")
+	// ErrWrongPassword 表示修改密码时提供的旧密码不正确。
+	ErrWrongPassword = errors.New("old password is incorrect")
+	// ErrInvalidResetToken 表示重置密码的令牌不存在、已使用或已过期。
+	ErrInvalidResetToken = errors.New("invalid or expired reset token")
+)
+
+// RegisterUser 按用户名和密码规则校验后创建新用户并存储哈希密码，违反规则时返回 *PolicyError。
 func RegisterUser(ctx context.Context, username, password string) error {
 	if username == "" || password == "" {
 		return fmt.Errorf("username and password are required")
 	}
+	if err := ValidateCredentials(username, password); err != nil {
+		return err
+	}
 
-	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashed, err := hashPassword(password)
 	if err != nil {
-		return fmt.Errorf("failed to hash password: %w", err)
+		return err
 	}
 
-	return dao.CreateUser(ctx, username, string(hashed))
+	return dao.CreateUser(ctx, username, hashed)
 }
 
-// AuthenticateUser 校验用户名密码。
-func AuthenticateUser(ctx context.Context, username, password string) error {
+// AuthenticateUser 校验用户名密码，成功时返回用户记录。用户不存在时同样执行一次 bcrypt 比较并返回
+// ErrInvalidCredentials，使响应时间和结果都与密码错误一致。
+// 密码哈希的代价低于当前配置时用新的代价重新哈希，失败不影响登录。
+func AuthenticateUser(ctx context.Context, username, password string) (dao.User, error) {
 	if username == "" || password == "" {
-		return fmt.Errorf("username and password are required")
+		return dao.User{}, fmt.Errorf("username and password are required")
 	}
 
 	u, err := dao.GetUserByName(ctx, username)
 	if err != nil {
-		return err
+		if err.Error() != "user not found" {
+			return dao.User{}, err
+		}
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return dao.User{}, ErrInvalidCredentials
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)); err != nil {
-		return fmt.Errorf("invalid credentials")
+		return dao.User{}, ErrInvalidCredentials
+	}
+	if err := checkUserEnabled(u); err != nil {
+		return dao.User{}, err
+	}
+	rehashPassword(ctx, u, password)
+	return u, nil
+}
+
+var (
+	dummyHashMu sync.Mutex
+	dummyHashes = map[int][]byte{}
+)
+
+// dummyPasswordHash 返回按当前代价生成的哈希，用于未知用户的比较，使其耗时与真实用户相同。
+func dummyPasswordHash() []byte {
+	cost := bcryptCost()
+	dummyHashMu.Lock()
+	defer dummyHashMu.Unlock()
+	if h, ok := dummyHashes[cost]; ok {
+		return h
+	}
+	h, err := bcrypt.GenerateFromPassword([]byte("filestore-dummy-password"), cost)
+	if err != nil {
+		return nil
+	}
+	dummyHashes[cost] = h
+	return h
+}
+
+// bcryptCost 返回配置的 bcrypt 代价，超出 bcrypt 允许范围时取边界值。
+func bcryptCost() int {
+	cost := config.Int("FILESTORE_BCRYPT_COST", bcrypt.DefaultCost)
+	return min(max(cost, bcrypt.MinCost), bcrypt.MaxCost)
+}
+
+// hashPassword 按配置的代价哈希密码。
+func hashPassword(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost())
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hashed), nil
+}
+
+// rehashPassword 在配置的代价提高后，用登录时得到的明文密码更新哈希。
+func rehashPassword(ctx context.Context, u dao.User, password string) {
+	cost, err := bcrypt.Cost([]byte(u.Password))
+	if err != nil || cost >= bcryptCost() {
+		return
+	}
+	hashed, err := hashPassword(password)
+	if err == nil {
+		err = dao.UpdateUserPassword(ctx, u.UserName, hashed)
+	}
+	if err != nil {
+		log.Printf("failed to rehash password for %s: %v", u.UserName, err)
+	}
+}
+
+// ChangePassword 校验旧密码后修改密码，用户的其他会话随之失效；返回更新后的用户记录，供调用方刷新当前会话。
+func ChangePassword(ctx context.Context, username, oldPassword, newPassword string) (dao.User, error) {
+	if oldPassword == "" || newPassword == "" {
+		return dao.User{}, ErrPasswordRequired
+	}
+	u, err := dao.GetUserByName(ctx, username)
+	if err != nil {
+		return dao.User{}, err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(oldPassword)); err != nil {
+		return dao.User{}, ErrWrongPassword
+	}
+	if err := ValidatePassword(username, newPassword); err != nil {
+		return dao.User{}, err
+	}
+
+	if err := setPassword(ctx, username, newPassword); err != nil {
+		return dao.User{}, err
+	}
+	audit(ctx, username, "user.password_change", username, "")
+	return dao.GetUserByName(ctx, username)
+}
+
+// setPassword 修改密码并使已有会话和未使用的重置令牌全部失效。
+func setPassword(ctx context.Context, username, password string) error {
+	hashed, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := dao.ChangeUserPassword(ctx, username, hashed); err != nil {
+		return err
+	}
+	return dao.DeletePasswordResetTokens(ctx, username)
+}
+
+// RequestPasswordReset 为用户名或已验证邮箱对应的账户生成重置令牌，发送到已验证的邮箱或手机号。
+// 为避免泄露账户是否存在，账户不存在、没有已验证的联系方式或申请过于频繁时同样返回 nil。
+func RequestPasswordReset(ctx context.Context, login string) error {
+	login = strings.TrimSpace(login)
+	if login == "" {
+		return nil
+	}
+	var usernames []string
+	if strings.Contains(login, "@") {
+		names, err := dao.GetUserNamesByVerifiedEmail(ctx, login)
+		if err != nil {
+			return err
+		}
+		usernames = names
+	} else {
+		usernames = []string{login}
+	}
+
+	for _, username := range usernames {
+		if err := sendPasswordReset(ctx, username); err != nil {
+			return err
+		}
 	}
 	return nil
 }
+
+func sendPasswordReset(ctx context.Context, username string) error {
+	u, err := dao.GetUserByName(ctx, username)
+	if err != nil {
+		if err.Error() == "user not found" {
+			return nil
+		}
+		return err
+	}
+	if !u.Enabled() {
+		return nil
+	}
+	var msg notify.Message
+	switch {
+	case u.Email != "" && u.EmailValid:
+		msg = notify.Message{Channel: notify.ChannelEmail, To: u.Email}
+	case u.Phone != "" && u.PhoneValid:
+		msg = notify.Message{Channel: notify.ChannelSMS, To: u.Phone}
+	default:
+		return nil
+	}
+
+	latest, err := dao.GetLatestPasswordResetAt(ctx, username)
+	if err != nil {
+		return err
+	}
+	if time.Since(latest) < config.Duration("FILESTORE_RESET_RESEND_INTERVAL", time.Minute) {
+		return nil
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Errorf("failed to generate reset token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	ttl := config.Duration("FILESTORE_RESET_TOKEN_TTL", 30*time.Minute)
+	if err := dao.InsertPasswordResetToken(ctx, hashResetToken(token), username, time.Now().Add(ttl)); err != nil {
+		return err
+	}
+
+	msg.Subject = "Reset your password"
+	msg.Body = fmt.Sprintf("Use this token to reset the password of %s: %s\nIt expires in %d minutes. Ignore this message if you did not request it.",
+		username, token, int(ttl.Minutes()))
+	if base := config.String("FILESTORE_PUBLIC_URL", ""); base != "" {
+		msg.Body += fmt.Sprintf("\n%s/user/password/reset?token=%s", strings.TrimRight(base, "/"), token)
+	}
+	if err := sendNotification(ctx, msg); err != nil {
+		return fmt.Errorf("failed to send reset token: %w", err)
+	}
+	audit(ctx, username, "user.password_reset_request", username, "channel="+msg.Channel)
+	return nil
+}
+
+// ResetPassword 用重置令牌设置新密码。令牌只能使用一次，使用后用户的全部会话失效。
+func ResetPassword(ctx context.Context, token, password string) error {
+	if password == "" {
+		return ErrPasswordRequired
+	}
+	// 令牌在校验密码规则之前不会被消耗，违反规则时用户可以换一个密码重试。
+	username, err := dao.GetPasswordResetTokenUser(ctx, hashResetToken(strings.TrimSpace(token)))
+	if err != nil {
+		if errors.Is(err, dao.ErrResetTokenNotFound) {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+	if err := ValidatePassword(username, password); err != nil {
+		return err
+	}
+	if _, err := dao.ConsumePasswordResetToken(ctx, hashResetToken(strings.TrimSpace(token))); err != nil {
+		if errors.Is(err, dao.ErrResetTokenNotFound) {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+	if err := setPassword(ctx, username, password); err != nil {
+		return err
+	}
+	audit(ctx, username, "user.password_reset", username, "")
+	return nil
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
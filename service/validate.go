@@ -0,0 +1,245 @@
+package service
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"filestore-server/pkg/config"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// bcryptMaxPasswordLen 是 bcrypt 能处理的最大密码字节数，超出部分会被拒绝。
+const bcryptMaxPasswordLen = 72
+
+const defaultUsernamePattern = `^[A-Za-z0-9][A-Za-z0-9_.-]*$`
+
+// defaultReservedUsernames 包括审计日志中使用的操作者名称，避免与真实用户混淆。
+var defaultReservedUsernames = []string{"admin", "administrator", "root", "system", "cli", "support", "api", "team", "null"}
+
+// PolicyViolation 是注册或设置密码时违反的一条规则。
+type PolicyViolation struct {
+	// Field 为 "username" 或 "password"。
+	Field string `json:"field"`
+	// Rule 为规则名，如 min_length、charset、reserved、classes、breached。
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PolicyError 列出用户名或密码违反的全部规则。
+type PolicyError struct {
+	Violations []PolicyViolation
+}
+
+func (e *PolicyError) Error() string {
+	msgs := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		msgs = append(msgs, v.Message)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// policyErr 在有违反的规则时返回 *PolicyError，否则返回 nil。
+func policyErr(violations []PolicyViolation) error {
+	if len(violations) == 0 {
+		return nil
+	}
+	return &PolicyError{Violations: violations}
+}
+
+var (
+	usernamePatternMu  sync.Mutex
+	usernamePatternSrc string
+	usernamePatternRe  *regexp.Regexp
+)
+
+// usernamePattern 返回配置的用户名字符规则，配置无法编译时退回默认规则。
+func usernamePattern() *regexp.Regexp {
+	src := config.String("FILESTORE_USERNAME_PATTERN", defaultUsernamePattern)
+	usernamePatternMu.Lock()
+	defer usernamePatternMu.Unlock()
+	if usernamePatternRe == nil || src != usernamePatternSrc {
+		re, err := regexp.Compile(src)
+		if err != nil {
+			log.Printf("invalid FILESTORE_USERNAME_PATTERN %q: %v", src, err)
+			re = regexp.MustCompile(defaultUsernamePattern)
+		}
+		usernamePatternSrc, usernamePatternRe = src, re
+	}
+	return usernamePatternRe
+}
+
+// reservedUsernames 返回不能注册的用户名（小写），FILESTORE_RESERVED_USERNAMES 以逗号分隔，设置后替换默认列表。
+func reservedUsernames() []string {
+	v := config.String("FILESTORE_RESERVED_USERNAMES", "")
+	if v == "" {
+		return defaultReservedUsernames
+	}
+	var names []string
+	for _, name := range strings.Split(v, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// usernameViolations 检查用户名的长度、字符和保留名规则。
+func usernameViolations(username string) []PolicyViolation {
+	var vs []PolicyViolation
+	add := func(rule, format string, args ...any) {
+		vs = append(vs, PolicyViolation{Field: "username", Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	minLen := config.Int("FILESTORE_USERNAME_MIN_LEN", 3)
+	// tbl_user.user_name 为 varchar(64)。
+	maxLen := min(config.Int("FILESTORE_USERNAME_MAX_LEN", 64), 64)
+	n := utf8.RuneCountInString(username)
+	if n < minLen {
+		add("min_length", "username must be at least %d characters", minLen)
+	}
+	if n > maxLen {
+		add("max_length", "username must be at most %d characters", maxLen)
+	}
+	// 团队文件以 "team:<id>" 作为所有者保存，无论如何配置字符规则，用户名都不能包含 ':' 或控制字符。
+	if !utf8.ValidString(username) || strings.ContainsFunc(username, unicode.IsControl) ||
+		strings.Contains(username, ":") || !usernamePattern().MatchString(username) {
+		add("charset", "username contains characters that are not allowed")
+	}
+	lower := strings.ToLower(username)
+	for _, name := range reservedUsernames() {
+		if lower == name {
+			add("reserved", "username is reserved")
+			break
+		}
+	}
+	return vs
+}
+
+// passwordViolations 检查密码的长度、字符类别、是否包含用户名以及是否在泄露密码列表中。
+func passwordViolations(username, password string) []PolicyViolation {
+	var vs []PolicyViolation
+	add := func(rule, format string, args ...any) {
+		vs = append(vs, PolicyViolation{Field: "password", Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	minLen := config.Int("FILESTORE_PASSWORD_MIN_LEN", 8)
+	if utf8.RuneCountInString(password) < minLen {
+		add("min_length", "password must be at least %d characters", minLen)
+	}
+	if len(password) > bcryptMaxPasswordLen {
+		add("max_length", "password must be at most %d bytes", bcryptMaxPasswordLen)
+	}
+	minClasses := config.Int("FILESTORE_PASSWORD_MIN_CLASSES", 2)
+	if classes := passwordClasses(password); classes < minClasses {
+		add("classes", "password must contain at least %d of: lowercase letters, uppercase letters, digits, symbols", minClasses)
+	}
+	if username != "" && len(username) >= 3 && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		add("contains_username", "password must not contain the username")
+	}
+	if passwordBreached(password) {
+		add("breached", "password appears in a list of breached passwords")
+	}
+	return vs
+}
+
+// passwordClasses 统计密码包含的字符类别数：小写字母、大写字母、数字、其他符号。
+func passwordClasses(password string) int {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	n := 0
+	for _, ok := range []bool{lower, upper, digit, other} {
+		if ok {
+			n++
+		}
+	}
+	return n
+}
+
+// ValidateCredentials 按配置的规则校验注册时的用户名和密码，返回列出全部违反规则的 *PolicyError。
+func ValidateCredentials(username, password string) error {
+	return policyErr(append(usernameViolations(username), passwordViolations(username, password)...))
+}
+
+// ValidatePassword 按配置的规则校验 username 的新密码。
+func ValidatePassword(username, password string) error {
+	return policyErr(passwordViolations(username, password))
+}
+
+var (
+	breachedMu   sync.Mutex
+	breachedPath string
+	breachedSet  map[string]struct{}
+)
+
+// passwordBreached 判断密码是否出现在 FILESTORE_BREACHED_PASSWORDS_FILE 指定的本地列表中。
+// 列表每行一个明文密码，或一个 SHA-1（十六进制，可带 HIBP 格式的 ":次数" 后缀）。
+func passwordBreached(password string) bool {
+	set := breachedPasswords()
+	if len(set) == 0 {
+		return false
+	}
+	sum := sha1.Sum([]byte(password))
+	_, ok := set[strings.ToUpper(hex.EncodeToString(sum[:]))]
+	return ok
+}
+
+// breachedPasswords 加载并缓存泄露密码列表的 SHA-1 集合，路径变化时重新加载。
+func breachedPasswords() map[string]struct{} {
+	path := config.String("FILESTORE_BREACHED_PASSWORDS_FILE", "")
+	breachedMu.Lock()
+	defer breachedMu.Unlock()
+	if path == breachedPath && (breachedSet != nil || path == "") {
+		return breachedSet
+	}
+	breachedPath, breachedSet = path, nil
+	if path == "" {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		// 缓存空集合，避免每次校验都重试并写日志。
+		log.Printf("failed to open breached password list: %v", err)
+		breachedSet = map[string]struct{}{}
+		return breachedSet
+	}
+	defer f.Close()
+
+	set := make(map[string]struct{})
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if h, _, _ := strings.Cut(line, ":"); isSha1Hex(h) {
+			set[strings.ToUpper(h)] = struct{}{}
+			continue
+		}
+		sum := sha1.Sum([]byte(line))
+		set[strings.ToUpper(hex.EncodeToString(sum[:]))] = struct{}{}
+	}
+	if err := sc.Err(); err != nil {
+		log.Printf("failed to read breached password list: %v", err)
+	}
+	breachedSet = set
+	return set
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"filestore-server/pkg/dao"
+	"fmt"
+	"log"
+)
+
+const (
+	// defaultMaxVersions 是用户未设置时每个路径保留的版本数。
+	defaultMaxVersions = 10
+	// MaxVersionsLimit 是用户可设置的版本保留数上限。
+	MaxVersionsLimit = 100
+)
+
+// ErrInvalidMaxVersions 表示版本保留数超出允许范围。
+var ErrInvalidMaxVersions = errors.New("invalid max versions")
+
+// SaveUserFileVersion 把上传结果记为用户路径的当前版本，并按用户的保留策略清理旧版本；超过配额时返回 ErrQuotaExceeded。
+// 清理掉的版本的内容不再被任何用户文件、版本或待接收的分享引用时随之删除。
+func SaveUserFileVersion(ctx context.Context, username string, fmeta dao.FileMeta) error {
+	if err := CheckUploadQuota(ctx, username, fmeta.FileName, fmeta.FileSize); err != nil {
+		return err
+	}
+	keep, err := userMaxVersions(ctx, username)
+	if err != nil {
+		return err
+	}
+	pruned, err := dao.SaveUserFileVersion(ctx, username, fmeta.FileName, fmeta.FileSha1, fmeta.FileSize, keep)
+	if err != nil {
+		return fmt.Errorf("failed to save user file version: %w", err)
+	}
+	// 版本已保存，回收失败只记录日志，内容留待下次删除时再回收。
+	for _, filehash := range pruned {
+		if err := reclaimBlob(ctx, filehash); err != nil {
+			log.Printf("failed to reclaim pruned version %s: %v", filehash, err)
+		}
+	}
+	return nil
+}
+
+// ListFileVersions 列出用户路径下保留的版本。
+func ListFileVersions(ctx context.Context, username, filename string) ([]dao.FileVersion, error) {
+	return dao.GetUserFileVersions(ctx, username, filename)
+}
+
+// DownloadFileVersion 下载用户路径下的指定版本，文件名使用用户路径而不是内容最初的文件名。
+func DownloadFileVersion(ctx context.Context, username, filename, filehash string, acceptEncoding func(string) bool) (dao.FileMeta, FileContent, error) {
+	if _, err := dao.GetUserFileVersion(ctx, username, filename, filehash); err != nil {
+		return dao.FileMeta{}, FileContent{}, err
+	}
+
+	// 版本记录已确认该内容属于 username 的这个路径，旧版本可能不在当前文件列表中。
+	fmeta, content, err := openFile(ctx, filehash, acceptEncoding)
+	if err != nil {
+		return dao.FileMeta{}, FileContent{}, err
+	}
+	fmeta.FileName = filename
+	return fmeta, content, nil
+}
+
+// RestoreFileVersion 把旧版本恢复为当前版本，恢复本身会作为一个新版本记录下来。
+func RestoreFileVersion(ctx context.Context, username, filename, filehash string) error {
+	v, err := dao.GetUserFileVersion(ctx, username, filename, filehash)
+	if err != nil {
+		return err
+	}
+	return SaveUserFileVersion(ctx, username, dao.FileMeta{
+		FileSha1: v.FileSha1,
+		FileName: filename,
+		FileSize: v.FileSize,
+	})
+}
+
+// SetMaxVersions 设置用户每个路径保留的版本数，0 表示恢复系统默认值。
+func SetMaxVersions(ctx context.Context, username string, maxVersions int) error {
+	if maxVersions < 0 || maxVersions > MaxVersionsLimit {
+		return ErrInvalidMaxVersions
+	}
+	return dao.UpdateUserMaxVersions(ctx, username, maxVersions)
+}
+
+func userMaxVersions(ctx context.Context, username string) (int, error) {
+	// 团队空间没有单独的保留策略。
+	if _, ok := teamIDFromNamespace(username); ok {
+		return defaultMaxVersions, nil
+	}
+	keep, err := dao.GetUserMaxVersions(ctx, username)
+	if err != nil {
+		return 0, err
+	}
+	if keep <= 0 {
+		keep = defaultMaxVersions
+	}
+	return keep, nil
+}
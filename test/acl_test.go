@@ -0,0 +1,102 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestACL_FolderInheritanceAndEffectivePermissions(t *testing.T) {
+	requireDB(t)
+
+	r := newTestRouter()
+	aliceCookie, alice := signupAndLogin(t, r)
+	bobCookie, bob := signupAndLogin(t, r)
+
+	place := func(name, dir string) string {
+		t.Helper()
+		f := uploadForTest(t, r, aliceCookie, name, []byte(name+" "+randHex(16)))
+		if dir != "" {
+			if rr := postForm(t, r, aliceCookie, "/file/move", url.Values{"filehash": {f.FileSha1}, "dir": {dir}}); rr.Code != http.StatusOK {
+				t.Fatalf("move failed: %d body:%s", rr.Code, rr.Body.String())
+			}
+		}
+		return f.FileSha1
+	}
+	top := place("a_"+randHex(4)+".txt", "docs")
+	nested := place("b_"+randHex(4)+".txt", "docs/sub")
+	private := place("c_"+randHex(4)+".txt", "")
+
+	grant := func(path, permission, inherit string) {
+		t.Helper()
+		rr := postForm(t, r, aliceCookie, "/file/acl", url.Values{"path": {path}, "principal": {bob}, "permission": {permission}, "inherit": {inherit}})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("set acl failed: %d body:%s", rr.Code, rr.Body.String())
+		}
+	}
+	download := func(filehash string) int {
+		return getWithCookie(r, bobCookie, "/file/download?filehash="+filehash+"&user_name="+alice).Code
+	}
+
+	// 不继承的目录权限只对直接位于目录中的文件生效。
+	grant("docs", "read", "false")
+	if code := download(top); code != http.StatusOK {
+		t.Errorf("bob should read docs/, got %d", code)
+	}
+	if code := download(nested); code != http.StatusNotFound {
+		t.Errorf("non-inherited acl should not cover docs/sub, got %d", code)
+	}
+	if code := download(private); code != http.StatusNotFound {
+		t.Errorf("bob should not read unshared file, got %d", code)
+	}
+	// 不指定空间时只在 bob 自己的空间中查找。
+	if rr := getWithCookie(r, bobCookie, "/file/download?filehash="+top); rr.Code != http.StatusNotFound {
+		t.Errorf("download without user_name should only look in bob's space, got %d", rr.Code)
+	}
+	if code, resp := queryFilelist(t, r, bobCookie, alice, url.Values{}); code != http.StatusOK || len(resp.Files) != 1 || resp.Files[0].FileSha1 != top {
+		t.Errorf("shared listing should contain only docs/ file, got %d %+v", code, resp.Files)
+	}
+
+	grant("docs", "read", "true")
+	if code := download(nested); code != http.StatusOK {
+		t.Errorf("inherited acl should cover docs/sub, got %d", code)
+	}
+	if code, resp := queryFilelist(t, r, bobCookie, alice, url.Values{}); code != http.StatusOK || len(resp.Files) != 2 {
+		t.Errorf("shared listing should contain both docs files, got %d %+v", code, resp.Files)
+	}
+
+	// 读权限不能删除，ACL 只能授予 read 或 write。
+	if rr := postForm(t, r, bobCookie, "/file/delete", url.Values{"filehash": {top}, "user_name": {alice}}); rr.Code != http.StatusForbidden {
+		t.Errorf("read-only delete should be forbidden, got %d", rr.Code)
+	}
+	rr := postForm(t, r, aliceCookie, "/file/acl", url.Values{"path": {"docs/sub"}, "principal": {bob}, "permission": {"admin"}})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("unknown permission should be rejected, got %d", rr.Code)
+	}
+
+	var perms struct {
+		Access []struct {
+			Permission string
+			Sources    []string
+		}
+	}
+	rr = getWithCookie(r, bobCookie, "/file/permissions?filehash="+nested)
+	json.Unmarshal(rr.Body.Bytes(), &perms)
+	if rr.Code != http.StatusOK || len(perms.Access) != 1 || perms.Access[0].Permission != "read" {
+		t.Errorf("unexpected effective permissions: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := getWithCookie(r, bobCookie, "/file/permissions?filehash="+nested+"&user="+alice); rr.Code != http.StatusForbidden {
+		t.Errorf("reader should not inspect others' permissions, got %d", rr.Code)
+	}
+	if rr := getWithCookie(r, aliceCookie, "/file/permissions?filehash="+private+"&user="+bob); rr.Code != http.StatusOK {
+		t.Errorf("owner should inspect permissions, got %d", rr.Code)
+	}
+
+	if rr := postForm(t, r, aliceCookie, "/file/acl/delete", url.Values{"path": {"docs"}, "principal": {bob}}); rr.Code != http.StatusOK {
+		t.Fatalf("delete acl failed: %d", rr.Code)
+	}
+	if code := download(top); code != http.StatusNotFound {
+		t.Errorf("revoked acl should remove access, got %d", code)
+	}
+}
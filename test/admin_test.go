@@ -0,0 +1,138 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"filestore-server/pkg/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+func login(r *gin.Engine, username, password string) int {
+	form := url.Values{"username": {username}, "password": {password}}
+	req := httptest.NewRequest("POST", "/user/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr.Code
+}
+
+func TestAdminUsers_StatusQuotaAndPassword(t *testing.T) {
+	requireDB(t)
+
+	r := newTestRouter()
+	adminCookie, adminName := signupAndLogin(t, r)
+	userCookie, username := signupAndLogin(t, r)
+
+	if rr := getWithCookie(r, adminCookie, "/admin/users"); rr.Code != http.StatusForbidden {
+		t.Fatalf("non-admin should be forbidden, got %d", rr.Code)
+	}
+	if _, err := db.DBconn().ExecContext(context.Background(), "update tbl_user set role='admin' where user_name=?", adminName); err != nil {
+		t.Fatalf("failed to promote admin: %v", err)
+	}
+
+	uploadForTest(t, r, userCookie, "usage_"+randHex(4)+".txt", []byte("0123456789"))
+
+	rr := getWithCookie(r, adminCookie, "/admin/users?q="+username)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("list users failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	var listed struct {
+		Total int
+		Users []struct {
+			UserName    string `json:"user_name"`
+			Status      string `json:"status"`
+			StorageUsed int64  `json:"storage_used"`
+			FileCount   int    `json:"file_count"`
+		}
+	}
+	json.Unmarshal(rr.Body.Bytes(), &listed)
+	if listed.Total != 1 || len(listed.Users) != 1 || listed.Users[0].UserName != username {
+		t.Fatalf("unexpected search result: %s", rr.Body.String())
+	}
+	if u := listed.Users[0]; u.Status != "active" || u.StorageUsed != 10 || u.FileCount != 1 {
+		t.Errorf("unexpected usage: %+v", u)
+	}
+
+	if rr := postForm(t, r, adminCookie, "/admin/users/"+username+"/quota", url.Values{"quota": {"4096"}}); rr.Code != http.StatusOK {
+		t.Fatalf("update quota failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	var detail struct {
+		Quota int64 `json:"quota"`
+	}
+	json.Unmarshal(getWithCookie(r, adminCookie, "/admin/users/"+username).Body.Bytes(), &detail)
+	if detail.Quota != 4096 {
+		t.Errorf("quota not updated: %d", detail.Quota)
+	}
+
+	rr = postForm(t, r, adminCookie, "/admin/users/"+username+"/password", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("reset password failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	var reset struct{ Password string }
+	json.Unmarshal(rr.Body.Bytes(), &reset)
+	if reset.Password == "" {
+		t.Fatal("expected generated password")
+	}
+	if code := login(r, username, reset.Password); code != http.StatusOK {
+		t.Errorf("login with new password failed: %d", code)
+	}
+
+	// 禁用后现有会话和重新登录都被拒绝。
+	if rr := postForm(t, r, adminCookie, "/admin/users/"+username+"/disable", nil); rr.Code != http.StatusOK {
+		t.Fatalf("disable failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	if rr := getWithCookie(r, userCookie, "/user/tags"); rr.Code != http.StatusForbidden {
+		t.Errorf("disabled session should be rejected, got %d", rr.Code)
+	}
+	if code := login(r, username, reset.Password); code != http.StatusForbidden {
+		t.Errorf("disabled login should be forbidden, got %d", code)
+	}
+	if rr := postForm(t, r, adminCookie, "/admin/users/"+adminName+"/lock", nil); rr.Code != http.StatusConflict {
+		t.Errorf("admin should not lock themselves, got %d", rr.Code)
+	}
+	if rr := postForm(t, r, adminCookie, "/admin/users/"+username+"/enable", nil); rr.Code != http.StatusOK {
+		t.Fatalf("enable failed: %d", rr.Code)
+	}
+	if rr := getWithCookie(r, userCookie, "/user/tags"); rr.Code != http.StatusOK {
+		t.Errorf("enabled session should work again, got %d", rr.Code)
+	}
+
+	if rr := postForm(t, r, adminCookie, "/admin/users/nobody_"+randHex(4)+"/disable", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown user should return 404, got %d", rr.Code)
+	}
+}
+
+func TestUploadQuota_RejectsOverQuotaUpload(t *testing.T) {
+	requireDB(t)
+
+	r := newTestRouter()
+	cookie, username := signupAndLogin(t, r)
+	if _, err := db.DBconn().ExecContext(context.Background(), "update tbl_user set quota=? where user_name=?", 64, username); err != nil {
+		t.Fatalf("failed to set quota: %v", err)
+	}
+
+	name := "quota_" + randHex(4) + ".txt"
+	uploadForTest(t, r, cookie, name, []byte(randHex(20)))
+
+	upload := func(filename string, content []byte) *httptest.ResponseRecorder {
+		req, _ := createUploadRequest("file", filename, content)
+		req.AddCookie(cookie)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := upload("big_"+randHex(4)+".txt", []byte(randHex(16))); rr.Code != http.StatusInsufficientStorage {
+		t.Fatalf("upload over quota should fail with 507, got %d body:%s", rr.Code, rr.Body.String())
+	}
+	// 覆盖同名文件只计算增加的部分。
+	if rr := upload(name, []byte(randHex(24))); rr.Code != http.StatusOK {
+		t.Errorf("replacing a file within quota should succeed, got %d body:%s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,202 @@
+package test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"filestore-server/pkg/dao"
+	"filestore-server/pkg/db"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBatchDownload_Zip(t *testing.T) {
+	requireDB(t)
+
+	r := newTestRouter()
+	sessionCookie, _ := signupAndLogin(t, r)
+
+	tmpDir := "./tmp"
+	if err := os.MkdirAll(tmpDir, 0o755); err != nil {
+		t.Fatalf("failed to create tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	want := map[string]string{
+		"batch_a_" + randHex(4) + ".txt": "a_" + randHex(8),
+		"batch_b_" + randHex(4) + ".txt": "b_" + randHex(8),
+	}
+	query := ""
+	for name, content := range want {
+		req, err := createUploadRequest("file", name, []byte(content))
+		if err != nil {
+			t.Fatalf("create request failed: %v", err)
+		}
+		req.AddCookie(sessionCookie)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("upload failed: %d body:%s", rr.Code, rr.Body.String())
+		}
+		var resp struct {
+			File dao.FileMeta `json:"file"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to unmarshal upload response: %v", err)
+		}
+		query += "&filehash=" + resp.File.FileSha1
+	}
+
+	req := httptest.NewRequest("GET", "/file/batch/download?format=zip"+query, nil)
+	req.AddCookie(sessionCookie)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("batch download failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatalf("invalid zip: %v", err)
+	}
+	if len(zr.File) != len(want) {
+		t.Fatalf("zip entries mismatch: got %d want %d", len(zr.File), len(want))
+	}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("open entry failed: %v", err)
+		}
+		got, _ := io.ReadAll(rc)
+		rc.Close()
+		if string(got) != want[f.Name] {
+			t.Errorf("entry %s content mismatch", f.Name)
+		}
+	}
+
+	// 不属于调用者的文件不能被打包。
+	req = httptest.NewRequest("GET", "/file/batch/download?filehash="+randHex(20), nil)
+	req.AddCookie(sessionCookie)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for foreign file, got %d", rr.Code)
+	}
+}
+
+func TestBatchDownload_Dir(t *testing.T) {
+	requireDB(t)
+
+	r := newTestRouter()
+	sessionCookie, username := signupAndLogin(t, r)
+
+	tmpDir := "./tmp"
+	if err := os.MkdirAll(tmpDir, 0o755); err != nil {
+		t.Fatalf("failed to create tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	want := map[string]string{"a.txt": "a_" + randHex(8), "sub/b.txt": "b_" + randHex(8)}
+	for name, content := range want {
+		dir, base := "docs", name
+		if i := strings.LastIndex(name, "/"); i >= 0 {
+			dir, base = "docs/"+name[:i], name[i+1:]
+		}
+		f := uploadForTest(t, r, sessionCookie, base, []byte(content))
+		if rr := postForm(t, r, sessionCookie, "/file/move", url.Values{"filehash": {f.FileSha1}, "dir": {dir}}); rr.Code != http.StatusOK {
+			t.Fatalf("move failed: %d body:%s", rr.Code, rr.Body.String())
+		}
+	}
+	uploadForTest(t, r, sessionCookie, "outside_"+randHex(4)+".txt", []byte("c_"+randHex(8)))
+
+	modified := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	if _, err := db.DBconn().ExecContext(context.Background(), "update tbl_user_file set last_update=? where user_name=? and file_name='docs/a.txt'", modified, username); err != nil {
+		t.Fatalf("failed to set last_update: %v", err)
+	}
+
+	rr := getWithCookie(r, sessionCookie, "/file/batch/download?format=zip&dir=/docs/")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("dir download failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	zr, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatalf("invalid zip: %v", err)
+	}
+	if len(zr.File) != len(want) {
+		t.Fatalf("zip entries mismatch: got %d want %d", len(zr.File), len(want))
+	}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("open entry failed: %v", err)
+		}
+		got, _ := io.ReadAll(rc)
+		rc.Close()
+		if string(got) != want[f.Name] {
+			t.Errorf("entry %s content mismatch", f.Name)
+		}
+		if f.Name == "a.txt" && !f.Modified.Equal(modified) {
+			t.Errorf("entry modified time should come from last_update: %v", f.Modified)
+		}
+	}
+
+	if rr := getWithCookie(r, sessionCookie, "/file/batch/download?dir=../etc"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for unsafe dir, got %d", rr.Code)
+	}
+	if rr := getWithCookie(r, sessionCookie, "/file/batch/download?dir=missing_"+randHex(4)); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for empty dir, got %d", rr.Code)
+	}
+}
+
+func TestBatchDownload_AbortsConnectionOnMidStreamError(t *testing.T) {
+	requireDB(t)
+
+	r := newTestRouter()
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	sessionCookie, _ := signupAndLogin(t, r)
+
+	tmpDir := "./tmp"
+	if err := os.MkdirAll(tmpDir, 0o755); err != nil {
+		t.Fatalf("failed to create tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// 第一个文件足够大，保证响应头和部分数据在第二个文件出错前已经发出。
+	big := make([]byte, 256<<10)
+	if _, err := rand.Read(big); err != nil {
+		t.Fatalf("failed to generate content: %v", err)
+	}
+	first := uploadForTest(t, r, sessionCookie, "abort_a_"+randHex(4)+".bin", big)
+	second := uploadForTest(t, r, sessionCookie, "abort_b_"+randHex(4)+".txt", []byte("b_"+randHex(8)))
+	fmeta, exists, err := dao.GetFileExist(context.Background(), second.FileSha1)
+	if err != nil || !exists {
+		t.Fatalf("uploaded file should exist: exists=%v err=%v", exists, err)
+	}
+	if err := os.Remove(fmeta.Location); err != nil {
+		t.Fatalf("failed to remove blob: %v", err)
+	}
+
+	req, err := http.NewRequest("GET", srv.URL+"/file/batch/download?format=zip&filehash="+first.FileSha1+"&filehash="+second.FileSha1, nil)
+	if err != nil {
+		t.Fatalf("create request failed: %v", err)
+	}
+	req.AddCookie(sessionCookie)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// 连接在响应头发出前就被断开，同样说明没有返回完整响应。
+		return
+	}
+	defer resp.Body.Close()
+	if _, err := io.ReadAll(resp.Body); err == nil {
+		t.Fatalf("expected truncated archive to fail the read, got complete %d response", resp.StatusCode)
+	}
+}
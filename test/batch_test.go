@@ -0,0 +1,126 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"filestore-server/pkg/dao"
+	"filestore-server/service"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func postBatch(t *testing.T, r *gin.Engine, cookie *http.Cookie, idemKey string, req service.BatchRequest) (*httptest.ResponseRecorder, service.BatchResult) {
+	t.Helper()
+	body, _ := json.Marshal(req)
+	httpReq := httptest.NewRequest("POST", "/file/batch", bytes.NewReader(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+	if idemKey != "" {
+		httpReq.Header.Set("Idempotency-Key", idemKey)
+	}
+	httpReq.AddCookie(cookie)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httpReq)
+
+	var result service.BatchResult
+	if rr.Code == http.StatusOK {
+		if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+			t.Fatalf("failed to unmarshal batch result: %v", err)
+		}
+	}
+	return rr, result
+}
+
+func TestFileBatch_PartialFailureAndIdempotency(t *testing.T) {
+	requireDB(t)
+
+	r := newTestRouter()
+	cookie, _ := signupAndLogin(t, r)
+
+	a := uploadForTest(t, r, cookie, "a_"+randHex(4)+".txt", []byte("a "+randHex(16)))
+	b := uploadForTest(t, r, cookie, "b_"+randHex(4)+".txt", []byte("b "+randHex(16)))
+	c := uploadForTest(t, r, cookie, "c_"+randHex(4)+".txt", []byte("c "+randHex(16)))
+
+	req := service.BatchRequest{Operations: []service.BatchOp{
+		{Op: service.BatchOpRename, FileHash: a.FileSha1, Name: "renamed.txt"},
+		{Op: service.BatchOpMove, FileHash: b.FileSha1, Dir: "docs/2024"},
+		{Op: service.BatchOpTag, FileHash: c.FileSha1, Tags: []string{"keep"}},
+		{Op: service.BatchOpDelete, FileHash: randHex(20)},
+		{Op: service.BatchOpDelete, FileHash: c.FileSha1},
+	}}
+	key := "key-" + randHex(8)
+	rr, result := postBatch(t, r, cookie, key, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("batch failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	if result.Succeeded != 4 || result.Failed != 1 || result.Results[3].OK {
+		t.Fatalf("unexpected batch result %+v", result)
+	}
+	if result.Results[0].FileName != "renamed.txt" || result.Results[1].FileName != "docs/2024/"+b.FileName {
+		t.Errorf("unexpected new paths %+v", result.Results)
+	}
+
+	// 同一个键重试时直接返回第一次的结果，不会再执行一次。
+	rr, replay := postBatch(t, r, cookie, key, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("retry should be replayed: %d %v", rr.Code, rr.Header())
+	}
+	if replay.Succeeded != 4 || replay.Failed != 1 {
+		t.Errorf("replayed result differs: %+v", replay)
+	}
+
+	req.Atomic = true
+	if rr, _ := postBatch(t, r, cookie, key, req); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reusing key with a different request should return 422, got %d", rr.Code)
+	}
+
+	// 原子模式下任一操作失败则全部撤销。
+	rr, atomic := postBatch(t, r, cookie, "", service.BatchRequest{Atomic: true, Operations: []service.BatchOp{
+		{Op: service.BatchOpRename, FileHash: a.FileSha1, Name: "again.txt"},
+		{Op: service.BatchOpRename, FileHash: b.FileSha1, Name: "../escape"},
+	}})
+	if rr.Code != http.StatusOK || atomic.Succeeded != 0 || atomic.Results[0].Error != "rolled back" {
+		t.Fatalf("unexpected atomic result %d %+v", rr.Code, atomic)
+	}
+	if files := searchFiles(t, r, cookie, url.Values{"name": {"renamed.txt"}}); len(files) != 1 {
+		t.Errorf("atomic failure should keep earlier state, got %+v", files)
+	}
+	if files := searchFiles(t, r, cookie, url.Values{"name": {c.FileName}}); len(files) != 0 {
+		t.Errorf("deleted file still listed: %+v", files)
+	}
+
+	t.Setenv("FILESTORE_BATCH_MAX_OPS", "1")
+	if rr, _ := postBatch(t, r, cookie, "", service.BatchRequest{Operations: make([]service.BatchOp, 2)}); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized batch should return 413, got %d", rr.Code)
+	}
+}
+
+func TestFileBatch_DeleteReclaimsCurrentAndVersions(t *testing.T) {
+	requireDB(t)
+
+	r := newTestRouter()
+	cookie, _ := signupAndLogin(t, r)
+
+	name := "doc_" + randHex(4) + ".txt"
+	v1 := uploadForTest(t, r, cookie, name, []byte("v1 "+randHex(16)))
+	v2 := uploadForTest(t, r, cookie, name, []byte("v2 "+randHex(16)))
+
+	rr, result := postBatch(t, r, cookie, "", service.BatchRequest{Operations: []service.BatchOp{
+		{Op: service.BatchOpDelete, FileHash: v2.FileSha1},
+	}})
+	if rr.Code != http.StatusOK || result.Succeeded != 1 {
+		t.Fatalf("batch delete failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+
+	// 当前内容和历史版本的内容都不再被引用，随删除一起回收。
+	ctx := context.Background()
+	for _, f := range []dao.FileMeta{v1, v2} {
+		if _, exists, err := dao.GetFileExist(ctx, f.FileSha1); err != nil || exists {
+			t.Errorf("content %s should be reclaimed: exists=%v err=%v", f.FileSha1, exists, err)
+		}
+	}
+}
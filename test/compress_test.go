@@ -0,0 +1,60 @@
+package test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"filestore-server/pkg/compress"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCompress_ShouldCompress(t *testing.T) {
+	logs := []byte(strings.Repeat("2025-12-11 10:00:00 INFO request served\n", 200))
+	if !compress.ShouldCompress("app.log", logs) {
+		t.Errorf("expected log file to be compressed")
+	}
+
+	random := make([]byte, 8<<10)
+	rand.Read(random)
+	if compress.ShouldCompress("blob.bin", random) {
+		t.Errorf("expected random data not to be compressed")
+	}
+	if compress.ShouldCompress("photo.jpg", logs) {
+		t.Errorf("expected jpeg not to be compressed")
+	}
+	if compress.ShouldCompress("small.txt", []byte("tiny")) {
+		t.Errorf("expected tiny file not to be compressed")
+	}
+}
+
+func TestCompress_RoundTrip(t *testing.T) {
+	plain := []byte(strings.Repeat("id,name,size\n1,a.csv,100\n", 500))
+	for _, alg := range []string{compress.Zstd, compress.Gzip} {
+		var buf bytes.Buffer
+		w, err := compress.NewWriter(alg, &buf)
+		if err != nil {
+			t.Fatalf("%s: new writer failed: %v", alg, err)
+		}
+		w.Write(plain)
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s: close failed: %v", alg, err)
+		}
+		if buf.Len() >= len(plain) {
+			t.Errorf("%s: compressed size %d not smaller than %d", alg, buf.Len(), len(plain))
+		}
+
+		r, err := compress.NewReader(alg, &buf)
+		if err != nil {
+			t.Fatalf("%s: new reader failed: %v", alg, err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("%s: decompress failed: %v", alg, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("%s: round trip mismatch", alg)
+		}
+	}
+}
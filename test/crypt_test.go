@@ -0,0 +1,98 @@
+package test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"filestore-server/pkg/crypt"
+	"io"
+	"testing"
+)
+
+func TestCrypt_StreamRoundTripAndSeek(t *testing.T) {
+	dataKey := make([]byte, crypt.DataKeySize)
+	rand.Read(dataKey)
+	plain := make([]byte, 3*crypt.ChunkSize+123)
+	rand.Read(plain)
+
+	var sealed bytes.Buffer
+	w, err := crypt.NewEncryptWriter(&sealed, dataKey)
+	if err != nil {
+		t.Fatalf("new encrypt writer failed: %v", err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatalf("encrypt failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	if int64(sealed.Len()) != crypt.EncryptedSize(int64(len(plain))) {
+		t.Fatalf("encrypted size mismatch: got %d want %d", sealed.Len(), crypt.EncryptedSize(int64(len(plain))))
+	}
+
+	r, err := crypt.NewDecryptReader(bytes.NewReader(sealed.Bytes()), dataKey, int64(len(plain)))
+	if err != nil {
+		t.Fatalf("new decrypt reader failed: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("decrypt failed: %v", err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatalf("decrypted content mismatch")
+	}
+
+	// 跨分块的随机读取，模拟 Range 请求。
+	offset := int64(crypt.ChunkSize - 10)
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		t.Fatalf("seek failed: %v", err)
+	}
+	part := make([]byte, 20)
+	if _, err := io.ReadFull(r, part); err != nil {
+		t.Fatalf("read after seek failed: %v", err)
+	}
+	if !bytes.Equal(part, plain[offset:offset+20]) {
+		t.Fatalf("range content mismatch")
+	}
+
+	// 截掉最后一块后解密必须失败。
+	truncated := sealed.Bytes()[:3*(crypt.ChunkSize+16)]
+	tr, _ := crypt.NewDecryptReader(bytes.NewReader(truncated), dataKey, 3*crypt.ChunkSize)
+	if _, err := io.ReadAll(tr); err == nil {
+		t.Fatalf("expected truncated ciphertext to fail")
+	}
+}
+
+func TestCrypt_KeyringRewrap(t *testing.T) {
+	oldKey := make([]byte, 32)
+	newKey := make([]byte, 32)
+	rand.Read(oldKey)
+	rand.Read(newKey)
+
+	oldRing, err := crypt.NewKeyring(map[string][]byte{"k1": oldKey}, "k1")
+	if err != nil {
+		t.Fatalf("new keyring failed: %v", err)
+	}
+	dataKey, keyID, wrapped, err := oldRing.NewDataKey()
+	if err != nil {
+		t.Fatalf("new data key failed: %v", err)
+	}
+
+	ring, err := crypt.NewKeyring(map[string][]byte{"k1": oldKey, "k2": newKey}, "k2")
+	if err != nil {
+		t.Fatalf("new keyring failed: %v", err)
+	}
+	newID, rewrapped, err := ring.Rewrap(keyID, wrapped)
+	if err != nil {
+		t.Fatalf("rewrap failed: %v", err)
+	}
+	if newID != "k2" {
+		t.Fatalf("rewrap key id mismatch: got %s want k2", newID)
+	}
+	got, err := ring.Unwrap(newID, rewrapped)
+	if err != nil {
+		t.Fatalf("unwrap failed: %v", err)
+	}
+	if !bytes.Equal(got, dataKey) {
+		t.Fatalf("data key changed after rewrap")
+	}
+}
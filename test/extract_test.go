@@ -0,0 +1,240 @@
+package test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"filestore-server/pkg/dao"
+	"filestore-server/pkg/db"
+	"filestore-server/pkg/queue"
+	"filestore-server/service"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func buildZip(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("create zip entry failed: %v", err)
+		}
+		w.Write(content)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("close zip failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func uploadAndExtract(t *testing.T, r *gin.Engine, sessionCookie *http.Cookie, archive []byte) (queue.Job, service.ExtractResult) {
+	t.Helper()
+	return uploadAndExtractAs(t, r, sessionCookie, "bundle_"+randHex(4)+".zip", archive)
+}
+
+// uploadAndExtractAs 以 archiveName 上传压缩包后解压。
+func uploadAndExtractAs(t *testing.T, r *gin.Engine, sessionCookie *http.Cookie, archiveName string, archive []byte) (queue.Job, service.ExtractResult) {
+	t.Helper()
+	req, err := createUploadRequest("file", archiveName, archive)
+	if err != nil {
+		t.Fatalf("create request failed: %v", err)
+	}
+	req.AddCookie(sessionCookie)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("upload failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	var upload struct {
+		File dao.FileMeta `json:"file"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &upload)
+	return extractAndWait(t, r, sessionCookie, url.Values{"filehash": {upload.File.FileSha1}})
+}
+
+// extractAndWait 创建解压任务并等待任务结束。
+func extractAndWait(t *testing.T, r *gin.Engine, sessionCookie *http.Cookie, params url.Values) (queue.Job, service.ExtractResult) {
+	t.Helper()
+	rr := postForm(t, r, sessionCookie, "/file/extract", params)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("extract failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	var started struct {
+		JobID string `json:"job_id"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &started)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		rr = getWithCookie(r, sessionCookie, "/jobs/"+started.JobID)
+		var job queue.Job
+		json.Unmarshal(rr.Body.Bytes(), &job)
+		if job.Status == queue.StatusDone || job.Status == queue.StatusDead {
+			var result service.ExtractResult
+			json.Unmarshal(job.Result, &result)
+			return job, result
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("extract job did not finish in time")
+	return queue.Job{}, service.ExtractResult{}
+}
+
+func TestFileExtract_Zip(t *testing.T) {
+	requireDB(t)
+
+	r := newTestRouter()
+	sessionCookie, username := signupAndLogin(t, r)
+
+	tmpDir := "./tmp"
+	if err := os.MkdirAll(tmpDir, 0o755); err != nil {
+		t.Fatalf("failed to create tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	a := []byte("a_" + randHex(8))
+	bundle := "bundle_" + randHex(4)
+	job, result := uploadAndExtractAs(t, r, sessionCookie, bundle+".zip", buildZip(t, map[string][]byte{
+		"data/a.txt": a,
+	}))
+	if job.Status != queue.StatusDone {
+		t.Fatalf("extract job failed: %s", job.Error)
+	}
+	// 解压到以压缩包命名的新目录，保留包内目录结构。
+	if len(result.Extracted) != 1 || result.Extracted[0] != bundle+"/data/a.txt" {
+		t.Fatalf("extracted files mismatch: %v", result.Extracted)
+	}
+	assertUserFileMeta(t, username, sha1Hex(a), bundle+"/data/a.txt", int64(len(a)))
+
+	slip, _ := uploadAndExtract(t, r, sessionCookie, buildZip(t, map[string][]byte{
+		"../../etc/evil_" + randHex(4): []byte("evil"),
+	}))
+	if slip.Status != queue.StatusDead {
+		t.Errorf("expected zip slip entry to fail the job")
+	}
+
+	bomb, _ := uploadAndExtract(t, r, sessionCookie, buildZip(t, map[string][]byte{
+		"zeros_" + randHex(4) + ".bin": make([]byte, 4<<20),
+	}))
+	if bomb.Status != queue.StatusDead {
+		t.Errorf("expected high ratio entry to fail the job")
+	}
+}
+
+func TestFileExtract_IntoTeamSpace(t *testing.T) {
+	requireDB(t)
+
+	r := newTestRouter()
+	ownerCookie, _ := signupAndLogin(t, r)
+	editorCookie, editor := signupAndLogin(t, r)
+
+	rr := postForm(t, r, ownerCookie, "/team/create", url.Values{"name": {"team " + randHex(4)}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create team failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	var created struct{ ID int64 }
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	team := strconv.FormatInt(created.ID, 10)
+	joinTeam(t, r, ownerCookie, editorCookie, team, editor, "editor")
+
+	a := []byte("team_a_" + randHex(8))
+	req, _ := createUploadRequest("file", "bundle_"+randHex(4)+".zip", buildZip(t, map[string][]byte{"a.txt": a}))
+	req.URL.RawQuery = "team=" + team
+	req.AddCookie(editorCookie)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("team upload failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	var upload struct {
+		File dao.FileMeta `json:"file"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &upload)
+
+	// 任务属于发起解压的成员，由其查询进度；文件保存到团队空间。
+	job, result := extractAndWait(t, r, editorCookie, url.Values{"filehash": {upload.File.FileSha1}, "team": {team}})
+	if job.Status != queue.StatusDone || len(result.Extracted) != 1 {
+		t.Fatalf("team extract failed: %s %v", job.Error, result.Extracted)
+	}
+	assertUserFileMeta(t, service.TeamNamespace(created.ID), sha1Hex(a), result.Extracted[0], int64(len(a)))
+
+	outsiderCookie, _ := signupAndLogin(t, r)
+	if rr := postForm(t, r, outsiderCookie, "/file/extract", url.Values{"filehash": {upload.File.FileSha1}}); rr.Code != http.StatusNotFound {
+		t.Errorf("outsider should not extract the team archive, got %d", rr.Code)
+	}
+}
+
+func TestFileExtract_AppliesTypePolicy(t *testing.T) {
+	requireDB(t)
+
+	r := newTestRouter()
+	sessionCookie, username := signupAndLogin(t, r)
+	group := "g_" + randHex(4)
+	ctx := context.Background()
+	if err := dao.SaveMimePolicy(ctx, dao.MimePolicy{GroupName: group, MimeType: "image/*", Action: dao.MimePolicyDeny}); err != nil {
+		t.Fatalf("save policy failed: %v", err)
+	}
+	if err := dao.UpdateUserGroup(ctx, username, group); err != nil {
+		t.Fatalf("update group failed: %v", err)
+	}
+
+	// 压缩包本身允许上传，但其中被禁止的类型不能借解压绕过规则。
+	pic := []byte("\x89PNG\r\n\x1a\n" + randHex(8))
+	job, _ := uploadAndExtract(t, r, sessionCookie, buildZip(t, map[string][]byte{"pic.bin": pic}))
+	if job.Status != queue.StatusDead {
+		t.Fatalf("denied entry should fail the job, got %s", job.Status)
+	}
+	var n int
+	db.DBconn().QueryRowContext(ctx, "select count(*) from tbl_user_file where user_name=? and file_sha1=?", username, sha1Hex(pic)).Scan(&n)
+	if n != 0 {
+		t.Errorf("denied entry should not be saved")
+	}
+}
+
+func TestFileExtract_DoesNotTouchExistingFiles(t *testing.T) {
+	requireDB(t)
+
+	r := newTestRouter()
+	sessionCookie, username := signupAndLogin(t, r)
+
+	tmpDir := "./tmp"
+	if err := os.MkdirAll(tmpDir, 0o755); err != nil {
+		t.Fatalf("failed to create tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// 空间里已有与压缩包同名目录下的同名文件。
+	bundle := "bundle_" + randHex(4)
+	existing := []byte("existing_" + randHex(8))
+	f := uploadForTest(t, r, sessionCookie, "a.txt", existing)
+	if rr := postForm(t, r, sessionCookie, "/file/move", url.Values{"filehash": {f.FileSha1}, "dir": {bundle}}); rr.Code != http.StatusOK {
+		t.Fatalf("move failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+
+	a := []byte("a_" + randHex(8))
+	job, result := uploadAndExtractAs(t, r, sessionCookie, bundle+".zip", buildZip(t, map[string][]byte{"a.txt": a}))
+	if job.Status != queue.StatusDone {
+		t.Fatalf("extract job failed: %s", job.Error)
+	}
+	if len(result.Extracted) != 1 || result.Extracted[0] != bundle+" (1)/a.txt" {
+		t.Fatalf("expected extraction into a fresh dir, got %v", result.Extracted)
+	}
+	assertUserFileMeta(t, username, sha1Hex(existing), bundle+"/a.txt", int64(len(existing)))
+	assertUserFileMeta(t, username, sha1Hex(a), bundle+" (1)/a.txt", int64(len(a)))
+
+	var versions int
+	db.DBconn().QueryRowContext(context.Background(), "select count(*) from tbl_user_file_version where user_name=? and file_name=?", username, bundle+"/a.txt").Scan(&versions)
+	if versions > 1 {
+		t.Errorf("existing file should not get a new version, got %d versions", versions)
+	}
+}
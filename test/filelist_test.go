@@ -0,0 +1,134 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"filestore-server/pkg/dao"
+	"filestore-server/pkg/db"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type filelistResp struct {
+	Total      *int           `json:"total"`
+	Files      []dao.FileMeta `json:"files"`
+	NextCursor string         `json:"next_cursor"`
+	PrevCursor string         `json:"prev_cursor"`
+}
+
+func queryFilelist(t *testing.T, r *gin.Engine, cookie *http.Cookie, username string, params url.Values) (int, filelistResp) {
+	t.Helper()
+	params.Set("user_name", username)
+	req := httptest.NewRequest("POST", "/user/filelist?"+params.Encode(), nil)
+	req.AddCookie(cookie)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	var resp filelistResp
+	if rr.Code == http.StatusOK {
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+	}
+	return rr.Code, resp
+}
+
+func fileNames(files []dao.FileMeta) []string {
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		names = append(names, f.FileName)
+	}
+	return names
+}
+
+func TestUserFilelistQuery_SortFilterAndCursor(t *testing.T) {
+	requireDB(t)
+
+	r := newTestRouter()
+	cookie, username := signupAndLogin(t, r)
+	conn := db.DBconn()
+	ctx := context.Background()
+
+	insert := func(name string, size int64) {
+		t.Helper()
+		_, err := conn.ExecContext(ctx,
+			"insert into tbl_user_file (user_name, file_sha1, file_size, file_name, upload_at, last_update, status) values (?,?,?,?,now(),now(),0)",
+			username, randHex(20), size, name)
+		if err != nil {
+			t.Fatalf("failed to seed user file meta: %v", err)
+		}
+	}
+	insert("b.txt", 400)
+	insert("d.txt", 100)
+	insert("a.txt", 300)
+	insert("c.txt", 200)
+
+	// 按文件名升序，用游标翻页。
+	code, first := queryFilelist(t, r, cookie, username, url.Values{"sort": {"name"}, "order": {"asc"}, "limit": {"2"}})
+	if code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if got := fileNames(first.Files); len(got) != 2 || got[0] != "a.txt" || got[1] != "b.txt" {
+		t.Fatalf("unexpected first page %v", got)
+	}
+	if first.Total == nil || *first.Total != 4 || first.NextCursor == "" || first.PrevCursor != "" {
+		t.Fatalf("unexpected first page cursors %+v", first)
+	}
+
+	// 翻页期间新增排在前面的文件，不影响下一页。
+	insert("0.txt", 50)
+	code, second := queryFilelist(t, r, cookie, username, url.Values{"sort": {"name"}, "order": {"asc"}, "limit": {"2"}, "cursor": {first.NextCursor}})
+	if code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if got := fileNames(second.Files); len(got) != 2 || got[0] != "c.txt" || got[1] != "d.txt" {
+		t.Fatalf("unexpected second page %v", got)
+	}
+	if second.Total != nil || second.NextCursor != "" || second.PrevCursor == "" {
+		t.Fatalf("unexpected second page cursors %+v", second)
+	}
+
+	code, back := queryFilelist(t, r, cookie, username, url.Values{"sort": {"name"}, "order": {"asc"}, "limit": {"2"}, "cursor": {second.PrevCursor}})
+	if code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if got := fileNames(back.Files); len(got) != 2 || got[0] != "a.txt" || got[1] != "b.txt" {
+		t.Fatalf("unexpected previous page %v", got)
+	}
+	if back.NextCursor == "" || back.PrevCursor == "" {
+		t.Fatalf("previous page should link both ways %+v", back)
+	}
+
+	// 游标与排序方式不一致时拒绝。
+	if code, _ := queryFilelist(t, r, cookie, username, url.Values{"sort": {"size"}, "cursor": {first.NextCursor}}); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for mismatched cursor, got %d", code)
+	}
+	if code, _ := queryFilelist(t, r, cookie, username, url.Values{"cursor": {"garbage"}}); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid cursor, got %d", code)
+	}
+
+	// 按大小降序并过滤。
+	code, sized := queryFilelist(t, r, cookie, username, url.Values{"sort": {"size"}, "min_size": {"100"}, "max_size": {"300"}})
+	if code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if got := fileNames(sized.Files); len(got) != 3 || got[0] != "a.txt" || got[1] != "c.txt" || got[2] != "d.txt" {
+		t.Fatalf("unexpected size filtered list %v", got)
+	}
+
+	code, prefixed := queryFilelist(t, r, cookie, username, url.Values{"prefix": {"0"}})
+	if code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if got := fileNames(prefixed.Files); len(got) != 1 || got[0] != "0.txt" {
+		t.Fatalf("unexpected prefix filtered list %v", got)
+	}
+
+	if code, _ := queryFilelist(t, r, cookie, username, url.Values{"sort": {"owner"}}); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid sort, got %d", code)
+	}
+}
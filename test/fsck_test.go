@@ -0,0 +1,117 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"filestore-server/pkg/dao"
+	"filestore-server/service"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func contains(list []string, want string) bool {
+	for _, v := range list {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
+
+func TestFsck_ReportsOrphanMissingAndCorrupt(t *testing.T) {
+	requireDB(t)
+
+	tmpDir := "./tmp"
+	if err := os.MkdirAll(tmpDir, 0o755); err != nil {
+		t.Fatalf("failed to create tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	ctx := context.Background()
+
+	orphan := filepath.Join(tmpDir, "orphan_"+randHex(4)+".txt")
+	if err := os.WriteFile(orphan, []byte("orphan"), 0o644); err != nil {
+		t.Fatalf("failed to write orphan: %v", err)
+	}
+
+	missingSha1 := randHex(20)
+	if err := dao.SaveFileMeta(ctx, missingSha1, "missing.txt", 1, filepath.Join(tmpDir, "missing_"+randHex(4))); err != nil {
+		t.Fatalf("failed to seed missing meta: %v", err)
+	}
+
+	corruptPath := filepath.Join(tmpDir, "corrupt_"+randHex(4)+".txt")
+	if err := os.WriteFile(corruptPath, []byte("not matching sha1"), 0o644); err != nil {
+		t.Fatalf("failed to write corrupt blob: %v", err)
+	}
+	corruptSha1 := randHex(20)
+	if err := dao.SaveFileMeta(ctx, corruptSha1, "corrupt.txt", 17, corruptPath); err != nil {
+		t.Fatalf("failed to seed corrupt meta: %v", err)
+	}
+
+	report, err := service.Fsck(ctx, service.FsckOptions{})
+	if err != nil {
+		t.Fatalf("fsck failed: %v", err)
+	}
+	if !contains(report.OrphanBlobs, filepath.Clean(orphan)) {
+		t.Errorf("orphan blob not reported: %v", report.OrphanBlobs)
+	}
+	if !contains(report.MissingBlobs, missingSha1) {
+		t.Errorf("missing blob not reported: %v", report.MissingBlobs)
+	}
+	if !contains(report.CorruptBlobs, corruptSha1) {
+		t.Errorf("corrupt blob not reported: %v", report.CorruptBlobs)
+	}
+	if _, err := os.Stat(orphan); err != nil {
+		t.Errorf("report-only fsck should not touch orphan: %v", err)
+	}
+}
+
+func TestFsck_ReuploadAfterCorruptBlob(t *testing.T) {
+	requireDB(t)
+
+	r := newTestRouter()
+	sessionCookie, _ := signupAndLogin(t, r)
+
+	tmpDir := "./tmp"
+	if err := os.MkdirAll(tmpDir, 0o755); err != nil {
+		t.Fatalf("failed to create tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	ctx := context.Background()
+	filename := "fsck_" + randHex(4) + ".txt"
+	content := []byte("fsck " + randHex(16))
+	f := uploadForTest(t, r, sessionCookie, filename, content)
+
+	fmeta, exists, err := dao.GetFileExist(ctx, f.FileSha1)
+	if err != nil || !exists {
+		t.Fatalf("uploaded file should exist: exists=%v err=%v", exists, err)
+	}
+	if err := os.WriteFile(fmeta.Location, []byte("bit rot"), 0o644); err != nil {
+		t.Fatalf("failed to corrupt blob: %v", err)
+	}
+
+	report, err := service.Fsck(ctx, service.FsckOptions{Repair: true, OrphanGrace: time.Hour})
+	if err != nil {
+		t.Fatalf("fsck failed: %v", err)
+	}
+	if !contains(report.CorruptBlobs, f.FileSha1) {
+		t.Fatalf("corrupt blob not reported: %v", report.CorruptBlobs)
+	}
+	if _, exists, err := dao.GetFileExist(ctx, f.FileSha1); err != nil || exists {
+		t.Fatalf("corrupt file should be taken offline: exists=%v err=%v", exists, err)
+	}
+
+	// 重新上传正确的内容后文件恢复可用。
+	uploadForTest(t, r, sessionCookie, filename, content)
+	rr := getWithCookie(r, sessionCookie, "/file/download?filehash="+f.FileSha1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("download after re-upload failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	if !bytes.Equal(rr.Body.Bytes(), content) {
+		t.Errorf("downloaded content mismatch: got %q want %q", rr.Body.Bytes(), content)
+	}
+}
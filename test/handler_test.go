@@ -120,6 +120,18 @@ func TestUploadFileHandler_FastUpload(t *testing.T) {
 		t.Fatalf("unexpected status on fast upload: %d body:%s", rr2.Code, rr2.Body.String())
 	}
 
+	// 相同内容不能再保存到另一个文件名下。
+	req3, err := createUploadRequest("file", "other_"+filename, content)
+	if err != nil {
+		t.Fatalf("create third request failed: %v", err)
+	}
+	req3.AddCookie(sessionCookie)
+	rr3 := httptest.NewRecorder()
+	r.ServeHTTP(rr3, req3)
+	if rr3.Code != http.StatusConflict {
+		t.Fatalf("same content under another name should conflict, got %d body:%s", rr3.Code, rr3.Body.String())
+	}
+
 	assertFileMeta(t, expectedSha1, filename, int64(len(content)))
 	assertUserFileMeta(t, username, expectedSha1, filename, int64(len(content)))
 
@@ -144,7 +156,7 @@ func TestGetFileMetaHandler(t *testing.T) {
 	requireDB(t)
 
 	r := newTestRouter()
-	sessionCookie, _ := signupAndLogin(t, r)
+	sessionCookie, username := signupAndLogin(t, r)
 
 	// Setup
 	fileSha1 := randHex(20)
@@ -158,6 +170,10 @@ func TestGetFileMetaHandler(t *testing.T) {
 	if err := dao.SaveFileMeta(context.Background(), expectedMeta.FileSha1, expectedMeta.FileName, expectedMeta.FileSize, expectedMeta.Location); err != nil {
 		t.Fatalf("failed to seed meta: %v", err)
 	}
+	// 文件须属于调用者才能访问。
+	if err := dao.InsertUserFileMeta(context.Background(), username, expectedMeta.FileSha1, expectedMeta.FileSize, expectedMeta.FileName); err != nil {
+		t.Fatalf("failed to seed user file: %v", err)
+	}
 
 	// Request
 	req := httptest.NewRequest("GET", "/file/meta?filehash="+fileSha1, nil)
@@ -190,7 +206,7 @@ func TestDownloadFileHandler(t *testing.T) {
 	requireDB(t)
 
 	r := newTestRouter()
-	sessionCookie, _ := signupAndLogin(t, r)
+	sessionCookie, username := signupAndLogin(t, r)
 
 	// Setup
 	tmpDir := "./tmp_download"
@@ -216,6 +232,10 @@ func TestDownloadFileHandler(t *testing.T) {
 	if err := dao.SaveFileMeta(context.Background(), fmeta.FileSha1, fmeta.FileName, fmeta.FileSize, fmeta.Location); err != nil {
 		t.Fatalf("failed to seed meta: %v", err)
 	}
+	// 文件须属于调用者才能访问。
+	if err := dao.InsertUserFileMeta(context.Background(), username, fmeta.FileSha1, fmeta.FileSize, fmeta.FileName); err != nil {
+		t.Fatalf("failed to seed user file: %v", err)
+	}
 
 	// Request
 	req := httptest.NewRequest("GET", "/file/download?filehash="+fileSha1, nil)
@@ -250,7 +270,7 @@ func TestFileMetaUpdateHandler(t *testing.T) {
 	requireDB(t)
 
 	r := newTestRouter()
-	sessionCookie, _ := signupAndLogin(t, r)
+	sessionCookie, username := signupAndLogin(t, r)
 
 	// Setup
 	fileSha1 := randHex(20)
@@ -266,6 +286,10 @@ func TestFileMetaUpdateHandler(t *testing.T) {
 	if err := dao.SaveFileMeta(context.Background(), fmeta.FileSha1, fmeta.FileName, fmeta.FileSize, fmeta.Location); err != nil {
 		t.Fatalf("failed to seed meta: %v", err)
 	}
+	// 文件须属于调用者才能访问。
+	if err := dao.InsertUserFileMeta(context.Background(), username, fmeta.FileSha1, fmeta.FileSize, fmeta.FileName); err != nil {
+		t.Fatalf("failed to seed user file: %v", err)
+	}
 
 	// Request
 	// op=0 表示重命名操作
@@ -292,15 +316,16 @@ func TestFileMetaUpdateHandler(t *testing.T) {
 		t.Errorf("FileName mismatch: got %v want %v", gotMeta.FileName, newName)
 	}
 
-	// Verify internal state
-	assertFileMeta(t, fileSha1, newName, fmeta.FileSize)
+	// Verify internal state：只修改调用者的文件，共享的内容记录不变。
+	assertUserFileMeta(t, username, fileSha1, newName, fmeta.FileSize)
+	assertFileMeta(t, fileSha1, originalName, fmeta.FileSize)
 }
 
 func TestFileDeleteHandler(t *testing.T) {
 	requireDB(t)
 
 	r := newTestRouter()
-	sessionCookie, _ := signupAndLogin(t, r)
+	sessionCookie, username := signupAndLogin(t, r)
 
 	// Setup
 	tmpDir := "./tmp_delete"
@@ -326,6 +351,10 @@ func TestFileDeleteHandler(t *testing.T) {
 	if err := dao.SaveFileMeta(context.Background(), fmeta.FileSha1, fmeta.FileName, fmeta.FileSize, fmeta.Location); err != nil {
 		t.Fatalf("failed to seed meta: %v", err)
 	}
+	// 文件须属于调用者才能访问。
+	if err := dao.InsertUserFileMeta(context.Background(), username, fmeta.FileSha1, fmeta.FileSize, fmeta.FileName); err != nil {
+		t.Fatalf("failed to seed user file: %v", err)
+	}
 
 	// Request
 	req := httptest.NewRequest("POST", "/file/delete?filehash="+fileSha1, nil)
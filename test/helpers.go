@@ -3,6 +3,7 @@ package test
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha1"
 	"encoding/hex"
 	"io"
 	"net/http"
@@ -31,7 +32,14 @@ CREATE TABLE IF NOT EXISTS tbl_user (
   signup_at datetime DEFAULT CURRENT_TIMESTAMP COMMENT '注册日期',
   last_active datetime DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT '最后活跃时间戳',
   profile text COMMENT '用户属性',
-  status int(11) NOT NULL DEFAULT '0' COMMENT '账户状态(启用/禁用/锁定/标记删除等)',
+  status int(11) NOT NULL DEFAULT '0' COMMENT '账户状态(0或1启用/2禁用/3锁定)',
+  max_versions int(11) NOT NULL DEFAULT '0' COMMENT '每个文件保留的历史版本数(0使用系统默认)',
+  role varchar(16) NOT NULL DEFAULT 'user' COMMENT '角色(user/admin)',
+  group_name varchar(64) NOT NULL DEFAULT 'default' COMMENT '用户组',
+  quota bigint NOT NULL DEFAULT 0 COMMENT '存储配额(字节,0使用系统默认)',
+  session_version int NOT NULL DEFAULT 0 COMMENT '会话版本(修改密码时递增,使旧会话失效)',
+  totp_enabled tinyint(1) NOT NULL DEFAULT 0 COMMENT '是否已启用TOTP两步验证',
+  totp_required tinyint(1) NOT NULL DEFAULT 0 COMMENT '管理员是否要求启用两步验证',
   PRIMARY KEY (id),
   UNIQUE KEY idx_username (user_name),
   KEY idx_status (status)
@@ -46,11 +54,36 @@ CREATE TABLE IF NOT EXISTS tbl_file (
   file_addr varchar(1024) NOT NULL,
   status int NOT NULL DEFAULT 1,
   upload_at datetime DEFAULT CURRENT_TIMESTAMP,
+  key_id varchar(64) NOT NULL DEFAULT '',
+  enc_key varchar(256) NOT NULL DEFAULT '',
+  compression varchar(16) NOT NULL DEFAULT '',
+  stored_size bigint NOT NULL DEFAULT 0,
+  store_state int NOT NULL DEFAULT 0,
+  mime_type varchar(128) NOT NULL DEFAULT '',
+  scan_state int NOT NULL DEFAULT 0,
   PRIMARY KEY (id),
   UNIQUE KEY idx_file_sha1 (file_sha1),
   KEY idx_status (status)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
 
+	fileDerivedTableDDL = `
+CREATE TABLE IF NOT EXISTS tbl_file_derived (
+  id int(11) NOT NULL AUTO_INCREMENT,
+  file_sha1 char(40) NOT NULL,
+  kind varchar(32) NOT NULL,
+  blob_key varchar(128) NOT NULL DEFAULT '',
+  file_addr varchar(1024) NOT NULL DEFAULT '',
+  file_size bigint NOT NULL DEFAULT 0,
+  stored_size bigint NOT NULL DEFAULT 0,
+  key_id varchar(64) NOT NULL DEFAULT '',
+  enc_key varchar(256) NOT NULL DEFAULT '',
+  compression varchar(16) NOT NULL DEFAULT '',
+  store_state int NOT NULL DEFAULT 0,
+  create_at datetime DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (id),
+  UNIQUE KEY idx_file_kind (file_sha1, kind)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+
 	userFileTableDDL = `
 CREATE TABLE IF NOT EXISTS tbl_user_file (
   id int(11) NOT NULL PRIMARY KEY AUTO_INCREMENT,
@@ -65,6 +98,180 @@ CREATE TABLE IF NOT EXISTS tbl_user_file (
   KEY idx_status (status),
   KEY idx_user_id (user_name)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+
+	userFileVersionTableDDL = `
+CREATE TABLE IF NOT EXISTS tbl_user_file_version (
+  id int(11) NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  user_name varchar(64) NOT NULL,
+  file_name varchar(256) NOT NULL DEFAULT '' COMMENT '用户文件路径',
+  version int(11) NOT NULL DEFAULT '0' COMMENT '版本号',
+  file_sha1 varchar(64) NOT NULL DEFAULT '' COMMENT '该版本的文件hash',
+  file_size bigint(20) DEFAULT '0' COMMENT '文件大小',
+  create_at datetime DEFAULT CURRENT_TIMESTAMP COMMENT '版本创建时间',
+  status int(11) NOT NULL DEFAULT '0' COMMENT '版本状态(0保留1已清理)',
+  UNIQUE KEY idx_user_file_version (user_name, file_name, version),
+  KEY idx_user_file_sha1 (user_name, file_name, file_sha1)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+
+	mimePolicyTableDDL = `
+CREATE TABLE IF NOT EXISTS tbl_mime_policy (
+  id int(11) NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  group_name varchar(64) NOT NULL DEFAULT '',
+  mime_type varchar(128) NOT NULL DEFAULT '',
+  action varchar(8) NOT NULL DEFAULT '',
+  UNIQUE KEY idx_group_mime (group_name, mime_type)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+
+	auditLogTableDDL = `
+CREATE TABLE IF NOT EXISTS tbl_audit_log (
+  id bigint NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  actor varchar(64) NOT NULL DEFAULT '',
+  action varchar(64) NOT NULL DEFAULT '',
+  target varchar(256) NOT NULL DEFAULT '',
+  detail text,
+  create_at datetime DEFAULT CURRENT_TIMESTAMP,
+  KEY idx_target (target)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+
+	userFileTagTableDDL = `
+CREATE TABLE IF NOT EXISTS tbl_user_file_tag (
+  id bigint NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  user_name varchar(64) NOT NULL,
+  file_sha1 varchar(64) NOT NULL DEFAULT '',
+  tag varchar(64) NOT NULL DEFAULT '',
+  create_at datetime DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE KEY idx_user_file_tag (user_name, file_sha1, tag),
+  KEY idx_user_tag (user_name, tag)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+
+	userFileAttrTableDDL = `
+CREATE TABLE IF NOT EXISTS tbl_user_file_attr (
+  id bigint NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  user_name varchar(64) NOT NULL,
+  file_sha1 varchar(64) NOT NULL DEFAULT '',
+  attr_key varchar(64) NOT NULL DEFAULT '',
+  attr_value varchar(1024) NOT NULL DEFAULT '',
+  UNIQUE KEY idx_user_file_attr (user_name, file_sha1, attr_key)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+
+	idempotencyTableDDL = `
+CREATE TABLE IF NOT EXISTS tbl_idempotency (
+  id bigint NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  user_name varchar(64) NOT NULL,
+  idem_key varchar(128) NOT NULL,
+  request_hash char(64) NOT NULL DEFAULT '',
+  response mediumblob,
+  create_at datetime DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE KEY idx_user_key (user_name, idem_key)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+
+	userInboxTableDDL = `
+CREATE TABLE IF NOT EXISTS tbl_user_inbox (
+  id bigint NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  sender varchar(64) NOT NULL,
+  recipient varchar(64) NOT NULL,
+  file_sha1 varchar(64) NOT NULL DEFAULT '',
+  file_name varchar(256) NOT NULL DEFAULT '',
+  file_size bigint NOT NULL DEFAULT 0,
+  message varchar(2048) NOT NULL DEFAULT '',
+  status int NOT NULL DEFAULT 0,
+  create_at datetime DEFAULT CURRENT_TIMESTAMP,
+  KEY idx_recipient_status (recipient, status)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+
+	teamTableDDL = `
+CREATE TABLE IF NOT EXISTS tbl_team (
+  id bigint NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  name varchar(64) NOT NULL DEFAULT '',
+  create_at datetime DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+
+	teamMemberTableDDL = `
+CREATE TABLE IF NOT EXISTS tbl_team_member (
+  id bigint NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  team_id bigint NOT NULL,
+  user_name varchar(64) NOT NULL,
+  role varchar(16) NOT NULL DEFAULT 'viewer',
+  join_at datetime DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE KEY idx_team_user (team_id, user_name),
+  KEY idx_user (user_name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+
+	teamInviteTableDDL = `
+CREATE TABLE IF NOT EXISTS tbl_team_invite (
+  id bigint NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  team_id bigint NOT NULL,
+  inviter varchar(64) NOT NULL,
+  invitee varchar(64) NOT NULL,
+  role varchar(16) NOT NULL DEFAULT 'viewer',
+  status int NOT NULL DEFAULT 0,
+  create_at datetime DEFAULT CURRENT_TIMESTAMP,
+  KEY idx_invitee_status (invitee, status)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+
+	aclTableDDL = `
+CREATE TABLE IF NOT EXISTS tbl_acl (
+  id bigint NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  owner varchar(64) NOT NULL,
+  path varchar(256) NOT NULL DEFAULT '',
+  principal varchar(64) NOT NULL,
+  permission varchar(16) NOT NULL DEFAULT 'read',
+  inherit tinyint(1) NOT NULL DEFAULT 0,
+  create_by varchar(64) NOT NULL DEFAULT '',
+  create_at datetime DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  UNIQUE KEY idx_owner_path_principal (owner, path, principal),
+  KEY idx_owner_principal (owner, principal)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+
+	verifyCodeTableDDL = `
+CREATE TABLE IF NOT EXISTS tbl_user_verify_code (
+  user_name varchar(64) NOT NULL,
+  channel varchar(16) NOT NULL,
+  target varchar(128) NOT NULL DEFAULT '',
+  code_hash char(64) NOT NULL DEFAULT '',
+  attempts int NOT NULL DEFAULT 0,
+  expire_at datetime NOT NULL,
+  create_at datetime NOT NULL,
+  PRIMARY KEY (user_name, channel)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+
+	passwordResetTableDDL = `
+CREATE TABLE IF NOT EXISTS tbl_password_reset (
+  token_hash char(64) NOT NULL PRIMARY KEY,
+  user_name varchar(64) NOT NULL,
+  expire_at datetime NOT NULL,
+  create_at datetime NOT NULL,
+  KEY idx_user_name (user_name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+
+	userTOTPTableDDL = `
+CREATE TABLE IF NOT EXISTS tbl_user_totp (
+  user_name varchar(64) NOT NULL PRIMARY KEY,
+  key_id varchar(64) NOT NULL DEFAULT '',
+  secret varchar(256) NOT NULL,
+  last_step bigint NOT NULL DEFAULT 0,
+  create_at datetime NOT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+
+	recoveryCodeTableDDL = `
+CREATE TABLE IF NOT EXISTS tbl_user_recovery_code (
+  user_name varchar(64) NOT NULL,
+  code_hash char(64) NOT NULL,
+  create_at datetime NOT NULL,
+  PRIMARY KEY (user_name, code_hash)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+
+	userIdentityTableDDL = `
+CREATE TABLE IF NOT EXISTS tbl_user_identity (
+  issuer varchar(255) NOT NULL,
+  subject varchar(255) NOT NULL,
+  user_name varchar(64) NOT NULL,
+  email varchar(64) NOT NULL DEFAULT '',
+  create_at datetime NOT NULL,
+  last_login_at datetime DEFAULT NULL,
+  PRIMARY KEY (issuer, subject),
+  KEY idx_user_name (user_name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
 )
 
 func requireDB(t *testing.T) {
@@ -89,9 +296,60 @@ func ensureTestTables(t *testing.T) {
 	if _, err := conn.ExecContext(ctx, fileTableDDL); err != nil {
 		t.Fatalf("failed to ensure tbl_file: %v", err)
 	}
+	if _, err := conn.ExecContext(ctx, fileDerivedTableDDL); err != nil {
+		t.Fatalf("failed to ensure tbl_file_derived: %v", err)
+	}
 	if _, err := conn.ExecContext(ctx, userFileTableDDL); err != nil {
 		t.Fatalf("failed to ensure tbl_user_file: %v", err)
 	}
+	if _, err := conn.ExecContext(ctx, userFileVersionTableDDL); err != nil {
+		t.Fatalf("failed to ensure tbl_user_file_version: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, mimePolicyTableDDL); err != nil {
+		t.Fatalf("failed to ensure tbl_mime_policy: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, auditLogTableDDL); err != nil {
+		t.Fatalf("failed to ensure tbl_audit_log: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, userFileTagTableDDL); err != nil {
+		t.Fatalf("failed to ensure tbl_user_file_tag: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, userFileAttrTableDDL); err != nil {
+		t.Fatalf("failed to ensure tbl_user_file_attr: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, idempotencyTableDDL); err != nil {
+		t.Fatalf("failed to ensure tbl_idempotency: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, userInboxTableDDL); err != nil {
+		t.Fatalf("failed to ensure tbl_user_inbox: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, teamTableDDL); err != nil {
+		t.Fatalf("failed to ensure tbl_team: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, teamMemberTableDDL); err != nil {
+		t.Fatalf("failed to ensure tbl_team_member: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, teamInviteTableDDL); err != nil {
+		t.Fatalf("failed to ensure tbl_team_invite: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, aclTableDDL); err != nil {
+		t.Fatalf("failed to ensure tbl_acl: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, verifyCodeTableDDL); err != nil {
+		t.Fatalf("failed to ensure tbl_user_verify_code: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, passwordResetTableDDL); err != nil {
+		t.Fatalf("failed to ensure tbl_password_reset: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, userTOTPTableDDL); err != nil {
+		t.Fatalf("failed to ensure tbl_user_totp: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, recoveryCodeTableDDL); err != nil {
+		t.Fatalf("failed to ensure tbl_user_recovery_code: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, userIdentityTableDDL); err != nil {
+		t.Fatalf("failed to ensure tbl_user_identity: %v", err)
+	}
 }
 
 func randHex(nBytes int) string {
@@ -215,3 +473,9 @@ func assertUserFileMeta(t *testing.T, username, fileSha1, expectedName string, e
 		t.Fatalf("user file size mismatch: got %d want %d", gotSize, expectedSize)
 	}
 }
+
+func sha1Hex(content []byte) string {
+	h := sha1.New()
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,147 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"filestore-server/pkg/dao"
+	"filestore-server/pkg/db"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+func TestSendFile_InboxAcceptDeclineAndQuota(t *testing.T) {
+	requireDB(t)
+
+	r := newTestRouter()
+	aliceCookie, _ := signupAndLogin(t, r)
+	bobCookie, bob := signupAndLogin(t, r)
+
+	doc := uploadForTest(t, r, aliceCookie, "doc_"+randHex(4)+".txt", []byte("shared "+randHex(16)))
+	big := uploadForTest(t, r, aliceCookie, "big_"+randHex(4)+".txt", []byte("big "+randHex(256)))
+
+	send := func(filehash string) int64 {
+		t.Helper()
+		rr := postForm(t, r, aliceCookie, "/file/send", url.Values{"filehash": {filehash}, "to": {bob}, "message": {"fyi"}})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("send failed: %d body:%s", rr.Code, rr.Body.String())
+		}
+		var resp struct{ ID int64 }
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return resp.ID
+	}
+	docID := send(doc.FileSha1)
+	bigID := send(big.FileSha1)
+
+	if rr := postForm(t, r, aliceCookie, "/file/send", url.Values{"filehash": {doc.FileSha1}, "to": {bob}}); rr.Code != http.StatusConflict {
+		t.Errorf("sending the same file twice should conflict, got %d", rr.Code)
+	}
+	if rr := postForm(t, r, aliceCookie, "/file/send", url.Values{"filehash": {doc.FileSha1}, "to": {"nobody_" + randHex(6)}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("sending to an unknown user should fail, got %d", rr.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/user/inbox", nil)
+	req.AddCookie(bobCookie)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	var inbox struct{ Items []dao.InboxItem }
+	json.Unmarshal(rr.Body.Bytes(), &inbox)
+	if len(inbox.Items) != 2 {
+		t.Fatalf("expected 2 inbox items, got %+v", inbox.Items)
+	}
+
+	rr = postForm(t, r, bobCookie, "/user/inbox/accept", url.Values{"id": {strconv.FormatInt(docID, 10)}, "dir": {"from-alice"}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("accept failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	if files := searchFiles(t, r, bobCookie, url.Values{"name": {"from-alice/doc_*"}}); len(files) != 1 || files[0].FileSha1 != doc.FileSha1 {
+		t.Errorf("accepted file should be in bob's folder, got %+v", files)
+	}
+	if rr := postForm(t, r, bobCookie, "/user/inbox/accept", url.Values{"id": {strconv.FormatInt(docID, 10)}}); rr.Code != http.StatusNotFound {
+		t.Errorf("accepting twice should return 404, got %d", rr.Code)
+	}
+
+	// 配额不足时不能接收。
+	if _, err := db.DBconn().ExecContext(context.Background(), "update tbl_user set quota=? where user_name=?", doc.FileSize+10, bob); err != nil {
+		t.Fatalf("failed to set quota: %v", err)
+	}
+	rr = postForm(t, r, bobCookie, "/user/inbox/accept", url.Values{"id": {strconv.FormatInt(bigID, 10)}})
+	if rr.Code != http.StatusInsufficientStorage {
+		t.Errorf("accept over quota should fail with 507, got %d", rr.Code)
+	}
+	if rr := postForm(t, r, bobCookie, "/user/inbox/decline", url.Values{"id": {strconv.FormatInt(bigID, 10)}}); rr.Code != http.StatusOK {
+		t.Errorf("decline failed: %d", rr.Code)
+	}
+	if rr := postForm(t, r, bobCookie, "/user/inbox/accept", url.Values{"id": {strconv.FormatInt(bigID, 10)}}); rr.Code != http.StatusNotFound {
+		t.Errorf("declined item should no longer be accepted, got %d", rr.Code)
+	}
+}
+
+func TestFileMove(t *testing.T) {
+	requireDB(t)
+
+	r := newTestRouter()
+	cookie, _ := signupAndLogin(t, r)
+	f := uploadForTest(t, r, cookie, "move_"+randHex(4)+".txt", []byte("move "+randHex(16)))
+
+	rr := postForm(t, r, cookie, "/file/move", url.Values{"filehash": {f.FileSha1}, "dir": {"/archive/2024/"}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("move failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	var resp struct{ Filename string }
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.Filename != "archive/2024/"+f.FileName {
+		t.Errorf("unexpected new path %q", resp.Filename)
+	}
+
+	if rr := postForm(t, r, cookie, "/file/move", url.Values{"filehash": {f.FileSha1}, "dir": {"../up"}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("unsafe dir should be rejected, got %d", rr.Code)
+	}
+}
+
+func TestDeleteSentFile_KeepsSenderCopy(t *testing.T) {
+	requireDB(t)
+
+	r := newTestRouter()
+	aliceCookie, _ := signupAndLogin(t, r)
+	bobCookie, bob := signupAndLogin(t, r)
+	content := []byte("keep " + randHex(16))
+	f := uploadForTest(t, r, aliceCookie, "keep_"+randHex(4)+".txt", content)
+
+	rr := postForm(t, r, aliceCookie, "/file/send", url.Values{"filehash": {f.FileSha1}, "to": {bob}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("send failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	var sent struct{ ID int64 }
+	json.Unmarshal(rr.Body.Bytes(), &sent)
+	if rr := postForm(t, r, bobCookie, "/user/inbox/accept", url.Values{"id": {strconv.FormatInt(sent.ID, 10)}}); rr.Code != http.StatusOK {
+		t.Fatalf("accept failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+
+	if rr := postForm(t, r, bobCookie, "/file/update", url.Values{"filehash": {f.FileSha1}, "op": {"0"}, "filename": {"renamed.txt"}}); rr.Code != http.StatusOK {
+		t.Fatalf("rename failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	if files := searchFiles(t, r, aliceCookie, url.Values{"name": {f.FileName}}); len(files) != 1 {
+		t.Errorf("recipient's rename should not change the sender's file, got %+v", files)
+	}
+
+	if rr := postForm(t, r, bobCookie, "/file/delete", url.Values{"filehash": {f.FileSha1}}); rr.Code != http.StatusOK {
+		t.Fatalf("delete failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	if rr := getWithCookie(r, bobCookie, "/file/download?filehash="+f.FileSha1); rr.Code == http.StatusOK {
+		t.Errorf("recipient should no longer download the deleted file")
+	}
+	rr = getWithCookie(r, aliceCookie, "/file/download?filehash="+f.FileSha1)
+	if rr.Code != http.StatusOK || rr.Body.String() != string(content) {
+		t.Fatalf("sender should still download the file, got %d body:%q", rr.Code, rr.Body.String())
+	}
+
+	if rr := postForm(t, r, aliceCookie, "/file/delete", url.Values{"filehash": {f.FileSha1}}); rr.Code != http.StatusOK {
+		t.Fatalf("delete failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	if _, exists, err := dao.GetFileExist(context.Background(), f.FileSha1); err != nil || exists {
+		t.Errorf("content without references should be reclaimed, exists=%v err=%v", exists, err)
+	}
+}
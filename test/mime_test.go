@@ -0,0 +1,118 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"filestore-server/pkg/db"
+	util "filestore-server/pkg/utils"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMime_DetectAndMatch(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	cases := []struct {
+		filename string
+		sample   []byte
+		want     string
+	}{
+		{"photo.txt", png, "image/png"},
+		{"notes.txt", []byte("hello world"), "text/plain; charset=utf-8"},
+		{"data.csv", []byte("a,b\n1,2\n"), "text/csv"},
+		{"fake.txt", []byte{0x00, 0x01, 0x02, 0xff}, "application/octet-stream"},
+	}
+	for _, tc := range cases {
+		if got := util.DetectMimeType(tc.filename, tc.sample); !strings.HasPrefix(got, tc.want) {
+			t.Errorf("DetectMimeType(%s) = %s, want %s", tc.filename, got, tc.want)
+		}
+	}
+
+	if !util.MimeTypeMatches("image/*", "image/png") || util.MimeTypeMatches("image/*", "text/plain") {
+		t.Errorf("wildcard match failed")
+	}
+	if !util.MimeTypeMatches("text/plain", "text/plain; charset=utf-8") {
+		t.Errorf("parameters should be ignored when matching")
+	}
+	if !util.InlineSafeMimeType("image/png") || util.InlineSafeMimeType("text/html") || util.InlineSafeMimeType("image/svg+xml") {
+		t.Errorf("inline safe types mismatch")
+	}
+}
+
+func postForm(t *testing.T, r *gin.Engine, cookie *http.Cookie, path string, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestMimePolicy_DenyByGroup(t *testing.T) {
+	requireDB(t)
+
+	r := newTestRouter()
+	adminCookie, adminName := signupAndLogin(t, r)
+	userCookie, username := signupAndLogin(t, r)
+	group := "g_" + randHex(4)
+
+	rr := postForm(t, r, adminCookie, "/admin/mime/policy", url.Values{"group": {group}, "mime_type": {"image/*"}, "action": {"deny"}})
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("non-admin should be forbidden, got %d", rr.Code)
+	}
+	if _, err := db.DBconn().ExecContext(context.Background(), "update tbl_user set role='admin' where user_name=?", adminName); err != nil {
+		t.Fatalf("failed to promote admin: %v", err)
+	}
+
+	rr = postForm(t, r, adminCookie, "/admin/mime/policy", url.Values{"group": {group}, "mime_type": {"image/*"}, "action": {"deny"}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("save policy failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	rr = postForm(t, r, adminCookie, "/admin/user/group", url.Values{"user_name": {username}, "group": {group}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("update group failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+
+	req, _ := createUploadRequest("file", "pic_"+randHex(4)+".bin", []byte("\x89PNG\r\n\x1a\n"+randHex(8)))
+	req.AddCookie(userCookie)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("denied type should be rejected, got %d body:%s", rr.Code, rr.Body.String())
+	}
+
+	text := uploadForTest(t, r, userCookie, "note_"+randHex(4)+".txt", []byte("plain text "+randHex(8)))
+	if !strings.HasPrefix(text.MimeType, "text/plain") {
+		t.Errorf("mime type not detected: %q", text.MimeType)
+	}
+
+	req = httptest.NewRequest("GET", "/file/download?filehash="+text.FileSha1, nil)
+	req.AddCookie(userCookie)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("download Content-Type mismatch: %s", ct)
+	}
+	if disposition := rr.Header().Get("Content-Disposition"); !strings.HasPrefix(disposition, "inline") {
+		t.Errorf("safe type should be inline: %s", disposition)
+	}
+
+	req = httptest.NewRequest("GET", "/admin/mime/policy?group="+group, nil)
+	req.AddCookie(adminCookie)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	var listed struct {
+		Policies []struct{ MimeType, Action string }
+	}
+	json.Unmarshal(rr.Body.Bytes(), &listed)
+	if len(listed.Policies) != 1 || listed.Policies[0].Action != "deny" {
+		t.Errorf("unexpected policies: %s", rr.Body.String())
+	}
+}
@@ -0,0 +1,134 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"filestore-server/pkg/notify"
+)
+
+// fakeSMTPMail 是假 SMTP 服务器收到的一封邮件。
+type fakeSMTPMail struct {
+	From string
+	To   []string
+	Data string
+}
+
+// startFakeSMTP 启动一个只实现基本命令、不支持 STARTTLS 和认证的 SMTP 服务器，收到的邮件写入返回的 channel。
+func startFakeSMTP(t *testing.T) (string, <-chan fakeSMTPMail) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	mails := make(chan fakeSMTPMail, 16)
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		ln.Close()
+		wg.Wait()
+	})
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				serveFakeSMTP(conn, mails)
+			}()
+		}
+	}()
+	return ln.Addr().String(), mails
+}
+
+func serveFakeSMTP(conn net.Conn, mails chan<- fakeSMTPMail) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	r := bufio.NewReader(conn)
+	reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+	reply("220 fake smtp ready")
+	var mail fakeSMTPMail
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimRight(line, "\r\n")
+		upper := strings.ToUpper(cmd)
+		switch {
+		case strings.HasPrefix(upper, "EHLO"), strings.HasPrefix(upper, "HELO"):
+			reply("250 fake")
+		case strings.HasPrefix(upper, "MAIL FROM:"):
+			mail = fakeSMTPMail{From: strings.Trim(cmd[len("MAIL FROM:"):], "<> ")}
+			reply("250 ok")
+		case strings.HasPrefix(upper, "RCPT TO:"):
+			mail.To = append(mail.To, strings.Trim(cmd[len("RCPT TO:"):], "<> "))
+			reply("250 ok")
+		case upper == "DATA":
+			reply("354 end with .")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(l, "."))
+			}
+			mail.Data = data.String()
+			mails <- mail
+			reply("250 queued")
+		case upper == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestSMTPNotifier_SendsMail(t *testing.T) {
+	addr, mails := startFakeSMTP(t)
+	n := &notify.SMTPNotifier{Addr: addr, From: "noreply@example.com", Timeout: 5 * time.Second}
+
+	err := n.Send(context.Background(), notify.Message{
+		Channel: notify.ChannelEmail,
+		To:      "alice@example.com",
+		Subject: "验证码",
+		Body:    "code 123456\n.\nbye",
+	})
+	if err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+
+	select {
+	case m := <-mails:
+		if m.From != "noreply@example.com" || len(m.To) != 1 || m.To[0] != "alice@example.com" {
+			t.Errorf("unexpected envelope: %+v", m)
+		}
+		if !strings.Contains(m.Data, "Subject: =?utf-8?q?") {
+			t.Errorf("subject should be encoded: %s", m.Data)
+		}
+		if !strings.Contains(m.Data, "code 123456\r\n.\r\nbye") {
+			t.Errorf("body not delivered intact: %q", m.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("mail not received")
+	}
+
+	if err := n.Send(context.Background(), notify.Message{Channel: notify.ChannelSMS, To: "+123456789"}); !errors.Is(err, notify.ErrUnsupportedChannel) {
+		t.Errorf("sms should be unsupported, got %v", err)
+	}
+}
@@ -0,0 +1,356 @@
+package test
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"filestore-server/pkg/db"
+	"filestore-server/pkg/oidc"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	fakeClientID     = "filestore"
+	fakeClientSecret = "s3cret"
+	fakeRedirectURL  = "http://filestore.test/user/oidc/callback"
+)
+
+// fakeOIDC 是测试用的身份提供方，实现发现文档、授权、令牌和 JWKS 接口。
+// 授权接口不需要登录，直接为 claims 中的用户签发授权码。
+type fakeOIDC struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+	// jwksFetches 记录 JWKS 被读取的次数。
+	jwksFetches atomic.Int32
+
+	mu     sync.Mutex
+	claims map[string]any
+	codes  map[string]fakeAuthCode
+}
+
+type fakeAuthCode struct {
+	challenge, nonce, redirect string
+	claims                     map[string]any
+}
+
+func newFakeOIDC(t *testing.T) *fakeOIDC {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeOIDC{key: key, codes: map[string]fakeAuthCode{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 f.URL,
+			"authorization_endpoint": f.URL + "/authorize",
+			"token_endpoint":         f.URL + "/token",
+			"jwks_uri":               f.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		f.jwksFetches.Add(1)
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1", "use": "sig", "alg": "RS256",
+			"n": base64.RawURLEncoding.EncodeToString(f.key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(f.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", f.authorize)
+	mux.HandleFunc("/token", f.token)
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// setUser 设置下一次授权时签发给的用户声明。
+func (f *fakeOIDC) setUser(claims map[string]any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.claims = claims
+}
+
+func (f *fakeOIDC) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != fakeClientID || q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	code := randHex(16)
+	f.mu.Lock()
+	f.codes[code] = fakeAuthCode{challenge: q.Get("code_challenge"), nonce: q.Get("nonce"), redirect: q.Get("redirect_uri"), claims: f.claims}
+	f.mu.Unlock()
+	http.Redirect(w, r, q.Get("redirect_uri")+"?"+url.Values{"code": {code}, "state": {q.Get("state")}}.Encode(), http.StatusFound)
+}
+
+func (f *fakeOIDC) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, _ := r.BasicAuth()
+	if id != fakeClientID || secret != fakeClientSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+	f.mu.Lock()
+	ac, ok := f.codes[r.PostFormValue("code")]
+	delete(f.codes, r.PostFormValue("code"))
+	f.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("redirect_uri") != ac.redirect ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != ac.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	claims := map[string]any{"nonce": ac.nonce}
+	for k, v := range ac.claims {
+		claims[k] = v
+	}
+	json.NewEncoder(w).Encode(map[string]any{"access_token": randHex(16), "token_type": "Bearer", "id_token": f.sign(f.key, claims)})
+}
+
+// sign 用 key 以 kid k1 签发 ID Token，补齐 claims 中没有的 iss、aud、iat、exp。
+func (f *fakeOIDC) sign(key *rsa.PrivateKey, claims map[string]any) string {
+	return f.signWithKid(key, "k1", claims)
+}
+
+// signWithKid 用 key 签发头部 kid 为 kid 的 ID Token。
+func (f *fakeOIDC) signWithKid(key *rsa.PrivateKey, kid string, claims map[string]any) string {
+	full := map[string]any{"iss": f.URL, "aud": fakeClientID, "iat": time.Now().Unix(), "exp": time.Now().Add(time.Hour).Unix()}
+	for k, v := range claims {
+		full[k] = v
+	}
+	enc := func(v any) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signing := enc(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"}) + "." + enc(full)
+	digest := sha256.Sum256([]byte(signing))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	return signing + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// noRedirect 是不跟随跳转的 HTTP 客户端。
+var noRedirect = &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+// authorizeCode 访问授权地址并返回跳转回 redirect_uri 时携带的参数。
+func authorizeCode(t *testing.T, authURL string) url.Values {
+	t.Helper()
+	resp, err := noRedirect.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if resp.StatusCode != http.StatusFound || err != nil {
+		t.Fatalf("authorize failed: %d %v", resp.StatusCode, err)
+	}
+	return loc.Query()
+}
+
+func TestOIDCClient_PKCEAndIDTokenVerification(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeOIDC(t)
+	client, err := oidc.NewClient(ctx, oidc.Config{Issuer: f.URL, ClientID: fakeClientID, ClientSecret: fakeClientSecret, RedirectURL: fakeRedirectURL})
+	if err != nil {
+		t.Fatalf("discovery failed: %v", err)
+	}
+	f.setUser(map[string]any{"sub": "u1", "email": "u1@example.com", "email_verified": true, "groups": []string{"staff", "fs-admins"}})
+
+	verifier, _ := oidc.RandomString(32)
+	authURL := client.AuthCodeURL("st", "n1", oidc.Challenge(verifier))
+	if q := authorizeCode(t, authURL); q.Get("state") != "st" {
+		t.Fatalf("state not returned: %v", q)
+	} else if _, err := client.Exchange(ctx, q.Get("code"), "wrong-verifier"); err == nil {
+		t.Fatal("exchange with wrong PKCE verifier should fail")
+	}
+
+	tok, err := client.Exchange(ctx, authorizeCode(t, authURL).Get("code"), verifier)
+	if err != nil {
+		t.Fatalf("exchange failed: %v", err)
+	}
+	claims, err := client.VerifyIDToken(ctx, tok.IDToken, "n1")
+	if err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+	if claims.Subject != "u1" || !claims.EmailVerified || strings.Join(claims.StringList("groups"), ",") != "staff,fs-admins" {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+	if _, err := client.VerifyIDToken(ctx, tok.IDToken, "other"); !errors.Is(err, oidc.ErrInvalidToken) {
+		t.Errorf("nonce mismatch should be rejected: %v", err)
+	}
+
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	parts := strings.Split(tok.IDToken, ".")
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + "."
+	bad := map[string]string{
+		"tampered":      parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin"}`)) + "." + parts[2],
+		"foreign key":   f.sign(otherKey, map[string]any{"sub": "u1", "nonce": "n1"}),
+		"expired":       f.sign(f.key, map[string]any{"sub": "u1", "nonce": "n1", "exp": time.Now().Add(-time.Hour).Unix()}),
+		"wrong aud":     f.sign(f.key, map[string]any{"sub": "u1", "nonce": "n1", "aud": "someone-else"}),
+		"wrong issuer":  f.sign(f.key, map[string]any{"sub": "u1", "nonce": "n1", "iss": "https://evil.example"}),
+		"alg none":      none,
+		"missing nonce": f.sign(f.key, map[string]any{"sub": "u1"}),
+	}
+	for name, raw := range bad {
+		if _, err := client.VerifyIDToken(ctx, raw, "n1"); !errors.Is(err, oidc.ErrInvalidToken) {
+			t.Errorf("%s: expected ErrInvalidToken, got %v", name, err)
+		}
+	}
+}
+
+func TestOIDCClient_JWKSRefreshAndKeySize(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeOIDC(t)
+	client, err := oidc.NewClient(ctx, oidc.Config{Issuer: f.URL, ClientID: fakeClientID, RedirectURL: fakeRedirectURL})
+	if err != nil {
+		t.Fatalf("discovery failed: %v", err)
+	}
+	// 同时到来的请求共用一次 JWKS 读取。
+	start := f.jwksFetches.Load()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.VerifyIDToken(ctx, f.sign(f.key, map[string]any{"sub": "u1", "nonce": "n1"}), "n1"); err != nil {
+				t.Errorf("verify failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := f.jwksFetches.Load() - start; n != 1 {
+		t.Errorf("concurrent verifications should share one jwks fetch, got %d", n)
+	}
+
+	// 刷新间隔内未知的 kid 不会再次读取 JWKS。
+	for i := 0; i < 3; i++ {
+		raw := f.signWithKid(f.key, "rotated_"+strconv.Itoa(i), map[string]any{"sub": "u1", "nonce": "n1"})
+		if _, err := client.VerifyIDToken(ctx, raw, "n1"); !errors.Is(err, oidc.ErrInvalidToken) {
+			t.Errorf("unknown kid should be rejected: %v", err)
+		}
+	}
+	if n := f.jwksFetches.Load() - start; n != 1 {
+		t.Errorf("unknown kids should not refetch jwks within the interval, got %d fetches", n)
+	}
+
+	weak := newFakeOIDC(t)
+	weakKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	weak.key = weakKey
+	weakClient, err := oidc.NewClient(ctx, oidc.Config{Issuer: weak.URL, ClientID: fakeClientID, RedirectURL: fakeRedirectURL})
+	if err != nil {
+		t.Fatalf("discovery failed: %v", err)
+	}
+	if _, err := weakClient.VerifyIDToken(ctx, weak.sign(weakKey, map[string]any{"sub": "u1", "nonce": "n1"}), "n1"); !errors.Is(err, oidc.ErrInvalidToken) {
+		t.Errorf("rsa keys under 2048 bits should be rejected: %v", err)
+	}
+}
+
+// oidcLogin 经由测试身份提供方完成一次 SSO 登录，返回回调的响应。
+func oidcLogin(t *testing.T, r *gin.Engine, f *fakeOIDC, claims map[string]any) *httptest.ResponseRecorder {
+	t.Helper()
+	f.setUser(claims)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/user/oidc/login", nil))
+	if rr.Code != http.StatusFound {
+		t.Fatalf("oidc login should redirect, got %d %s", rr.Code, rr.Body.String())
+	}
+	q := authorizeCode(t, rr.Header().Get("Location"))
+
+	req := httptest.NewRequest("GET", "/user/oidc/callback?"+q.Encode(), nil)
+	req.AddCookie(rr.Result().Cookies()[0])
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+// profileOf 返回会话对应的用户名和角色。
+func profileOf(t *testing.T, r *gin.Engine, rr *httptest.ResponseRecorder) (string, string) {
+	t.Helper()
+	if rr.Code != http.StatusOK || len(rr.Result().Cookies()) == 0 {
+		t.Fatalf("oidc callback failed: %d %s", rr.Code, rr.Body.String())
+	}
+	var p struct {
+		UserName string `json:"user_name"`
+		Role     string `json:"role"`
+	}
+	json.Unmarshal(getWithCookie(r, rr.Result().Cookies()[0], "/user/profile").Body.Bytes(), &p)
+	return p.UserName, p.Role
+}
+
+func TestOIDC_LoginCreatesAndLinksUsers(t *testing.T) {
+	requireDB(t)
+	f := newFakeOIDC(t)
+	t.Setenv("FILESTORE_OIDC_ISSUER", f.URL)
+	t.Setenv("FILESTORE_OIDC_CLIENT_ID", fakeClientID)
+	t.Setenv("FILESTORE_OIDC_CLIENT_SECRET", fakeClientSecret)
+	t.Setenv("FILESTORE_OIDC_REDIRECT_URL", fakeRedirectURL)
+	t.Setenv("FILESTORE_OIDC_ROLE_MAP", "fs-admins=admin, staff=user")
+	r := newTestRouter()
+
+	// 首次登录创建用户，组映射为管理员角色。
+	sub, nick := "sub-"+randHex(6), "sso."+randHex(4)
+	name, role := profileOf(t, r, oidcLogin(t, r, f, map[string]any{"sub": sub, "preferred_username": nick, "groups": []string{"fs-admins"}}))
+	if name != nick || role != "admin" {
+		t.Fatalf("expected new admin %s, got %s/%s", nick, name, role)
+	}
+	// 再次登录得到同一用户，角色随组变化。
+	name, role = profileOf(t, r, oidcLogin(t, r, f, map[string]any{"sub": sub, "preferred_username": "renamed", "groups": []string{"staff"}}))
+	if name != nick || role != "user" {
+		t.Errorf("expected same user demoted, got %s/%s", name, role)
+	}
+
+	// 已验证邮箱恰好对应一个本地用户时关联该用户，本地密码登录不受影响。
+	password := "pwd_" + randHex(6)
+	local := signupWithPassword(t, r, password)
+	email := "l" + randHex(4) + "@example.com"
+	if _, err := db.DBconn().ExecContext(context.Background(), "update tbl_user set email=?,email_validated=1 where user_name=?", email, local); err != nil {
+		t.Fatal(err)
+	}
+	// 未验证的邮箱不会关联已有用户。
+	name, _ = profileOf(t, r, oidcLogin(t, r, f, map[string]any{"sub": "sub-" + randHex(6), "email": email, "email_verified": false}))
+	if name == local {
+		t.Error("unverified email should not link to an existing user")
+	}
+	name, _ = profileOf(t, r, oidcLogin(t, r, f, map[string]any{"sub": "sub-" + randHex(6), "email": email, "email_verified": true}))
+	if name != local {
+		t.Errorf("verified email should link to %s, got %s", local, name)
+	}
+	if loginCookie(r, local, password) == nil {
+		t.Error("local password login should still work")
+	}
+
+	// 回调的 state 与会话不一致时拒绝。
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/user/oidc/login", nil))
+	q := authorizeCode(t, rr.Header().Get("Location"))
+	q.Set("state", "forged")
+	req := httptest.NewRequest("GET", "/user/oidc/callback?"+q.Encode(), nil)
+	req.AddCookie(rr.Result().Cookies()[0])
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("forged state should be rejected, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,161 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"filestore-server/pkg/db"
+	"filestore-server/pkg/notify"
+	"filestore-server/service"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var resetTokenPattern = regexp.MustCompile(`: ([A-Za-z0-9_-]{43})\n`)
+
+// loginCookie 登录并返回会话 cookie，登录失败时返回 nil。
+func loginCookie(r *gin.Engine, username, password string) *http.Cookie {
+	form := url.Values{"username": {username}, "password": {password}}
+	req := httptest.NewRequest("POST", "/user/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || len(rr.Result().Cookies()) == 0 {
+		return nil
+	}
+	return rr.Result().Cookies()[0]
+}
+
+// signupWithPassword 用指定密码注册用户并返回用户名。
+func signupWithPassword(t *testing.T, r *gin.Engine, password string) string {
+	t.Helper()
+	username := "user_" + randHex(6)
+	form := url.Values{"username": {username}, "password": {password}}
+	req := httptest.NewRequest("POST", "/user/signup", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("signup failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	return username
+}
+
+func passwordCost(t *testing.T, username string) int {
+	t.Helper()
+	var hash string
+	if err := db.DBconn().QueryRowContext(context.Background(), "select user_pwd from tbl_user where user_name=?", username).Scan(&hash); err != nil {
+		t.Fatalf("failed to read password hash: %v", err)
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		t.Fatalf("invalid hash: %v", err)
+	}
+	return cost
+}
+
+func TestPassword_ChangeRevokesOtherSessions(t *testing.T) {
+	requireDB(t)
+
+	r := newTestRouter()
+	oldPwd, newPwd := "old_"+randHex(6), "new_"+randHex(6)
+	username := signupWithPassword(t, r, oldPwd)
+	current := loginCookie(r, username, oldPwd)
+	other := loginCookie(r, username, oldPwd)
+	if current == nil || other == nil {
+		t.Fatal("login failed")
+	}
+
+	if rr := postForm(t, r, current, "/user/password", url.Values{"old_password": {"wrong"}, "new_password": {newPwd}}); rr.Code != http.StatusForbidden {
+		t.Errorf("wrong old password should be rejected, got %d", rr.Code)
+	}
+	rr := postForm(t, r, current, "/user/password", url.Values{"old_password": {oldPwd}, "new_password": {newPwd}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("change password failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	// 当前会话使用响应中更新后的 cookie 继续有效。
+	if cookies := rr.Result().Cookies(); len(cookies) > 0 {
+		current = cookies[0]
+	}
+	if rr := getWithCookie(r, current, "/user/profile"); rr.Code != http.StatusOK {
+		t.Errorf("current session should stay valid, got %d", rr.Code)
+	}
+	if rr := getWithCookie(r, other, "/user/profile"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("other session should be revoked, got %d", rr.Code)
+	}
+	if loginCookie(r, username, oldPwd) != nil {
+		t.Error("old password should no longer work")
+	}
+	if loginCookie(r, username, newPwd) == nil {
+		t.Error("login with new password failed")
+	}
+}
+
+func TestPassword_ForgotAndReset(t *testing.T) {
+	requireDB(t)
+	t.Setenv("FILESTORE_RESET_RESEND_INTERVAL", "0s")
+	mails := &recordingNotifier{}
+	service.SetNotifier(notify.ChannelEmail, mails)
+	t.Cleanup(func() { service.SetNotifier(notify.ChannelEmail, nil) })
+
+	r := newTestRouter()
+	username := signupWithPassword(t, r, "old_"+randHex(6))
+	email := "r" + randHex(4) + "@example.com"
+	if _, err := db.DBconn().ExecContext(context.Background(), "update tbl_user set email=?,email_validated=1 where user_name=?", email, username); err != nil {
+		t.Fatalf("failed to set email: %v", err)
+	}
+
+	// 不存在的账户得到相同的响应，且不发送邮件。
+	rr := postForm(t, r, nil, "/user/password/forgot", url.Values{"login": {"nobody_" + randHex(4)}})
+	if rr.Code != http.StatusOK || len(mails.sent) != 0 {
+		t.Fatalf("unknown account: %d, sent %d", rr.Code, len(mails.sent))
+	}
+	if rr := postForm(t, r, nil, "/user/password/forgot", url.Values{"login": {email}}); rr.Code != http.StatusOK {
+		t.Fatalf("forgot failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	msg := mails.last()
+	m := resetTokenPattern.FindStringSubmatch(msg.Body)
+	if msg.To != email || m == nil {
+		t.Fatalf("reset mail not sent: %+v", msg)
+	}
+
+	newPwd := "new_" + randHex(6)
+	if rr := postForm(t, r, nil, "/user/password/reset", url.Values{"token": {m[1]}, "password": {newPwd}}); rr.Code != http.StatusOK {
+		t.Fatalf("reset failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	if rr := postForm(t, r, nil, "/user/password/reset", url.Values{"token": {m[1]}, "password": {"again_" + randHex(6)}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("token should be single use, got %d", rr.Code)
+	}
+	if loginCookie(r, username, newPwd) == nil {
+		t.Error("login with reset password failed")
+	}
+}
+
+func TestPassword_RehashOnLoginWhenCostRises(t *testing.T) {
+	requireDB(t)
+
+	r := newTestRouter()
+	password := "pwd_" + randHex(6)
+	t.Setenv("FILESTORE_BCRYPT_COST", "4")
+	username := signupWithPassword(t, r, password)
+	if cost := passwordCost(t, username); cost != 4 {
+		t.Fatalf("expected cost 4, got %d", cost)
+	}
+
+	t.Setenv("FILESTORE_BCRYPT_COST", "6")
+	if loginCookie(r, username, password) == nil {
+		t.Fatal("login failed")
+	}
+	if cost := passwordCost(t, username); cost != 6 {
+		t.Errorf("password should be rehashed with cost 6, got %d", cost)
+	}
+	if loginCookie(r, username, password) == nil {
+		t.Error("login after rehash failed")
+	}
+}
@@ -0,0 +1,160 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"filestore-server/pkg/db"
+	"filestore-server/pkg/notify"
+	"filestore-server/service"
+)
+
+var verifyCodePattern = regexp.MustCompile(`\b[0-9]{6}\b`)
+
+// recordingNotifier 记录发出的通知。
+type recordingNotifier struct {
+	mu   sync.Mutex
+	sent []notify.Message
+}
+
+func (n *recordingNotifier) Send(ctx context.Context, msg notify.Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sent = append(n.sent, msg)
+	return nil
+}
+
+func (n *recordingNotifier) last() notify.Message {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.sent) == 0 {
+		return notify.Message{}
+	}
+	return n.sent[len(n.sent)-1]
+}
+
+func TestProfile_EmailAndPhoneVerification(t *testing.T) {
+	requireDB(t)
+	t.Setenv("FILESTORE_VERIFY_RESEND_INTERVAL", "0s")
+
+	addr, mails := startFakeSMTP(t)
+	service.SetNotifier(notify.ChannelEmail, &notify.SMTPNotifier{Addr: addr, From: "noreply@example.com", Timeout: 5 * time.Second})
+	sms := &recordingNotifier{}
+	service.SetNotifier(notify.ChannelSMS, sms)
+	t.Cleanup(func() {
+		service.SetNotifier(notify.ChannelEmail, nil)
+		service.SetNotifier(notify.ChannelSMS, nil)
+	})
+
+	r := newTestRouter()
+	cookie, _ := signupAndLogin(t, r)
+
+	if rr := postForm(t, r, cookie, "/user/profile", url.Values{"nickname": {"Alice"}, "website": {"https://example.com"}}); rr.Code != http.StatusOK {
+		t.Fatalf("update profile failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	if rr := postForm(t, r, cookie, "/user/profile", url.Values{"website": {"javascript:alert(1)"}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid website should be rejected, got %d", rr.Code)
+	}
+
+	email := "u" + randHex(4) + "@example.com"
+	if rr := postForm(t, r, cookie, "/user/email", url.Values{"email": {"not an email"}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid email should be rejected, got %d", rr.Code)
+	}
+	if rr := postForm(t, r, cookie, "/user/email", url.Values{"email": {email}}); rr.Code != http.StatusOK {
+		t.Fatalf("set email failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	var code string
+	select {
+	case m := <-mails:
+		if len(m.To) != 1 || m.To[0] != email {
+			t.Fatalf("mail sent to wrong address: %+v", m.To)
+		}
+		code = verifyCodePattern.FindString(m.Data)
+	case <-time.After(5 * time.Second):
+		t.Fatal("verification mail not received")
+	}
+
+	wrong := "000000"
+	if wrong == code {
+		wrong = "111111"
+	}
+	if rr := postForm(t, r, cookie, "/user/email/verify", url.Values{"code": {wrong}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("wrong code should be rejected, got %d", rr.Code)
+	}
+	if rr := postForm(t, r, cookie, "/user/email/verify", url.Values{"code": {code}}); rr.Code != http.StatusOK {
+		t.Fatalf("verify email failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	if rr := postForm(t, r, cookie, "/user/email/verify", url.Values{"code": {code}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("code should be single use, got %d", rr.Code)
+	}
+
+	if rr := postForm(t, r, cookie, "/user/phone", url.Values{"phone": {"+86 138-0000-0000"}}); rr.Code != http.StatusOK {
+		t.Fatalf("set phone failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	msg := sms.last()
+	if msg.To != "+8613800000000" {
+		t.Fatalf("sms sent to wrong number: %q", msg.To)
+	}
+	if rr := postForm(t, r, cookie, "/user/phone/verify", url.Values{"code": {verifyCodePattern.FindString(msg.Body)}}); rr.Code != http.StatusOK {
+		t.Fatalf("verify phone failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+
+	rr := getWithCookie(r, cookie, "/user/profile")
+	var profile service.UserProfile
+	json.Unmarshal(rr.Body.Bytes(), &profile)
+	if profile.Email != email || !profile.EmailVerified || profile.Phone != "+8613800000000" || !profile.PhoneVerified {
+		t.Errorf("unexpected contact state: %+v", profile)
+	}
+	if profile.Profile.Nickname != "Alice" || profile.Profile.Website != "https://example.com" {
+		t.Errorf("profile not saved: %+v", profile.Profile)
+	}
+}
+
+func TestProfile_VerifyAttemptsLimitedUnderConcurrency(t *testing.T) {
+	requireDB(t)
+
+	sms := &recordingNotifier{}
+	service.SetNotifier(notify.ChannelSMS, sms)
+	t.Cleanup(func() { service.SetNotifier(notify.ChannelSMS, nil) })
+
+	r := newTestRouter()
+	cookie, username := signupAndLogin(t, r)
+	if rr := postForm(t, r, cookie, "/user/phone", url.Values{"phone": {"+86 139-0000-0000"}}); rr.Code != http.StatusOK {
+		t.Fatalf("set phone failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	code := verifyCodePattern.FindString(sms.last().Body)
+	wrong := "000000"
+	if wrong == code {
+		wrong = "111111"
+	}
+
+	// 并发的错误尝试合计不能超过上限。
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			postForm(t, r, cookie, "/user/phone/verify", url.Values{"code": {wrong}})
+		}()
+	}
+	wg.Wait()
+
+	var attempts int
+	err := db.DBconn().QueryRowContext(context.Background(),
+		"select attempts from tbl_user_verify_code where user_name=? and channel=?", username, notify.ChannelSMS).Scan(&attempts)
+	if err != nil {
+		t.Fatalf("failed to query attempts: %v", err)
+	}
+	if attempts != 5 {
+		t.Errorf("attempts should stop at the limit, got %d", attempts)
+	}
+	if rr := postForm(t, r, cookie, "/user/phone/verify", url.Values{"code": {code}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("correct code after exhausting attempts should be rejected, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,93 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"filestore-server/pkg/queue"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func waitJob(t *testing.T, q *queue.Queue, id string, statuses ...string) queue.Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := q.Get(context.Background(), id)
+		if err != nil {
+			t.Fatalf("get job failed: %v", err)
+		}
+		for _, s := range statuses {
+			if job.Status == s {
+				return job
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not reach %v in time", id, statuses)
+	return queue.Job{}
+}
+
+func TestQueue_RetryAndDeadLetter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q := queue.New(queue.NewMemoryBackend(), queue.Options{Workers: 2, MaxAttempts: 3, BaseBackoff: 10 * time.Millisecond})
+
+	var flakyCalls int32
+	q.Register("flaky", 0, func(ctx context.Context, job *queue.Job) error {
+		if atomic.AddInt32(&flakyCalls, 1) < 2 {
+			return errors.New("temporary failure")
+		}
+		return job.SetResult("ok")
+	})
+	q.Register("broken", 0, func(ctx context.Context, job *queue.Job) error {
+		return errors.New("always fails")
+	})
+	q.Register("fatal", 0, func(ctx context.Context, job *queue.Job) error {
+		return queue.Permanent(errors.New("bad input"))
+	})
+	q.Start(ctx)
+
+	flakyID, _ := q.Enqueue(ctx, "flaky", "alice", nil)
+	brokenID, _ := q.Enqueue(ctx, "broken", "alice", nil)
+	fatalID, _ := q.Enqueue(ctx, "fatal", "alice", nil)
+
+	flaky := waitJob(t, q, flakyID, queue.StatusDone, queue.StatusDead)
+	if flaky.Status != queue.StatusDone || flaky.Attempts != 2 {
+		t.Errorf("flaky job should succeed on second attempt: %+v", flaky)
+	}
+
+	broken := waitJob(t, q, brokenID, queue.StatusDead)
+	if broken.Attempts != 3 {
+		t.Errorf("broken job attempts mismatch: got %d want 3", broken.Attempts)
+	}
+
+	fatal := waitJob(t, q, fatalID, queue.StatusDead)
+	if fatal.Attempts != 1 {
+		t.Errorf("permanent error should not be retried: got %d attempts", fatal.Attempts)
+	}
+}
+
+func TestQueue_UnlimitedRetries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q := queue.New(queue.NewMemoryBackend(), queue.Options{Workers: 1, MaxAttempts: 2, BaseBackoff: time.Millisecond})
+
+	// 不限次数的任务在超过默认重试次数后仍会继续重试，直到成功。
+	var calls int32
+	q.Register("patient", queue.Unlimited, func(ctx context.Context, job *queue.Job) error {
+		if atomic.AddInt32(&calls, 1) <= 5 {
+			return errors.New("scanner unavailable")
+		}
+		return nil
+	})
+	q.Start(ctx)
+
+	id, _ := q.Enqueue(ctx, "patient", "", nil)
+	job := waitJob(t, q, id, queue.StatusDone, queue.StatusDead)
+	if job.Status != queue.StatusDone || job.Attempts != 6 {
+		t.Errorf("unlimited job should keep retrying until it succeeds: %+v", job)
+	}
+}
@@ -0,0 +1,164 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"filestore-server/pkg/dao"
+	"filestore-server/pkg/scan"
+	"filestore-server/service"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// startFakeClamd 启动一个实现 INSTREAM 协议的假 clamd，内容包含 EICAR 特征时报告感染。
+// gate 非 nil 时，每次应答前都要等待从 gate 收到信号。
+func startFakeClamd(t *testing.T, gate <-chan struct{}) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				cmd := make([]byte, len("zINSTREAM\x00"))
+				if _, err := io.ReadFull(conn, cmd); err != nil || string(cmd) != "zINSTREAM\x00" {
+					conn.Write([]byte("UNKNOWN COMMAND\x00"))
+					return
+				}
+				var data bytes.Buffer
+				size := make([]byte, 4)
+				for {
+					if _, err := io.ReadFull(conn, size); err != nil {
+						return
+					}
+					n := binary.BigEndian.Uint32(size)
+					if n == 0 {
+						break
+					}
+					if _, err := io.CopyN(&data, conn, int64(n)); err != nil {
+						return
+					}
+				}
+				if gate != nil {
+					<-gate
+				}
+				if strings.Contains(data.String(), "EICAR-STANDARD-ANTIVIRUS-TEST-FILE") {
+					conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+					return
+				}
+				conn.Write([]byte("stream: OK\x00"))
+			}(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestScan_ClamdProtocol(t *testing.T) {
+	addr := startFakeClamd(t, nil)
+	s := scan.NewClamdScanner("tcp://"+addr, time.Second)
+	ctx := context.Background()
+
+	// 超过一个块的数据，确保分块发送正确。
+	clean := bytes.Repeat([]byte("clean data "), 10000)
+	result, err := s.Scan(ctx, bytes.NewReader(clean))
+	if err != nil {
+		t.Fatalf("scan clean failed: %v", err)
+	}
+	if result.Infected {
+		t.Errorf("clean data reported infected")
+	}
+
+	result, err = s.Scan(ctx, strings.NewReader(eicar))
+	if err != nil {
+		t.Fatalf("scan eicar failed: %v", err)
+	}
+	if !result.Infected || result.Signature != "Eicar-Test-Signature" {
+		t.Errorf("eicar not detected: %+v", result)
+	}
+
+	if _, err := scan.NewClamdScanner("127.0.0.1:1", 100*time.Millisecond).Scan(ctx, strings.NewReader("x")); err == nil {
+		t.Errorf("unreachable clamd should return an error")
+	}
+}
+
+func waitScanState(t *testing.T, filehash string, want int) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		fmeta, err := dao.GetFileMeta(context.Background(), filehash)
+		if err != nil {
+			t.Fatalf("get meta failed: %v", err)
+		}
+		if fmeta.ScanState == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("scan state of %s is %d, want %d", filehash, fmeta.ScanState, want)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestScan_BlocksDownloadUntilClean(t *testing.T) {
+	requireDB(t)
+
+	gate := make(chan struct{})
+	service.SetScanner(scan.NewClamdScanner(startFakeClamd(t, gate), time.Second))
+	defer service.SetScanner(nil)
+
+	r := newTestRouter()
+	sessionCookie, _ := signupAndLogin(t, r)
+	download := func(filehash string) int {
+		req := httptest.NewRequest("GET", "/file/download?filehash="+filehash, nil)
+		req.AddCookie(sessionCookie)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	clean := uploadForTest(t, r, sessionCookie, "clean_"+randHex(4)+".txt", []byte("clean "+randHex(8)))
+	if code := download(clean.FileSha1); code != http.StatusConflict {
+		t.Errorf("pending file should not be downloadable, got %d", code)
+	}
+	gate <- struct{}{}
+	waitScanState(t, clean.FileSha1, dao.ScanClean)
+	if code := download(clean.FileSha1); code != http.StatusOK {
+		t.Errorf("clean file should be downloadable, got %d", code)
+	}
+
+	infected := uploadForTest(t, r, sessionCookie, "virus_"+randHex(4)+".txt", []byte(eicar+randHex(8)))
+	gate <- struct{}{}
+	waitScanState(t, infected.FileSha1, dao.ScanInfected)
+	if code := download(infected.FileSha1); code != http.StatusForbidden {
+		t.Errorf("infected file should be blocked, got %d", code)
+	}
+
+	// 审计日志在状态更新之后写入，稍等片刻。
+	var logs []dao.AuditLog
+	for deadline := time.Now().Add(5 * time.Second); len(logs) == 0 && time.Now().Before(deadline); {
+		var err error
+		if logs, err = dao.GetAuditLogs(context.Background(), infected.FileSha1, 10); err != nil {
+			t.Fatalf("get audit logs failed: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if len(logs) == 0 || logs[0].Action != "file.infected" || !strings.Contains(logs[0].Detail, "Eicar-Test-Signature") {
+		t.Errorf("audit entry missing: %+v", logs)
+	}
+}
@@ -0,0 +1,141 @@
+package test
+
+import (
+	"encoding/json"
+	"filestore-server/pkg/dao"
+	"filestore-server/pkg/search"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSearchIndex_PutSearchDelete(t *testing.T) {
+	idx, err := search.Open(filepath.Join(t.TempDir(), "index.bleve"))
+	if err != nil {
+		t.Fatalf("open index failed: %v", err)
+	}
+	defer idx.Close()
+
+	idx.Put("a", "the quarterly budget report for finance")
+	idx.Put("b", "holiday photos and travel notes")
+	idx.Put("c", "budget draft")
+	all := []string{"a", "b", "c"}
+
+	ids, total, err := idx.Search("budget report", all, 0, 10)
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	if len(ids) != 1 || ids[0] != "a" || total != 1 {
+		t.Errorf("all terms should be required, got %v total=%d", ids, total)
+	}
+
+	ids, total, _ = idx.Search("budget", all, 0, 10)
+	if len(ids) != 2 || total != 2 {
+		t.Errorf("expected 2 hits, got %v total=%d", ids, total)
+	}
+
+	// 只在给定的文档中检索，总数不含其他文档。
+	ids, total, _ = idx.Search("budget", []string{"b", "c"}, 0, 10)
+	if len(ids) != 1 || ids[0] != "c" || total != 1 {
+		t.Errorf("search should be limited to the given ids, got %v total=%d", ids, total)
+	}
+	ids, total, _ = idx.Search("budget", all, 1, 1)
+	if len(ids) != 1 || total != 2 {
+		t.Errorf("paging should keep the total, got %v total=%d", ids, total)
+	}
+
+	idx.Delete("a")
+	ids, _, _ = idx.Search("budget", all, 0, 10)
+	if len(ids) != 1 || ids[0] != "c" {
+		t.Errorf("deleted document still returned: %v", ids)
+	}
+}
+
+func searchFiles(t *testing.T, r *gin.Engine, cookie *http.Cookie, params url.Values) []dao.FileMeta {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/file/search?"+params.Encode(), nil)
+	req.AddCookie(cookie)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("search failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Total int
+		Files []dao.FileMeta
+	}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.Total != len(resp.Files) {
+		t.Fatalf("total %d does not match %d files", resp.Total, len(resp.Files))
+	}
+	return resp.Files
+}
+
+func TestFileSearch_FiltersAndFullText(t *testing.T) {
+	requireDB(t)
+
+	r := newTestRouter()
+	sessionCookie, _ := signupAndLogin(t, r)
+	word := "zebra" + randHex(4)
+
+	report := uploadForTest(t, r, sessionCookie, "report_"+randHex(4)+".txt", []byte("annual report mentions "+word))
+	uploadForTest(t, r, sessionCookie, "notes_"+randHex(4)+".md", []byte("meeting notes "+randHex(32)))
+	pic := uploadForTest(t, r, sessionCookie, "image_"+randHex(4)+".png", []byte("\x89PNG\r\n\x1a\n"+randHex(8)))
+
+	if files := searchFiles(t, r, sessionCookie, url.Values{"name": {"report_"}}); len(files) != 1 || files[0].FileSha1 != report.FileSha1 {
+		t.Errorf("substring search mismatch: %+v", files)
+	}
+	if files := searchFiles(t, r, sessionCookie, url.Values{"name": {"*.md"}}); len(files) != 1 {
+		t.Errorf("glob search mismatch: %+v", files)
+	}
+	if files := searchFiles(t, r, sessionCookie, url.Values{"mime": {"image/*"}}); len(files) != 1 || files[0].FileSha1 != pic.FileSha1 {
+		t.Errorf("mime search mismatch: %+v", files)
+	}
+	if files := searchFiles(t, r, sessionCookie, url.Values{"min_size": {"60"}}); len(files) != 1 {
+		t.Errorf("size search mismatch: %+v", files)
+	}
+	today := time.Now().Format(time.DateOnly)
+	if files := searchFiles(t, r, sessionCookie, url.Values{"from": {today}, "to": {today}}); len(files) != 3 {
+		t.Errorf("date search mismatch: %+v", files)
+	}
+
+	// 其他用户的文件也包含相同的词，但不出现在结果和总数中。
+	otherCookie, _ := signupAndLogin(t, r)
+	uploadForTest(t, r, otherCookie, "other_"+randHex(4)+".txt", []byte("someone else's "+word))
+
+	// 全文索引由后台任务写入。
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		files := searchFiles(t, r, sessionCookie, url.Values{"q": {word}})
+		if len(files) == 1 && files[0].FileSha1 == report.FileSha1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("full-text search did not find report: %+v", files)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	req := httptest.NewRequest("GET", "/file/search?q="+word, nil)
+	req.AddCookie(sessionCookie)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	var resp struct{ Total int }
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if rr.Code != http.StatusOK || resp.Total != 1 {
+		t.Errorf("full-text total should only count the caller's files: %d %s", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/file/search?min_size=10&max_size=5", nil)
+	req.AddCookie(sessionCookie)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid size range should be rejected, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,102 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"filestore-server/pkg/dao"
+	"filestore-server/service"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tags, err := service.NormalizeTags([]string{" Work ", "work", "项目"})
+	if err != nil {
+		t.Fatalf("normalize failed: %v", err)
+	}
+	if len(tags) != 2 || tags[0] != "work" || tags[1] != "项目" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+
+	for _, bad := range []string{"", "a,b", "tab\there", strings.Repeat("x", 65)} {
+		if _, err := service.NormalizeTags([]string{bad}); !errors.Is(err, service.ErrInvalidTag) {
+			t.Errorf("tag %q should be rejected, got %v", bad, err)
+		}
+	}
+}
+
+func TestFileTagsAndAttrs(t *testing.T) {
+	requireDB(t)
+
+	r := newTestRouter()
+	cookie, _ := signupAndLogin(t, r)
+	otherCookie, _ := signupAndLogin(t, r)
+
+	a := uploadForTest(t, r, cookie, "a_"+randHex(4)+".txt", []byte("a "+randHex(16)))
+	b := uploadForTest(t, r, cookie, "b_"+randHex(4)+".txt", []byte("b "+randHex(16)))
+
+	rr := postForm(t, r, cookie, "/file/tags", url.Values{"filehash": {a.FileSha1 + "," + b.FileSha1}, "tag": {"Work"}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("bulk tag failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	rr = postForm(t, r, cookie, "/file/tags", url.Values{"filehash": {a.FileSha1}, "tag": {"urgent"}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("tag failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+
+	// 标签按用户隔离，不能给别人的文件打标签。
+	rr = postForm(t, r, otherCookie, "/file/tags", url.Values{"filehash": {a.FileSha1}, "tag": {"mine"}})
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("tagging another user's file should fail, got %d", rr.Code)
+	}
+
+	if files := searchFiles(t, r, cookie, url.Values{"tag": {"work"}}); len(files) != 2 {
+		t.Errorf("expected 2 files tagged work, got %+v", files)
+	}
+	files := searchFiles(t, r, cookie, url.Values{"tag": {"work", "urgent"}})
+	if len(files) != 1 || files[0].FileSha1 != a.FileSha1 {
+		t.Fatalf("expected only file a tagged work and urgent, got %+v", files)
+	}
+	if len(files[0].Tags) != 2 || files[0].Tags[0] != "urgent" || files[0].Tags[1] != "work" {
+		t.Errorf("search result should carry tags, got %v", files[0].Tags)
+	}
+
+	rr = postForm(t, r, cookie, "/file/attr", url.Values{"filehash": {a.FileSha1}, "key": {"project"}, "value": {"apollo"}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("set attr failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	rr = postForm(t, r, cookie, "/file/attr", url.Values{"filehash": {a.FileSha1}, "key": {"bad key"}, "value": {"x"}})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid attr key should be rejected, got %d", rr.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/file/meta?filehash="+a.FileSha1, nil)
+	req.AddCookie(cookie)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	var meta dao.FileMeta
+	json.Unmarshal(rr.Body.Bytes(), &meta)
+	if len(meta.Tags) != 2 || meta.Attrs["project"] != "apollo" {
+		t.Errorf("meta should include tags and attrs, got %+v", meta)
+	}
+
+	rr = postForm(t, r, cookie, "/file/tags/remove", url.Values{"filehash": {a.FileSha1, b.FileSha1}, "tag": {"work"}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("bulk untag failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	if files := searchFiles(t, r, cookie, url.Values{"tag": {"work"}}); len(files) != 0 {
+		t.Errorf("expected no files tagged work after untag, got %+v", files)
+	}
+
+	rr = postForm(t, r, cookie, "/file/attr/delete", url.Values{"filehash": {a.FileSha1}, "key": {"project"}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("delete attr failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	rr = postForm(t, r, cookie, "/file/attr/delete", url.Values{"filehash": {a.FileSha1}, "key": {"project"}})
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("deleting a missing attr should return 404, got %d", rr.Code)
+	}
+}
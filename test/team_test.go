@@ -0,0 +1,158 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func getWithCookie(r *gin.Engine, cookie *http.Cookie, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	req.AddCookie(cookie)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+// joinTeam 由 owner 邀请 member 以 role 加入团队，并由 member 接受邀请。
+func joinTeam(t *testing.T, r *gin.Engine, ownerCookie, memberCookie *http.Cookie, team, member, role string) {
+	t.Helper()
+	rr := postForm(t, r, ownerCookie, "/team/invite", url.Values{"team": {team}, "user": {member}, "role": {role}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("invite failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	var invite struct{ ID int64 }
+	json.Unmarshal(rr.Body.Bytes(), &invite)
+	rr = postForm(t, r, memberCookie, "/team/invite/accept", url.Values{"id": {strconv.FormatInt(invite.ID, 10)}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("accept invite failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestTeam_RolesControlFileAccess(t *testing.T) {
+	requireDB(t)
+
+	r := newTestRouter()
+	ownerCookie, _ := signupAndLogin(t, r)
+	editorCookie, editor := signupAndLogin(t, r)
+	viewerCookie, viewer := signupAndLogin(t, r)
+	outsiderCookie, outsider := signupAndLogin(t, r)
+
+	rr := postForm(t, r, ownerCookie, "/team/create", url.Values{"name": {"team " + randHex(4)}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create team failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	var created struct{ ID int64 }
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	team := strconv.FormatInt(created.ID, 10)
+
+	joinTeam(t, r, ownerCookie, editorCookie, team, editor, "editor")
+	joinTeam(t, r, ownerCookie, viewerCookie, team, viewer, "viewer")
+	if rr := postForm(t, r, viewerCookie, "/team/invite", url.Values{"team": {team}, "user": {outsider}}); rr.Code != http.StatusForbidden {
+		t.Errorf("viewer should not invite members, got %d", rr.Code)
+	}
+
+	upload := func(cookie *http.Cookie, name string) *httptest.ResponseRecorder {
+		req, _ := createUploadRequest("file", name, []byte("team "+randHex(16)))
+		req.URL.RawQuery = "team=" + team
+		req.AddCookie(cookie)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+	rr = upload(editorCookie, "plan_"+randHex(4)+".txt")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("editor upload failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	var uploaded struct {
+		File struct{ FileSha1 string }
+	}
+	json.Unmarshal(rr.Body.Bytes(), &uploaded)
+	filehash := uploaded.File.FileSha1
+	if rr := upload(viewerCookie, "nope_"+randHex(4)+".txt"); rr.Code != http.StatusForbidden {
+		t.Errorf("viewer upload should be forbidden, got %d", rr.Code)
+	}
+
+	// viewer 可以看到并下载团队文件，但不能删除。
+	if code, resp := queryFilelist(t, r, viewerCookie, viewer, url.Values{"team": {team}}); code != http.StatusOK || len(resp.Files) != 1 {
+		t.Errorf("viewer should list the team file, got %d %+v", code, resp.Files)
+	}
+	if rr := getWithCookie(r, viewerCookie, "/file/download?filehash="+filehash+"&team="+team); rr.Code != http.StatusOK {
+		t.Errorf("viewer download failed: %d", rr.Code)
+	}
+	if rr := postForm(t, r, viewerCookie, "/file/delete", url.Values{"filehash": {filehash}, "team": {team}}); rr.Code != http.StatusForbidden {
+		t.Errorf("viewer delete should be forbidden, got %d", rr.Code)
+	}
+
+	// 非成员既看不到团队，也访问不到团队文件。
+	if rr := getWithCookie(r, outsiderCookie, "/file/meta?filehash="+filehash+"&team="+team); rr.Code != http.StatusNotFound {
+		t.Errorf("outsider should not see team file, got %d", rr.Code)
+	}
+	if code, _ := queryFilelist(t, r, outsiderCookie, outsider, url.Values{"team": {team}}); code != http.StatusNotFound {
+		t.Errorf("outsider team listing should return 404, got %d", code)
+	}
+	if code, _ := queryFilelist(t, r, outsiderCookie, viewer, url.Values{}); code != http.StatusForbidden {
+		t.Errorf("listing another user's files should be forbidden, got %d", code)
+	}
+
+	// 降级为 viewer 后 editor 失去写权限。
+	if rr := postForm(t, r, ownerCookie, "/team/member/role", url.Values{"team": {team}, "user": {editor}, "role": {"viewer"}}); rr.Code != http.StatusOK {
+		t.Fatalf("change role failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	if rr := postForm(t, r, editorCookie, "/file/delete", url.Values{"filehash": {filehash}, "team": {team}}); rr.Code != http.StatusForbidden {
+		t.Errorf("demoted editor delete should be forbidden, got %d", rr.Code)
+	}
+
+	if rr := postForm(t, r, ownerCookie, "/team/member/remove", url.Values{"team": {team}}); rr.Code != http.StatusConflict {
+		t.Errorf("last owner should not leave, got %d", rr.Code)
+	}
+	if rr := postForm(t, r, viewerCookie, "/team/member/remove", url.Values{"team": {team}}); rr.Code != http.StatusOK {
+		t.Errorf("viewer leave failed: %d", rr.Code)
+	}
+	if rr := getWithCookie(r, viewerCookie, "/file/download?filehash="+filehash+"&team="+team); rr.Code != http.StatusNotFound {
+		t.Errorf("former member should lose access, got %d", rr.Code)
+	}
+}
+
+func TestTeam_FileOpsOnlyTouchRequestedSpace(t *testing.T) {
+	requireDB(t)
+
+	r := newTestRouter()
+	cookie, _ := signupAndLogin(t, r)
+
+	rr := postForm(t, r, cookie, "/team/create", url.Values{"name": {"team " + randHex(4)}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create team failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	var created struct{ ID int64 }
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	team := strconv.FormatInt(created.ID, 10)
+
+	// 同一内容分别保存在个人空间和团队空间。
+	content := []byte("both " + randHex(16))
+	mine := uploadForTest(t, r, cookie, "mine_"+randHex(4)+".txt", content)
+	req, _ := createUploadRequest("file", "team_"+randHex(4)+".txt", content)
+	req.URL.RawQuery = "team=" + team
+	req.AddCookie(cookie)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("team upload failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+
+	if rr := postForm(t, r, cookie, "/file/delete", url.Values{"filehash": {mine.FileSha1}}); rr.Code != http.StatusOK {
+		t.Fatalf("delete failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	// 不带 team 参数只删除个人空间中的文件，团队空间中的文件不受影响。
+	if rr := getWithCookie(r, cookie, "/file/meta?filehash="+mine.FileSha1); rr.Code != http.StatusNotFound {
+		t.Errorf("personal copy should be deleted, got %d", rr.Code)
+	}
+	if rr := getWithCookie(r, cookie, "/file/download?filehash="+mine.FileSha1+"&team="+team); rr.Code != http.StatusOK {
+		t.Errorf("team copy should be kept, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,124 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"filestore-server/pkg/db"
+	"filestore-server/pkg/throttle"
+	"filestore-server/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMemoryThrottle_SlidingWindow(t *testing.T) {
+	ctx := context.Background()
+	s := throttle.NewMemoryStore()
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		s.RecordFailure(ctx, "k", now.Add(time.Duration(i)*time.Minute), 5*time.Minute)
+	}
+	if n, _ := s.Failures(ctx, "k", now.Add(2*time.Minute), 5*time.Minute); n != 3 {
+		t.Errorf("expected 3 failures in window, got %d", n)
+	}
+	if n, _ := s.Failures(ctx, "k", now.Add(6*time.Minute+time.Second), 5*time.Minute); n != 1 {
+		t.Errorf("old failures should slide out of the window, got %d", n)
+	}
+
+	s.Block(ctx, "k", now.Add(time.Minute))
+	if until, _ := s.BlockedUntil(ctx, "k", now); !until.Equal(now.Add(time.Minute)) {
+		t.Errorf("unexpected block: %v", until)
+	}
+	if until, _ := s.BlockedUntil(ctx, "k", now.Add(2*time.Minute)); !until.IsZero() {
+		t.Errorf("block should expire, got %v", until)
+	}
+	s.Reset(ctx, "k")
+	if n, _ := s.Failures(ctx, "k", now.Add(2*time.Minute), 5*time.Minute); n != 0 {
+		t.Errorf("reset should clear failures, got %d", n)
+	}
+}
+
+// loginFrom 从指定 IP 登录，返回响应。
+func loginFrom(r *gin.Engine, ip, username, password string) *httptest.ResponseRecorder {
+	form := url.Values{"username": {username}, "password": {password}}
+	req := httptest.NewRequest("POST", "/user/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Forwarded-For", ip)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestLogin_DelayAndLockout(t *testing.T) {
+	requireDB(t)
+	service.SetLoginThrottle(throttle.NewMemoryStore())
+	t.Cleanup(func() { service.SetLoginThrottle(nil) })
+	t.Setenv("FILESTORE_LOGIN_DELAY_AFTER", "2")
+	t.Setenv("FILESTORE_LOGIN_DELAY_BASE", "50ms")
+	t.Setenv("FILESTORE_LOGIN_DELAY_MAX", "50ms")
+	t.Setenv("FILESTORE_LOGIN_LOCK_AFTER", "4")
+	t.Setenv("FILESTORE_LOGIN_LOCK_DURATION", "1h")
+
+	r := newTestRouter()
+	adminCookie, adminName := signupAndLogin(t, r)
+	if _, err := db.DBconn().ExecContext(context.Background(), "update tbl_user set role='admin' where user_name=?", adminName); err != nil {
+		t.Fatalf("failed to promote admin: %v", err)
+	}
+	password := "pwd_" + randHex(6)
+	username := signupWithPassword(t, r, password)
+	ip := "198.51.100.7"
+
+	// 未知用户名与密码错误的响应相同。
+	unknown := loginFrom(r, "198.51.100.8", "nobody_"+randHex(4), "whatever1")
+	wrong := loginFrom(r, ip, username, "wrong_password1")
+	if unknown.Code != http.StatusUnauthorized || wrong.Code != http.StatusUnauthorized || unknown.Body.String() != wrong.Body.String() {
+		t.Fatalf("unknown user and wrong password should look the same: %d %s / %d %s",
+			unknown.Code, unknown.Body.String(), wrong.Code, wrong.Body.String())
+	}
+
+	if rr := loginFrom(r, ip, username, "wrong_password2"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("second failure: %d", rr.Code)
+	}
+	// 两次失败后需要等待，即使密码正确。
+	rr := loginFrom(r, ip, username, password)
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected delay, got %d headers %v", rr.Code, rr.Header())
+	}
+
+	for i := 0; i < 2; i++ {
+		time.Sleep(60 * time.Millisecond)
+		if rr := loginFrom(r, ip, username, "wrong_again"); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("failure after delay: %d %s", rr.Code, rr.Body.String())
+		}
+	}
+	time.Sleep(60 * time.Millisecond)
+	rr = loginFrom(r, ip, username, password)
+	if rr.Code != http.StatusTooManyRequests || !strings.Contains(rr.Body.String(), "locked") {
+		t.Fatalf("account should be locked: %d %s", rr.Code, rr.Body.String())
+	}
+
+	var detail struct {
+		Login struct {
+			Failures     int    `json:"login_failures"`
+			BlockedUntil string `json:"login_blocked_until"`
+		} `json:"login"`
+	}
+	json.Unmarshal(getWithCookie(r, adminCookie, "/admin/users/"+username).Body.Bytes(), &detail)
+	if detail.Login.Failures != 4 || detail.Login.BlockedUntil == "" {
+		t.Errorf("admin should see lock state: %+v", detail.Login)
+	}
+
+	if rr := postForm(t, r, adminCookie, "/admin/users/"+username+"/unlock", nil); rr.Code != http.StatusOK {
+		t.Fatalf("unlock failed: %d", rr.Code)
+	}
+	if rr := loginFrom(r, ip, username, password); rr.Code != http.StatusOK {
+		t.Errorf("login after unlock failed: %d %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,152 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"filestore-server/pkg/dao"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func uploadForTest(t *testing.T, r *gin.Engine, sessionCookie *http.Cookie, filename string, content []byte) dao.FileMeta {
+	t.Helper()
+	req, err := createUploadRequest("file", filename, content)
+	if err != nil {
+		t.Fatalf("create request failed: %v", err)
+	}
+	req.AddCookie(sessionCookie)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("upload failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	var upload struct {
+		File dao.FileMeta `json:"file"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &upload)
+	return upload.File
+}
+
+func TestFileThumbnail(t *testing.T) {
+	requireDB(t)
+
+	r := newTestRouter()
+	sessionCookie, _ := signupAndLogin(t, r)
+
+	// 400x200 的随机色图片，保证每次内容不同。
+	img := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	seed := randHex(3)
+	fill := color.RGBA{R: seed[0], G: seed[2], B: seed[4], A: 255}
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 400; x++ {
+			img.Set(x, y, fill)
+		}
+	}
+	img.Set(0, 0, color.RGBA{R: seed[1], G: seed[3], B: seed[5], A: 128})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encode png failed: %v", err)
+	}
+	pic := uploadForTest(t, r, sessionCookie, "pic_"+randHex(4)+".png", buf.Bytes())
+
+	req := httptest.NewRequest("GET", "/file/thumbnail?filehash="+pic.FileSha1+"&size=128", nil)
+	req.AddCookie(sessionCookie)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("thumbnail failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "image/jpeg" {
+		t.Errorf("Content-Type mismatch: %s", ct)
+	}
+	thumb, err := jpeg.Decode(rr.Body)
+	if err != nil {
+		t.Fatalf("thumbnail is not a jpeg: %v", err)
+	}
+	if b := thumb.Bounds(); b.Dx() != 128 || b.Dy() != 64 {
+		t.Errorf("thumbnail size mismatch: %v", b)
+	}
+
+	req = httptest.NewRequest("GET", "/file/thumbnail?filehash="+pic.FileSha1+"&size=100", nil)
+	req.AddCookie(sessionCookie)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("unsupported size should be rejected, got %d", rr.Code)
+	}
+
+	text := uploadForTest(t, r, sessionCookie, "note_"+randHex(4)+".txt", []byte("not an image "+randHex(8)))
+	req = httptest.NewRequest("GET", "/file/thumbnail?filehash="+text.FileSha1, nil)
+	req.AddCookie(sessionCookie)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("non-image should have no preview, got %d body:%s", rr.Code, rr.Body.String())
+	}
+}
+
+// buildPDF 生成只有一页文字的最小 PDF，页面大小为 Letter（612x792 点）。
+func buildPDF(text string) []byte {
+	stream := "BT /F1 24 Tf 72 700 Td (" + text + ") Tj ET"
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 /MediaBox [0 0 612 792] >>",
+		"<< /Type /Page /Parent 2 0 R /Resources << /Font << /F1 4 0 R >> >> /Contents 5 0 R >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(stream), stream),
+	}
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+func TestFileThumbnail_PDFPreviewStoredAsDerivedBlob(t *testing.T) {
+	requireDB(t)
+
+	r := newTestRouter()
+	sessionCookie, _ := signupAndLogin(t, r)
+	doc := uploadForTest(t, r, sessionCookie, "doc_"+randHex(4)+".pdf", buildPDF("Preview "+randHex(8)))
+
+	req := httptest.NewRequest("GET", "/file/thumbnail?filehash="+doc.FileSha1+"&size=256", nil)
+	req.AddCookie(sessionCookie)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("pdf thumbnail failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	thumb, err := jpeg.Decode(rr.Body)
+	if err != nil {
+		t.Fatalf("thumbnail is not a jpeg: %v", err)
+	}
+	if b := thumb.Bounds(); b.Dy() != 256 || b.Dx() >= b.Dy() {
+		t.Errorf("pdf preview should keep the portrait page shape, got %v", b)
+	}
+
+	blob, err := dao.GetDerivedBlob(context.Background(), doc.FileSha1, "thumb_256")
+	if err != nil {
+		t.Fatalf("thumbnail should be saved as a derived blob: %v", err)
+	}
+	if blob.StoredSize <= 0 || blob.Location == "" {
+		t.Errorf("unexpected derived blob %+v", blob)
+	}
+}
@@ -0,0 +1,202 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"filestore-server/pkg/db"
+	"filestore-server/pkg/throttle"
+	"filestore-server/pkg/totp"
+	"filestore-server/service"
+)
+
+func TestTOTP_RFC6238Vectors(t *testing.T) {
+	// RFC 6238 附录 B 的 SHA1 测试密钥 "12345678901234567890"，取 8 位结果的后 6 位。
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	cases := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tc := range cases {
+		got, err := totp.Code(secret, totp.Step(time.Unix(tc.unix, 0)))
+		if err != nil || got != tc.want {
+			t.Errorf("Code at %d = %q, %v; want %q", tc.unix, got, err, tc.want)
+		}
+	}
+
+	now := time.Unix(1234567890, 0)
+	if step, ok := totp.Validate(secret, "005924", now.Add(30*time.Second), 1); !ok || step != totp.Step(now) {
+		t.Errorf("code from previous step should be accepted within skew: %d %v", step, ok)
+	}
+	if _, ok := totp.Validate(secret, "005924", now.Add(90*time.Second), 1); ok {
+		t.Error("code outside skew should be rejected")
+	}
+
+	uri := totp.URI("file store", "alice", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/file%20store:alice?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("unexpected uri: %s", uri)
+	}
+}
+
+// currentCode 返回密钥在当前时间步之后第 offset 个时间步的验证码。
+func currentCode(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+	code, err := totp.Code(secret, totp.Step(time.Now())+offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestTOTP_EnrollAndTwoStepLogin(t *testing.T) {
+	requireDB(t)
+	service.SetLoginThrottle(throttle.NewMemoryStore())
+	t.Cleanup(func() { service.SetLoginThrottle(nil) })
+
+	r := newTestRouter()
+	password := "pwd_" + randHex(6)
+	username := signupWithPassword(t, r, password)
+	cookie := loginCookie(r, username, password)
+	if cookie == nil {
+		t.Fatal("login failed")
+	}
+
+	var enrollment service.TOTPEnrollment
+	rr := postForm(t, r, cookie, "/user/2fa/enroll", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("enroll failed: %d %s", rr.Code, rr.Body.String())
+	}
+	json.Unmarshal(rr.Body.Bytes(), &enrollment)
+	if enrollment.Secret == "" || !strings.Contains(enrollment.URI, "secret="+enrollment.Secret) {
+		t.Fatalf("unexpected enrollment: %+v", enrollment)
+	}
+
+	code := currentCode(t, enrollment.Secret, 0)
+	wrong := code[:5] + string('0'+(code[5]-'0'+1)%10)
+	if rr := postForm(t, r, cookie, "/user/2fa/confirm", url.Values{"code": {wrong}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("wrong code should be rejected, got %d", rr.Code)
+	}
+	var confirmed struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	rr = postForm(t, r, cookie, "/user/2fa/confirm", url.Values{"code": {currentCode(t, enrollment.Secret, 0)}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("confirm failed: %d %s", rr.Code, rr.Body.String())
+	}
+	json.Unmarshal(rr.Body.Bytes(), &confirmed)
+	if len(confirmed.RecoveryCodes) != 10 {
+		t.Fatalf("expected 10 recovery codes, got %v", confirmed.RecoveryCodes)
+	}
+
+	// 密码正确后只得到待验证状态。
+	ip := "203.0.113.49"
+	rr = loginFrom(r, ip, username, password)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "2fa_required") {
+		t.Fatalf("expected pending login: %d %s", rr.Code, rr.Body.String())
+	}
+	pending := rr.Result().Cookies()[0]
+	if rr := getWithCookie(r, pending, "/user/profile"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("pending session should not be authenticated, got %d", rr.Code)
+	}
+	// 确认时使用过的验证码不能再次使用。
+	if rr := postForm(t, r, pending, "/user/login/2fa", url.Values{"code": {currentCode(t, enrollment.Secret, 0)}}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("replayed code should be rejected, got %d", rr.Code)
+	}
+	rr = postForm(t, r, pending, "/user/login/2fa", url.Values{"code": {currentCode(t, enrollment.Secret, 1)}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("second factor failed: %d %s", rr.Code, rr.Body.String())
+	}
+	other := rr.Result().Cookies()[0]
+	if rr := getWithCookie(r, other, "/user/profile"); rr.Code != http.StatusOK {
+		t.Errorf("session after second factor should be valid, got %d", rr.Code)
+	}
+
+	// 恢复码只能使用一次。
+	recovery := strings.ToUpper(confirmed.RecoveryCodes[0])
+	pending = loginFrom(r, ip, username, password).Result().Cookies()[0]
+	if rr := postForm(t, r, pending, "/user/login/2fa", url.Values{"code": {recovery}}); rr.Code != http.StatusOK {
+		t.Fatalf("recovery code login failed: %d %s", rr.Code, rr.Body.String())
+	}
+	pending = loginFrom(r, ip, username, password).Result().Cookies()[0]
+	if rr := postForm(t, r, pending, "/user/login/2fa", url.Values{"code": {recovery}}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("recovery code should be single use, got %d", rr.Code)
+	}
+
+	var status service.TOTPStatus
+	json.Unmarshal(getWithCookie(r, cookie, "/user/2fa").Body.Bytes(), &status)
+	if !status.Enabled || status.RecoveryCodesLeft != 9 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+
+	// 关闭两步验证后其他会话失效，当前会话刷新后继续有效。
+	rr = postForm(t, r, cookie, "/user/2fa/disable", url.Values{"password": {password}, "code": {confirmed.RecoveryCodes[1]}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("disable failed: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := getWithCookie(r, rr.Result().Cookies()[0], "/user/profile"); rr.Code != http.StatusOK {
+		t.Errorf("current session should stay valid, got %d", rr.Code)
+	}
+	if rr := getWithCookie(r, other, "/user/profile"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("other sessions should be revoked after disabling 2fa, got %d", rr.Code)
+	}
+}
+
+func TestTOTP_AdminRequiresEnrollment(t *testing.T) {
+	requireDB(t)
+	service.SetLoginThrottle(throttle.NewMemoryStore())
+	t.Cleanup(func() { service.SetLoginThrottle(nil) })
+
+	r := newTestRouter()
+	adminCookie, adminName := signupAndLogin(t, r)
+	if _, err := db.DBconn().ExecContext(context.Background(), "update tbl_user set role='admin' where user_name=?", adminName); err != nil {
+		t.Fatalf("failed to promote admin: %v", err)
+	}
+	password := "pwd_" + randHex(6)
+	username := signupWithPassword(t, r, password)
+	cookie := loginCookie(r, username, password)
+
+	if rr := postForm(t, r, adminCookie, "/admin/users/"+username+"/2fa", url.Values{"required": {"true"}}); rr.Code != http.StatusOK {
+		t.Fatalf("require 2fa failed: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := getWithCookie(r, cookie, "/user/profile"); rr.Code != http.StatusForbidden {
+		t.Errorf("user without 2fa should be blocked, got %d", rr.Code)
+	}
+	var enrollment service.TOTPEnrollment
+	rr := postForm(t, r, cookie, "/user/2fa/enroll", nil)
+	json.Unmarshal(rr.Body.Bytes(), &enrollment)
+	if rr := postForm(t, r, cookie, "/user/2fa/confirm", url.Values{"code": {currentCode(t, enrollment.Secret, 0)}}); rr.Code != http.StatusOK {
+		t.Fatalf("confirm failed: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := getWithCookie(r, cookie, "/user/profile"); rr.Code != http.StatusOK {
+		t.Errorf("user should have access after enrolling, got %d", rr.Code)
+	}
+
+	form := url.Values{"password": {password}, "code": {currentCode(t, enrollment.Secret, 1)}}
+	if rr := postForm(t, r, cookie, "/user/2fa/disable", form); rr.Code != http.StatusForbidden {
+		t.Errorf("required 2fa should not be disabled by user, got %d", rr.Code)
+	}
+	pending := loginFrom(r, "203.0.113.50", username, password).Result().Cookies()[0]
+	if rr := postForm(t, r, adminCookie, "/admin/users/"+username+"/2fa/reset", nil); rr.Code != http.StatusOK {
+		t.Fatalf("admin reset failed: %d", rr.Code)
+	}
+	// 重置后已有会话和待完成的两步登录都失效。
+	if rr := getWithCookie(r, cookie, "/user/profile"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("sessions should be revoked after reset, got %d", rr.Code)
+	}
+	if rr := postForm(t, r, pending, "/user/login/2fa", url.Values{"code": {currentCode(t, enrollment.Secret, 2)}}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("pending login should be revoked after reset, got %d", rr.Code)
+	}
+	if rr := loginFrom(r, "203.0.113.50", username, password); rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), "2fa_required") {
+		t.Errorf("login after reset should not ask for a code: %d %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,132 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"filestore-server/pkg/dao"
+	"filestore-server/service"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memStore 是内存中的二级存储，前 failPuts 次上传会失败。
+type memStore struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	failPuts int
+	puts     int
+}
+
+func (s *memStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.puts++
+	if s.puts <= s.failPuts {
+		return errors.New("remote unavailable")
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.objects[key] = data
+	return nil
+}
+
+func (s *memStore) Get(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("object %s: %w", key, fs.ErrNotExist)
+	}
+	return nopSeekCloser{bytes.NewReader(data)}, nil
+}
+
+func (s *memStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *memStore) Location(key string) string { return "mem://" + key }
+
+type nopSeekCloser struct{ io.ReadSeeker }
+
+func (nopSeekCloser) Close() error { return nil }
+
+func TestTransfer_RetriesThenServesFromRemote(t *testing.T) {
+	requireDB(t)
+
+	store := &memStore{objects: make(map[string][]byte), failPuts: 1}
+	service.SetRemoteStore(store)
+	defer service.SetRemoteStore(nil)
+
+	r := newTestRouter()
+	sessionCookie, _ := signupAndLogin(t, r)
+
+	content := []byte("transfer " + randHex(16))
+	req, err := createUploadRequest("file", "transfer_"+randHex(4)+".txt", content)
+	if err != nil {
+		t.Fatalf("create request failed: %v", err)
+	}
+	req.AddCookie(sessionCookie)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("upload failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	var upload struct {
+		File dao.FileMeta `json:"file"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &upload)
+	local := upload.File.Location
+
+	// 第一次转存失败后本地文件必须保留，下载照常可用。
+	if _, err := os.Stat(local); err != nil {
+		t.Fatalf("local copy should exist before transfer: %v", err)
+	}
+
+	var fmeta dao.FileMeta
+	deadline := time.Now().Add(15 * time.Second)
+	for {
+		fmeta, err = dao.GetFileMeta(context.Background(), upload.File.FileSha1)
+		if err != nil {
+			t.Fatalf("get meta failed: %v", err)
+		}
+		if fmeta.StoreState == dao.StoreRemote {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("file not transferred in time, puts=%d", store.puts)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if fmeta.Location != store.Location(fmeta.FileSha1) {
+		t.Errorf("file_addr not updated: %s", fmeta.Location)
+	}
+	if _, err := os.Stat(local); !os.IsNotExist(err) {
+		t.Errorf("local copy should be removed after transfer: %v", err)
+	}
+
+	req = httptest.NewRequest("GET", "/file/download?filehash="+fmeta.FileSha1, nil)
+	req.AddCookie(sessionCookie)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("download failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+	if !bytes.Equal(rr.Body.Bytes(), content) {
+		t.Errorf("download content mismatch: got %q", rr.Body.String())
+	}
+}
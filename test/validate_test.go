@@ -0,0 +1,91 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"filestore-server/service"
+)
+
+func violatedRules(err error) []string {
+	var perr *service.PolicyError
+	if !errors.As(err, &perr) {
+		return nil
+	}
+	var rules []string
+	for _, v := range perr.Violations {
+		rules = append(rules, v.Field+"."+v.Rule)
+	}
+	sort.Strings(rules)
+	return rules
+}
+
+func TestValidateCredentials_Rules(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "breached.txt")
+	// 第二行为 "Password123" 的 SHA-1，HIBP 格式。
+	content := "letmein99\nB2E98AD6F6EB8508DD6A14CFA704BAD7F05F6FB1:42\n"
+	if err := os.WriteFile(list, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FILESTORE_BREACHED_PASSWORDS_FILE", list)
+
+	cases := []struct {
+		username, password string
+		want               []string
+	}{
+		{"alice_01", "s3cret-pass", nil},
+		{"al", "s3cret-pass", []string{"username.min_length"}},
+		{"bob smith", "s3cret-pass", []string{"username.charset"}},
+		{"team:1", "s3cret-pass", []string{"username.charset"}},
+		{"bad\x07name", "s3cret-pass", []string{"username.charset"}},
+		{"Admin", "s3cret-pass", []string{"username.reserved"}},
+		{"carol", "x1", []string{"password.min_length"}},
+		{"carol", "abcdefghij", []string{"password.classes"}},
+		{"carol", "carol-2024!", []string{"password.contains_username"}},
+		{"carol", "letmein99", []string{"password.breached"}},
+		{"carol", "Password123", []string{"password.breached"}},
+		{"carol", strings.Repeat("a1", 40), []string{"password.max_length"}},
+		{"x", "y", []string{"password.classes", "password.min_length", "username.min_length"}},
+	}
+	for _, tc := range cases {
+		got := violatedRules(service.ValidateCredentials(tc.username, tc.password))
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("ValidateCredentials(%q, %q) = %v, want %v", tc.username, tc.password, got, tc.want)
+		}
+	}
+
+	t.Setenv("FILESTORE_RESERVED_USERNAMES", "ops")
+	t.Setenv("FILESTORE_PASSWORD_MIN_CLASSES", "3")
+	if got := violatedRules(service.ValidateCredentials("ops", "abc12345")); strings.Join(got, ",") != "password.classes,username.reserved" {
+		t.Errorf("configured rules not applied: %v", got)
+	}
+	if err := service.ValidateCredentials("admin", "aB3-defgh"); err != nil {
+		t.Errorf("admin should no longer be reserved: %v", err)
+	}
+}
+
+func TestSignup_ReturnsViolations(t *testing.T) {
+	r := newTestRouter()
+	rr := postForm(t, r, nil, "/user/signup", url.Values{"username": {"a b"}, "password": {"short"}})
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d body:%s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Violations []service.PolicyViolation
+	}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	rules := map[string]bool{}
+	for _, v := range resp.Violations {
+		rules[v.Field+"."+v.Rule] = true
+	}
+	if !rules["username.charset"] || !rules["password.min_length"] {
+		t.Errorf("unexpected violations: %s", rr.Body.String())
+	}
+}
@@ -0,0 +1,142 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"filestore-server/pkg/dao"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFileVersions_UploadListDownloadRestore(t *testing.T) {
+	requireDB(t)
+
+	r := newTestRouter()
+	sessionCookie, username := signupAndLogin(t, r)
+
+	tmpDir := "./tmp"
+	if err := os.MkdirAll(tmpDir, 0o755); err != nil {
+		t.Fatalf("failed to create tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	filename := "config_" + randHex(4) + ".yaml"
+	v1 := []byte("v1_" + randHex(8))
+	v2 := []byte("v2_" + randHex(8))
+
+	var hashes []string
+	for _, content := range [][]byte{v1, v2} {
+		req, err := createUploadRequest("file", filename, content)
+		if err != nil {
+			t.Fatalf("create request failed: %v", err)
+		}
+		req.AddCookie(sessionCookie)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("upload failed: %d body:%s", rr.Code, rr.Body.String())
+		}
+		var resp struct {
+			File dao.FileMeta `json:"file"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to unmarshal upload response: %v", err)
+		}
+		hashes = append(hashes, resp.File.FileSha1)
+	}
+
+	// 同名上传只保留一条当前文件记录，指向最新内容。
+	assertUserFileMeta(t, username, hashes[1], filename, int64(len(v2)))
+
+	listReq := httptest.NewRequest("GET", "/file/versions?filename="+url.QueryEscape(filename), nil)
+	listReq.AddCookie(sessionCookie)
+	listRes := httptest.NewRecorder()
+	r.ServeHTTP(listRes, listReq)
+	if listRes.Code != http.StatusOK {
+		t.Fatalf("list versions failed: %d body:%s", listRes.Code, listRes.Body.String())
+	}
+	var list struct {
+		Versions []dao.FileVersion `json:"versions"`
+	}
+	if err := json.Unmarshal(listRes.Body.Bytes(), &list); err != nil {
+		t.Fatalf("failed to unmarshal versions: %v", err)
+	}
+	if len(list.Versions) != 2 {
+		t.Fatalf("versions length mismatch: got %d want %d", len(list.Versions), 2)
+	}
+	if list.Versions[0].FileSha1 != hashes[1] || !list.Versions[0].Current {
+		t.Errorf("latest version should be current: %+v", list.Versions[0])
+	}
+
+	dlReq := httptest.NewRequest("GET", "/file/version/download?filename="+url.QueryEscape(filename)+"&filehash="+hashes[0], nil)
+	dlReq.AddCookie(sessionCookie)
+	dlRes := httptest.NewRecorder()
+	r.ServeHTTP(dlRes, dlReq)
+	if dlRes.Code != http.StatusOK {
+		t.Fatalf("download version failed: %d body:%s", dlRes.Code, dlRes.Body.String())
+	}
+	if dlRes.Body.String() != string(v1) {
+		t.Errorf("downloaded version content mismatch")
+	}
+
+	form := url.Values{"filename": {filename}, "filehash": {hashes[0]}}
+	restoreReq := httptest.NewRequest("POST", "/file/version/restore", strings.NewReader(form.Encode()))
+	restoreReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	restoreReq.AddCookie(sessionCookie)
+	restoreRes := httptest.NewRecorder()
+	r.ServeHTTP(restoreRes, restoreReq)
+	if restoreRes.Code != http.StatusOK {
+		t.Fatalf("restore failed: %d body:%s", restoreRes.Code, restoreRes.Body.String())
+	}
+
+	assertUserFileMeta(t, username, hashes[0], filename, int64(len(v1)))
+}
+
+func TestFileVersions_PruneReclaimsUnreferencedContent(t *testing.T) {
+	requireDB(t)
+
+	r := newTestRouter()
+	sessionCookie, _ := signupAndLogin(t, r)
+
+	tmpDir := "./tmp"
+	if err := os.MkdirAll(tmpDir, 0o755); err != nil {
+		t.Fatalf("failed to create tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if rr := postForm(t, r, sessionCookie, "/user/version/policy", url.Values{"max_versions": {"1"}}); rr.Code != http.StatusOK {
+		t.Fatalf("set version policy failed: %d body:%s", rr.Code, rr.Body.String())
+	}
+
+	filename := "notes_" + randHex(4) + ".txt"
+	shared := []byte("shared_" + randHex(8))
+	v1Content := []byte("v1_" + randHex(8))
+	v1 := uploadForTest(t, r, sessionCookie, filename, v1Content)
+	// 其他用户也引用的内容在版本被清理后仍然保留。
+	otherCookie, _ := signupAndLogin(t, r)
+	uploadForTest(t, r, otherCookie, filename, shared)
+	v2 := uploadForTest(t, r, sessionCookie, filename, shared)
+	uploadForTest(t, r, sessionCookie, filename, []byte("v3_"+randHex(8)))
+
+	ctx := context.Background()
+	if _, exists, err := dao.GetFileExist(ctx, v1.FileSha1); err != nil || exists {
+		t.Errorf("pruned content should be reclaimed: exists=%v err=%v", exists, err)
+	}
+	if _, exists, err := dao.GetFileExist(ctx, v2.FileSha1); err != nil || !exists {
+		t.Errorf("content referenced by another user should be kept: exists=%v err=%v", exists, err)
+	}
+
+	// 回收过的内容可以再次上传。
+	uploadForTest(t, r, sessionCookie, "again_"+filename, v1Content)
+	fmeta, exists, err := dao.GetFileExist(ctx, v1.FileSha1)
+	if err != nil || !exists {
+		t.Fatalf("re-uploaded content should be available: exists=%v err=%v", exists, err)
+	}
+	if _, err := os.Stat(fmeta.Location); err != nil {
+		t.Errorf("re-uploaded content should be stored at %s: %v", fmeta.Location, err)
+	}
+}